@@ -0,0 +1,139 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/manuel/make-it-rain/config"
+	"github.com/manuel/make-it-rain/data_structures"
+	"github.com/manuel/make-it-rain/db"
+	"github.com/manuel/make-it-rain/logging"
+	"github.com/manuel/make-it-rain/models"
+	"github.com/manuel/make-it-rain/pubsub"
+)
+
+// APIKeyPrefix starts every personal API key, so keys are easy to spot in
+// logs and by secret scanners, and can't be mistaken for access tokens.
+const APIKeyPrefix = "mir_"
+
+// apiKeyDisplayLen is how much of a key, prefix included, is stored in the
+// clear and shown in listings.
+const apiKeyDisplayLen = len(APIKeyPrefix) + 8
+
+// maxCachedAPIKeys bounds the resolved key cache.
+const maxCachedAPIKeys = 10000
+
+// NewAPIKey returns a new key, the part of it shown in listings and its
+// hash. The key itself is only shown to the user once.
+func NewAPIKey() (key, prefix, hash string, err error) {
+	token, _, err := NewToken()
+	if err != nil {
+		return "", "", "", err
+	}
+	key = APIKeyPrefix + token
+	return key, key[:apiKeyDisplayLen], HashToken(key), nil
+}
+
+// IsAPIKey reports whether a bearer token is an API key rather than an
+// access token.
+func IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, APIKeyPrefix)
+}
+
+type cachedAPIKey struct {
+	key      *models.APIKey
+	loadedAt time.Time
+}
+
+// APIKeys resolves API keys presented by clients. Resolved keys are cached
+// for cfg.CacheTTL so busy keys don't cost a query per request; revocations
+// on any replica drop the cached key through HandleChange.
+type APIKeys struct {
+	store   db.APIKeyStore
+	ttl     time.Duration
+	cache   *data_structures.LRUCacheWithTTL[string, cachedAPIKey]
+	version atomic.Uint64
+	now     func() time.Time
+}
+
+func NewAPIKeys(store db.APIKeyStore, cfg config.APIKeysConfig) *APIKeys {
+	return &APIKeys{
+		store: store,
+		ttl:   cfg.CacheTTL,
+		cache: data_structures.NewLRUCacheWithTTL[string, cachedAPIKey](maxCachedAPIKeys, cfg.CacheTTL),
+		now:   time.Now,
+	}
+}
+
+// Resolve returns the live key for a client's API key, recording that it
+// was used, or ErrInvalidToken.
+func (k *APIKeys) Resolve(ctx context.Context, key string) (*models.APIKey, error) {
+	if !IsAPIKey(key) {
+		return nil, ErrInvalidToken
+	}
+	if found, ok := k.Cached(key); ok {
+		return found, nil
+	}
+
+	hash := HashToken(key)
+	version := k.version.Load()
+	found, err := k.store.UseAPIKey(ctx, hash)
+	if err != nil {
+		if errors.Is(err, db.ErrTokenInvalid) {
+			return nil, ErrInvalidToken
+		}
+		return nil, err
+	}
+	// A change that arrived during the query may be for this key
+	if k.version.Load() == version {
+		k.cache.Put(hash, cachedAPIKey{key: found, loadedAt: k.now()})
+	}
+	return found, nil
+}
+
+// Cached returns a key resolved in the last cfg.CacheTTL without querying
+// the database, so callers ahead of authentication can't be made to run a
+// query for every made-up key.
+func (k *APIKeys) Cached(key string) (*models.APIKey, bool) {
+	if !IsAPIKey(key) {
+		return nil, false
+	}
+	// The cache's own TTL slides on every hit, so the load time is checked
+	// here to make busy keys look themselves up again.
+	c, ok := k.cache.Get(HashToken(key))
+	now := k.now()
+	if !ok || now.Sub(c.loadedAt) >= k.ttl || !now.Before(c.key.ExpiresAt) {
+		return nil, false
+	}
+	return c.key, true
+}
+
+// HandleChange drops a key revoked or changed on any replica. A change to
+// all of a user's keys, when the user is deactivated or deleted, drops the
+// whole cache, since it isn't indexed by user; those are rare, and so is a
+// malformed change, which also drops everything. It is subscribed to
+// db.APIKeysChannel.
+func (k *APIKeys) HandleChange(ctx context.Context, msg pubsub.Message) {
+	var change db.APIKeyChange
+	if err := msg.Decode(&change); err != nil {
+		logging.Ctx(ctx, logging.Services).Warn().Err(err).Msg("Ignoring malformed API key change")
+		k.InvalidateAll()
+		return
+	}
+	k.version.Add(1)
+	if change.KeyHash != "" {
+		k.cache.Delete(change.KeyHash)
+		return
+	}
+	k.cache.Clear()
+}
+
+// InvalidateAll drops every cached key, for when changes may have been
+// missed.
+func (k *APIKeys) InvalidateAll() {
+	k.version.Add(1)
+	k.cache.Clear()
+}
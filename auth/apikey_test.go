@@ -0,0 +1,164 @@
+package auth
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/manuel/make-it-rain/config"
+	"github.com/manuel/make-it-rain/db"
+	"github.com/manuel/make-it-rain/models"
+	"github.com/manuel/make-it-rain/pubsub"
+)
+
+// memoryAPIKeyStore serves UseAPIKey from a map of live keys by hash.
+type memoryAPIKeyStore struct {
+	db.APIKeyStore
+	keys map[string]*models.APIKey
+	uses int
+}
+
+func (s *memoryAPIKeyStore) UseAPIKey(ctx context.Context, keyHash string) (*models.APIKey, error) {
+	s.uses++
+	k, ok := s.keys[keyHash]
+	if !ok {
+		return nil, db.ErrTokenInvalid
+	}
+	copied := *k
+	return &copied, nil
+}
+
+func newTestAPIKeys(t *testing.T, now *time.Time) (*APIKeys, *memoryAPIKeyStore, string) {
+	key, prefix, hash, err := NewAPIKey()
+	if err != nil {
+		t.Fatalf("NewAPIKey failed: %v", err)
+	}
+	store := &memoryAPIKeyStore{keys: map[string]*models.APIKey{
+		hash: {ID: 1, UserID: 42, Prefix: prefix, KeyHash: hash, Tier: "standard", ExpiresAt: now.Add(time.Hour)},
+	}}
+	keys := NewAPIKeys(store, config.APIKeysConfig{CacheTTL: time.Minute})
+	keys.now = func() time.Time { return *now }
+	return keys, store, key
+}
+
+func TestNewAPIKey(t *testing.T) {
+	key, prefix, hash, err := NewAPIKey()
+	if err != nil {
+		t.Fatalf("NewAPIKey failed: %v", err)
+	}
+	if !IsAPIKey(key) || !strings.HasPrefix(key, prefix) || len(prefix) != 12 {
+		t.Errorf("Unexpected key %q with prefix %q", key, prefix)
+	}
+	if hash != HashToken(key) {
+		t.Error("Expected the hash of the whole key")
+	}
+	if IsAPIKey("eyJhbGciOiJIUzI1NiJ9.e30.sig") {
+		t.Error("Expected a JWT not to look like an API key")
+	}
+}
+
+func TestAPIKeysResolveCachesKeys(t *testing.T) {
+	now := time.Date(2024, time.March, 1, 10, 0, 0, 0, time.UTC)
+	keys, store, key := newTestAPIKeys(t, &now)
+	ctx := context.Background()
+
+	if _, ok := keys.Cached(key); ok {
+		t.Error("Expected nothing cached before the first use")
+	}
+	for i := 0; i < 3; i++ {
+		found, err := keys.Resolve(ctx, key)
+		if err != nil || found.UserID != 42 {
+			t.Fatalf("Expected the key of user 42, got %+v, %v", found, err)
+		}
+	}
+	if store.uses != 1 {
+		t.Errorf("Expected 1 lookup, got %d", store.uses)
+	}
+	if found, ok := keys.Cached(key); !ok || found.ID != 1 {
+		t.Errorf("Expected key 1 cached, got %+v", found)
+	}
+
+	// Busy keys are looked up again after the cache TTL, which records use
+	now = now.Add(time.Minute)
+	if _, ok := keys.Cached(key); ok {
+		t.Error("Expected the cached key to need a lookup after the cache TTL")
+	}
+	keys.Resolve(ctx, key)
+	if store.uses != 2 {
+		t.Errorf("Expected 2 lookups, got %d", store.uses)
+	}
+}
+
+func TestAPIKeysResolveRejectsUnknownKeys(t *testing.T) {
+	now := time.Now()
+	keys, store, _ := newTestAPIKeys(t, &now)
+
+	for _, bad := range []string{"mir_unknown", "not-an-api-key", ""} {
+		if _, err := keys.Resolve(context.Background(), bad); err != ErrInvalidToken {
+			t.Errorf("%q: expected ErrInvalidToken, got %v", bad, err)
+		}
+	}
+	if store.uses != 1 {
+		t.Errorf("Expected only the mir_ key to be looked up, got %d lookups", store.uses)
+	}
+}
+
+func TestAPIKeysCacheHonoursExpiry(t *testing.T) {
+	now := time.Date(2024, time.March, 1, 10, 0, 0, 0, time.UTC)
+	keys, store, key := newTestAPIKeys(t, &now)
+	for _, k := range store.keys {
+		k.ExpiresAt = now.Add(30 * time.Second)
+	}
+
+	keys.Resolve(context.Background(), key)
+	now = now.Add(30 * time.Second)
+	if _, ok := keys.Cached(key); ok {
+		t.Error("Expected an expired key not to be served from the cache")
+	}
+}
+
+func TestAPIKeysHandleChangeDropsKey(t *testing.T) {
+	now := time.Now()
+	keys, store, key := newTestAPIKeys(t, &now)
+	keys.Resolve(context.Background(), key)
+
+	delete(store.keys, HashToken(key))
+	keys.HandleChange(context.Background(), pubsub.Message{
+		Channel: db.APIKeysChannel,
+		Payload: `{"key_hash":"` + HashToken(key) + `"}`,
+	})
+	if _, err := keys.Resolve(context.Background(), key); err != ErrInvalidToken {
+		t.Errorf("Expected a revoked key to be refused, got %v", err)
+	}
+}
+
+func TestAPIKeysHandleChangeDropsUserKeys(t *testing.T) {
+	now := time.Now()
+	keys, store, key := newTestAPIKeys(t, &now)
+	keys.Resolve(context.Background(), key)
+
+	// The user was deactivated, so the store no longer finds the key live
+	store.keys = map[string]*models.APIKey{}
+	keys.HandleChange(context.Background(), pubsub.Message{
+		Channel: db.APIKeysChannel,
+		Payload: `{"user_id":42}`,
+	})
+	if _, ok := keys.Cached(key); ok {
+		t.Error("Expected the user's keys to be dropped from the cache")
+	}
+	if _, err := keys.Resolve(context.Background(), key); err != ErrInvalidToken {
+		t.Errorf("Expected a deactivated user's key to be refused, got %v", err)
+	}
+}
+
+func TestAPIKeysHandleChangeMalformedDropsAll(t *testing.T) {
+	now := time.Now()
+	keys, _, key := newTestAPIKeys(t, &now)
+	keys.Resolve(context.Background(), key)
+
+	keys.HandleChange(context.Background(), pubsub.Message{Channel: db.APIKeysChannel, Payload: "not json"})
+	if _, ok := keys.Cached(key); ok {
+		t.Error("Expected a malformed change to drop every cached key")
+	}
+}
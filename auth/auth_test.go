@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/manuel/make-it-rain/config"
+)
+
+func newTestSigner(now time.Time) *Signer {
+	s := NewSigner(config.JWTConfig{SecretKey: "test-secret", ExpiryDuration: 15 * time.Minute})
+	s.now = func() time.Time { return now }
+	return s
+}
+
+func TestSignerRoundTrip(t *testing.T) {
+	now := time.Date(2024, time.March, 1, 10, 0, 0, 0, time.UTC)
+	s := newTestSigner(now)
+
+	token, expiresAt, err := s.Sign(42, 7)
+	if err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+	if !expiresAt.Equal(now.Add(15 * time.Minute)) {
+		t.Errorf("Expected expiry %v, got %v", now.Add(15*time.Minute), expiresAt)
+	}
+
+	claims, err := s.Verify(token)
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if id, _ := claims.UserID(); id != 42 || claims.SessionID != 7 {
+		t.Errorf("Expected user 42 and session 7, got %d and %d", id, claims.SessionID)
+	}
+}
+
+func TestSignerRejectsBadTokens(t *testing.T) {
+	now := time.Date(2024, time.March, 1, 10, 0, 0, 0, time.UTC)
+	s := newTestSigner(now)
+	token, _, _ := s.Sign(42, 0)
+	header, rest, _ := strings.Cut(token, ".")
+	payload, sig, _ := strings.Cut(rest, ".")
+
+	forged := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"1","iat":0,"exp":9999999999}`))
+	none := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`))
+	other := NewSigner(config.JWTConfig{SecretKey: "other-secret", ExpiryDuration: time.Minute})
+	otherToken, _, _ := other.Sign(42, 0)
+
+	tests := map[string]string{
+		"empty":             "",
+		"garbage":           "not-a-token",
+		"changed payload":   header + "." + forged + "." + sig,
+		"alg none":          none + "." + payload + ".",
+		"other key":         otherToken,
+		"missing signature": header + "." + payload,
+	}
+	for name, bad := range tests {
+		if _, err := s.Verify(bad); err != ErrInvalidToken {
+			t.Errorf("%s: expected ErrInvalidToken, got %v", name, err)
+		}
+	}
+
+	s.now = func() time.Time { return now.Add(15 * time.Minute) }
+	if _, err := s.Verify(token); err != ErrInvalidToken {
+		t.Errorf("Expected an expired token to be rejected, got %v", err)
+	}
+}
+
+func TestNewTokenHashes(t *testing.T) {
+	token, hash, err := NewToken()
+	if err != nil {
+		t.Fatalf("NewToken failed: %v", err)
+	}
+	other, _, _ := NewToken()
+	if token == other {
+		t.Error("Expected tokens to be random")
+	}
+	if hash != HashToken(token) || hash == token || len(hash) != 64 {
+		t.Errorf("Expected the SHA-256 hex of the token, got %q", hash)
+	}
+}
+
+func TestThrottleLimitsEachKey(t *testing.T) {
+	th := NewThrottle(2, time.Hour)
+
+	if !th.Allow("email:a", "ip:1") || !th.Allow("email:a", "ip:1") {
+		t.Fatal("Expected the first two events to be allowed")
+	}
+	if th.Allow("email:a", "ip:2") {
+		t.Error("Expected a third event for the same email to be limited")
+	}
+	if th.Allow("email:b", "ip:1") {
+		t.Error("Expected a third event from the same IP to be limited")
+	}
+	if !th.Allow("email:b", "ip:3") {
+		t.Error("Expected other keys to be unaffected")
+	}
+}
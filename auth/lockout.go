@@ -0,0 +1,211 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/manuel/make-it-rain/config"
+	"github.com/manuel/make-it-rain/db"
+	"github.com/manuel/make-it-rain/logging"
+	"github.com/manuel/make-it-rain/metrics"
+	"github.com/manuel/make-it-rain/models"
+	"github.com/manuel/make-it-rain/pubsub"
+)
+
+// LoginLockedError is returned by LoginLimiter.Check while an account or
+// client IP is locked out or has to wait before trying again. It looks the
+// same whether or not the account exists.
+type LoginLockedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LoginLockedError) Error() string {
+	return fmt.Sprintf("too many failed login attempts, retry after %s", e.RetryAfter)
+}
+
+// UserFinder looks up the account behind an email address; db.DBService
+// implements it.
+type UserFinder interface {
+	GetUserByEmail(ctx context.Context, email string) (*models.User, error)
+}
+
+// AccountKey and IPKey name the counters a failed login is recorded under.
+func AccountKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func IPKey(ip string) string {
+	return "ip:" + ip
+}
+
+// LoginLimiter tracks failed logins per account and per client IP. Each
+// replica counts failures in process, which lets it turn attackers away
+// without a database round trip, and the store keeps the shared count and
+// locks so every replica enforces them.
+//
+// From cfg.DelayAfter failures on, an account has to wait an exponentially
+// growing delay before the next attempt; at cfg.Threshold it is locked for
+// cfg.Duration. Failures are keyed by the address typed in, so addresses
+// without an account are delayed and locked exactly like real ones.
+type LoginLimiter struct {
+	store    db.LoginAttemptStore
+	users    UserFinder
+	cfg      config.LockoutConfig
+	accounts *Throttle
+	ips      *Throttle
+	now      func() time.Time
+}
+
+func NewLoginLimiter(store db.LoginAttemptStore, users UserFinder, cfg config.LockoutConfig) *LoginLimiter {
+	return &LoginLimiter{
+		store:    store,
+		users:    users,
+		cfg:      cfg,
+		accounts: NewThrottle(cfg.Threshold, cfg.Window),
+		ips:      NewThrottle(cfg.IPThreshold, cfg.Window),
+		now:      time.Now,
+	}
+}
+
+// Check returns a *LoginLockedError if email or clientIP may not try to log
+// in yet.
+func (l *LoginLimiter) Check(ctx context.Context, email, clientIP string) error {
+	account, ip := AccountKey(email), IPKey(clientIP)
+
+	// This replica alone has seen enough failures, so there is no need to
+	// ask the database. The lock lasts until they leave the window here.
+	if l.accounts.Count(account) >= l.cfg.Threshold || l.ips.Count(ip) >= l.cfg.IPThreshold {
+		metrics.LoginAttemptsTotal.WithLabelValues("throttled").Inc()
+		return &LoginLockedError{RetryAfter: l.cfg.Window}
+	}
+
+	until, err := l.store.LoginLockedUntil(ctx, []string{account, ip})
+	if err != nil {
+		return err
+	}
+	if wait := until.Sub(l.now()); wait > 0 {
+		metrics.LoginAttemptsTotal.WithLabelValues("throttled").Inc()
+		return &LoginLockedError{RetryAfter: wait}
+	}
+	return nil
+}
+
+// RecordFailure counts a wrong password and applies any delay or lock that
+// follows from it.
+func (l *LoginLimiter) RecordFailure(ctx context.Context, email, clientIP string) error {
+	metrics.LoginAttemptsTotal.WithLabelValues("failed").Inc()
+	account, ip := AccountKey(email), IPKey(clientIP)
+	l.accounts.Allow(account)
+	l.ips.Allow(ip)
+
+	failures, err := l.store.RecordLoginFailure(ctx, account, l.cfg.Window)
+	if err != nil {
+		return err
+	}
+	switch {
+	case failures >= l.cfg.Threshold:
+		userID := l.lookup(ctx, email)
+		if err := l.store.LockLogin(ctx, account, l.now().Add(l.cfg.Duration), userID); err != nil {
+			return err
+		}
+		metrics.LoginLockoutsTotal.WithLabelValues("account").Inc()
+		logging.Ctx(ctx, logging.Services).Warn().Int64("user_id", userID).Int("failures", failures).Msg("Account locked after failed logins")
+	case failures >= l.cfg.DelayAfter:
+		if err := l.store.LockLogin(ctx, account, l.now().Add(l.delay(failures)), 0); err != nil {
+			return err
+		}
+	}
+
+	failures, err = l.store.RecordLoginFailure(ctx, ip, l.cfg.Window)
+	if err != nil {
+		return err
+	}
+	if failures >= l.cfg.IPThreshold {
+		if err := l.store.LockLogin(ctx, ip, l.now().Add(l.cfg.Duration), 0); err != nil {
+			return err
+		}
+		metrics.LoginLockoutsTotal.WithLabelValues("ip").Inc()
+		logging.Ctx(ctx, logging.Services).Warn().Str("ip", clientIP).Int("failures", failures).Msg("Client IP locked after failed logins")
+	}
+	return nil
+}
+
+// RecordSuccess forgets the account's failures. Those of the client IP are
+// kept, so logging in to one account doesn't earn more guesses at others.
+func (l *LoginLimiter) RecordSuccess(ctx context.Context, email string) error {
+	metrics.LoginAttemptsTotal.WithLabelValues("succeeded").Inc()
+	account := AccountKey(email)
+	l.accounts.Reset(account)
+	return l.store.ClearLoginFailures(ctx, account)
+}
+
+// Unlock lifts the lock on key, an AccountKey or IPKey, on every replica.
+// It returns db.ErrLoginNotLocked when key isn't locked.
+func (l *LoginLimiter) Unlock(ctx context.Context, key string) error {
+	var userID int64
+	if email, ok := strings.CutPrefix(key, "account:"); ok {
+		userID = l.lookup(ctx, email)
+	}
+	return l.unlock(ctx, key, userID)
+}
+
+// UnlockUser lifts the lock on user's account.
+func (l *LoginLimiter) UnlockUser(ctx context.Context, user *models.User) error {
+	return l.unlock(ctx, AccountKey(user.Email), user.ID)
+}
+
+func (l *LoginLimiter) unlock(ctx context.Context, key string, userID int64) error {
+	// The local count can outlast the stored lock, so it is dropped even
+	// when there is nothing left to unlock in the store.
+	l.reset(key)
+	if err := l.store.UnlockLogin(ctx, key, userID); err != nil {
+		return err
+	}
+	logging.Ctx(ctx, logging.Services).Info().Str("key", key).Int64("user_id", userID).Msg("Login unlocked")
+	return nil
+}
+
+// HandleUnlock drops the in-process count for a key unlocked on another
+// replica. It is subscribed to db.LoginUnlocksChannel.
+func (l *LoginLimiter) HandleUnlock(ctx context.Context, msg pubsub.Message) {
+	var unlock db.LoginUnlock
+	if err := msg.Decode(&unlock); err != nil {
+		logging.Ctx(ctx, logging.Services).Warn().Err(err).Msg("Ignoring malformed login unlock")
+		return
+	}
+	l.reset(unlock.Key)
+}
+
+func (l *LoginLimiter) ListLocks(ctx context.Context, limit int) ([]models.LoginLock, error) {
+	return l.store.ListLoginLocks(ctx, limit)
+}
+
+func (l *LoginLimiter) reset(key string) {
+	l.accounts.Reset(key)
+	l.ips.Reset(key)
+}
+
+// delay doubles cfg.BaseDelay for each failure past cfg.DelayAfter, up to
+// cfg.MaxDelay.
+func (l *LoginLimiter) delay(failures int) time.Duration {
+	d := l.cfg.BaseDelay
+	for i := l.cfg.DelayAfter; i < failures && d < l.cfg.MaxDelay; i++ {
+		d *= 2
+	}
+	return min(d, l.cfg.MaxDelay)
+}
+
+// lookup returns the ID of the account using email, or 0 if there is none.
+// It only labels audit events, so errors are logged and otherwise ignored.
+func (l *LoginLimiter) lookup(ctx context.Context, email string) int64 {
+	user, err := l.users.GetUserByEmail(ctx, email)
+	if err != nil {
+		if err.Error() != "user not found" {
+			logging.Ctx(ctx, logging.Services).Warn().Err(err).Msg("Failed to look up locked account")
+		}
+		return 0
+	}
+	return user.ID
+}
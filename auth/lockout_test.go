@@ -0,0 +1,274 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/manuel/make-it-rain/config"
+	"github.com/manuel/make-it-rain/db"
+	"github.com/manuel/make-it-rain/models"
+)
+
+type loginEntry struct {
+	failures    int
+	windowStart time.Time
+	lockedUntil time.Time
+}
+
+// memoryLoginStore is an in-memory db.LoginAttemptStore on a shared clock.
+type memoryLoginStore struct {
+	now     *time.Time
+	entries map[string]*loginEntry
+	events  []string
+	lookups int
+}
+
+func (s *memoryLoginStore) RecordLoginFailure(ctx context.Context, key string, window time.Duration) (int, error) {
+	e, ok := s.entries[key]
+	if !ok || e.windowStart.Before(s.now.Add(-window)) {
+		e = &loginEntry{windowStart: *s.now, lockedUntil: s.entryLock(key)}
+		s.entries[key] = e
+	}
+	e.failures++
+	return e.failures, nil
+}
+
+func (s *memoryLoginStore) entryLock(key string) time.Time {
+	if e, ok := s.entries[key]; ok {
+		return e.lockedUntil
+	}
+	return time.Time{}
+}
+
+func (s *memoryLoginStore) LockLogin(ctx context.Context, key string, until time.Time, userID int64) error {
+	e := s.entries[key]
+	if until.After(e.lockedUntil) {
+		e.lockedUntil = until
+	}
+	if userID != 0 {
+		s.events = append(s.events, fmt.Sprintf("%s %d", models.EventUserLocked, userID))
+	}
+	return nil
+}
+
+func (s *memoryLoginStore) LoginLockedUntil(ctx context.Context, keys []string) (time.Time, error) {
+	s.lookups++
+	var until time.Time
+	for _, key := range keys {
+		if e, ok := s.entries[key]; ok && e.lockedUntil.After(*s.now) && e.lockedUntil.After(until) {
+			until = e.lockedUntil
+		}
+	}
+	return until, nil
+}
+
+func (s *memoryLoginStore) ClearLoginFailures(ctx context.Context, key string) error {
+	delete(s.entries, key)
+	return nil
+}
+
+func (s *memoryLoginStore) UnlockLogin(ctx context.Context, key string, userID int64) error {
+	e, ok := s.entries[key]
+	if !ok || !e.lockedUntil.After(*s.now) {
+		return db.ErrLoginNotLocked
+	}
+	delete(s.entries, key)
+	if userID != 0 {
+		s.events = append(s.events, fmt.Sprintf("%s %d", models.EventUserUnlocked, userID))
+	}
+	return nil
+}
+
+func (s *memoryLoginStore) ListLoginLocks(ctx context.Context, limit int) ([]models.LoginLock, error) {
+	return nil, nil
+}
+
+type memoryUsers map[string]int64
+
+func (u memoryUsers) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	id, ok := u[email]
+	if !ok {
+		return nil, fmt.Errorf("user not found")
+	}
+	return &models.User{ID: id, Email: email}, nil
+}
+
+var testLockout = config.LockoutConfig{
+	Window:      15 * time.Minute,
+	DelayAfter:  3,
+	BaseDelay:   time.Second,
+	MaxDelay:    5 * time.Second,
+	Threshold:   6,
+	Duration:    10 * time.Minute,
+	IPThreshold: 10,
+}
+
+func newTestLimiter(now *time.Time) (*LoginLimiter, *memoryLoginStore) {
+	store := &memoryLoginStore{now: now, entries: map[string]*loginEntry{}}
+	l := NewLoginLimiter(store, memoryUsers{"alice@example.com": 1}, testLockout)
+	l.now = func() time.Time { return *now }
+	return l, store
+}
+
+// retryAfter returns how long Check asks the client to wait, or 0.
+func retryAfter(t *testing.T, l *LoginLimiter, email, ip string) time.Duration {
+	t.Helper()
+	err := l.Check(context.Background(), email, ip)
+	if err == nil {
+		return 0
+	}
+	var locked *LoginLockedError
+	if !errors.As(err, &locked) {
+		t.Fatalf("Expected LoginLockedError, got %v", err)
+	}
+	return locked.RetryAfter
+}
+
+func TestLoginLimiterDelaysThenLocks(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, time.March, 1, 10, 0, 0, 0, time.UTC)
+	l, store := newTestLimiter(&now)
+
+	// Failures 3, 4 and 5 wait 1s, 2s and 4s; the sixth locks the account.
+	wants := []time.Duration{0, 0, time.Second, 2 * time.Second, 4 * time.Second, 10 * time.Minute}
+	for i, want := range wants {
+		if got := retryAfter(t, l, "alice@example.com", "10.0.0.1"); got != 0 {
+			t.Fatalf("Attempt %d: expected to be allowed, got retry after %s", i+1, got)
+		}
+		if err := l.RecordFailure(ctx, "alice@example.com", "10.0.0.1"); err != nil {
+			t.Fatalf("RecordFailure failed: %v", err)
+		}
+		if i < len(wants)-1 {
+			if got := retryAfter(t, l, "Alice@Example.com", "10.0.0.2"); got != want {
+				t.Errorf("After failure %d: expected retry after %s, got %s", i+1, want, got)
+			}
+			now = now.Add(want)
+		}
+	}
+
+	if len(store.events) != 1 || store.events[0] != "user.locked 1" {
+		t.Errorf("Expected one user.locked event, got %v", store.events)
+	}
+	if got := retryAfter(t, l, "alice@example.com", "10.0.0.2"); got != testLockout.Window {
+		t.Errorf("Expected the local count to lock for the window, got %s", got)
+	}
+}
+
+func TestLoginLimiterDelayIsCapped(t *testing.T) {
+	l, _ := newTestLimiter(new(time.Time))
+	for failures, want := range map[int]time.Duration{3: time.Second, 4: 2 * time.Second, 5: 4 * time.Second, 6: 5 * time.Second, 100: 5 * time.Second} {
+		if got := l.delay(failures); got != want {
+			t.Errorf("Expected delay %s after %d failures, got %s", want, failures, got)
+		}
+	}
+}
+
+func TestLoginLimiterTreatsUnknownAccountsAlike(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, time.March, 1, 10, 0, 0, 0, time.UTC)
+	l, store := newTestLimiter(&now)
+
+	for i := 0; i < testLockout.Threshold; i++ {
+		for _, email := range []string{"alice@example.com", "nobody@example.com"} {
+			if err := l.RecordFailure(ctx, email, fmt.Sprintf("10.0.0.%d", i)); err != nil {
+				t.Fatalf("RecordFailure failed: %v", err)
+			}
+		}
+	}
+
+	known := store.entries[AccountKey("alice@example.com")].lockedUntil
+	unknown := store.entries[AccountKey("nobody@example.com")].lockedUntil
+	if !known.Equal(unknown) || !known.Equal(now.Add(testLockout.Duration)) {
+		t.Errorf("Expected both accounts locked until %v, got %v and %v", now.Add(testLockout.Duration), known, unknown)
+	}
+	if len(store.events) != 1 {
+		t.Errorf("Expected an audit event only for the real account, got %v", store.events)
+	}
+}
+
+func TestLoginLimiterLocksIPAcrossAccounts(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, time.March, 1, 10, 0, 0, 0, time.UTC)
+	l, store := newTestLimiter(&now)
+
+	for i := 0; i < testLockout.IPThreshold; i++ {
+		if err := l.RecordFailure(ctx, fmt.Sprintf("user%d@example.com", i), "10.0.0.1"); err != nil {
+			t.Fatalf("RecordFailure failed: %v", err)
+		}
+	}
+	if got := retryAfter(t, l, "someone@example.com", "10.0.0.1"); got == 0 {
+		t.Error("Expected the IP to be locked")
+	}
+	if got := retryAfter(t, l, "someone@example.com", "10.0.0.2"); got != 0 {
+		t.Errorf("Expected other IPs to be allowed, got retry after %s", got)
+	}
+
+	// Logging in to an account doesn't clear the IP.
+	if err := l.RecordSuccess(ctx, "someone@example.com"); err != nil {
+		t.Fatalf("RecordSuccess failed: %v", err)
+	}
+	if _, ok := store.entries[IPKey("10.0.0.1")]; !ok {
+		t.Error("Expected the IP failures to be kept after a successful login")
+	}
+}
+
+func TestLoginLimiterSuccessClearsAccount(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, time.March, 1, 10, 0, 0, 0, time.UTC)
+	l, store := newTestLimiter(&now)
+
+	for i := 0; i < 2; i++ {
+		l.RecordFailure(ctx, "alice@example.com", "10.0.0.1")
+	}
+	if err := l.RecordSuccess(ctx, "alice@example.com"); err != nil {
+		t.Fatalf("RecordSuccess failed: %v", err)
+	}
+	if _, ok := store.entries[AccountKey("alice@example.com")]; ok {
+		t.Error("Expected the account failures to be cleared")
+	}
+	if got := l.accounts.Count(AccountKey("alice@example.com")); got != 0 {
+		t.Errorf("Expected the local count to be cleared, got %d", got)
+	}
+}
+
+func TestLoginLimiterUnlock(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, time.March, 1, 10, 0, 0, 0, time.UTC)
+	l, store := newTestLimiter(&now)
+
+	for i := 0; i < testLockout.Threshold; i++ {
+		l.RecordFailure(ctx, "alice@example.com", "10.0.0.1")
+	}
+	if err := l.Unlock(ctx, AccountKey("alice@example.com")); err != nil {
+		t.Fatalf("Unlock failed: %v", err)
+	}
+	if got := retryAfter(t, l, "alice@example.com", "10.0.0.2"); got != 0 {
+		t.Errorf("Expected the account to be unlocked, got retry after %s", got)
+	}
+	if store.events[len(store.events)-1] != "user.unlocked 1" {
+		t.Errorf("Expected a user.unlocked event, got %v", store.events)
+	}
+	if err := l.Unlock(ctx, AccountKey("alice@example.com")); !errors.Is(err, db.ErrLoginNotLocked) {
+		t.Errorf("Expected ErrLoginNotLocked, got %v", err)
+	}
+}
+
+func TestLoginLimiterLocalCountSkipsStore(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, time.March, 1, 10, 0, 0, 0, time.UTC)
+	l, store := newTestLimiter(&now)
+
+	for i := 0; i < testLockout.Threshold; i++ {
+		l.RecordFailure(ctx, "alice@example.com", "10.0.0.1")
+	}
+	store.lookups = 0
+	if got := retryAfter(t, l, "alice@example.com", "10.0.0.2"); got == 0 {
+		t.Fatal("Expected the account to be locked")
+	}
+	if store.lookups != 0 {
+		t.Errorf("Expected no store lookups, got %d", store.lookups)
+	}
+}
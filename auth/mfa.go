@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"fmt"
+	"strings"
+
+	"github.com/manuel/make-it-rain/config"
+	"github.com/manuel/make-it-rain/secrets"
+)
+
+var recoveryEncoding = base32.NewEncoding("abcdefghijkmnpqrstuvwxyz23456789").WithPadding(base32.NoPadding)
+
+// NewRecoveryCodes returns n single-use codes such as "k7qm-2xwp-9tbd-a3nr"
+// and their hashes. Only the hashes should be stored.
+func NewRecoveryCodes(n int) (codes, hashes []string, err error) {
+	for i := 0; i < n; i++ {
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, fmt.Errorf("failed to generate recovery code: %w", err)
+		}
+		raw := recoveryEncoding.EncodeToString(b)
+		code := raw[0:4] + "-" + raw[4:8] + "-" + raw[8:12] + "-" + raw[12:16]
+		codes = append(codes, code)
+		hashes = append(hashes, HashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// HashRecoveryCode hashes a recovery code as typed, ignoring case, spaces
+// and dashes.
+func HashRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.NewReplacer("-", "", " ", "").Replace(code)
+	return HashToken(code)
+}
+
+// SecretBox encrypts TOTP secrets at rest. Unlike passwords and tokens they
+// can't be hashed, because the server needs them to compute codes.
+type SecretBox struct {
+	key []byte
+}
+
+// NewSecretBox uses cfg.EncryptionKey, or without one a key derived from
+// the JWT secret; config validation requires a real key in production.
+func NewSecretBox(cfg config.MFAConfig, jwt config.JWTConfig) (*SecretBox, error) {
+	if cfg.EncryptionKey == "" {
+		key := sha256.Sum256([]byte("mfa:" + jwt.SecretKey))
+		return &SecretBox{key: key[:]}, nil
+	}
+	key, err := secrets.ParseKey(cfg.EncryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	return &SecretBox{key: key}, nil
+}
+
+func (b *SecretBox) Seal(secret string) ([]byte, error) {
+	return secrets.Encrypt(b.key, []byte(secret))
+}
+
+func (b *SecretBox) Open(sealed []byte) (string, error) {
+	plain, err := secrets.Decrypt(b.key, sealed)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt TOTP secret: %w", err)
+	}
+	return string(plain), nil
+}
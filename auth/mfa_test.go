@@ -0,0 +1,146 @@
+package auth
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/manuel/make-it-rain/config"
+)
+
+// rfc6238Secret is the SHA-1 key of the RFC 6238 test vectors,
+// "12345678901234567890", in base32.
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestHOTPMatchesRFC6238Vectors(t *testing.T) {
+	key := []byte("12345678901234567890")
+	vectors := map[int64]string{
+		59:          "94287082",
+		1111111109:  "07081804",
+		1111111111:  "14050471",
+		1234567890:  "89005924",
+		2000000000:  "69279037",
+		20000000000: "65353130",
+	}
+	for unix, want := range vectors {
+		if got := hotp(key, totpStep(time.Unix(unix, 0)), 8); got != want {
+			t.Errorf("At %d: expected %s, got %s", unix, want, got)
+		}
+	}
+}
+
+func TestTOTPUsesSixDigits(t *testing.T) {
+	code, err := TOTP(rfc6238Secret, time.Unix(1111111109, 0))
+	if err != nil {
+		t.Fatalf("TOTP failed: %v", err)
+	}
+	if code != "081804" {
+		t.Errorf("Expected 081804, got %s", code)
+	}
+}
+
+func TestVerifyTOTPAcceptsSkew(t *testing.T) {
+	now := time.Date(2024, time.March, 1, 10, 0, 15, 0, time.UTC)
+	current, _ := TOTP(rfc6238Secret, now)
+	previous, _ := TOTP(rfc6238Secret, now.Add(-30*time.Second))
+	stale, _ := TOTP(rfc6238Secret, now.Add(-90*time.Second))
+
+	step, err := VerifyTOTP(rfc6238Secret, current, now, 1)
+	if err != nil || step != totpStep(now) {
+		t.Errorf("Expected the current code to match step %d, got %d, %v", totpStep(now), step, err)
+	}
+	step, err = VerifyTOTP(rfc6238Secret, previous, now, 1)
+	if err != nil || step != totpStep(now)-1 {
+		t.Errorf("Expected the previous code to match step %d, got %d, %v", totpStep(now)-1, step, err)
+	}
+	if _, err := VerifyTOTP(rfc6238Secret, previous, now, 0); err != ErrInvalidCode {
+		t.Errorf("Expected the previous code to be refused without skew, got %v", err)
+	}
+	if _, err := VerifyTOTP(rfc6238Secret, stale, now, 1); err != ErrInvalidCode {
+		t.Errorf("Expected a code three steps old to be refused, got %v", err)
+	}
+	for _, bad := range []string{"", "12345", "abcdef", current + "0"} {
+		if _, err := VerifyTOTP(rfc6238Secret, bad, now, 1); err != ErrInvalidCode {
+			t.Errorf("Expected %q to be refused, got %v", bad, err)
+		}
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	secret, err := NewTOTPSecret()
+	if err != nil {
+		t.Fatalf("NewTOTPSecret failed: %v", err)
+	}
+	if len(secret) != 32 {
+		t.Errorf("Expected a 32 character secret, got %q", secret)
+	}
+
+	uri, err := url.Parse(TOTPURI("Make It Rain", "alice@example.com", secret))
+	if err != nil {
+		t.Fatalf("Invalid URI: %v", err)
+	}
+	if uri.Scheme != "otpauth" || uri.Host != "totp" || uri.Path != "/Make It Rain:alice@example.com" {
+		t.Errorf("Unexpected URI %s", uri)
+	}
+	q := uri.Query()
+	if q.Get("secret") != secret || q.Get("issuer") != "Make It Rain" || q.Get("digits") != "6" || q.Get("period") != "30" {
+		t.Errorf("Unexpected parameters %v", q)
+	}
+	if strings.Contains(uri.RawQuery, "+") {
+		t.Errorf("Expected spaces encoded as %%20, got %s", uri.RawQuery)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, hashes, err := NewRecoveryCodes(10)
+	if err != nil {
+		t.Fatalf("NewRecoveryCodes failed: %v", err)
+	}
+	if len(codes) != 10 || len(hashes) != 10 {
+		t.Fatalf("Expected 10 codes and hashes, got %d and %d", len(codes), len(hashes))
+	}
+
+	seen := map[string]bool{}
+	for i, code := range codes {
+		if len(code) != 19 || strings.Count(code, "-") != 3 {
+			t.Errorf("Unexpected code format %q", code)
+		}
+		if seen[code] {
+			t.Errorf("Duplicate code %q", code)
+		}
+		seen[code] = true
+		typed := strings.ToUpper(strings.ReplaceAll(code, "-", " "))
+		if HashRecoveryCode(typed) != hashes[i] {
+			t.Errorf("Expected %q to hash like %q", typed, code)
+		}
+	}
+}
+
+func TestSecretBox(t *testing.T) {
+	jwt := config.JWTConfig{SecretKey: "test-secret"}
+	box, err := NewSecretBox(config.MFAConfig{}, jwt)
+	if err != nil {
+		t.Fatalf("NewSecretBox failed: %v", err)
+	}
+
+	sealed, err := box.Seal(rfc6238Secret)
+	if err != nil {
+		t.Fatalf("Seal failed: %v", err)
+	}
+	if strings.Contains(string(sealed), rfc6238Secret) {
+		t.Error("Expected the secret to be encrypted")
+	}
+	opened, err := box.Open(sealed)
+	if err != nil || opened != rfc6238Secret {
+		t.Errorf("Expected %s, got %q, %v", rfc6238Secret, opened, err)
+	}
+
+	other, _ := NewSecretBox(config.MFAConfig{}, config.JWTConfig{SecretKey: "other-secret"})
+	if _, err := other.Open(sealed); err == nil {
+		t.Error("Expected a different key to fail")
+	}
+	if _, err := NewSecretBox(config.MFAConfig{EncryptionKey: "not-a-key"}, jwt); err == nil {
+		t.Error("Expected an invalid key to be rejected")
+	}
+}
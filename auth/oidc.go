@@ -0,0 +1,411 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/manuel/make-it-rain/config"
+)
+
+var (
+	// ErrInvalidIDToken is returned for an ID token that is malformed, badly
+	// signed, expired or not meant for this client or login.
+	ErrInvalidIDToken = errors.New("invalid ID token")
+	// ErrProviderUnavailable is returned when an identity provider can't be
+	// reached or answers with something other than OpenID Connect.
+	ErrProviderUnavailable = errors.New("identity provider unavailable")
+)
+
+// OIDCError is an error answered by a provider's token endpoint, e.g.
+// invalid_grant for a code that has expired or was already used.
+type OIDCError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+func (e *OIDCError) Error() string {
+	if e.Description == "" {
+		return "identity provider error: " + e.Code
+	}
+	return "identity provider error: " + e.Code + ": " + e.Description
+}
+
+const (
+	// maxProviderResponse bounds what is read from a provider.
+	maxProviderResponse = 1 << 20
+	// jwksRefreshInterval is the least time between two JWKS fetches, so
+	// tokens naming unknown keys can't make us hammer the provider.
+	jwksRefreshInterval = time.Minute
+	// idTokenLeeway allows for clock skew with the provider.
+	idTokenLeeway = time.Minute
+)
+
+var defaultOIDCScopes = []string{"openid", "email", "profile"}
+
+// IDTokenClaims are the ID token claims used to find or create the user.
+type IDTokenClaims struct {
+	Issuer          string   `json:"iss"`
+	Subject         string   `json:"sub"`
+	Audience        audience `json:"aud"`
+	AuthorizedParty string   `json:"azp"`
+	ExpiresAt       int64    `json:"exp"`
+	IssuedAt        int64    `json:"iat"`
+	Nonce           string   `json:"nonce"`
+	Email           string   `json:"email"`
+	EmailVerified   flexBool `json:"email_verified"`
+	Name            string   `json:"name"`
+	Locale          string   `json:"locale"`
+}
+
+// audience is the aud claim, which may be a string or an array.
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+// flexBool accepts true as well as "true", which some providers send for
+// email_verified.
+type flexBool bool
+
+func (b *flexBool) UnmarshalJSON(data []byte) error {
+	switch string(data) {
+	case "true", `"true"`:
+		*b = true
+	default:
+		*b = false
+	}
+	return nil
+}
+
+// oidcDiscovery is the part of the provider's discovery document we use.
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// OIDCProvider logs users in through an external OpenID Connect provider
+// with the authorization code flow and PKCE. Its endpoints are discovered
+// from the issuer on first use, and its signing keys are fetched from the
+// JWKS endpoint then and whenever a token names a key not seen yet. Only
+// RS256 ID tokens are accepted.
+type OIDCProvider struct {
+	name   string
+	cfg    config.OIDCProviderConfig
+	client *http.Client
+	now    func() time.Time
+
+	mu            sync.Mutex
+	discovery     *oidcDiscovery
+	keys          map[string]*rsa.PublicKey
+	keysFetchedAt time.Time
+}
+
+func NewOIDCProvider(name string, cfg config.OIDCProviderConfig, timeout time.Duration) *OIDCProvider {
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = defaultOIDCScopes
+	}
+	return &OIDCProvider{
+		name:   name,
+		cfg:    cfg,
+		client: &http.Client{Timeout: timeout},
+		now:    time.Now,
+	}
+}
+
+func (p *OIDCProvider) Name() string {
+	return p.name
+}
+
+// AllowSignup reports whether users may be created on first login.
+func (p *OIDCProvider) AllowSignup() bool {
+	return p.cfg.AllowSignup
+}
+
+// CodeChallenge returns the S256 PKCE challenge for a code verifier.
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL returns the provider URL to send the user to. state comes
+// back with the code; nonce comes back in the ID token; verifier is kept to
+// exchange the code.
+func (p *OIDCProvider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	u, err := url.Parse(d.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("%w: invalid authorization endpoint: %v", ErrProviderUnavailable, err)
+	}
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", p.cfg.ClientID)
+	q.Set("redirect_uri", p.cfg.RedirectURL)
+	q.Set("scope", strings.Join(p.cfg.Scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", CodeChallenge(verifier))
+	q.Set("code_challenge_method", "S256")
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// Exchange redeems an authorization code and returns the claims of the
+// verified ID token, which must carry nonce.
+func (p *OIDCProvider) Exchange(ctx context.Context, code, verifier, nonce string) (*IDTokenClaims, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.cfg.RedirectURL},
+		"code_verifier": {verifier},
+	}
+	if p.cfg.ClientSecret == "" {
+		form.Set("client_id", p.cfg.ClientID)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrProviderUnavailable, err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.cfg.ClientSecret != "" {
+		// client_secret_basic form-encodes both values first (RFC 6749 2.3.1)
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+
+	var body struct {
+		IDToken string `json:"id_token"`
+		OIDCError
+	}
+	status, err := p.do(req, &body)
+	if err != nil {
+		return nil, err
+	}
+	if body.Code != "" {
+		return nil, &body.OIDCError
+	}
+	if status != http.StatusOK || body.IDToken == "" {
+		return nil, fmt.Errorf("%w: token endpoint answered %d without an ID token", ErrProviderUnavailable, status)
+	}
+	return p.VerifyIDToken(ctx, body.IDToken, nonce)
+}
+
+// VerifyIDToken checks an ID token's signature against the provider's keys
+// and its issuer, audience, expiry and nonce.
+func (p *OIDCProvider) VerifyIDToken(ctx context.Context, token, nonce string) (*IDTokenClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidIDToken
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil || header.Alg != "RS256" {
+		return nil, ErrInvalidIDToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidIDToken
+	}
+
+	key, err := p.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig); err != nil {
+		return nil, ErrInvalidIDToken
+	}
+
+	var claims IDTokenClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, ErrInvalidIDToken
+	}
+	now := p.now()
+	switch {
+	case claims.Issuer != p.cfg.Issuer,
+		claims.Subject == "",
+		!claims.hasAudience(p.cfg.ClientID),
+		claims.AuthorizedParty != "" && claims.AuthorizedParty != p.cfg.ClientID,
+		now.After(time.Unix(claims.ExpiresAt, 0).Add(idTokenLeeway)),
+		time.Unix(claims.IssuedAt, 0).After(now.Add(idTokenLeeway)),
+		subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1:
+		return nil, ErrInvalidIDToken
+	}
+	return &claims, nil
+}
+
+func (c *IDTokenClaims) hasAudience(clientID string) bool {
+	for _, aud := range c.Audience {
+		if aud == clientID {
+			return true
+		}
+	}
+	return false
+}
+
+// discover fetches the discovery document once it is first needed, so a
+// provider that is down doesn't stop the server from starting.
+func (p *OIDCProvider) discover(ctx context.Context) (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	endpoint := strings.TrimSuffix(p.cfg.Issuer, "/") + "/.well-known/openid-configuration"
+	var d oidcDiscovery
+	if err := p.get(ctx, endpoint, &d); err != nil {
+		return nil, err
+	}
+	if d.Issuer != p.cfg.Issuer {
+		return nil, fmt.Errorf("%w: discovery document is for issuer %q", ErrProviderUnavailable, d.Issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, fmt.Errorf("%w: discovery document is missing endpoints", ErrProviderUnavailable)
+	}
+	p.discovery = &d
+	return p.discovery, nil
+}
+
+// key returns the signing key with the given ID, fetching the provider's
+// keys again if it isn't known. A token without a key ID is accepted when
+// the provider has a single key.
+func (p *OIDCProvider) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key := p.lookupKey(kid); key != nil {
+		return key, nil
+	}
+	if !p.keysFetchedAt.IsZero() && p.now().Sub(p.keysFetchedAt) < jwksRefreshInterval {
+		return nil, ErrInvalidIDToken
+	}
+
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			Alg string `json:"alg"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := p.get(ctx, d.JWKSURI, &set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]*rsa.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") || (k.Alg != "" && k.Alg != "RS256") {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(k.N)
+		e, errE := base64.RawURLEncoding.DecodeString(k.E)
+		if errN != nil || errE != nil || len(e) == 0 || len(e) > 4 {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	p.keys = keys
+	p.keysFetchedAt = p.now()
+
+	if key := p.lookupKey(kid); key != nil {
+		return key, nil
+	}
+	return nil, ErrInvalidIDToken
+}
+
+func (p *OIDCProvider) lookupKey(kid string) *rsa.PublicKey {
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key
+		}
+	}
+	return p.keys[kid]
+}
+
+// get fetches a JSON document from the provider.
+func (p *OIDCProvider) get(ctx context.Context, endpoint string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrProviderUnavailable, err)
+	}
+	req.Header.Set("Accept", "application/json")
+	status, err := p.do(req, v)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("%w: %s answered %d", ErrProviderUnavailable, endpoint, status)
+	}
+	return nil
+}
+
+// do sends req and decodes the JSON response into v whatever the status.
+func (p *OIDCProvider) do(req *http.Request, v interface{}) (int, error) {
+	resp, err := p.client.Do(req)
+	if err != nil {
+		if ctxErr := req.Context().Err(); ctxErr != nil {
+			return 0, ctxErr
+		}
+		return 0, fmt.Errorf("%w: %v", ErrProviderUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxProviderResponse)).Decode(v); err != nil && resp.StatusCode == http.StatusOK {
+		return 0, fmt.Errorf("%w: invalid response from %s: %v", ErrProviderUnavailable, req.URL.Redacted(), err)
+	}
+	return resp.StatusCode, nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
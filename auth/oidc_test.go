@@ -0,0 +1,152 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/manuel/make-it-rain/auth/oidctest"
+	"github.com/manuel/make-it-rain/config"
+)
+
+func newTestProvider(mock *oidctest.Provider) *OIDCProvider {
+	return NewOIDCProvider("mock", mock.Config(), time.Second)
+}
+
+func TestOIDCAuthorizationCodeFlow(t *testing.T) {
+	mock := oidctest.NewProvider(t)
+	provider := newTestProvider(mock)
+	ctx := context.Background()
+
+	authURL, err := provider.AuthCodeURL(ctx, "state-1", "nonce-1", "verifier-1")
+	if err != nil {
+		t.Fatalf("AuthCodeURL failed: %v", err)
+	}
+	if !strings.HasPrefix(authURL, mock.Server.URL+"/authorize?") || !strings.Contains(authURL, "state=state-1") {
+		t.Errorf("Expected the discovered authorization endpoint with the state, got %s", authURL)
+	}
+
+	code := mock.Approve(authURL, nil)
+	claims, err := provider.Exchange(ctx, code, "verifier-1", "nonce-1")
+	if err != nil {
+		t.Fatalf("Exchange failed: %v", err)
+	}
+	if claims.Subject != "subject-1" || claims.Email != "ana@example.com" || !bool(claims.EmailVerified) {
+		t.Errorf("Unexpected claims %+v", claims)
+	}
+
+	// Codes are single use
+	var oidcErr *OIDCError
+	if _, err := provider.Exchange(ctx, code, "verifier-1", "nonce-1"); !errors.As(err, &oidcErr) || oidcErr.Code != "invalid_grant" {
+		t.Errorf("Expected invalid_grant for a reused code, got %v", err)
+	}
+}
+
+func TestOIDCExchangeRequiresCodeVerifier(t *testing.T) {
+	mock := oidctest.NewProvider(t)
+	provider := newTestProvider(mock)
+	ctx := context.Background()
+
+	authURL, _ := provider.AuthCodeURL(ctx, "state-1", "nonce-1", "verifier-1")
+	code := mock.Approve(authURL, nil)
+
+	var oidcErr *OIDCError
+	if _, err := provider.Exchange(ctx, code, "someone-elses-verifier", "nonce-1"); !errors.As(err, &oidcErr) || oidcErr.Code != "invalid_grant" {
+		t.Errorf("Expected invalid_grant for the wrong verifier, got %v", err)
+	}
+}
+
+func TestOIDCRejectsInvalidIDTokens(t *testing.T) {
+	mock := oidctest.NewProvider(t)
+	provider := newTestProvider(mock)
+	ctx := context.Background()
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid := mock.IDToken("nonce-1", nil)
+	parts := strings.Split(valid, ".")
+	hs256 := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT","kid":"key-1"}`))
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"wrong nonce", mock.IDToken("nonce-2", nil)},
+		{"wrong audience", mock.IDToken("nonce-1", map[string]interface{}{"aud": "another-client"})},
+		{"wrong authorized party", mock.IDToken("nonce-1", map[string]interface{}{"aud": []string{oidctest.ClientID, "another-client"}, "azp": "another-client"})},
+		{"wrong issuer", mock.IDToken("nonce-1", map[string]interface{}{"iss": "https://evil.example.com"})},
+		{"expired", mock.IDToken("nonce-1", map[string]interface{}{"exp": time.Now().Add(-2 * time.Minute).Unix()})},
+		{"issued in the future", mock.IDToken("nonce-1", map[string]interface{}{"iat": time.Now().Add(time.Hour).Unix()})},
+		{"no subject", mock.IDToken("nonce-1", map[string]interface{}{"sub": ""})},
+		{"signed with another key", oidctest.SignRS256(t, otherKey, map[string]string{"alg": "RS256", "kid": "key-1"}, map[string]interface{}{"iss": mock.Server.URL, "sub": "subject-1", "aud": oidctest.ClientID, "exp": time.Now().Add(time.Minute).Unix(), "nonce": "nonce-1"})},
+		{"tampered payload", parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"admin"}`)) + "." + parts[2]},
+		{"HS256", hs256 + "." + parts[1] + "." + parts[2]},
+		{"unsigned", base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." + parts[1] + "."},
+		{"malformed", "not-a-token"},
+	}
+
+	if _, err := provider.VerifyIDToken(ctx, valid, "nonce-1"); err != nil {
+		t.Fatalf("Expected a valid ID token, got %v", err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := provider.VerifyIDToken(ctx, tt.token, "nonce-1"); err != ErrInvalidIDToken {
+				t.Errorf("Expected ErrInvalidIDToken, got %v", err)
+			}
+		})
+	}
+}
+
+func TestOIDCFetchesRotatedKeys(t *testing.T) {
+	mock := oidctest.NewProvider(t)
+	provider := newTestProvider(mock)
+	now := time.Now()
+	provider.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	if _, err := provider.VerifyIDToken(ctx, mock.IDToken("nonce-1", nil), "nonce-1"); err != nil {
+		t.Fatalf("Expected a valid ID token, got %v", err)
+	}
+
+	// A token naming an unknown key doesn't refetch keys more than once a
+	// minute
+	mock.RotateKey("key-2")
+	rotated := mock.IDToken("nonce-1", nil)
+	if _, err := provider.VerifyIDToken(ctx, rotated, "nonce-1"); err != ErrInvalidIDToken {
+		t.Errorf("Expected the new key to be unknown until the refresh interval, got %v", err)
+	}
+	if mock.JWKSFetches() != 1 {
+		t.Errorf("Expected 1 JWKS fetch, got %d", mock.JWKSFetches())
+	}
+
+	now = now.Add(jwksRefreshInterval)
+	if _, err := provider.VerifyIDToken(ctx, rotated, "nonce-1"); err != nil {
+		t.Errorf("Expected a token signed with the rotated key to verify, got %v", err)
+	}
+	if mock.JWKSFetches() != 2 {
+		t.Errorf("Expected 2 JWKS fetches, got %d", mock.JWKSFetches())
+	}
+}
+
+func TestOIDCRejectsMismatchedIssuer(t *testing.T) {
+	mock := oidctest.NewProvider(t)
+	provider := NewOIDCProvider("mock", config.OIDCProviderConfig{
+		Issuer:      mock.Server.URL + "/other",
+		ClientID:    oidctest.ClientID,
+		RedirectURL: oidctest.RedirectURL,
+	}, time.Second)
+
+	// The discovery document is served for the mock's own issuer only
+	mock.Server.Config.Handler = http.StripPrefix("/other", mock.Server.Config.Handler)
+	if _, err := provider.AuthCodeURL(context.Background(), "state", "nonce", "verifier"); !errors.Is(err, ErrProviderUnavailable) {
+		t.Errorf("Expected ErrProviderUnavailable, got %v", err)
+	}
+}
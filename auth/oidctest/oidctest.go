@@ -0,0 +1,203 @@
+// Package oidctest runs an OpenID Connect provider in-process for tests of
+// the login flow. It doesn't import auth, so auth's own tests can use it.
+package oidctest
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/manuel/make-it-rain/config"
+)
+
+const (
+	ClientID     = "make-it-rain"
+	ClientSecret = "client-secret"
+	RedirectURL  = "https://app.example.com/login/callback"
+)
+
+// Provider is an OpenID Connect provider serving discovery, JWKS and token
+// endpoints. Approve plays the part of the user consenting at the
+// authorization endpoint.
+type Provider struct {
+	Server *httptest.Server
+
+	t           testing.TB
+	mu          sync.Mutex
+	key         *rsa.PrivateKey
+	kid         string
+	grants      map[string]grant
+	jwksFetches int
+}
+
+type grant struct {
+	challenge   string
+	nonce       string
+	redirectURI string
+	claims      map[string]interface{}
+}
+
+// NewProvider starts a provider that is shut down when the test ends.
+func NewProvider(t testing.TB) *Provider {
+	t.Helper()
+	p := &Provider{t: t, grants: make(map[string]grant)}
+	p.RotateKey("key-1")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 p.Server.URL,
+			"authorization_endpoint": p.Server.URL + "/authorize",
+			"token_endpoint":         p.Server.URL + "/token",
+			"jwks_uri":               p.Server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", p.handleJWKS)
+	mux.HandleFunc("/token", p.handleToken)
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Server.Close)
+	return p
+}
+
+// Config returns the client configuration for this provider.
+func (p *Provider) Config() config.OIDCProviderConfig {
+	return config.OIDCProviderConfig{
+		Issuer:       p.Server.URL,
+		ClientID:     ClientID,
+		ClientSecret: ClientSecret,
+		RedirectURL:  RedirectURL,
+	}
+}
+
+// RotateKey starts signing with a new key named kid.
+func (p *Provider) RotateKey(kid string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.key, p.kid = key, kid
+}
+
+// JWKSFetches returns how many times the keys were fetched.
+func (p *Provider) JWKSFetches() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.jwksFetches
+}
+
+// Approve returns a code for the login started at authURL. The ID token
+// issued for it has the default claims with overrides replacing them.
+func (p *Provider) Approve(authURL string, overrides map[string]interface{}) string {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("client_id") != ClientID || q.Get("response_type") != "code" || q.Get("code_challenge_method") != "S256" {
+		p.t.Fatalf("Unexpected authorization request %s", authURL)
+	}
+	b := make([]byte, 32)
+	rand.Read(b)
+	code := base64.RawURLEncoding.EncodeToString(b)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.grants[code] = grant{
+		challenge:   q.Get("code_challenge"),
+		nonce:       q.Get("nonce"),
+		redirectURI: q.Get("redirect_uri"),
+		claims:      overrides,
+	}
+	return code
+}
+
+func (p *Provider) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.jwksFetches++
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": p.kid,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(p.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()),
+		}},
+	})
+}
+
+func (p *Provider) handleToken(w http.ResponseWriter, r *http.Request) {
+	id, secret, _ := r.BasicAuth()
+	if id != ClientID || secret != ClientSecret {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	p.mu.Lock()
+	g, ok := p.grants[r.PostFormValue("code")]
+	delete(p.grants, r.PostFormValue("code"))
+	p.mu.Unlock()
+	sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if !ok || r.PostFormValue("redirect_uri") != g.redirectURI || base64.RawURLEncoding.EncodeToString(sum[:]) != g.challenge {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{
+		"access_token": "provider-access-token",
+		"token_type":   "Bearer",
+		"id_token":     p.IDToken(g.nonce, g.claims),
+	})
+}
+
+// IDToken signs an ID token for "subject-1", ana@example.com, with the
+// current key; overrides replace claims.
+func (p *Provider) IDToken(nonce string, overrides map[string]interface{}) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	claims := map[string]interface{}{
+		"iss":            p.Server.URL,
+		"sub":            "subject-1",
+		"aud":            ClientID,
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"iat":            now.Unix(),
+		"nonce":          nonce,
+		"email":          "ana@example.com",
+		"email_verified": true,
+		"name":           "Ana",
+	}
+	for k, v := range overrides {
+		claims[k] = v
+	}
+	return SignRS256(p.t, p.key, map[string]string{"alg": "RS256", "typ": "JWT", "kid": p.kid}, claims)
+}
+
+// SignRS256 returns a JWT with header and claims signed by key.
+func SignRS256(t testing.TB, key *rsa.PrivateKey, header map[string]string, claims map[string]interface{}) string {
+	t.Helper()
+	h, _ := json.Marshal(header)
+	c, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/manuel/make-it-rain/data_structures"
+	"github.com/manuel/make-it-rain/db"
+	"github.com/manuel/make-it-rain/logging"
+	"github.com/manuel/make-it-rain/pubsub"
+)
+
+// maxCachedSessions bounds the live session cache.
+const maxCachedSessions = 10000
+
+// Sessions checks that the session an access token was issued from is still
+// live, so revoking a session or deactivating its user takes effect before
+// the token expires. Live sessions are cached for ttl; revocations on any
+// replica drop them through HandleRevocation.
+type Sessions struct {
+	store db.SessionStore
+	ttl   time.Duration
+	// live maps a session ID to when it was last found live
+	live    *data_structures.LRUCacheWithTTL[int64, time.Time]
+	version atomic.Uint64
+	now     func() time.Time
+}
+
+func NewSessions(store db.SessionStore, ttl time.Duration) *Sessions {
+	return &Sessions{
+		store: store,
+		ttl:   ttl,
+		live:  data_structures.NewLRUCacheWithTTL[int64, time.Time](maxCachedSessions, ttl),
+		now:   time.Now,
+	}
+}
+
+// CheckSession returns ErrInvalidToken unless the session is live.
+func (s *Sessions) CheckSession(ctx context.Context, sessionID int64) error {
+	now := s.now()
+	// The cache's own TTL slides on every hit, so the time it was found
+	// live is checked here
+	if checked, ok := s.live.Get(sessionID); ok && now.Sub(checked) < s.ttl {
+		return nil
+	}
+
+	version := s.version.Load()
+	if err := s.store.SessionActive(ctx, sessionID); err != nil {
+		if errors.Is(err, db.ErrTokenInvalid) {
+			return ErrInvalidToken
+		}
+		return err
+	}
+	// A revocation that arrived during the query may be for this session
+	if s.version.Load() == version {
+		s.live.Put(sessionID, now)
+	}
+	return nil
+}
+
+// HandleRevocation drops revoked sessions. A revocation of all of a user's
+// sessions drops the whole cache, since it isn't indexed by user; those are
+// rare. It is subscribed to db.SessionsChannel.
+func (s *Sessions) HandleRevocation(ctx context.Context, msg pubsub.Message) {
+	var revocation db.SessionRevocation
+	if err := msg.Decode(&revocation); err != nil {
+		logging.Ctx(ctx, logging.Services).Warn().Err(err).Msg("Ignoring malformed session revocation")
+		s.InvalidateAll()
+		return
+	}
+	s.version.Add(1)
+	if revocation.SessionID != 0 {
+		s.live.Delete(revocation.SessionID)
+		return
+	}
+	s.live.Clear()
+}
+
+// InvalidateAll drops every cached session, for when revocations may have
+// been missed.
+func (s *Sessions) InvalidateAll() {
+	s.version.Add(1)
+	s.live.Clear()
+}
@@ -0,0 +1,108 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/manuel/make-it-rain/db"
+	"github.com/manuel/make-it-rain/pubsub"
+)
+
+// memorySessionStore answers SessionActive from a set of live session IDs.
+type memorySessionStore struct {
+	db.SessionStore
+	live   map[int64]bool
+	checks int
+	// during runs inside SessionActive, to simulate a concurrent revocation
+	during func()
+}
+
+func (s *memorySessionStore) SessionActive(ctx context.Context, sessionID int64) error {
+	s.checks++
+	live := s.live[sessionID]
+	if s.during != nil {
+		s.during()
+	}
+	if !live {
+		return db.ErrTokenInvalid
+	}
+	return nil
+}
+
+func revocation(userID, sessionID int64) pubsub.Message {
+	payload := fmt.Sprintf(`{"user_id":%d,"session_id":%d}`, userID, sessionID)
+	return pubsub.Message{Channel: db.SessionsChannel, Payload: payload}
+}
+
+func TestSessionsCachesLiveSessions(t *testing.T) {
+	now := time.Date(2024, time.March, 1, 10, 0, 0, 0, time.UTC)
+	store := &memorySessionStore{live: map[int64]bool{7: true}}
+	sessions := NewSessions(store, time.Minute)
+	sessions.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if err := sessions.CheckSession(ctx, 7); err != nil {
+			t.Fatalf("Expected session 7 to be live, got %v", err)
+		}
+	}
+	if store.checks != 1 {
+		t.Errorf("Expected 1 check, got %d", store.checks)
+	}
+
+	now = now.Add(time.Minute)
+	sessions.CheckSession(ctx, 7)
+	if store.checks != 2 {
+		t.Errorf("Expected a new check after the cache TTL, got %d checks", store.checks)
+	}
+
+	if err := sessions.CheckSession(ctx, 8); err != ErrInvalidToken {
+		t.Errorf("Expected an unknown session to be refused, got %v", err)
+	}
+}
+
+func TestSessionsHandleRevocation(t *testing.T) {
+	store := &memorySessionStore{live: map[int64]bool{7: true, 8: true}}
+	sessions := NewSessions(store, time.Minute)
+	ctx := context.Background()
+	sessions.CheckSession(ctx, 7)
+	sessions.CheckSession(ctx, 8)
+
+	store.live[7] = false
+	sessions.HandleRevocation(ctx, revocation(1, 7))
+	if err := sessions.CheckSession(ctx, 7); err != ErrInvalidToken {
+		t.Errorf("Expected revoked session 7 to be refused, got %v", err)
+	}
+	if err := sessions.CheckSession(ctx, 8); err != nil || store.checks != 3 {
+		t.Errorf("Expected session 8 to stay cached, got %v after %d checks", err, store.checks)
+	}
+
+	// Revoking all of a user's sessions drops everything
+	store.live[8] = false
+	sessions.HandleRevocation(ctx, revocation(1, 0))
+	if err := sessions.CheckSession(ctx, 8); err != ErrInvalidToken {
+		t.Errorf("Expected session 8 to be refused after revoking all sessions, got %v", err)
+	}
+}
+
+func TestSessionsDoesNotCacheAcrossRevocation(t *testing.T) {
+	store := &memorySessionStore{live: map[int64]bool{7: true}}
+	sessions := NewSessions(store, time.Minute)
+	ctx := context.Background()
+
+	// The session is found live, but revoked before the result is cached
+	store.during = func() {
+		store.live[7] = false
+		sessions.HandleRevocation(ctx, revocation(1, 7))
+	}
+	if err := sessions.CheckSession(ctx, 7); err != nil {
+		t.Fatalf("Expected the first check to pass, got %v", err)
+	}
+	store.during = nil
+
+	if err := sessions.CheckSession(ctx, 7); err != ErrInvalidToken {
+		t.Errorf("Expected the revoked session not to be served from the cache, got %v", err)
+	}
+}
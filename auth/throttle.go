@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"sync"
+	"time"
+
+	"github.com/manuel/make-it-rain/data_structures"
+)
+
+// maxThrottledKeys bounds the throttle table; the least recently seen keys
+// are evicted first.
+const maxThrottledKeys = 10000
+
+// Throttle allows limit events per key in a sliding window, e.g. emails sent
+// per address or per client IP. It is per process.
+type Throttle struct {
+	limit    int
+	window   time.Duration
+	counters *data_structures.LRUCache[string, *data_structures.SlidingWindowCounter]
+	mu       sync.Mutex
+}
+
+func NewThrottle(limit int, window time.Duration) *Throttle {
+	return &Throttle{
+		limit:    limit,
+		window:   window,
+		counters: data_structures.NewLRUCache[string, *data_structures.SlidingWindowCounter](maxThrottledKeys),
+	}
+}
+
+// Allow records an event for each key that is under the limit and reports
+// whether all of them were. Every key is checked even after one is over, so
+// the counts don't depend on argument order.
+func (t *Throttle) Allow(keys ...string) bool {
+	allowed := true
+	for _, key := range keys {
+		if !t.counter(key).Allow() {
+			allowed = false
+		}
+	}
+	return allowed
+}
+
+// Count returns the number of events recorded for key in the window.
+func (t *Throttle) Count(key string) int {
+	t.mu.Lock()
+	counter, ok := t.counters.Get(key)
+	t.mu.Unlock()
+	if !ok {
+		return 0
+	}
+	return counter.Count()
+}
+
+// Reset forgets the events recorded for key.
+func (t *Throttle) Reset(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.counters.Delete(key)
+}
+
+func (t *Throttle) counter(key string) *data_structures.SlidingWindowCounter {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	counter, ok := t.counters.Get(key)
+	if !ok {
+		counter = data_structures.NewSlidingWindowCounter(t.window, t.limit)
+		t.counters.Put(key, counter)
+	}
+	return counter
+}
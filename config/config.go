@@ -1,17 +1,40 @@
 package config
 
 import (
+	"context"
 	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/manuel/make-it-rain/secrets"
+
 	"github.com/spf13/viper"
 )
 
 type Config struct {
-	Server   ServerConfig
-	Database DatabaseConfig
-	JWT      JWTConfig
-	App      AppConfig
+	Server      ServerConfig
+	Database    DatabaseConfig
+	JWT         JWTConfig
+	App         AppConfig
+	Admin       AdminConfig
+	Tracing     TracingConfig
+	Log         LogConfig
+	CORS        CORSConfig
+	Secrets     SecretsConfig
+	Security    SecurityConfig
+	Idempotency IdempotencyConfig
+	Cache       CacheConfig
+	Outbox      OutboxConfig
+	Webhooks    WebhooksConfig
+	Jobs        JobsConfig
+	Scheduler   SchedulerConfig
+	Mail        MailConfig
+	Auth        AuthConfig
+	Features    map[string]bool `mapstructure:"features"`
 }
 
 type ServerConfig struct {
@@ -26,7 +49,7 @@ type DatabaseConfig struct {
 	Host            string        `mapstructure:"host"`
 	Port            int           `mapstructure:"port"`
 	User            string        `mapstructure:"user"`
-	Password        string        `mapstructure:"password"`
+	Password        string        `mapstructure:"password" secret:"true"`
 	Name            string        `mapstructure:"name"`
 	SSLMode         string        `mapstructure:"ssl_mode"`
 	MaxConnections  int           `mapstructure:"max_connections"`
@@ -36,92 +59,731 @@ type DatabaseConfig struct {
 }
 
 type JWTConfig struct {
-	SecretKey       string        `mapstructure:"secret_key"`
+	SecretKey       string        `mapstructure:"secret_key" secret:"true"`
 	ExpiryDuration  time.Duration `mapstructure:"expiry_duration"`
 	RefreshDuration time.Duration `mapstructure:"refresh_duration"`
 }
 
 type AppConfig struct {
-	Name        string `mapstructure:"name"`
-	Version     string `mapstructure:"version"`
-	LogLevel    string `mapstructure:"log_level"`
+	Name         string `mapstructure:"name"`
+	Version      string `mapstructure:"version"`
+	LogLevel     string `mapstructure:"log_level"`
 	RateLimitRPS int    `mapstructure:"rate_limit_rps"`
 }
 
+type AdminConfig struct {
+	Port  string `mapstructure:"port"`
+	Token string `mapstructure:"token" secret:"true"`
+}
+
+type TracingConfig struct {
+	Enabled      bool    `mapstructure:"enabled"`
+	Exporter     string  `mapstructure:"exporter"`
+	OTLPEndpoint string  `mapstructure:"otlp_endpoint"`
+	FilePath     string  `mapstructure:"file_path"`
+	SampleRatio  float64 `mapstructure:"sample_ratio"`
+}
+
+// LogConfig controls the access log written by middleware.Logger.
+type LogConfig struct {
+	RedactQueryParams  []string `mapstructure:"redact_query_params"`
+	RedactHeaders      []string `mapstructure:"redact_headers"`
+	RedactBodyFields   []string `mapstructure:"redact_body_fields"`
+	AccessLogFields    []string `mapstructure:"access_log_fields"`
+	SuccessSampleEvery int      `mapstructure:"success_sample_every"`
+	SkipPaths          []string `mapstructure:"skip_paths"`
+	// ComponentLevels overrides APP_LOG_LEVEL per component, e.g. "db=debug,http=warn"
+	ComponentLevels     string        `mapstructure:"component_levels"`
+	SignalDebugDuration time.Duration `mapstructure:"signal_debug_duration"`
+}
+
+// CORSPolicyConfig describes one CORS policy. Origins are exact
+// ("https://app.example.com"), wildcard subdomains ("https://*.example.com")
+// or "*" for any origin; credentials are never allowed for "*".
+type CORSPolicyConfig struct {
+	AllowedOrigins   []string      `mapstructure:"allowed_origins"`
+	AllowedMethods   []string      `mapstructure:"allowed_methods"`
+	AllowedHeaders   []string      `mapstructure:"allowed_headers"`
+	ExposedHeaders   []string      `mapstructure:"exposed_headers"`
+	AllowCredentials bool          `mapstructure:"allow_credentials"`
+	MaxAge           time.Duration `mapstructure:"max_age"`
+}
+
+// CORSRoutePolicy overrides the default policy for a route group. Empty
+// lists and a zero MaxAge inherit the default policy's values.
+type CORSRoutePolicy struct {
+	PathPrefix       string `mapstructure:"path_prefix"`
+	CORSPolicyConfig `mapstructure:",squash"`
+}
+
+type CORSConfig struct {
+	CORSPolicyConfig `mapstructure:",squash"`
+	RoutePolicies    []CORSRoutePolicy `mapstructure:"route_policies"`
+}
+
+// SecretsConfig selects where secrets come from in addition to plain
+// environment variables and their *_FILE variants.
+type SecretsConfig struct {
+	// Provider is "env" (default) or "encrypted_file"
+	Provider string `mapstructure:"provider"`
+	File     string `mapstructure:"file"`
+	// Key is the base64 AES-256 key for the encrypted file (SECRETS_KEY or SECRETS_KEY_FILE)
+	Key string `mapstructure:"key" secret:"true"`
+}
+
+type SecurityConfig struct {
+	// HSTSMaxAge is sent as Strict-Transport-Security on HTTPS requests; 0 disables it
+	HSTSMaxAge   time.Duration `mapstructure:"hsts_max_age"`
+	FrameOptions string        `mapstructure:"frame_options"`
+	// ContentSecurityPolicy applies to the SPA; APIContentSecurityPolicy to /api responses
+	ContentSecurityPolicy    string        `mapstructure:"content_security_policy"`
+	APIContentSecurityPolicy string        `mapstructure:"api_content_security_policy"`
+	RequestTimeout           time.Duration `mapstructure:"request_timeout"`
+	MaxBodyBytes             int64         `mapstructure:"max_body_bytes"`
+	// Per-route overrides keyed by "METHOD /route/:template"
+	RouteTimeouts   map[string]time.Duration `mapstructure:"route_timeouts"`
+	RouteBodyLimits map[string]int64         `mapstructure:"route_body_limits"`
+}
+
+type IdempotencyConfig struct {
+	// TTL is how long a completed response is replayed for a key
+	TTL time.Duration `mapstructure:"ttl"`
+	// LockTimeout after which an unfinished request's key can be claimed again
+	LockTimeout time.Duration `mapstructure:"lock_timeout"`
+	CacheSize   int           `mapstructure:"cache_size"`
+}
+
+type CacheConfig struct {
+	Users CachePolicyConfig `mapstructure:"users"`
+}
+
+type CachePolicyConfig struct {
+	Enabled  bool          `mapstructure:"enabled"`
+	Capacity int           `mapstructure:"capacity"`
+	TTL      time.Duration `mapstructure:"ttl"`
+}
+
+type OutboxConfig struct {
+	PollInterval time.Duration `mapstructure:"poll_interval"`
+	BatchSize    int           `mapstructure:"batch_size"`
+	// LockTimeout bounds one delivery; a claimed event is retried by another
+	// dispatcher after it passes
+	LockTimeout     time.Duration `mapstructure:"lock_timeout"`
+	MaxAttempts     int           `mapstructure:"max_attempts"`
+	RetryBackoff    time.Duration `mapstructure:"retry_backoff"`
+	MaxRetryBackoff time.Duration `mapstructure:"max_retry_backoff"`
+}
+
+type WebhooksConfig struct {
+	PollInterval    time.Duration `mapstructure:"poll_interval"`
+	BatchSize       int           `mapstructure:"batch_size"`
+	Concurrency     int           `mapstructure:"concurrency"`
+	Timeout         time.Duration `mapstructure:"timeout"`
+	UserAgent       string        `mapstructure:"user_agent"`
+	MaxAttempts     int           `mapstructure:"max_attempts"`
+	RetryBackoff    time.Duration `mapstructure:"retry_backoff"`
+	MaxRetryBackoff time.Duration `mapstructure:"max_retry_backoff"`
+	// Consecutive failures that open an endpoint's circuit, and how long it
+	// stays open before a trial request
+	BreakerFailures int           `mapstructure:"breaker_failures"`
+	BreakerReset    time.Duration `mapstructure:"breaker_reset"`
+}
+
+type JobsConfig struct {
+	Workers      int           `mapstructure:"workers"`
+	PollInterval time.Duration `mapstructure:"poll_interval"`
+	// Timeout bounds one run of a job; a running job whose worker died is
+	// claimed again once it passes
+	Timeout         time.Duration `mapstructure:"timeout"`
+	MaxAttempts     int           `mapstructure:"max_attempts"`
+	RetryBackoff    time.Duration `mapstructure:"retry_backoff"`
+	MaxRetryBackoff time.Duration `mapstructure:"max_retry_backoff"`
+}
+
+type SchedulerConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// LockKey identifies the advisory lock replicas compete for to lead
+	LockKey             int64         `mapstructure:"lock_key"`
+	LeaderCheckInterval time.Duration `mapstructure:"leader_check_interval"`
+	TaskTimeout         time.Duration `mapstructure:"task_timeout"`
+	PurgeBatchSize      int           `mapstructure:"purge_batch_size"`
+	// Tasks overrides a task's cron schedule by name; "off" disables it
+	Tasks map[string]string `mapstructure:"tasks"`
+}
+
+type MailConfig struct {
+	// Driver is "smtp", "file" (writes .eml files to FileDir) or "memory"
+	Driver        string     `mapstructure:"driver"`
+	From          string     `mapstructure:"from"`
+	DefaultLocale string     `mapstructure:"default_locale"`
+	FileDir       string     `mapstructure:"file_dir"`
+	SMTP          SMTPConfig `mapstructure:"smtp"`
+}
+
+type SMTPConfig struct {
+	Host     string `mapstructure:"host"`
+	Port     int    `mapstructure:"port"`
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password" secret:"true"`
+	// TLS is "starttls", "tls" (implicit, usually port 465) or "none"
+	TLS     string        `mapstructure:"tls"`
+	Timeout time.Duration `mapstructure:"timeout"`
+}
+
+type AuthConfig struct {
+	// RequireVerifiedEmail rejects logins until the address is verified
+	RequireVerifiedEmail bool          `mapstructure:"require_verified_email"`
+	VerificationTTL      time.Duration `mapstructure:"verification_ttl"`
+	// VerifyEmailURL is the page the emailed link opens; the token is
+	// appended as the "token" query parameter
+	VerifyEmailURL   string        `mapstructure:"verify_email_url"`
+	PasswordResetTTL time.Duration `mapstructure:"password_reset_ttl"`
+	// ResetPasswordURL is the page the password reset link opens
+	ResetPasswordURL string `mapstructure:"reset_password_url"`
+	// ResendLimit verification or password reset emails per address and per
+	// IP in ResendWindow
+	ResendLimit  int           `mapstructure:"resend_limit"`
+	ResendWindow time.Duration `mapstructure:"resend_window"`
+	// SessionCacheTTL is how long a session stays trusted before a request
+	// using it is checked against the database again. Revocations reach
+	// every instance at once; this only bounds a missed notification.
+	SessionCacheTTL time.Duration `mapstructure:"session_cache_ttl"`
+	Lockout         LockoutConfig `mapstructure:"lockout"`
+	MFA             MFAConfig     `mapstructure:"mfa"`
+	APIKeys         APIKeysConfig `mapstructure:"api_keys"`
+	OIDC            OIDCConfig    `mapstructure:"oidc"`
+}
+
+// OIDCConfig configures login through external OpenID Connect providers.
+type OIDCConfig struct {
+	// LoginTTL is how long a user has to finish logging in at the provider
+	LoginTTL time.Duration `mapstructure:"login_ttl"`
+	// Timeout applies to each discovery, token and JWKS request
+	Timeout time.Duration `mapstructure:"timeout"`
+	// Providers are keyed by the name used in their login URLs
+	Providers map[string]OIDCProviderConfig `mapstructure:"providers"`
+}
+
+// OIDCProviderConfig configures one OpenID Connect provider. Its endpoints
+// are discovered from Issuer.
+type OIDCProviderConfig struct {
+	Issuer   string `mapstructure:"issuer"`
+	ClientID string `mapstructure:"client_id"`
+	// ClientSecret may be empty for a public client, which relies on PKCE
+	// alone. It can also be set as AUTH_OIDC_<NAME>_CLIENT_SECRET.
+	ClientSecret string `mapstructure:"client_secret" secret:"true"`
+	// RedirectURL is the page the provider sends the user back to, which
+	// posts the code and state to the callback endpoint
+	RedirectURL string `mapstructure:"redirect_url"`
+	// Scopes default to openid, email and profile
+	Scopes []string `mapstructure:"scopes"`
+	// AllowSignup creates a user on first login when no account has the
+	// provider's verified email
+	AllowSignup bool `mapstructure:"allow_signup"`
+}
+
+// SecretEnv returns the environment variable that holds a provider's client
+// secret, e.g. AUTH_OIDC_COMPANY_SSO_CLIENT_SECRET for "company-sso".
+func (c OIDCConfig) SecretEnv(name string) string {
+	return "AUTH_OIDC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_CLIENT_SECRET"
+}
+
+// APIKeysConfig configures personal API keys.
+type APIKeysConfig struct {
+	// DefaultTTL applies when a key is created without an expiry; MaxTTL
+	// caps the expiry a user can ask for
+	DefaultTTL time.Duration `mapstructure:"default_ttl"`
+	MaxTTL     time.Duration `mapstructure:"max_ttl"`
+	MaxPerUser int           `mapstructure:"max_per_user"`
+	// CacheTTL is how long a resolved key is trusted before it is looked up
+	// again, which also bounds how often last_used_at is written
+	CacheTTL time.Duration `mapstructure:"cache_ttl"`
+	// Tiers maps rate limit tier names to requests per second per key
+	Tiers       map[string]int `mapstructure:"tiers"`
+	DefaultTier string         `mapstructure:"default_tier"`
+}
+
+// MFAConfig configures TOTP two-factor authentication.
+type MFAConfig struct {
+	// Issuer is shown in authenticator apps; defaults to app.name
+	Issuer string `mapstructure:"issuer"`
+	// EncryptionKey is a base64 AES-256 key that encrypts TOTP secrets at
+	// rest. Outside production it defaults to one derived from
+	// jwt.secret_key.
+	EncryptionKey string `mapstructure:"encryption_key" secret:"true"`
+	// RequiredRoles must use a second factor; their users enroll at login
+	RequiredRoles []string      `mapstructure:"required_roles"`
+	ChallengeTTL  time.Duration `mapstructure:"challenge_ttl"`
+	// Skew is how many 30-second steps either side of now a code is accepted
+	Skew          int `mapstructure:"skew"`
+	RecoveryCodes int `mapstructure:"recovery_codes"`
+}
+
+// LockoutConfig slows down and then stops repeated failed logins. Failures
+// are counted per account and per client IP within Window.
+type LockoutConfig struct {
+	Window time.Duration `mapstructure:"window"`
+	// From the DelayAfter-th failure on, each failure makes the account wait
+	// BaseDelay, doubling up to MaxDelay, before the next attempt
+	DelayAfter int           `mapstructure:"delay_after"`
+	BaseDelay  time.Duration `mapstructure:"base_delay"`
+	MaxDelay   time.Duration `mapstructure:"max_delay"`
+	// Threshold failures lock the account for Duration
+	Threshold int           `mapstructure:"threshold"`
+	Duration  time.Duration `mapstructure:"duration"`
+	// IPThreshold failures from one IP, across accounts, lock the IP
+	IPThreshold int `mapstructure:"ip_threshold"`
+	// MinResponseTime pads every login response so timing doesn't reveal
+	// whether an account exists
+	MinResponseTime time.Duration `mapstructure:"min_response_time"`
+}
+
+// DefaultLogConfig is also used by middleware.Logger when Cfg is not loaded.
+var DefaultLogConfig = LogConfig{
+	RedactQueryParams:  []string{"token", "access_token", "refresh_token", "password", "email", "code", "secret", "api_key"},
+	RedactHeaders:      []string{"Authorization", "Cookie", "Set-Cookie", "X-Api-Key", "Idempotency-Key"},
+	RedactBodyFields:   []string{"password", "current_password", "new_password", "token", "refresh_token", "mfa_token", "secret", "code"},
+	AccessLogFields:    []string{"user_id", "response_size", "user_agent", "route"},
+	SuccessSampleEvery: 1,
+	SkipPaths:          []string{"/health", "/ready"},
+}
+
 var Cfg *Config
 
+// SecretStore resolves secrets at runtime, e.g. the database password for
+// each new connection. It is set by LoadConfig.
+var SecretStore secrets.Provider = secrets.FileEnvProvider{}
+
+// LoadConfig builds Cfg from, in increasing order of precedence: built-in
+// defaults, config.{yaml,yml,toml,json} in path, the profile file
+// config.<environment>.* and environment variables.
 func LoadConfig(path string) error {
-	viper.SetConfigName(".env")
-	viper.SetConfigType("env")
-	viper.AddConfigPath(path)
-	viper.AddConfigPath(".")
-
-	viper.SetDefault("server.port", "8080")
-	viper.SetDefault("server.environment", "development")
-	viper.SetDefault("server.read_timeout", 10*time.Second)
-	viper.SetDefault("server.write_timeout", 10*time.Second)
-	viper.SetDefault("server.shutdown_timeout", 10*time.Second)
-
-	viper.SetDefault("database.host", "localhost")
-	viper.SetDefault("database.port", 5432)
-	viper.SetDefault("database.ssl_mode", "disable")
-	viper.SetDefault("database.max_connections", 20)
-	viper.SetDefault("database.min_connections", 2)
-	viper.SetDefault("database.max_conn_lifetime", 1*time.Hour)
-	viper.SetDefault("database.max_conn_idle_time", 30*time.Minute)
-
-	viper.SetDefault("jwt.expiry_duration", 24*time.Hour)
-	viper.SetDefault("jwt.refresh_duration", 7*24*time.Hour)
-
-	viper.SetDefault("app.name", "Make It Rain API")
-	viper.SetDefault("app.version", "1.0.0")
-	viper.SetDefault("app.log_level", "info")
-	viper.SetDefault("app.rate_limit_rps", 100)
-
-	viper.AutomaticEnv()
-
-	// Explicitly bind environment variables
-	viper.BindEnv("server.port", "SERVER_PORT")
-	viper.BindEnv("server.environment", "SERVER_ENVIRONMENT")
-	viper.BindEnv("server.read_timeout", "SERVER_READ_TIMEOUT")
-	viper.BindEnv("server.write_timeout", "SERVER_WRITE_TIMEOUT")
-	viper.BindEnv("server.shutdown_timeout", "SERVER_SHUTDOWN_TIMEOUT")
-
-	viper.BindEnv("database.host", "DATABASE_HOST")
-	viper.BindEnv("database.port", "DATABASE_PORT")
-	viper.BindEnv("database.user", "DATABASE_USER")
-	viper.BindEnv("database.password", "DATABASE_PASSWORD")
-	viper.BindEnv("database.name", "DATABASE_NAME")
-	viper.BindEnv("database.ssl_mode", "DATABASE_SSL_MODE")
-	viper.BindEnv("database.max_connections", "DATABASE_MAX_CONNECTIONS")
-	viper.BindEnv("database.min_connections", "DATABASE_MIN_CONNECTIONS")
-	viper.BindEnv("database.max_conn_lifetime", "DATABASE_MAX_CONN_LIFETIME")
-	viper.BindEnv("database.max_conn_idle_time", "DATABASE_MAX_CONN_IDLE_TIME")
-
-	viper.BindEnv("jwt.secret_key", "JWT_SECRET_KEY")
-	viper.BindEnv("jwt.expiry_duration", "JWT_EXPIRY_DURATION")
-	viper.BindEnv("jwt.refresh_duration", "JWT_REFRESH_DURATION")
-
-	viper.BindEnv("app.name", "APP_NAME")
-	viper.BindEnv("app.version", "APP_VERSION")
-	viper.BindEnv("app.log_level", "APP_LOG_LEVEL")
-	viper.BindEnv("app.rate_limit_rps", "APP_RATE_LIMIT_RPS")
-
-	if err := viper.ReadInConfig(); err != nil {
+	cfg, err := Load(path)
+	if err != nil {
+		return err
+	}
+
+	store, err := cfg.secretProvider()
+	if err != nil {
+		return err
+	}
+
+	Cfg = cfg
+	SecretStore = store
+	current.Store(cfg)
+	return nil
+}
+
+// Load reads the layered configuration without touching Cfg.
+func Load(path string) (*Config, error) {
+	v := viper.New()
+	v.AddConfigPath(path)
+	v.AddConfigPath(".")
+
+	setDefaults(v)
+	v.AutomaticEnv()
+	bindEnv(v)
+
+	if err := readConfigFiles(v); err != nil {
+		return nil, err
+	}
+
+	cfg := &Config{}
+	if err := v.Unmarshal(cfg); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
+	}
+
+	if err := cfg.resolveSecrets(context.Background()); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// readConfigFiles reads the base file and merges the profile for the
+// configured environment on top of it. Both files are optional.
+func readConfigFiles(v *viper.Viper) error {
+	v.SetConfigName("config")
+	if err := v.ReadInConfig(); err != nil {
 		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
 			return fmt.Errorf("failed to read config file: %w", err)
 		}
 	}
 
-	Cfg = &Config{}
-	if err := viper.Unmarshal(Cfg); err != nil {
-		return fmt.Errorf("failed to unmarshal config: %w", err)
+	// The environment may come from the base file or SERVER_ENVIRONMENT
+	env := v.GetString("server.environment")
+	v.SetConfigName("config." + env)
+	if err := v.MergeInConfig(); err != nil {
+		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
+			return fmt.Errorf("failed to read %s config file: %w", env, err)
+		}
 	}
 
 	return nil
 }
 
+func setDefaults(v *viper.Viper) {
+	v.SetDefault("server.port", "8080")
+	v.SetDefault("server.environment", "development")
+	v.SetDefault("server.read_timeout", 10*time.Second)
+	v.SetDefault("server.write_timeout", 10*time.Second)
+	v.SetDefault("server.shutdown_timeout", 10*time.Second)
+
+	v.SetDefault("database.host", "localhost")
+	v.SetDefault("database.port", 5432)
+	v.SetDefault("database.ssl_mode", "disable")
+	v.SetDefault("database.max_connections", 20)
+	v.SetDefault("database.min_connections", 2)
+	v.SetDefault("database.max_conn_lifetime", 1*time.Hour)
+	v.SetDefault("database.max_conn_idle_time", 30*time.Minute)
+
+	v.SetDefault("jwt.expiry_duration", 24*time.Hour)
+	v.SetDefault("jwt.refresh_duration", 7*24*time.Hour)
+
+	v.SetDefault("app.name", "Make It Rain API")
+	v.SetDefault("app.version", "1.0.0")
+	v.SetDefault("app.log_level", "info")
+	v.SetDefault("app.rate_limit_rps", 100)
+
+	v.SetDefault("admin.port", "9090")
+
+	v.SetDefault("tracing.enabled", false)
+	v.SetDefault("tracing.exporter", "otlp")
+	v.SetDefault("tracing.otlp_endpoint", "http://localhost:4318")
+	v.SetDefault("tracing.file_path", "traces.json")
+	v.SetDefault("tracing.sample_ratio", 1.0)
+
+	v.SetDefault("log.redact_query_params", DefaultLogConfig.RedactQueryParams)
+	v.SetDefault("log.redact_headers", DefaultLogConfig.RedactHeaders)
+	v.SetDefault("log.redact_body_fields", DefaultLogConfig.RedactBodyFields)
+	v.SetDefault("log.access_log_fields", DefaultLogConfig.AccessLogFields)
+	v.SetDefault("log.success_sample_every", DefaultLogConfig.SuccessSampleEvery)
+	v.SetDefault("log.skip_paths", DefaultLogConfig.SkipPaths)
+	v.SetDefault("log.signal_debug_duration", 15*time.Minute)
+
+	v.SetDefault("cors.allowed_origins", []string{"*"})
+	v.SetDefault("cors.allowed_methods", []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"})
+	v.SetDefault("cors.allowed_headers", []string{"Content-Type", "Authorization", "Accept", "Cache-Control", "X-Requested-With", "X-Request-ID", "X-CSRF-Token", "Idempotency-Key"})
+	v.SetDefault("cors.exposed_headers", []string{"X-Request-ID", "X-RateLimit-Limit", "Retry-After", "Idempotent-Replayed"})
+	v.SetDefault("cors.allow_credentials", true)
+	v.SetDefault("cors.max_age", 10*time.Minute)
+
+	v.SetDefault("secrets.provider", "env")
+
+	v.SetDefault("security.hsts_max_age", 180*24*time.Hour)
+	v.SetDefault("security.frame_options", "DENY")
+	v.SetDefault("security.content_security_policy", "default-src 'self'; script-src 'self'; style-src 'self' 'unsafe-inline'; img-src 'self' data:; connect-src 'self'; frame-ancestors 'none'; base-uri 'self'; form-action 'self'")
+	v.SetDefault("security.api_content_security_policy", "default-src 'none'; frame-ancestors 'none'")
+	v.SetDefault("security.request_timeout", 8*time.Second)
+	v.SetDefault("security.max_body_bytes", 1<<20)
+
+	v.SetDefault("idempotency.ttl", 24*time.Hour)
+	v.SetDefault("idempotency.lock_timeout", time.Minute)
+	v.SetDefault("idempotency.cache_size", 10000)
+
+	v.SetDefault("cache.users.enabled", true)
+	v.SetDefault("cache.users.capacity", 10000)
+	v.SetDefault("cache.users.ttl", 5*time.Minute)
+
+	v.SetDefault("outbox.poll_interval", time.Second)
+	v.SetDefault("outbox.batch_size", 100)
+	v.SetDefault("outbox.lock_timeout", 30*time.Second)
+	v.SetDefault("outbox.max_attempts", 10)
+	v.SetDefault("outbox.retry_backoff", time.Second)
+	v.SetDefault("outbox.max_retry_backoff", time.Hour)
+
+	v.SetDefault("webhooks.poll_interval", time.Second)
+	v.SetDefault("webhooks.batch_size", 50)
+	v.SetDefault("webhooks.concurrency", 8)
+	v.SetDefault("webhooks.timeout", 10*time.Second)
+	v.SetDefault("webhooks.user_agent", "make-it-rain-webhooks/1.0")
+	v.SetDefault("webhooks.max_attempts", 8)
+	v.SetDefault("webhooks.retry_backoff", 10*time.Second)
+	v.SetDefault("webhooks.max_retry_backoff", 6*time.Hour)
+	v.SetDefault("webhooks.breaker_failures", 5)
+	v.SetDefault("webhooks.breaker_reset", time.Minute)
+
+	v.SetDefault("jobs.workers", 4)
+	v.SetDefault("jobs.poll_interval", time.Second)
+	v.SetDefault("jobs.timeout", 5*time.Minute)
+	v.SetDefault("jobs.max_attempts", 5)
+	v.SetDefault("jobs.retry_backoff", 5*time.Second)
+	v.SetDefault("jobs.max_retry_backoff", time.Hour)
+
+	v.SetDefault("scheduler.enabled", true)
+	v.SetDefault("scheduler.lock_key", 7310001)
+	v.SetDefault("scheduler.leader_check_interval", 15*time.Second)
+	v.SetDefault("scheduler.task_timeout", 10*time.Minute)
+	v.SetDefault("scheduler.purge_batch_size", 1000)
+
+	v.SetDefault("mail.driver", "file")
+	v.SetDefault("mail.from", "Make It Rain <no-reply@localhost>")
+	v.SetDefault("mail.default_locale", "en")
+	v.SetDefault("mail.file_dir", "tmp/mail")
+	v.SetDefault("mail.smtp.port", 587)
+	v.SetDefault("mail.smtp.tls", "starttls")
+	v.SetDefault("mail.smtp.timeout", 10*time.Second)
+
+	v.SetDefault("auth.require_verified_email", false)
+	v.SetDefault("auth.verification_ttl", 24*time.Hour)
+	v.SetDefault("auth.verify_email_url", "http://localhost:3000/verify-email")
+	v.SetDefault("auth.password_reset_ttl", time.Hour)
+	v.SetDefault("auth.reset_password_url", "http://localhost:3000/reset-password")
+	v.SetDefault("auth.resend_limit", 3)
+	v.SetDefault("auth.resend_window", time.Hour)
+	v.SetDefault("auth.session_cache_ttl", time.Minute)
+	v.SetDefault("auth.lockout.window", 15*time.Minute)
+	v.SetDefault("auth.lockout.delay_after", 3)
+	v.SetDefault("auth.lockout.base_delay", time.Second)
+	v.SetDefault("auth.lockout.max_delay", 30*time.Second)
+	v.SetDefault("auth.lockout.threshold", 10)
+	v.SetDefault("auth.lockout.duration", 15*time.Minute)
+	v.SetDefault("auth.lockout.ip_threshold", 50)
+	v.SetDefault("auth.lockout.min_response_time", 300*time.Millisecond)
+	v.SetDefault("auth.mfa.issuer", "")
+	v.SetDefault("auth.mfa.encryption_key", "")
+	v.SetDefault("auth.mfa.required_roles", []string{"admin"})
+	v.SetDefault("auth.mfa.challenge_ttl", 5*time.Minute)
+	v.SetDefault("auth.mfa.skew", 1)
+	v.SetDefault("auth.mfa.recovery_codes", 10)
+	v.SetDefault("auth.api_keys.default_ttl", 90*24*time.Hour)
+	v.SetDefault("auth.api_keys.max_ttl", 365*24*time.Hour)
+	v.SetDefault("auth.api_keys.max_per_user", 10)
+	v.SetDefault("auth.api_keys.cache_ttl", time.Minute)
+	v.SetDefault("auth.api_keys.tiers", map[string]int{"standard": 20, "batch": 200})
+	v.SetDefault("auth.api_keys.default_tier", "standard")
+	v.SetDefault("auth.oidc.login_ttl", 10*time.Minute)
+	v.SetDefault("auth.oidc.timeout", 10*time.Second)
+}
+
+func bindEnv(v *viper.Viper) {
+	v.BindEnv("server.port", "SERVER_PORT")
+	v.BindEnv("server.environment", "SERVER_ENVIRONMENT")
+	v.BindEnv("server.read_timeout", "SERVER_READ_TIMEOUT")
+	v.BindEnv("server.write_timeout", "SERVER_WRITE_TIMEOUT")
+	v.BindEnv("server.shutdown_timeout", "SERVER_SHUTDOWN_TIMEOUT")
+
+	v.BindEnv("database.host", "DATABASE_HOST")
+	v.BindEnv("database.port", "DATABASE_PORT")
+	v.BindEnv("database.user", "DATABASE_USER")
+	v.BindEnv("database.password", "DATABASE_PASSWORD")
+	v.BindEnv("database.name", "DATABASE_NAME")
+	v.BindEnv("database.ssl_mode", "DATABASE_SSL_MODE")
+	v.BindEnv("database.max_connections", "DATABASE_MAX_CONNECTIONS")
+	v.BindEnv("database.min_connections", "DATABASE_MIN_CONNECTIONS")
+	v.BindEnv("database.max_conn_lifetime", "DATABASE_MAX_CONN_LIFETIME")
+	v.BindEnv("database.max_conn_idle_time", "DATABASE_MAX_CONN_IDLE_TIME")
+
+	v.BindEnv("jwt.secret_key", "JWT_SECRET_KEY")
+	v.BindEnv("jwt.expiry_duration", "JWT_EXPIRY_DURATION")
+	v.BindEnv("jwt.refresh_duration", "JWT_REFRESH_DURATION")
+
+	v.BindEnv("app.name", "APP_NAME")
+	v.BindEnv("app.version", "APP_VERSION")
+	v.BindEnv("app.log_level", "APP_LOG_LEVEL")
+	v.BindEnv("app.rate_limit_rps", "APP_RATE_LIMIT_RPS")
+
+	v.BindEnv("admin.port", "ADMIN_PORT")
+	v.BindEnv("admin.token", "ADMIN_TOKEN")
+
+	v.BindEnv("tracing.enabled", "TRACING_ENABLED")
+	v.BindEnv("tracing.exporter", "TRACING_EXPORTER")
+	v.BindEnv("tracing.otlp_endpoint", "TRACING_OTLP_ENDPOINT")
+	v.BindEnv("tracing.file_path", "TRACING_FILE_PATH")
+	v.BindEnv("tracing.sample_ratio", "TRACING_SAMPLE_RATIO")
+
+	v.BindEnv("log.redact_query_params", "LOG_REDACT_QUERY_PARAMS")
+	v.BindEnv("log.redact_headers", "LOG_REDACT_HEADERS")
+	v.BindEnv("log.redact_body_fields", "LOG_REDACT_BODY_FIELDS")
+	v.BindEnv("log.access_log_fields", "LOG_ACCESS_LOG_FIELDS")
+	v.BindEnv("log.success_sample_every", "LOG_SUCCESS_SAMPLE_EVERY")
+	v.BindEnv("log.skip_paths", "LOG_SKIP_PATHS")
+	v.BindEnv("log.component_levels", "LOG_COMPONENT_LEVELS")
+	v.BindEnv("log.signal_debug_duration", "LOG_SIGNAL_DEBUG_DURATION")
+
+	v.BindEnv("cors.allowed_origins", "CORS_ALLOWED_ORIGINS")
+	v.BindEnv("cors.allowed_methods", "CORS_ALLOWED_METHODS")
+	v.BindEnv("cors.allowed_headers", "CORS_ALLOWED_HEADERS")
+	v.BindEnv("cors.exposed_headers", "CORS_EXPOSED_HEADERS")
+	v.BindEnv("cors.allow_credentials", "CORS_ALLOW_CREDENTIALS")
+	v.BindEnv("cors.max_age", "CORS_MAX_AGE")
+
+	v.BindEnv("secrets.provider", "SECRETS_PROVIDER")
+	v.BindEnv("secrets.file", "SECRETS_FILE")
+	v.BindEnv("secrets.key", "SECRETS_KEY")
+
+	v.BindEnv("security.hsts_max_age", "SECURITY_HSTS_MAX_AGE")
+	v.BindEnv("security.frame_options", "SECURITY_FRAME_OPTIONS")
+	v.BindEnv("security.content_security_policy", "SECURITY_CONTENT_SECURITY_POLICY")
+	v.BindEnv("security.api_content_security_policy", "SECURITY_API_CONTENT_SECURITY_POLICY")
+	v.BindEnv("security.request_timeout", "SECURITY_REQUEST_TIMEOUT")
+	v.BindEnv("security.max_body_bytes", "SECURITY_MAX_BODY_BYTES")
+
+	v.BindEnv("idempotency.ttl", "IDEMPOTENCY_TTL")
+	v.BindEnv("idempotency.lock_timeout", "IDEMPOTENCY_LOCK_TIMEOUT")
+	v.BindEnv("idempotency.cache_size", "IDEMPOTENCY_CACHE_SIZE")
+
+	v.BindEnv("cache.users.enabled", "CACHE_USERS_ENABLED")
+	v.BindEnv("cache.users.capacity", "CACHE_USERS_CAPACITY")
+	v.BindEnv("cache.users.ttl", "CACHE_USERS_TTL")
+
+	v.BindEnv("outbox.poll_interval", "OUTBOX_POLL_INTERVAL")
+	v.BindEnv("outbox.batch_size", "OUTBOX_BATCH_SIZE")
+	v.BindEnv("outbox.lock_timeout", "OUTBOX_LOCK_TIMEOUT")
+	v.BindEnv("outbox.max_attempts", "OUTBOX_MAX_ATTEMPTS")
+	v.BindEnv("outbox.retry_backoff", "OUTBOX_RETRY_BACKOFF")
+	v.BindEnv("outbox.max_retry_backoff", "OUTBOX_MAX_RETRY_BACKOFF")
+
+	v.BindEnv("webhooks.poll_interval", "WEBHOOKS_POLL_INTERVAL")
+	v.BindEnv("webhooks.batch_size", "WEBHOOKS_BATCH_SIZE")
+	v.BindEnv("webhooks.concurrency", "WEBHOOKS_CONCURRENCY")
+	v.BindEnv("webhooks.timeout", "WEBHOOKS_TIMEOUT")
+	v.BindEnv("webhooks.user_agent", "WEBHOOKS_USER_AGENT")
+	v.BindEnv("webhooks.max_attempts", "WEBHOOKS_MAX_ATTEMPTS")
+	v.BindEnv("webhooks.retry_backoff", "WEBHOOKS_RETRY_BACKOFF")
+	v.BindEnv("webhooks.max_retry_backoff", "WEBHOOKS_MAX_RETRY_BACKOFF")
+	v.BindEnv("webhooks.breaker_failures", "WEBHOOKS_BREAKER_FAILURES")
+	v.BindEnv("webhooks.breaker_reset", "WEBHOOKS_BREAKER_RESET")
+
+	v.BindEnv("jobs.workers", "JOBS_WORKERS")
+	v.BindEnv("jobs.poll_interval", "JOBS_POLL_INTERVAL")
+	v.BindEnv("jobs.timeout", "JOBS_TIMEOUT")
+	v.BindEnv("jobs.max_attempts", "JOBS_MAX_ATTEMPTS")
+	v.BindEnv("jobs.retry_backoff", "JOBS_RETRY_BACKOFF")
+	v.BindEnv("jobs.max_retry_backoff", "JOBS_MAX_RETRY_BACKOFF")
+
+	v.BindEnv("scheduler.enabled", "SCHEDULER_ENABLED")
+	v.BindEnv("scheduler.lock_key", "SCHEDULER_LOCK_KEY")
+	v.BindEnv("scheduler.leader_check_interval", "SCHEDULER_LEADER_CHECK_INTERVAL")
+	v.BindEnv("scheduler.task_timeout", "SCHEDULER_TASK_TIMEOUT")
+	v.BindEnv("scheduler.purge_batch_size", "SCHEDULER_PURGE_BATCH_SIZE")
+
+	v.BindEnv("mail.driver", "MAIL_DRIVER")
+	v.BindEnv("mail.from", "MAIL_FROM")
+	v.BindEnv("mail.default_locale", "MAIL_DEFAULT_LOCALE")
+	v.BindEnv("mail.file_dir", "MAIL_FILE_DIR")
+	v.BindEnv("mail.smtp.host", "MAIL_SMTP_HOST")
+	v.BindEnv("mail.smtp.port", "MAIL_SMTP_PORT")
+	v.BindEnv("mail.smtp.username", "MAIL_SMTP_USERNAME")
+	v.BindEnv("mail.smtp.password", "MAIL_SMTP_PASSWORD")
+	v.BindEnv("mail.smtp.tls", "MAIL_SMTP_TLS")
+	v.BindEnv("mail.smtp.timeout", "MAIL_SMTP_TIMEOUT")
+
+	v.BindEnv("auth.require_verified_email", "AUTH_REQUIRE_VERIFIED_EMAIL")
+	v.BindEnv("auth.verification_ttl", "AUTH_VERIFICATION_TTL")
+	v.BindEnv("auth.verify_email_url", "AUTH_VERIFY_EMAIL_URL")
+	v.BindEnv("auth.password_reset_ttl", "AUTH_PASSWORD_RESET_TTL")
+	v.BindEnv("auth.reset_password_url", "AUTH_RESET_PASSWORD_URL")
+	v.BindEnv("auth.resend_limit", "AUTH_RESEND_LIMIT")
+	v.BindEnv("auth.resend_window", "AUTH_RESEND_WINDOW")
+	v.BindEnv("auth.session_cache_ttl", "AUTH_SESSION_CACHE_TTL")
+	v.BindEnv("auth.lockout.window", "AUTH_LOCKOUT_WINDOW")
+	v.BindEnv("auth.lockout.delay_after", "AUTH_LOCKOUT_DELAY_AFTER")
+	v.BindEnv("auth.lockout.base_delay", "AUTH_LOCKOUT_BASE_DELAY")
+	v.BindEnv("auth.lockout.max_delay", "AUTH_LOCKOUT_MAX_DELAY")
+	v.BindEnv("auth.lockout.threshold", "AUTH_LOCKOUT_THRESHOLD")
+	v.BindEnv("auth.lockout.duration", "AUTH_LOCKOUT_DURATION")
+	v.BindEnv("auth.lockout.ip_threshold", "AUTH_LOCKOUT_IP_THRESHOLD")
+	v.BindEnv("auth.lockout.min_response_time", "AUTH_LOCKOUT_MIN_RESPONSE_TIME")
+	v.BindEnv("auth.mfa.issuer", "AUTH_MFA_ISSUER")
+	v.BindEnv("auth.mfa.encryption_key", "AUTH_MFA_ENCRYPTION_KEY")
+	v.BindEnv("auth.mfa.required_roles", "AUTH_MFA_REQUIRED_ROLES")
+	v.BindEnv("auth.mfa.challenge_ttl", "AUTH_MFA_CHALLENGE_TTL")
+	v.BindEnv("auth.mfa.skew", "AUTH_MFA_SKEW")
+	v.BindEnv("auth.mfa.recovery_codes", "AUTH_MFA_RECOVERY_CODES")
+	v.BindEnv("auth.api_keys.default_ttl", "AUTH_API_KEYS_DEFAULT_TTL")
+	v.BindEnv("auth.api_keys.max_ttl", "AUTH_API_KEYS_MAX_TTL")
+	v.BindEnv("auth.api_keys.max_per_user", "AUTH_API_KEYS_MAX_PER_USER")
+	v.BindEnv("auth.api_keys.cache_ttl", "AUTH_API_KEYS_CACHE_TTL")
+	v.BindEnv("auth.api_keys.default_tier", "AUTH_API_KEYS_DEFAULT_TIER")
+	v.BindEnv("auth.oidc.login_ttl", "AUTH_OIDC_LOGIN_TTL")
+	v.BindEnv("auth.oidc.timeout", "AUTH_OIDC_TIMEOUT")
+}
+
+// GetConnectionString builds the connection URL with credentials escaped, so
+// passwords containing @, / or % don't break parsing.
 func (c *DatabaseConfig) GetConnectionString() string {
-	return fmt.Sprintf("postgres://%s:%s@%s:%d/%s?sslmode=%s",
-		c.User, c.Password, c.Host, c.Port, c.Name, c.SSLMode)
-}
\ No newline at end of file
+	u := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(c.User, c.Password),
+		Host:     net.JoinHostPort(c.Host, strconv.Itoa(c.Port)),
+		Path:     "/" + c.Name,
+		RawQuery: url.Values{"sslmode": {c.SSLMode}}.Encode(),
+	}
+	return u.String()
+}
+
+// secretProvider builds the provider chain for the configured backend.
+// *_FILE variables always take precedence.
+func (c *Config) secretProvider() (secrets.Provider, error) {
+	switch c.Secrets.Provider {
+	case "", "env":
+		return secrets.FileEnvProvider{}, nil
+
+	case "encrypted_file":
+		key, err := secrets.ParseKey(c.Secrets.Key)
+		if err != nil {
+			return nil, err
+		}
+		encrypted, err := secrets.NewEncryptedFileProvider(c.Secrets.File, key)
+		if err != nil {
+			return nil, err
+		}
+		return secrets.Chain{secrets.FileEnvProvider{}, encrypted}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown secrets provider %q", c.Secrets.Provider)
+	}
+}
+
+// resolveSecrets overrides secret fields with values from the secret
+// provider, keeping the plain environment/config value when it has none.
+func (c *Config) resolveSecrets(ctx context.Context) error {
+	// The encrypted file key itself may only come from SECRETS_KEY(_FILE)
+	key, err := secrets.Resolve(ctx, secrets.FileEnvProvider{}, "SECRETS_KEY", c.Secrets.Key)
+	if err != nil {
+		return err
+	}
+	c.Secrets.Key = key
+
+	provider, err := c.secretProvider()
+	if err != nil {
+		return err
+	}
+
+	fields := []struct {
+		name  string
+		value *string
+	}{
+		{"DATABASE_USER", &c.Database.User},
+		{"DATABASE_PASSWORD", &c.Database.Password},
+		{"JWT_SECRET_KEY", &c.JWT.SecretKey},
+		{"ADMIN_TOKEN", &c.Admin.Token},
+		{"MAIL_SMTP_PASSWORD", &c.Mail.SMTP.Password},
+		{"AUTH_MFA_ENCRYPTION_KEY", &c.Auth.MFA.EncryptionKey},
+	}
+
+	for _, f := range fields {
+		value, err := secrets.Resolve(ctx, provider, f.name, *f.value)
+		if err != nil {
+			return fmt.Errorf("failed to resolve %s: %w", f.name, err)
+		}
+		*f.value = value
+	}
+
+	// Providers are a map, so their secrets can't be bound through viper
+	for name, p := range c.Auth.OIDC.Providers {
+		env := c.Auth.OIDC.SecretEnv(name)
+		if value, ok := os.LookupEnv(env); ok {
+			p.ClientSecret = value
+		}
+		value, err := secrets.Resolve(ctx, provider, env, p.ClientSecret)
+		if err != nil {
+			return fmt.Errorf("failed to resolve %s: %w", env, err)
+		}
+		p.ClientSecret = value
+		c.Auth.OIDC.Providers[name] = p
+	}
+
+	return nil
+}
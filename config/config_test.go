@@ -0,0 +1,165 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func validConfig(t *testing.T) *Config {
+	t.Helper()
+	t.Setenv("DATABASE_USER", "postgres")
+	t.Setenv("DATABASE_NAME", "make_it_rain")
+	t.Setenv("JWT_SECRET_KEY", "a-long-enough-secret-for-production-use")
+
+	cfg, err := Load(t.TempDir())
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	return cfg
+}
+
+func TestLoadLayersProfileOverBase(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("config.yaml", "server:\n  environment: staging\n  port: \"8000\"\napp:\n  name: Base\n")
+	write("config.staging.toml", "[app]\nname = \"Staging\"\n")
+	t.Setenv("APP_LOG_LEVEL", "debug")
+
+	cfg, err := Load(dir)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	if cfg.Server.Port != "8000" {
+		t.Errorf("Expected port from base file, got %s", cfg.Server.Port)
+	}
+	if cfg.App.Name != "Staging" {
+		t.Errorf("Expected name from profile file, got %s", cfg.App.Name)
+	}
+	if cfg.App.LogLevel != "debug" {
+		t.Errorf("Expected log level from env, got %s", cfg.App.LogLevel)
+	}
+}
+
+func TestValidateListsEveryProblem(t *testing.T) {
+	cfg := validConfig(t)
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Expected valid config, got %v", err)
+	}
+
+	cfg.Database.User = ""
+	cfg.JWT.SecretKey = ""
+	cfg.Server.ReadTimeout = 0
+	cfg.Database.MinConnections = 50
+
+	err := cfg.Validate()
+	verr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("Expected *ValidationError, got %v", err)
+	}
+	if len(verr.Problems) != 4 {
+		t.Errorf("Expected 4 problems, got %d: %v", len(verr.Problems), verr.Problems)
+	}
+}
+
+func TestValidateRejectsDefaultSecretInProduction(t *testing.T) {
+	cfg := validConfig(t)
+	cfg.Server.Environment = "production"
+	cfg.JWT.SecretKey = "your-secret-key-change-in-production"
+
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "jwt.secret_key") {
+		t.Errorf("Expected jwt.secret_key problem, got %v", err)
+	}
+}
+
+func TestPrintRedactsSecrets(t *testing.T) {
+	cfg := validConfig(t)
+	cfg.Database.Password = "hunter22"
+
+	var buf bytes.Buffer
+	if err := cfg.Print(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	out := buf.String()
+	if strings.Contains(out, "hunter22") || strings.Contains(out, cfg.JWT.SecretKey) {
+		t.Errorf("Expected secrets to be redacted, got:\n%s", out)
+	}
+	if !strings.Contains(out, "read_timeout: 10s") {
+		t.Errorf("Expected durations to be printed as strings, got:\n%s", out)
+	}
+}
+
+func TestGetConnectionStringEscapesCredentials(t *testing.T) {
+	c := DatabaseConfig{
+		Host:     "db",
+		Port:     5432,
+		User:     "app",
+		Password: "p@ss/w%rd:1",
+		Name:     "make_it_rain",
+		SSLMode:  "disable",
+	}
+
+	got := c.GetConnectionString()
+	want := "postgres://app:p%40ss%2Fw%25rd%3A1@db:5432/make_it_rain?sslmode=disable"
+	if got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
+
+func TestLoadReadsSecretFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "password")
+	if err := os.WriteFile(path, []byte("from-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("DATABASE_PASSWORD", "from-env")
+	t.Setenv("DATABASE_PASSWORD_FILE", path)
+
+	cfg, err := Load(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Database.Password != "from-file" {
+		t.Errorf("Expected password from file, got %q", cfg.Database.Password)
+	}
+}
+
+func TestLoadReadsOIDCProviders(t *testing.T) {
+	dir := t.TempDir()
+	yaml := "auth:\n  oidc:\n    providers:\n      company-sso:\n        issuer: https://sso.example.com\n        client_id: make-it-rain\n        redirect_url: https://app.example.com/login/callback\n"
+	if err := os.WriteFile(filepath.Join(dir, "config.yaml"), []byte(yaml), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("DATABASE_USER", "postgres")
+	t.Setenv("DATABASE_NAME", "make_it_rain")
+	t.Setenv("JWT_SECRET_KEY", "a-long-enough-secret-for-production-use")
+	t.Setenv("AUTH_OIDC_COMPANY_SSO_CLIENT_SECRET", "sso-client-secret")
+
+	cfg, err := Load(dir)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Expected valid config, got %v", err)
+	}
+	provider := cfg.Auth.OIDC.Providers["company-sso"]
+	if provider.ClientSecret != "sso-client-secret" {
+		t.Errorf("Expected client secret from env, got %q", provider.ClientSecret)
+	}
+
+	var buf bytes.Buffer
+	if err := cfg.Print(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	if strings.Contains(out, "sso-client-secret") || !strings.Contains(out, "https://sso.example.com") {
+		t.Errorf("Expected provider printed with its secret redacted, got:\n%s", out)
+	}
+}
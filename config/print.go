@@ -0,0 +1,79 @@
+package config
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const redacted = "[REDACTED]"
+
+// Redacted returns the configuration as a nested map keyed like the config
+// files, with fields tagged `secret:"true"` masked. Empty secrets are shown
+// as empty so a missing value is still visible.
+func (c *Config) Redacted() map[string]interface{} {
+	return structToMap(reflect.ValueOf(*c), true)
+}
+
+// Print writes the redacted configuration as YAML.
+func (c *Config) Print(w io.Writer) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	defer enc.Close()
+	return enc.Encode(c.Redacted())
+}
+
+// structToMap converts a config struct to a nested map keyed by mapstructure
+// names, optionally masking secrets.
+func structToMap(v reflect.Value, redact bool) map[string]interface{} {
+	out := make(map[string]interface{})
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name := field.Tag.Get("mapstructure")
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+
+		value := v.Field(i)
+		if strings.Contains(name, "squash") {
+			for k, nested := range structToMap(value, redact) {
+				out[k] = nested
+			}
+			continue
+		}
+
+		switch {
+		case redact && field.Tag.Get("secret") == "true":
+			if value.IsZero() {
+				out[name] = ""
+			} else {
+				out[name] = redacted
+			}
+		case field.Type == reflect.TypeOf(time.Duration(0)):
+			out[name] = time.Duration(value.Int()).String()
+		case value.Kind() == reflect.Struct:
+			out[name] = structToMap(value, redact)
+		case value.Kind() == reflect.Map && field.Type.Elem().Kind() == reflect.Struct:
+			// e.g. OIDC providers, whose structs hold secrets
+			entries := make(map[string]interface{}, value.Len())
+			iter := value.MapRange()
+			for iter.Next() {
+				entries[iter.Key().String()] = structToMap(iter.Value(), redact)
+			}
+			out[name] = entries
+		default:
+			out[name] = value.Interface()
+		}
+	}
+
+	return out
+}
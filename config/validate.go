@@ -0,0 +1,367 @@
+package config
+
+import (
+	"fmt"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/manuel/make-it-rain/secrets"
+)
+
+// insecureJWTSecrets are the placeholder secrets shipped in .env.example and
+// docker-compose.yml; they must never reach production.
+var insecureJWTSecrets = map[string]bool{
+	"your-secret-key-change-in-production": true,
+	"secret-key-change-in-production":      true,
+	"secret":                               true,
+	"changeme":                             true,
+}
+
+const minProductionSecretLength = 32
+
+// oidcProviderName matches provider names, which appear in login URLs.
+var oidcProviderName = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// ValidationError lists every problem found by Validate.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+// Validate checks the whole configuration and reports all problems at once
+// rather than stopping at the first.
+func (c *Config) Validate() error {
+	var problems []string
+	addf := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+	positive := func(name string, d time.Duration) {
+		if d <= 0 {
+			addf("%s must be greater than 0, got %s", name, d)
+		}
+	}
+
+	switch c.Server.Environment {
+	case "development", "test", "staging", "production":
+	default:
+		addf("server.environment must be one of development, test, staging, production, got %q", c.Server.Environment)
+	}
+	if c.Server.Port == "" {
+		addf("server.port is required")
+	}
+	positive("server.read_timeout", c.Server.ReadTimeout)
+	positive("server.write_timeout", c.Server.WriteTimeout)
+	positive("server.shutdown_timeout", c.Server.ShutdownTimeout)
+
+	if c.Database.Host == "" {
+		addf("database.host is required (DATABASE_HOST)")
+	}
+	if c.Database.User == "" {
+		addf("database.user is required (DATABASE_USER)")
+	}
+	if c.Database.Name == "" {
+		addf("database.name is required (DATABASE_NAME)")
+	}
+	if c.Database.MaxConnections <= 0 {
+		addf("database.max_connections must be greater than 0, got %d", c.Database.MaxConnections)
+	}
+	if c.Database.MinConnections < 0 {
+		addf("database.min_connections must not be negative, got %d", c.Database.MinConnections)
+	}
+	if c.Database.MinConnections > c.Database.MaxConnections {
+		addf("database.min_connections (%d) must not exceed database.max_connections (%d)",
+			c.Database.MinConnections, c.Database.MaxConnections)
+	}
+	positive("database.max_conn_lifetime", c.Database.MaxConnLifetime)
+	positive("database.max_conn_idle_time", c.Database.MaxConnIdleTime)
+
+	if c.JWT.SecretKey == "" {
+		addf("jwt.secret_key is required (JWT_SECRET_KEY)")
+	} else if c.Server.Environment == "production" {
+		if insecureJWTSecrets[c.JWT.SecretKey] {
+			addf("jwt.secret_key must be changed from the default value in production")
+		} else if len(c.JWT.SecretKey) < minProductionSecretLength {
+			addf("jwt.secret_key must be at least %d characters in production", minProductionSecretLength)
+		}
+	}
+	positive("jwt.expiry_duration", c.JWT.ExpiryDuration)
+	positive("jwt.refresh_duration", c.JWT.RefreshDuration)
+
+	switch strings.ToLower(c.App.LogLevel) {
+	case "trace", "debug", "info", "warn", "warning", "error", "fatal", "panic", "disabled":
+	default:
+		addf("app.log_level %q is not a valid level", c.App.LogLevel)
+	}
+	if c.App.RateLimitRPS <= 0 {
+		addf("app.rate_limit_rps must be greater than 0, got %d", c.App.RateLimitRPS)
+	}
+
+	if c.Admin.Port == c.Server.Port {
+		addf("admin.port must differ from server.port (%s)", c.Server.Port)
+	}
+
+	if c.Tracing.Enabled {
+		switch c.Tracing.Exporter {
+		case "otlp", "stdout", "file":
+		default:
+			addf("tracing.exporter must be one of otlp, stdout, file, got %q", c.Tracing.Exporter)
+		}
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		addf("tracing.sample_ratio must be between 0 and 1, got %g", c.Tracing.SampleRatio)
+	}
+
+	validateCORSPolicy := func(name string, p CORSPolicyConfig) {
+		for _, origin := range p.AllowedOrigins {
+			if origin == "*" {
+				continue
+			}
+			if !strings.HasPrefix(origin, "http://") && !strings.HasPrefix(origin, "https://") {
+				addf("%s.allowed_origins entry %q must start with http:// or https://", name, origin)
+			} else if strings.Count(origin, "*") > 1 || (strings.Contains(origin, "*") && !strings.Contains(origin, "://*.")) {
+				addf("%s.allowed_origins entry %q may only use a leading *. subdomain wildcard", name, origin)
+			}
+		}
+		if p.MaxAge < 0 {
+			addf("%s.max_age must not be negative, got %s", name, p.MaxAge)
+		}
+	}
+	validateCORSPolicy("cors", c.CORS.CORSPolicyConfig)
+	for i, rp := range c.CORS.RoutePolicies {
+		name := fmt.Sprintf("cors.route_policies[%d]", i)
+		if !strings.HasPrefix(rp.PathPrefix, "/") {
+			addf("%s.path_prefix must start with /, got %q", name, rp.PathPrefix)
+		}
+		validateCORSPolicy(name, rp.CORSPolicyConfig)
+	}
+
+	positive("security.request_timeout", c.Security.RequestTimeout)
+	if c.Security.RequestTimeout >= c.Server.WriteTimeout {
+		addf("security.request_timeout (%s) must be shorter than server.write_timeout (%s) so timeouts can be reported",
+			c.Security.RequestTimeout, c.Server.WriteTimeout)
+	}
+	if c.Security.MaxBodyBytes <= 0 {
+		addf("security.max_body_bytes must be greater than 0, got %d", c.Security.MaxBodyBytes)
+	}
+	for route, d := range c.Security.RouteTimeouts {
+		positive(fmt.Sprintf("security.route_timeouts[%s]", route), d)
+	}
+	for route, n := range c.Security.RouteBodyLimits {
+		if n <= 0 {
+			addf("security.route_body_limits[%s] must be greater than 0, got %d", route, n)
+		}
+	}
+	switch c.Security.FrameOptions {
+	case "DENY", "SAMEORIGIN", "":
+	default:
+		addf("security.frame_options must be DENY, SAMEORIGIN or empty, got %q", c.Security.FrameOptions)
+	}
+
+	positive("idempotency.ttl", c.Idempotency.TTL)
+	if c.Idempotency.LockTimeout <= c.Security.RequestTimeout {
+		addf("idempotency.lock_timeout (%s) must be longer than security.request_timeout (%s)",
+			c.Idempotency.LockTimeout, c.Security.RequestTimeout)
+	}
+	if c.Idempotency.CacheSize <= 0 {
+		addf("idempotency.cache_size must be greater than 0, got %d", c.Idempotency.CacheSize)
+	}
+
+	if c.Cache.Users.Enabled {
+		if c.Cache.Users.Capacity <= 0 {
+			addf("cache.users.capacity must be greater than 0, got %d", c.Cache.Users.Capacity)
+		}
+		positive("cache.users.ttl", c.Cache.Users.TTL)
+	}
+
+	positive("outbox.poll_interval", c.Outbox.PollInterval)
+	positive("outbox.lock_timeout", c.Outbox.LockTimeout)
+	positive("outbox.retry_backoff", c.Outbox.RetryBackoff)
+	if c.Outbox.BatchSize <= 0 {
+		addf("outbox.batch_size must be greater than 0, got %d", c.Outbox.BatchSize)
+	}
+	if c.Outbox.MaxAttempts <= 0 {
+		addf("outbox.max_attempts must be greater than 0, got %d", c.Outbox.MaxAttempts)
+	}
+	if c.Outbox.MaxRetryBackoff < c.Outbox.RetryBackoff {
+		addf("outbox.max_retry_backoff (%s) must not be less than outbox.retry_backoff (%s)",
+			c.Outbox.MaxRetryBackoff, c.Outbox.RetryBackoff)
+	}
+
+	positive("webhooks.poll_interval", c.Webhooks.PollInterval)
+	positive("webhooks.timeout", c.Webhooks.Timeout)
+	positive("webhooks.retry_backoff", c.Webhooks.RetryBackoff)
+	positive("webhooks.breaker_reset", c.Webhooks.BreakerReset)
+	if c.Webhooks.BatchSize <= 0 {
+		addf("webhooks.batch_size must be greater than 0, got %d", c.Webhooks.BatchSize)
+	}
+	if c.Webhooks.Concurrency <= 0 {
+		addf("webhooks.concurrency must be greater than 0, got %d", c.Webhooks.Concurrency)
+	}
+	if c.Webhooks.MaxAttempts <= 0 {
+		addf("webhooks.max_attempts must be greater than 0, got %d", c.Webhooks.MaxAttempts)
+	}
+	if c.Webhooks.MaxRetryBackoff < c.Webhooks.RetryBackoff {
+		addf("webhooks.max_retry_backoff (%s) must not be less than webhooks.retry_backoff (%s)",
+			c.Webhooks.MaxRetryBackoff, c.Webhooks.RetryBackoff)
+	}
+	// The half-open state lets through breaker_failures/2 trial requests.
+	if c.Webhooks.BreakerFailures < 2 {
+		addf("webhooks.breaker_failures must be at least 2, got %d", c.Webhooks.BreakerFailures)
+	}
+
+	positive("jobs.poll_interval", c.Jobs.PollInterval)
+	positive("jobs.timeout", c.Jobs.Timeout)
+	positive("jobs.retry_backoff", c.Jobs.RetryBackoff)
+	if c.Jobs.Workers <= 0 {
+		addf("jobs.workers must be greater than 0, got %d", c.Jobs.Workers)
+	}
+	if c.Jobs.MaxAttempts <= 0 {
+		addf("jobs.max_attempts must be greater than 0, got %d", c.Jobs.MaxAttempts)
+	}
+	if c.Jobs.MaxRetryBackoff < c.Jobs.RetryBackoff {
+		addf("jobs.max_retry_backoff (%s) must not be less than jobs.retry_backoff (%s)",
+			c.Jobs.MaxRetryBackoff, c.Jobs.RetryBackoff)
+	}
+
+	if c.Scheduler.Enabled {
+		positive("scheduler.leader_check_interval", c.Scheduler.LeaderCheckInterval)
+		positive("scheduler.task_timeout", c.Scheduler.TaskTimeout)
+		if c.Scheduler.PurgeBatchSize <= 0 {
+			addf("scheduler.purge_batch_size must be greater than 0, got %d", c.Scheduler.PurgeBatchSize)
+		}
+	}
+
+	switch c.Mail.Driver {
+	case "smtp":
+		if c.Mail.SMTP.Host == "" {
+			addf("mail.smtp.host is required when mail.driver is smtp (MAIL_SMTP_HOST)")
+		}
+		if c.Mail.SMTP.Port <= 0 || c.Mail.SMTP.Port > 65535 {
+			addf("mail.smtp.port must be between 1 and 65535, got %d", c.Mail.SMTP.Port)
+		}
+		switch c.Mail.SMTP.TLS {
+		case "starttls", "tls", "none":
+		default:
+			addf("mail.smtp.tls must be one of starttls, tls, none, got %q", c.Mail.SMTP.TLS)
+		}
+		positive("mail.smtp.timeout", c.Mail.SMTP.Timeout)
+	case "file":
+		if c.Mail.FileDir == "" {
+			addf("mail.file_dir is required when mail.driver is file")
+		}
+	case "memory":
+		if c.Server.Environment == "production" {
+			addf("mail.driver memory discards every email and can't be used in production")
+		}
+	default:
+		addf("mail.driver must be one of smtp, file, memory, got %q", c.Mail.Driver)
+	}
+	if _, err := mail.ParseAddress(c.Mail.From); err != nil {
+		addf("mail.from %q is not a valid address: %v", c.Mail.From, err)
+	}
+
+	positive("auth.verification_ttl", c.Auth.VerificationTTL)
+	positive("auth.password_reset_ttl", c.Auth.PasswordResetTTL)
+	for _, link := range []struct{ name, value string }{
+		{"auth.verify_email_url", c.Auth.VerifyEmailURL},
+		{"auth.reset_password_url", c.Auth.ResetPasswordURL},
+	} {
+		if u, err := url.Parse(link.value); err != nil || u.Scheme == "" || u.Host == "" {
+			addf("%s must be an absolute URL, got %q", link.name, link.value)
+		}
+	}
+	if c.Auth.ResendLimit < 1 {
+		addf("auth.resend_limit must be at least 1, got %d", c.Auth.ResendLimit)
+	}
+	positive("auth.resend_window", c.Auth.ResendWindow)
+	positive("auth.session_cache_ttl", c.Auth.SessionCacheTTL)
+	lockout := c.Auth.Lockout
+	positive("auth.lockout.window", lockout.Window)
+	positive("auth.lockout.base_delay", lockout.BaseDelay)
+	positive("auth.lockout.max_delay", lockout.MaxDelay)
+	positive("auth.lockout.duration", lockout.Duration)
+	if lockout.Threshold < 1 || lockout.IPThreshold < 1 {
+		addf("auth.lockout.threshold and auth.lockout.ip_threshold must be at least 1, got %d and %d", lockout.Threshold, lockout.IPThreshold)
+	}
+	if lockout.DelayAfter < 1 || lockout.DelayAfter > lockout.Threshold {
+		addf("auth.lockout.delay_after must be between 1 and auth.lockout.threshold, got %d", lockout.DelayAfter)
+	}
+	if lockout.MinResponseTime < 0 {
+		addf("auth.lockout.min_response_time must not be negative, got %s", lockout.MinResponseTime)
+	}
+	mfa := c.Auth.MFA
+	if mfa.EncryptionKey != "" {
+		if _, err := secrets.ParseKey(mfa.EncryptionKey); err != nil {
+			addf("auth.mfa.encryption_key: %v", err)
+		}
+	} else if c.Server.Environment == "production" {
+		addf("auth.mfa.encryption_key is required in production (AUTH_MFA_ENCRYPTION_KEY)")
+	}
+	positive("auth.mfa.challenge_ttl", mfa.ChallengeTTL)
+	if mfa.Skew < 0 || mfa.Skew > 10 {
+		addf("auth.mfa.skew must be between 0 and 10, got %d", mfa.Skew)
+	}
+	if mfa.RecoveryCodes < 1 {
+		addf("auth.mfa.recovery_codes must be at least 1, got %d", mfa.RecoveryCodes)
+	}
+	apiKeys := c.Auth.APIKeys
+	positive("auth.api_keys.default_ttl", apiKeys.DefaultTTL)
+	positive("auth.api_keys.max_ttl", apiKeys.MaxTTL)
+	positive("auth.api_keys.cache_ttl", apiKeys.CacheTTL)
+	if apiKeys.DefaultTTL > apiKeys.MaxTTL {
+		addf("auth.api_keys.default_ttl must not exceed auth.api_keys.max_ttl, got %s", apiKeys.DefaultTTL)
+	}
+	if apiKeys.MaxPerUser < 1 {
+		addf("auth.api_keys.max_per_user must be at least 1, got %d", apiKeys.MaxPerUser)
+	}
+	for tier, rps := range apiKeys.Tiers {
+		if rps < 1 {
+			addf("auth.api_keys.tiers[%s] must be at least 1, got %d", tier, rps)
+		}
+	}
+	if _, ok := apiKeys.Tiers[apiKeys.DefaultTier]; !ok {
+		addf("auth.api_keys.default_tier %q is not in auth.api_keys.tiers", apiKeys.DefaultTier)
+	}
+	oidc := c.Auth.OIDC
+	positive("auth.oidc.login_ttl", oidc.LoginTTL)
+	positive("auth.oidc.timeout", oidc.Timeout)
+	for name, p := range oidc.Providers {
+		prefix := "auth.oidc.providers." + name
+		if !oidcProviderName.MatchString(name) {
+			addf("%s: provider names may only contain lowercase letters, digits and dashes", prefix)
+		}
+		for _, link := range []struct{ name, value string }{
+			{"issuer", p.Issuer},
+			{"redirect_url", p.RedirectURL},
+		} {
+			if u, err := url.Parse(link.value); err != nil || u.Scheme == "" || u.Host == "" {
+				addf("%s.%s must be an absolute URL, got %q", prefix, link.name, link.value)
+			} else if link.name == "issuer" && u.Scheme != "https" && c.Server.Environment == "production" {
+				addf("%s.issuer must use https in production, got %q", prefix, link.value)
+			}
+		}
+		if p.ClientID == "" {
+			addf("%s.client_id is required", prefix)
+		}
+		if len(p.Scopes) > 0 && !slices.Contains(p.Scopes, "openid") {
+			addf("%s.scopes must include openid, got %v", prefix, p.Scopes)
+		}
+	}
+
+	if c.Log.SuccessSampleEvery < 1 {
+		addf("log.success_sample_every must be at least 1, got %d", c.Log.SuccessSampleEvery)
+	}
+	positive("log.signal_debug_duration", c.Log.SignalDebugDuration)
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
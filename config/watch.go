@@ -0,0 +1,255 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/rs/zerolog/log"
+)
+
+// reloadDebounce coalesces the burst of events editors produce on save.
+const reloadDebounce = 250 * time.Millisecond
+
+// reloadableKeys are the settings applied live on reload; a trailing dot
+// matches a whole section. Everything else needs a restart and is ignored
+// with a warning if it changes.
+var reloadableKeys = []string{
+	"app.log_level",
+	"log.component_levels",
+	"app.rate_limit_rps",
+	"cors.",
+	"features",
+	// New connections resolve the password again; see db.InitDB
+	"database.password",
+}
+
+func isReloadable(key string) bool {
+	for _, k := range reloadableKeys {
+		if key == k || (strings.HasSuffix(k, ".") && strings.HasPrefix(key, k)) {
+			return true
+		}
+	}
+	return false
+}
+
+func applyReloadable(dst, src *Config) {
+	dst.App.LogLevel = src.App.LogLevel
+	dst.Log.ComponentLevels = src.Log.ComponentLevels
+	dst.App.RateLimitRPS = src.App.RateLimitRPS
+	dst.CORS = src.CORS
+	dst.Features = src.Features
+	dst.Database.Password = src.Database.Password
+}
+
+var current atomic.Pointer[Config]
+
+// Current returns the live configuration including hot-reloaded values. It is
+// safe for concurrent use; the returned Config must be treated as read-only.
+func Current() *Config {
+	if c := current.Load(); c != nil {
+		return c
+	}
+	return Cfg
+}
+
+// FeatureEnabled reports whether a feature flag is switched on in the live
+// configuration.
+func FeatureEnabled(name string) bool {
+	return Current().Features[name]
+}
+
+// ChangeFunc is called after a reload with the previous and new config.
+type ChangeFunc func(old, new *Config)
+
+var (
+	subscribersMu sync.Mutex
+	subscribers   = make(map[int]ChangeFunc)
+	nextSubID     int
+)
+
+// OnChange registers fn to be called after every reload that changed a
+// reloadable setting. The returned function unsubscribes.
+func OnChange(fn ChangeFunc) (unsubscribe func()) {
+	subscribersMu.Lock()
+	defer subscribersMu.Unlock()
+
+	id := nextSubID
+	nextSubID++
+	subscribers[id] = fn
+
+	return func() {
+		subscribersMu.Lock()
+		defer subscribersMu.Unlock()
+		delete(subscribers, id)
+	}
+}
+
+func notify(old, new *Config) {
+	subscribersMu.Lock()
+	fns := make([]ChangeFunc, 0, len(subscribers))
+	for _, fn := range subscribers {
+		fns = append(fns, fn)
+	}
+	subscribersMu.Unlock()
+
+	for _, fn := range fns {
+		fn(old, new)
+	}
+}
+
+// ReloadResult describes what a reload did.
+type ReloadResult struct {
+	Applied         []string
+	RestartRequired []string
+}
+
+// Reload re-reads the configuration from path. An invalid configuration is
+// rejected as a whole. Reloadable settings are swapped in atomically and
+// subscribers notified; changes to other settings are reported in
+// RestartRequired and not applied.
+func Reload(path string) (ReloadResult, error) {
+	var result ReloadResult
+
+	fresh, err := Load(path)
+	if err != nil {
+		return result, err
+	}
+	if err := fresh.Validate(); err != nil {
+		return result, err
+	}
+
+	old := Current()
+	for _, key := range changedKeys(old, fresh) {
+		if isReloadable(key) {
+			result.Applied = append(result.Applied, key)
+		} else {
+			result.RestartRequired = append(result.RestartRequired, key)
+		}
+	}
+
+	if len(result.Applied) == 0 {
+		return result, nil
+	}
+
+	next := *old
+	applyReloadable(&next, fresh)
+	current.Store(&next)
+	notify(old, &next)
+
+	return result, nil
+}
+
+// changedKeys returns the dotted keys whose values differ. Maps such as
+// features are compared as a whole.
+func changedKeys(a, b *Config) []string {
+	flatA := make(map[string]interface{})
+	flatB := make(map[string]interface{})
+	flatten("", structToMap(reflect.ValueOf(*a), false), flatA)
+	flatten("", structToMap(reflect.ValueOf(*b), false), flatB)
+
+	var keys []string
+	for key, value := range flatA {
+		if !reflect.DeepEqual(value, flatB[key]) {
+			keys = append(keys, key)
+		}
+	}
+	for key := range flatB {
+		if _, ok := flatA[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func flatten(prefix string, m map[string]interface{}, out map[string]interface{}) {
+	for key, value := range m {
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		if nested, ok := value.(map[string]interface{}); ok {
+			flatten(key, nested, out)
+			continue
+		}
+		out[key] = value
+	}
+}
+
+// Watch reloads the configuration whenever a config file in path changes,
+// until ctx is cancelled. The directory is watched rather than the files so
+// that atomic saves (write to temp file, rename) are picked up.
+func Watch(ctx context.Context, path string) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create config watcher: %w", err)
+	}
+	if err := watcher.Add(path); err != nil {
+		watcher.Close()
+		return fmt.Errorf("failed to watch %s: %w", path, err)
+	}
+
+	go func() {
+		defer watcher.Close()
+
+		var debounce <-chan time.Time
+		for {
+			select {
+			case <-ctx.Done():
+				return
+
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if isConfigFile(event.Name) && event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) != 0 {
+					debounce = time.After(reloadDebounce)
+				}
+
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Error().Err(err).Msg("Config watcher error")
+
+			case <-debounce:
+				debounce = nil
+				logReload(Reload(path))
+			}
+		}
+	}()
+
+	return nil
+}
+
+func logReload(result ReloadResult, err error) {
+	if err != nil {
+		log.Error().Err(err).Msg("Config reload rejected, keeping current configuration")
+		return
+	}
+	if len(result.RestartRequired) > 0 {
+		log.Warn().Strs("keys", result.RestartRequired).Msg("Config changes require a restart and were not applied")
+	}
+	if len(result.Applied) > 0 {
+		log.Info().Strs("keys", result.Applied).Msg("Config reloaded")
+	}
+}
+
+func isConfigFile(name string) bool {
+	base := filepath.Base(name)
+	if !strings.HasPrefix(base, "config.") {
+		return false
+	}
+	switch filepath.Ext(base) {
+	case ".yaml", ".yml", ".toml", ".json":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,76 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReloadAppliesOnlyReloadableKeys(t *testing.T) {
+	t.Setenv("DATABASE_USER", "postgres")
+	t.Setenv("DATABASE_NAME", "make_it_rain")
+	t.Setenv("JWT_SECRET_KEY", "secret")
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "config.yaml")
+	write := func(content string) {
+		if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("app:\n  log_level: info\n")
+	if err := LoadConfig(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	var notified *Config
+	unsubscribe := OnChange(func(old, new *Config) { notified = new })
+	defer unsubscribe()
+
+	write("app:\n  log_level: debug\nserver:\n  port: \"9999\"\nfeatures:\n  beta: true\n")
+	result, err := Reload(dir)
+	if err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+
+	if len(result.RestartRequired) != 1 || result.RestartRequired[0] != "server.port" {
+		t.Errorf("Expected server.port to require a restart, got %v", result.RestartRequired)
+	}
+	if Current().App.LogLevel != "debug" {
+		t.Errorf("Expected live log level debug, got %s", Current().App.LogLevel)
+	}
+	if Current().Server.Port != "8080" {
+		t.Errorf("Expected port to stay 8080, got %s", Current().Server.Port)
+	}
+	if !FeatureEnabled("beta") {
+		t.Error("Expected beta feature flag to be enabled")
+	}
+	if notified == nil || notified.App.LogLevel != "debug" {
+		t.Error("Expected subscriber to be notified with the new config")
+	}
+	if Cfg.App.LogLevel != "info" {
+		t.Errorf("Expected startup config to be left untouched, got %s", Cfg.App.LogLevel)
+	}
+}
+
+func TestReloadRejectsInvalidConfig(t *testing.T) {
+	t.Setenv("DATABASE_USER", "postgres")
+	t.Setenv("DATABASE_NAME", "make_it_rain")
+	t.Setenv("JWT_SECRET_KEY", "secret")
+
+	dir := t.TempDir()
+	if err := LoadConfig(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "config.yaml"), []byte("app:\n  rate_limit_rps: 0\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Reload(dir); err == nil {
+		t.Error("Expected invalid config to be rejected")
+	}
+	if Current().App.RateLimitRPS != 100 {
+		t.Errorf("Expected rate limit to stay 100, got %d", Current().App.RateLimitRPS)
+	}
+}
@@ -0,0 +1,138 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/manuel/make-it-rain/db"
+	"github.com/manuel/make-it-rain/logging"
+	"github.com/manuel/make-it-rain/services"
+)
+
+type createAPIKeyRequest struct {
+	Name   string   `json:"name" binding:"required,max=100"`
+	Scopes []string `json:"scopes"`
+	// ExpiresAt defaults to auth.api_keys.default_ttl from now
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// CreateAPIKey issues a personal API key for the authenticated user. The key
+// is in the response only this once.
+func CreateAPIKey(c *gin.Context) {
+	var req createAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	created, err := apiKeyService.Create(c.Request.Context(), currentUserID(c), req.Name, req.Scopes, req.ExpiresAt)
+	if err != nil {
+		respondAPIKeyError(c, err, "Failed to create API key")
+		return
+	}
+	c.JSON(http.StatusCreated, created)
+}
+
+// ListAPIKeys returns the authenticated user's keys, without the keys
+// themselves.
+func ListAPIKeys(c *gin.Context) {
+	listAPIKeys(c, currentUserID(c))
+}
+
+// RevokeAPIKey revokes one of the authenticated user's keys.
+func RevokeAPIKey(c *gin.Context) {
+	revokeAPIKey(c, currentUserID(c))
+}
+
+// ListAllAPIKeys lists API keys from the admin API, optionally only those
+// of ?user_id=.
+func ListAllAPIKeys(c *gin.Context) {
+	userID, err := strconv.ParseInt(c.DefaultQuery("user_id", "0"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	listAPIKeys(c, userID)
+}
+
+// AdminRevokeAPIKey revokes any user's key from the admin API.
+func AdminRevokeAPIKey(c *gin.Context) {
+	if revokeAPIKey(c, 0) {
+		logging.Ctx(c.Request.Context(), logging.HTTP).Warn().Str("api_key_id", c.Param("id")).Msg("API key revoked via admin API")
+	}
+}
+
+type setAPIKeyTierRequest struct {
+	Tier string `json:"tier" binding:"required"`
+}
+
+// SetAPIKeyTier moves a key to another rate limit tier from the admin API.
+func SetAPIKeyTier(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid API key ID"})
+		return
+	}
+	var req setAPIKeyTierRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	key, err := apiKeyService.SetTier(c.Request.Context(), id, req.Tier)
+	if err != nil {
+		respondAPIKeyError(c, err, "Failed to set API key tier")
+		return
+	}
+
+	logging.Ctx(c.Request.Context(), logging.HTTP).Warn().Int64("api_key_id", id).Str("tier", req.Tier).Msg("API key tier changed via admin API")
+	c.JSON(http.StatusOK, key)
+}
+
+func listAPIKeys(c *gin.Context, userID int64) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if limit < 1 || limit > 500 {
+		limit = 50
+	}
+
+	keys, err := apiKeyService.List(c.Request.Context(), userID, limit)
+	if err != nil {
+		respondAPIKeyError(c, err, "Failed to list API keys")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"api_keys": keys})
+}
+
+// revokeAPIKey revokes key :id, which must belong to userID unless it is 0,
+// and reports whether it did.
+func revokeAPIKey(c *gin.Context, userID int64) bool {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid API key ID"})
+		return false
+	}
+
+	if err := apiKeyService.Revoke(c.Request.Context(), userID, id); err != nil {
+		respondAPIKeyError(c, err, "Failed to revoke API key")
+		return false
+	}
+	c.Status(http.StatusNoContent)
+	return true
+}
+
+func respondAPIKeyError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, services.ErrInvalidAPIKey):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, db.ErrAPIKeyLimit):
+		c.JSON(http.StatusConflict, gin.H{"error": "Too many API keys; revoke one first"})
+	case errors.Is(err, db.ErrAPIKeyNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+	default:
+		logging.Ctx(c.Request.Context(), logging.HTTP).Error().Err(err).Msg(message)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
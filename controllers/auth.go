@@ -0,0 +1,273 @@
+package controllers
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/manuel/make-it-rain/auth"
+	"github.com/manuel/make-it-rain/config"
+	"github.com/manuel/make-it-rain/db"
+	"github.com/manuel/make-it-rain/logging"
+	"github.com/manuel/make-it-rain/services"
+	"github.com/manuel/make-it-rain/utils"
+)
+
+type loginRequest struct {
+	Email    string `json:"email" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+type verifyEmailRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+type resendVerificationRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type forgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type resetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required,min=8"`
+}
+
+type verifyMFARequest struct {
+	MFAToken string `json:"mfa_token" binding:"required"`
+	Code     string `json:"code" binding:"required"`
+}
+
+type enrollMFARequest struct {
+	MFAToken string `json:"mfa_token" binding:"required"`
+}
+
+// maxUserAgentLen caps the user agent recorded with a session.
+const maxUserAgentLen = 512
+
+// requestClient describes the caller for the session a login starts.
+func requestClient(c *gin.Context) services.Client {
+	userAgent := c.Request.UserAgent()
+	if len(userAgent) > maxUserAgentLen {
+		userAgent = strings.ToValidUTF8(userAgent[:maxUserAgentLen], "")
+	}
+	return services.Client{IP: c.ClientIP(), UserAgent: userAgent}
+}
+
+func Login(c *gin.Context) {
+	var req loginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tokens, err := authService.Login(c.Request.Context(), req.Email, req.Password, requestClient(c))
+	if err != nil {
+		respondAuthError(c, err, "Failed to log in")
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+// VerifyMFA completes a login that answered "code": "mfa_required" or
+// "mfa_enrollment_required".
+func VerifyMFA(c *gin.Context) {
+	var req verifyMFARequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tokens, err := authService.VerifyMFA(c.Request.Context(), req.MFAToken, req.Code, requestClient(c))
+	if err != nil {
+		respondAuthError(c, err, "Failed to verify second factor")
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+// EnrollMFA starts authenticator setup for a login that answered
+// "code": "mfa_enrollment_required".
+func EnrollMFA(c *gin.Context) {
+	var req enrollMFARequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	enrollment, err := authService.EnrollMFA(c.Request.Context(), req.MFAToken)
+	if err != nil {
+		respondAuthError(c, err, "Failed to set up two-factor authentication")
+		return
+	}
+	c.JSON(http.StatusOK, enrollment)
+}
+
+func RefreshToken(c *gin.Context) {
+	var req refreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tokens, err := authService.Refresh(c.Request.Context(), req.RefreshToken, requestClient(c))
+	if err != nil {
+		respondAuthError(c, err, "Failed to refresh token")
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+func Logout(c *gin.Context) {
+	var req refreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := authService.Logout(c.Request.Context(), req.RefreshToken); err != nil {
+		respondAuthError(c, err, "Failed to log out")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func VerifyEmail(c *gin.Context) {
+	var req verifyEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := verificationService.VerifyEmail(c.Request.Context(), req.Token)
+	if err != nil {
+		if errors.Is(err, db.ErrTokenInvalid) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Verification link is invalid or expired"})
+			return
+		}
+		respondAuthError(c, err, "Failed to verify email")
+		return
+	}
+	c.JSON(http.StatusOK, user)
+}
+
+// ResendVerification answers the same way whether or not the address is
+// registered.
+func ResendVerification(c *gin.Context) {
+	var req resendVerificationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := verificationService.ResendVerification(c.Request.Context(), req.Email, c.ClientIP()); err != nil {
+		respondAuthError(c, err, "Failed to send verification email")
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"message": "If the address needs verifying, a new link has been sent"})
+}
+
+// ForgotPassword answers the same way whether or not the address is
+// registered.
+func ForgotPassword(c *gin.Context) {
+	var req forgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := passwordService.ForgotPassword(c.Request.Context(), req.Email, c.ClientIP()); err != nil {
+		respondAuthError(c, err, "Failed to send password reset email")
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"message": "If an account uses this address, a password reset link has been sent"})
+}
+
+func ResetPassword(c *gin.Context) {
+	var req resetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := passwordService.ResetPassword(c.Request.Context(), req.Token, req.Password); err != nil {
+		if errors.Is(err, db.ErrTokenInvalid) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Reset link is invalid or expired"})
+			return
+		}
+		respondAuthError(c, err, "Failed to reset password")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Password has been reset"})
+}
+
+func respondAuthError(c *gin.Context, err error, message string) {
+	var locked *auth.LoginLockedError
+	var mfaRequired *services.MFARequiredError
+	var providerErr *auth.OIDCError
+	switch {
+	case errors.As(err, &mfaRequired):
+		code := "mfa_required"
+		if mfaRequired.EnrollmentRequired {
+			code = "mfa_enrollment_required"
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "A second factor is required", "code": code, "mfa_token": mfaRequired.Token})
+	case errors.As(err, &locked):
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(locked.RetryAfter.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed login attempts, try again later"})
+	case errors.Is(err, services.ErrInvalidCredentials):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email or password"})
+	case errors.Is(err, services.ErrUserInactive):
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account is inactive"})
+	case errors.Is(err, services.ErrEmailNotVerified):
+		c.JSON(http.StatusForbidden, gin.H{"error": "Email address is not verified", "code": "email_not_verified"})
+	case errors.Is(err, db.ErrTokenInvalid):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Token is invalid or expired"})
+	case errors.Is(err, db.ErrSessionNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+	case errors.Is(err, auth.ErrInvalidCode):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid authentication code"})
+	case errors.Is(err, db.ErrMFANotEnrolled):
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is not set up"})
+	case errors.Is(err, db.ErrMFAAlreadyEnabled):
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+	case errors.Is(err, services.ErrMFARequired):
+		c.JSON(http.StatusForbidden, gin.H{"error": "Two-factor authentication is required for this account"})
+	case errors.Is(err, services.ErrUnknownProvider):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Identity provider not found"})
+	case errors.As(err, &providerErr), errors.Is(err, auth.ErrInvalidIDToken):
+		logging.Ctx(c.Request.Context(), logging.HTTP).Warn().Err(err).Msg("Identity provider login refused")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Login with the identity provider failed"})
+	case errors.Is(err, auth.ErrProviderUnavailable):
+		logging.Ctx(c.Request.Context(), logging.HTTP).Error().Err(err).Msg(message)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Identity provider is unavailable"})
+	case errors.Is(err, services.ErrProviderEmailUnverified):
+		c.JSON(http.StatusForbidden, gin.H{"error": "The identity provider did not confirm your email address", "code": "provider_email_unverified"})
+	case errors.Is(err, services.ErrAccountNotLinkable):
+		c.JSON(http.StatusConflict, gin.H{"error": "An account uses this email address; verify it before logging in with this provider", "code": "email_not_verified"})
+	case errors.Is(err, services.ErrSignupDisabled):
+		c.JSON(http.StatusForbidden, gin.H{"error": "No account uses this email address", "code": "signup_disabled"})
+	case errors.Is(err, db.ErrIdentityLinked):
+		c.JSON(http.StatusConflict, gin.H{"error": "This account is already linked"})
+	case errors.Is(err, db.ErrIdentityNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Linked account not found"})
+	case errors.Is(err, services.ErrRateLimited):
+		c.Header("Retry-After", strconv.Itoa(int(config.Cfg.Auth.ResendWindow.Seconds())))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many requests, try again later"})
+	default:
+		if utils.RespondWithContextError(c, err) {
+			return
+		}
+		logging.Ctx(c.Request.Context(), logging.HTTP).Error().Err(err).Msg(message)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
@@ -0,0 +1,100 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/manuel/make-it-rain/db"
+	"github.com/manuel/make-it-rain/logging"
+	"github.com/manuel/make-it-rain/models"
+)
+
+var jobStore = db.NewJobStore()
+
+// ListJobs lists recent jobs, optionally filtered by ?status= and ?type=.
+func ListJobs(c *gin.Context) {
+	status := c.Query("status")
+	switch status {
+	case "", models.JobQueued, models.JobRunning, models.JobSucceeded, models.JobDead, models.JobCancelled:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid status"})
+		return
+	}
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if limit < 1 || limit > 500 {
+		limit = 50
+	}
+
+	jobs, err := jobStore.ListJobs(c.Request.Context(), status, c.Query("type"), limit)
+	if err != nil {
+		logging.Ctx(c.Request.Context(), logging.HTTP).Error().Err(err).Msg("Failed to list jobs")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list jobs"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"jobs": jobs})
+}
+
+func GetJob(c *gin.Context) {
+	id, ok := parseIDParam(c, "id")
+	if !ok {
+		return
+	}
+
+	job, err := jobStore.GetJob(c.Request.Context(), id)
+	if err != nil {
+		respondJobError(c, err, id, "Failed to get job")
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
+// RetryJob queues a dead or cancelled job to run again now.
+func RetryJob(c *gin.Context) {
+	id, ok := parseIDParam(c, "id")
+	if !ok {
+		return
+	}
+
+	job, err := jobStore.RetryJob(c.Request.Context(), id)
+	if err != nil {
+		respondJobError(c, err, id, "Failed to retry job")
+		return
+	}
+
+	logging.Ctx(c.Request.Context(), logging.HTTP).Warn().Int64("job_id", id).Msg("Job retried via admin API")
+	c.JSON(http.StatusOK, job)
+}
+
+// CancelJob stops a queued job from running. Running jobs can't be cancelled.
+func CancelJob(c *gin.Context) {
+	id, ok := parseIDParam(c, "id")
+	if !ok {
+		return
+	}
+
+	job, err := jobStore.CancelJob(c.Request.Context(), id)
+	if err != nil {
+		respondJobError(c, err, id, "Failed to cancel job")
+		return
+	}
+
+	logging.Ctx(c.Request.Context(), logging.HTTP).Warn().Int64("job_id", id).Msg("Job cancelled via admin API")
+	c.JSON(http.StatusOK, job)
+}
+
+func respondJobError(c *gin.Context, err error, id int64, msg string) {
+	switch {
+	case errors.Is(err, db.ErrJobNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+	case errors.Is(err, db.ErrJobState):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		logging.Ctx(c.Request.Context(), logging.HTTP).Error().Err(err).Int64("job_id", id).Msg(msg)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+	}
+}
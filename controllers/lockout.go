@@ -0,0 +1,77 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/manuel/make-it-rain/db"
+	"github.com/manuel/make-it-rain/logging"
+)
+
+// ListLoginLocks shows the accounts and client IPs currently locked out
+// after failed logins.
+func ListLoginLocks(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if limit < 1 || limit > 500 {
+		limit = 50
+	}
+
+	locks, err := authService.ListLoginLocks(c.Request.Context(), limit)
+	if err != nil {
+		logging.Ctx(c.Request.Context(), logging.HTTP).Error().Err(err).Msg("Failed to list login locks")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list login locks"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"locks": locks})
+}
+
+// UnlockUser lifts a login lockout on a user's account.
+func UnlockUser(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	if err := authService.UnlockUser(c.Request.Context(), id); err != nil {
+		if err.Error() == "user not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		respondUnlockError(c, err)
+		return
+	}
+
+	logging.Ctx(c.Request.Context(), logging.HTTP).Warn().Int64("user_id", id).Msg("Account unlocked via admin API")
+	c.JSON(http.StatusOK, gin.H{"message": "Account unlocked"})
+}
+
+// DeleteLoginLock lifts the lockout on ?key=, an "account:<email>" or
+// "ip:<address>" key as listed by ListLoginLocks.
+func DeleteLoginLock(c *gin.Context) {
+	key := c.Query("key")
+	if key == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "key is required"})
+		return
+	}
+
+	if err := authService.UnlockLogin(c.Request.Context(), key); err != nil {
+		respondUnlockError(c, err)
+		return
+	}
+
+	logging.Ctx(c.Request.Context(), logging.HTTP).Warn().Str("key", key).Msg("Login lock removed via admin API")
+	c.JSON(http.StatusOK, gin.H{"message": "Login unlocked"})
+}
+
+func respondUnlockError(c *gin.Context, err error) {
+	if errors.Is(err, db.ErrLoginNotLocked) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Login is not locked"})
+		return
+	}
+	logging.Ctx(c.Request.Context(), logging.HTTP).Error().Err(err).Msg("Failed to unlock login")
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlock login"})
+}
@@ -0,0 +1,64 @@
+package controllers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/manuel/make-it-rain/logging"
+	"github.com/rs/zerolog"
+)
+
+type SetLogLevelRequest struct {
+	// Component is one of logging.Components; empty changes all of them
+	Component string `json:"component"`
+	Level     string `json:"level" binding:"required"`
+	// Duration such as "15m" after which the previous level is restored
+	Duration string `json:"duration"`
+}
+
+func GetLogLevels(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"levels": logging.Levels.Status()})
+}
+
+func SetLogLevel(c *gin.Context) {
+	var req SetLogLevelRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	level, err := logging.ParseLevel(req.Level)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var ttl time.Duration
+	if req.Duration != "" {
+		ttl, err = time.ParseDuration(req.Duration)
+		if err != nil || ttl <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid duration"})
+			return
+		}
+	}
+
+	if err := logging.Levels.Set(req.Component, level, ttl); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	zerolog.Ctx(c.Request.Context()).Warn().
+		Str("component", req.Component).
+		Str("level", level.String()).
+		Dur("duration", ttl).
+		Msg("Log level changed via admin API")
+
+	c.JSON(http.StatusOK, gin.H{"levels": logging.Levels.Status()})
+}
+
+// ResetLogLevels restores the configured levels.
+func ResetLogLevels(c *gin.Context) {
+	logging.Levels.Reset()
+	c.JSON(http.StatusOK, gin.H{"levels": logging.Levels.Status()})
+}
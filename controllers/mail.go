@@ -0,0 +1,54 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/manuel/make-it-rain/db"
+	"github.com/manuel/make-it-rain/logging"
+)
+
+var mailLogStore = db.NewMailLogStore()
+
+// ListSentEmails shows the outgoing mail log, optionally filtered by
+// ?recipient=.
+func ListSentEmails(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if limit < 1 || limit > 500 {
+		limit = 50
+	}
+
+	emails, err := mailLogStore.ListSentEmails(c.Request.Context(), c.Query("recipient"), limit)
+	if err != nil {
+		logging.Ctx(c.Request.Context(), logging.HTTP).Error().Err(err).Msg("Failed to list sent emails")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list sent emails"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"emails": emails})
+}
+
+type testEmailRequest struct {
+	To     string `json:"to" binding:"required,email"`
+	Locale string `json:"locale"`
+}
+
+// SendTestEmail queues the "test" template, to check the mail setup end to end.
+func SendTestEmail(c *gin.Context) {
+	var req testEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	data := gin.H{"SentAt": time.Now().UTC().Format(time.RFC1123)}
+	if err := mailSender.Send(c.Request.Context(), req.To, "test", req.Locale, data); err != nil {
+		logging.Ctx(c.Request.Context(), logging.HTTP).Error().Err(err).Msg("Failed to queue test email")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to queue test email"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "Test email queued"})
+}
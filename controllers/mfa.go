@@ -0,0 +1,122 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/manuel/make-it-rain/logging"
+)
+
+type mfaCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// StartMFAEnrollment creates a pending authenticator secret for the
+// authenticated user.
+func StartMFAEnrollment(c *gin.Context) {
+	enrollment, err := mfaService.Enroll(c.Request.Context(), currentUserID(c))
+	if err != nil {
+		respondAuthError(c, err, "Failed to set up two-factor authentication")
+		return
+	}
+	c.JSON(http.StatusOK, enrollment)
+}
+
+// ConfirmMFA enables MFA with a first code and returns the recovery codes.
+func ConfirmMFA(c *gin.Context) {
+	var req mfaCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	codes, err := mfaService.Confirm(c.Request.Context(), currentUserID(c), req.Code, c.ClientIP())
+	if err != nil {
+		respondAuthError(c, err, "Failed to enable two-factor authentication")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+func DisableMFA(c *gin.Context) {
+	var req mfaCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := mfaService.Disable(c.Request.Context(), currentUserID(c), req.Code, c.ClientIP()); err != nil {
+		respondAuthError(c, err, "Failed to disable two-factor authentication")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func RegenerateRecoveryCodes(c *gin.Context) {
+	var req mfaCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	codes, err := mfaService.RegenerateRecoveryCodes(c.Request.Context(), currentUserID(c), req.Code, c.ClientIP())
+	if err != nil {
+		respondAuthError(c, err, "Failed to regenerate recovery codes")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+type setRoleRequest struct {
+	Role string `json:"role" binding:"required,oneof=user admin"`
+}
+
+// SetUserRole changes a user's role from the admin API.
+func SetUserRole(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	var req setRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := userService.SetRole(c.Request.Context(), id, req.Role)
+	if err != nil {
+		if err.Error() == "user not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		respondAuthError(c, err, "Failed to set user role")
+		return
+	}
+
+	logging.Ctx(c.Request.Context(), logging.HTTP).Warn().Int64("user_id", id).Str("role", req.Role).Msg("User role changed via admin API")
+	c.JSON(http.StatusOK, user)
+}
+
+// ResetUserMFA removes a user's second factor, e.g. after they lost their
+// device.
+func ResetUserMFA(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	if err := mfaService.Reset(c.Request.Context(), id); err != nil {
+		if err.Error() == "user not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		respondAuthError(c, err, "Failed to reset two-factor authentication")
+		return
+	}
+
+	logging.Ctx(c.Request.Context(), logging.HTTP).Warn().Int64("user_id", id).Msg("Two-factor authentication reset via admin API")
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,72 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type oidcCallbackRequest struct {
+	Code  string `json:"code" binding:"required"`
+	State string `json:"state" binding:"required"`
+}
+
+// ListOIDCProviders returns the identity providers users can log in with.
+func ListOIDCProviders(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"providers": oidcService.Providers()})
+}
+
+// StartOIDCLogin returns the provider URL to send the user to. The provider
+// sends them back to its redirect_url with a code and the state.
+func StartOIDCLogin(c *gin.Context) {
+	authorization, err := oidcService.StartLogin(c.Request.Context(), c.Param("provider"))
+	if err != nil {
+		respondAuthError(c, err, "Failed to start login")
+		return
+	}
+	c.JSON(http.StatusOK, authorization)
+}
+
+// FinishOIDCLogin logs the user in with the code and state the provider
+// sent them back with. It answers like Login.
+func FinishOIDCLogin(c *gin.Context) {
+	var req oidcCallbackRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tokens, err := oidcService.FinishLogin(c.Request.Context(), c.Param("provider"), req.Code, req.State, requestClient(c))
+	if err != nil {
+		respondAuthError(c, err, "Failed to log in")
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+// ListIdentities returns the provider accounts linked to the authenticated
+// user.
+func ListIdentities(c *gin.Context) {
+	identities, err := oidcService.ListIdentities(c.Request.Context(), currentUserID(c))
+	if err != nil {
+		respondAuthError(c, err, "Failed to list linked accounts")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"identities": identities})
+}
+
+// UnlinkIdentity removes a provider account from the authenticated user.
+func UnlinkIdentity(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid identity ID"})
+		return
+	}
+
+	if err := oidcService.UnlinkIdentity(c.Request.Context(), currentUserID(c), id); err != nil {
+		respondAuthError(c, err, "Failed to unlink account")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,62 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/manuel/make-it-rain/db"
+	"github.com/manuel/make-it-rain/logging"
+	"github.com/manuel/make-it-rain/models"
+)
+
+var outboxStore = db.NewOutboxStore()
+
+// ListOutboxEvents lists recent outbox events, optionally filtered by
+// ?status=pending|delivered|dead.
+func ListOutboxEvents(c *gin.Context) {
+	status := c.Query("status")
+	switch status {
+	case "", models.EventPending, models.EventDelivered, models.EventDead:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid status"})
+		return
+	}
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if limit < 1 || limit > 500 {
+		limit = 50
+	}
+
+	events, err := outboxStore.ListOutboxEvents(c.Request.Context(), status, limit)
+	if err != nil {
+		logging.Ctx(c.Request.Context(), logging.HTTP).Error().Err(err).Msg("Failed to list outbox events")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list outbox events"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"events": events})
+}
+
+// RequeueOutboxEvent moves a dead-lettered event back to pending.
+func RequeueOutboxEvent(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event ID"})
+		return
+	}
+
+	if err := outboxStore.RequeueOutboxEvent(c.Request.Context(), id); err != nil {
+		if errors.Is(err, db.ErrOutboxEventNotDead) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Dead-lettered event not found"})
+			return
+		}
+		logging.Ctx(c.Request.Context(), logging.HTTP).Error().Err(err).Int64("event_id", id).Msg("Failed to requeue outbox event")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to requeue outbox event"})
+		return
+	}
+
+	logging.Ctx(c.Request.Context(), logging.HTTP).Warn().Int64("event_id", id).Msg("Outbox event requeued via admin API")
+	c.JSON(http.StatusOK, gin.H{"message": "Event requeued"})
+}
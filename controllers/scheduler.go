@@ -0,0 +1,23 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/manuel/make-it-rain/db"
+	"github.com/manuel/make-it-rain/logging"
+)
+
+var scheduleStore = db.NewScheduleStore()
+
+// ListScheduledTasks shows each recurring task with its last run and outcome.
+func ListScheduledTasks(c *gin.Context) {
+	tasks, err := scheduleStore.ListScheduledTasks(c.Request.Context())
+	if err != nil {
+		logging.Ctx(c.Request.Context(), logging.HTTP).Error().Err(err).Msg("Failed to list scheduled tasks")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list scheduled tasks"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"tasks": tasks})
+}
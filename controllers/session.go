@@ -0,0 +1,80 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/manuel/make-it-rain/logging"
+	"github.com/manuel/make-it-rain/middleware"
+)
+
+// ListSessions returns the authenticated user's live sessions, marking the
+// one the request was made with.
+func ListSessions(c *gin.Context) {
+	listSessions(c, currentUserID(c), c.GetInt64(middleware.SessionIDKey))
+}
+
+// RevokeSession logs one of the authenticated user's sessions out.
+func RevokeSession(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID"})
+		return
+	}
+
+	if err := authService.RevokeSession(c.Request.Context(), currentUserID(c), id); err != nil {
+		respondAuthError(c, err, "Failed to revoke session")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// RevokeAllSessions logs the authenticated user out everywhere, including
+// the session the request was made with.
+func RevokeAllSessions(c *gin.Context) {
+	revokeAllSessions(c, currentUserID(c))
+}
+
+// ListUserSessions lists a user's live sessions from the admin API.
+func ListUserSessions(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	listSessions(c, id, 0)
+}
+
+// RevokeUserSessions logs a user out everywhere from the admin API.
+func RevokeUserSessions(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	if revokeAllSessions(c, id) {
+		logging.Ctx(c.Request.Context(), logging.HTTP).Warn().Int64("user_id", id).Msg("Sessions revoked via admin API")
+	}
+}
+
+func listSessions(c *gin.Context, userID, currentID int64) {
+	sessions, err := authService.ListSessions(c.Request.Context(), userID, currentID)
+	if err != nil {
+		respondAuthError(c, err, "Failed to list sessions")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"sessions": sessions})
+}
+
+// revokeAllSessions ends every session of userID and reports whether it
+// did.
+func revokeAllSessions(c *gin.Context, userID int64) bool {
+	revoked, err := authService.RevokeAllSessions(c.Request.Context(), userID)
+	if err != nil {
+		respondAuthError(c, err, "Failed to revoke sessions")
+		return false
+	}
+	c.JSON(http.StatusOK, gin.H{"revoked": revoked})
+	return true
+}
@@ -1,32 +1,126 @@
 package controllers
 
 import (
+	"context"
+	"errors"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/manuel/make-it-rain/auth"
+	"github.com/manuel/make-it-rain/config"
 	"github.com/manuel/make-it-rain/db"
+	"github.com/manuel/make-it-rain/logging"
+	"github.com/manuel/make-it-rain/mailer"
+	"github.com/manuel/make-it-rain/middleware"
+	"github.com/manuel/make-it-rain/models"
 	"github.com/manuel/make-it-rain/services"
-	"github.com/rs/zerolog/log"
+	"github.com/manuel/make-it-rain/tracing"
+	"github.com/manuel/make-it-rain/utils"
 )
 
-var userService *services.UserService
+var (
+	userService         *services.UserService
+	authService         *services.AuthService
+	verificationService *services.VerificationService
+	passwordService     *services.PasswordService
+	mfaService          *services.MFAService
+	apiKeyService       *services.APIKeyService
+	oidcService         *services.OIDCService
+	mailSender          *mailer.Sender
+)
+
+// InitServices wires the controllers to the database layer, mailer, login
+// limiter and MFA secret box. It is called from main once the configuration
+// and connection pool are ready.
+func InitServices(dbService db.DBService, mail *mailer.Sender, limiter *auth.LoginLimiter, box *auth.SecretBox) {
+	authStore := db.NewAuthStore()
+	verificationService = services.NewVerificationService(dbService, authStore, mail, config.Cfg.Auth)
+	userService = services.NewUserService(dbService, verificationService)
+	mfaService = services.NewMFAService(dbService, db.NewMFAStore(), limiter, box, config.Cfg.App.Name, config.Cfg.Auth.MFA)
+	authService = services.NewAuthService(userService, authStore, limiter, mfaService, config.Cfg.JWT, config.Cfg.Auth)
+	passwordService = services.NewPasswordService(dbService, authStore, mail, config.Cfg.Auth)
+	apiKeyService = services.NewAPIKeyService(db.NewAPIKeyStore(), config.Cfg.Auth.APIKeys)
+	oidcService = services.NewOIDCService(authService, dbService, db.NewIdentityStore(), config.Cfg.Auth.OIDC)
+	webhookService = services.NewWebhookService(db.NewWebhookStore())
+	mailSender = mail
+}
+
+// requestLocale returns the first language in Accept-Language, e.g. "es-MX"
+// for "es-MX,es;q=0.9". Templates fall back from there.
+func requestLocale(c *gin.Context) string {
+	first, _, _ := strings.Cut(c.GetHeader("Accept-Language"), ",")
+	tag, _, _ := strings.Cut(first, ";")
+	tag = strings.TrimSpace(tag)
+	if tag == "*" || len(tag) > 35 {
+		return ""
+	}
+	return tag
+}
 
-func init() {
-	dbService := db.NewDBService()
-	userService = services.NewUserService(dbService)
+// currentUserID returns the user authenticated by middleware.Auth.
+func currentUserID(c *gin.Context) int64 {
+	return c.GetInt64(middleware.UserIDKey)
+}
+
+// isAdmin reports whether the authenticated user is an active admin.
+func isAdmin(ctx context.Context, c *gin.Context) (bool, error) {
+	user, err := userService.GetUser(ctx, currentUserID(c))
+	if err != nil {
+		if err.Error() == "user not found" {
+			return false, nil
+		}
+		return false, err
+	}
+	return user.IsActive && user.Role == models.RoleAdmin, nil
+}
+
+// requireAdmin responds with 403 unless the authenticated user is an admin.
+func requireAdmin(ctx context.Context, c *gin.Context) bool {
+	admin, err := isAdmin(ctx, c)
+	if err != nil {
+		if !utils.RespondWithContextError(c, err) {
+			logging.Ctx(ctx, logging.HTTP).Error().Err(err).Msg("Failed to check user role")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check permissions"})
+		}
+		return false
+	}
+	if !admin {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only admins can do this"})
+		return false
+	}
+	return true
+}
+
+// requireOwnerOrAdmin responds with 403 unless userID is the authenticated
+// user or the authenticated user is an admin.
+func requireOwnerOrAdmin(ctx context.Context, c *gin.Context, userID int64) bool {
+	if userID == currentUserID(c) {
+		return true
+	}
+	return requireAdmin(ctx, c)
 }
 
 func CreateUser(c *gin.Context) {
+	ctx, span := tracing.Start(c.Request.Context(), "controllers.CreateUser")
+	defer span.End()
+
 	var req db.CreateUserRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if req.Locale == "" {
+		req.Locale = requestLocale(c)
+	}
 
-	user, err := userService.CreateUser(c.Request.Context(), &req)
+	user, err := userService.CreateUser(ctx, &req)
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to create user")
+		if utils.RespondWithContextError(c, err) {
+			return
+		}
+		logging.Ctx(ctx, logging.HTTP).Error().Err(err).Msg("Failed to create user")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
 		return
 	}
@@ -35,19 +129,28 @@ func CreateUser(c *gin.Context) {
 }
 
 func GetUser(c *gin.Context) {
+	ctx, span := tracing.Start(c.Request.Context(), "controllers.GetUser")
+	defer span.End()
+
 	userID, err := strconv.ParseInt(c.Param("id"), 10, 64)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
 		return
 	}
+	if !requireOwnerOrAdmin(ctx, c, userID) {
+		return
+	}
 
-	user, err := userService.GetUser(c.Request.Context(), userID)
+	user, err := userService.GetUser(ctx, userID)
 	if err != nil {
 		if err.Error() == "user not found" {
 			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 			return
 		}
-		log.Error().Err(err).Int64("user_id", userID).Msg("Failed to get user")
+		if utils.RespondWithContextError(c, err) {
+			return
+		}
+		logging.Ctx(ctx, logging.HTTP).Error().Err(err).Int64("user_id", userID).Msg("Failed to get user")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user"})
 		return
 	}
@@ -55,7 +158,72 @@ func GetUser(c *gin.Context) {
 	c.JSON(http.StatusOK, user)
 }
 
+// GetCurrentUser returns the authenticated user.
+func GetCurrentUser(c *gin.Context) {
+	ctx, span := tracing.Start(c.Request.Context(), "controllers.GetCurrentUser")
+	defer span.End()
+
+	userID := currentUserID(c)
+	user, err := userService.GetUser(ctx, userID)
+	if err != nil {
+		if err.Error() == "user not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		if utils.RespondWithContextError(c, err) {
+			return
+		}
+		logging.Ctx(ctx, logging.HTTP).Error().Err(err).Int64("user_id", userID).Msg("Failed to get user")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user"})
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+type changePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required,min=8"`
+}
+
+// ChangePassword sets a new password for the authenticated user. All their
+// sessions are revoked, and the caller gets a new one.
+func ChangePassword(c *gin.Context) {
+	ctx, span := tracing.Start(c.Request.Context(), "controllers.ChangePassword")
+	defer span.End()
+
+	var req changePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID := currentUserID(c)
+	if err := passwordService.ChangePassword(ctx, userID, req.CurrentPassword, req.NewPassword); err != nil {
+		if errors.Is(err, services.ErrWrongPassword) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Current password is incorrect"})
+			return
+		}
+		respondAuthError(c, err, "Failed to change password")
+		return
+	}
+
+	tokens, err := authService.StartSession(ctx, userID, requestClient(c))
+	if err != nil {
+		respondAuthError(c, err, "Password changed, but failed to start a new session")
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
 func GetUsers(c *gin.Context) {
+	ctx, span := tracing.Start(c.Request.Context(), "controllers.GetUsers")
+	defer span.End()
+
+	if !requireAdmin(ctx, c) {
+		return
+	}
+
 	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
 	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "10"))
 	sortBy := c.DefaultQuery("sort_by", "created_at")
@@ -71,9 +239,12 @@ func GetUsers(c *gin.Context) {
 		sortOrder = "desc"
 	}
 
-	result, err := userService.GetUsers(c.Request.Context(), page, pageSize, sortBy, sortOrder)
+	result, err := userService.GetUsers(ctx, page, pageSize, sortBy, sortOrder)
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to get users")
+		if utils.RespondWithContextError(c, err) {
+			return
+		}
+		logging.Ctx(ctx, logging.HTTP).Error().Err(err).Msg("Failed to get users")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get users"})
 		return
 	}
@@ -82,11 +253,17 @@ func GetUsers(c *gin.Context) {
 }
 
 func UpdateUser(c *gin.Context) {
+	ctx, span := tracing.Start(c.Request.Context(), "controllers.UpdateUser")
+	defer span.End()
+
 	userID, err := strconv.ParseInt(c.Param("id"), 10, 64)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
 		return
 	}
+	if !requireOwnerOrAdmin(ctx, c, userID) {
+		return
+	}
 
 	var updates map[string]interface{}
 	if err := c.ShouldBindJSON(&updates); err != nil {
@@ -94,16 +271,24 @@ func UpdateUser(c *gin.Context) {
 		return
 	}
 
-	delete(updates, "id")
-	delete(updates, "created_at")
-	delete(updates, "password")
+	// Only admins activate and deactivate accounts
+	if _, ok := updates["is_active"]; ok && !requireAdmin(ctx, c) {
+		return
+	}
 
-	if err := userService.UpdateUser(c.Request.Context(), userID, updates); err != nil {
+	if err := userService.UpdateUser(ctx, userID, updates); err != nil {
+		if errors.Is(err, services.ErrInvalidUpdate) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		if err.Error() == "user not found" {
 			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 			return
 		}
-		log.Error().Err(err).Int64("user_id", userID).Msg("Failed to update user")
+		if utils.RespondWithContextError(c, err) {
+			return
+		}
+		logging.Ctx(ctx, logging.HTTP).Error().Err(err).Int64("user_id", userID).Msg("Failed to update user")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
 		return
 	}
@@ -112,18 +297,27 @@ func UpdateUser(c *gin.Context) {
 }
 
 func DeleteUser(c *gin.Context) {
+	ctx, span := tracing.Start(c.Request.Context(), "controllers.DeleteUser")
+	defer span.End()
+
 	userID, err := strconv.ParseInt(c.Param("id"), 10, 64)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
 		return
 	}
+	if !requireOwnerOrAdmin(ctx, c, userID) {
+		return
+	}
 
-	if err := userService.DeleteUser(c.Request.Context(), userID); err != nil {
+	if err := userService.DeleteUser(ctx, userID); err != nil {
 		if err.Error() == "user not found" {
 			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 			return
 		}
-		log.Error().Err(err).Int64("user_id", userID).Msg("Failed to delete user")
+		if utils.RespondWithContextError(c, err) {
+			return
+		}
+		logging.Ctx(ctx, logging.HTTP).Error().Err(err).Int64("user_id", userID).Msg("Failed to delete user")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user"})
 		return
 	}
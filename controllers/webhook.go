@@ -0,0 +1,155 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/manuel/make-it-rain/db"
+	"github.com/manuel/make-it-rain/logging"
+	"github.com/manuel/make-it-rain/models"
+	"github.com/manuel/make-it-rain/services"
+)
+
+var webhookService *services.WebhookService
+
+// webhookWithSecret is returned on creation and secret rotation, the only
+// times the signing secret is shown.
+type webhookWithSecret struct {
+	*models.WebhookSubscription
+	Secret string `json:"secret"`
+}
+
+func CreateWebhook(c *gin.Context) {
+	var req services.CreateWebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	webhook, err := webhookService.CreateWebhook(c.Request.Context(), &req)
+	if err != nil {
+		respondWebhookError(c, err, "Failed to create webhook")
+		return
+	}
+
+	c.JSON(http.StatusCreated, webhookWithSecret{WebhookSubscription: webhook, Secret: webhook.Secret})
+}
+
+func GetWebhooks(c *gin.Context) {
+	webhooks, err := webhookService.ListWebhooks(c.Request.Context())
+	if err != nil {
+		respondWebhookError(c, err, "Failed to list webhooks")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"webhooks": webhooks})
+}
+
+func GetWebhook(c *gin.Context) {
+	id, ok := parseIDParam(c, "id")
+	if !ok {
+		return
+	}
+
+	webhook, err := webhookService.GetWebhook(c.Request.Context(), id)
+	if err != nil {
+		respondWebhookError(c, err, "Failed to get webhook")
+		return
+	}
+	c.JSON(http.StatusOK, webhook)
+}
+
+func UpdateWebhook(c *gin.Context) {
+	id, ok := parseIDParam(c, "id")
+	if !ok {
+		return
+	}
+
+	var req services.UpdateWebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	webhook, err := webhookService.UpdateWebhook(c.Request.Context(), id, &req)
+	if err != nil {
+		respondWebhookError(c, err, "Failed to update webhook")
+		return
+	}
+
+	if req.RotateSecret {
+		c.JSON(http.StatusOK, webhookWithSecret{WebhookSubscription: webhook, Secret: webhook.Secret})
+		return
+	}
+	c.JSON(http.StatusOK, webhook)
+}
+
+func DeleteWebhook(c *gin.Context) {
+	id, ok := parseIDParam(c, "id")
+	if !ok {
+		return
+	}
+
+	if err := webhookService.DeleteWebhook(c.Request.Context(), id); err != nil {
+		respondWebhookError(c, err, "Failed to delete webhook")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Webhook deleted successfully"})
+}
+
+func GetWebhookDeliveries(c *gin.Context) {
+	id, ok := parseIDParam(c, "id")
+	if !ok {
+		return
+	}
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if limit < 1 || limit > 500 {
+		limit = 50
+	}
+
+	deliveries, err := webhookService.ListDeliveries(c.Request.Context(), id, limit)
+	if err != nil {
+		respondWebhookError(c, err, "Failed to list webhook deliveries")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"deliveries": deliveries})
+}
+
+func RedeliverWebhook(c *gin.Context) {
+	id, ok := parseIDParam(c, "id")
+	if !ok {
+		return
+	}
+
+	delivery, err := webhookService.Redeliver(c.Request.Context(), id)
+	if err != nil {
+		respondWebhookError(c, err, "Failed to redeliver webhook")
+		return
+	}
+	c.JSON(http.StatusAccepted, delivery)
+}
+
+func respondWebhookError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, services.ErrInvalidWebhook):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, db.ErrWebhookNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+	case errors.Is(err, db.ErrWebhookDeliveryNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook delivery not found"})
+	default:
+		logging.Ctx(c.Request.Context(), logging.HTTP).Error().Err(err).Msg(message)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
+
+func parseIDParam(c *gin.Context, name string) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param(name), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return 0, false
+	}
+	return id, true
+}
@@ -216,6 +216,19 @@ func (lru *LRUCacheWithTTL[K, V]) Put(key K, value V) {
 	}
 }
 
+// Delete removes a key from cache
+func (lru *LRUCacheWithTTL[K, V]) Delete(key K) bool {
+	lru.mu.Lock()
+	defer lru.mu.Unlock()
+
+	if elem, exists := lru.cache[key]; exists {
+		lru.list.Remove(elem)
+		delete(lru.cache, key)
+		return true
+	}
+	return false
+}
+
 // cleanupExpired periodically removes expired entries
 func (lru *LRUCacheWithTTL[K, V]) cleanupExpired() {
 	ticker := time.NewTicker(lru.ttl / 2)
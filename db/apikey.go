@@ -0,0 +1,198 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/manuel/make-it-rain/models"
+	"github.com/manuel/make-it-rain/pubsub"
+)
+
+// APIKeysChannel is notified when an API key is revoked or changed, so every
+// instance can drop it from its cache of resolved keys.
+const APIKeysChannel = "api_key_changes"
+
+// APIKeyChange is the payload published on APIKeysChannel. An empty KeyHash
+// means every key of the user, e.g. when the user is deactivated or deleted.
+type APIKeyChange struct {
+	KeyHash string `json:"key_hash,omitempty"`
+	UserID  int64  `json:"user_id,omitempty"`
+}
+
+var (
+	ErrAPIKeyNotFound = errors.New("API key not found")
+	// ErrAPIKeyLimit is returned when a user already has the maximum number
+	// of live keys.
+	ErrAPIKeyLimit = errors.New("too many API keys")
+)
+
+// APIKeyStore keeps personal API keys. Keys are looked up by hash; the plain
+// values are never stored.
+type APIKeyStore interface {
+	// CreateAPIKey stores k unless the user already has maxPerUser keys that
+	// are neither revoked nor expired, in which case it returns
+	// ErrAPIKeyLimit.
+	CreateAPIKey(ctx context.Context, k *models.APIKey, maxPerUser int) error
+	// ListAPIKeys returns the newest keys of a user, or of every user when
+	// userID is 0, including revoked and expired ones until they're purged.
+	ListAPIKeys(ctx context.Context, userID int64, limit int) ([]models.APIKey, error)
+	// UseAPIKey returns the live key with keyHash and records that it was
+	// used. Keys of deactivated users aren't live. It returns
+	// ErrTokenInvalid for anything else.
+	UseAPIKey(ctx context.Context, keyHash string) (*models.APIKey, error)
+	// RevokeAPIKey revokes a key and notifies APIKeysChannel. When userID is
+	// non-zero the key must belong to that user. It returns
+	// ErrAPIKeyNotFound when there's no such live key.
+	RevokeAPIKey(ctx context.Context, id, userID int64) error
+	// SetAPIKeyTier changes a key's rate limit tier and notifies
+	// APIKeysChannel.
+	SetAPIKeyTier(ctx context.Context, id int64, tier string) (*models.APIKey, error)
+}
+
+func NewAPIKeyStore() APIKeyStore {
+	return &RealDBService{}
+}
+
+const apiKeyColumns = `id, user_id, name, prefix, key_hash, scopes, tier, expires_at, last_used_at, revoked_at, created_at`
+
+func scanAPIKey(row pgx.Row, k *models.APIKey) error {
+	return row.Scan(
+		&k.ID,
+		&k.UserID,
+		&k.Name,
+		&k.Prefix,
+		&k.KeyHash,
+		&k.Scopes,
+		&k.Tier,
+		&k.ExpiresAt,
+		&k.LastUsedAt,
+		&k.RevokedAt,
+		&k.CreatedAt,
+	)
+}
+
+func (s *RealDBService) CreateAPIKey(ctx context.Context, k *models.APIKey, maxPerUser int) error {
+	query := `
+		INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, tier, expires_at)
+		SELECT $1, $2, $3, $4, $5, $6, $7
+		WHERE (
+			SELECT COUNT(*) FROM api_keys
+			WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+		) < $8
+		RETURNING id, created_at`
+
+	err := Conn.QueryRow(ctx, query,
+		k.UserID, k.Name, k.Prefix, k.KeyHash, k.Scopes, k.Tier, k.ExpiresAt, maxPerUser,
+	).Scan(&k.ID, &k.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrAPIKeyLimit
+		}
+		return fmt.Errorf("failed to create API key: %w", err)
+	}
+	return nil
+}
+
+func (s *RealDBService) ListAPIKeys(ctx context.Context, userID int64, limit int) ([]models.APIKey, error) {
+	query := `
+		SELECT ` + apiKeyColumns + `
+		FROM api_keys
+		WHERE $1 = 0 OR user_id = $1
+		ORDER BY created_at DESC, id DESC
+		LIMIT $2`
+
+	rows, err := Conn.Query(ctx, query, userID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list API keys: %w", err)
+	}
+	defer rows.Close()
+
+	keys := []models.APIKey{}
+	for rows.Next() {
+		var k models.APIKey
+		if err := scanAPIKey(rows, &k); err != nil {
+			return nil, fmt.Errorf("failed to scan API key: %w", err)
+		}
+		keys = append(keys, k)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list API keys: %w", err)
+	}
+	return keys, nil
+}
+
+func (s *RealDBService) UseAPIKey(ctx context.Context, keyHash string) (*models.APIKey, error) {
+	query := `
+		UPDATE api_keys k
+		SET last_used_at = NOW()
+		FROM users u
+		WHERE k.key_hash = $1 AND k.revoked_at IS NULL AND k.expires_at > NOW()
+			AND u.id = k.user_id AND u.is_active
+		RETURNING k.id, k.user_id, k.name, k.prefix, k.key_hash, k.scopes, k.tier,
+			k.expires_at, k.last_used_at, k.revoked_at, k.created_at`
+
+	var k models.APIKey
+	if err := scanAPIKey(Conn.QueryRow(ctx, query, keyHash), &k); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrTokenInvalid
+		}
+		return nil, fmt.Errorf("failed to use API key: %w", err)
+	}
+	return &k, nil
+}
+
+func (s *RealDBService) RevokeAPIKey(ctx context.Context, id, userID int64) error {
+	err := pgx.BeginFunc(ctx, Conn, func(tx pgx.Tx) error {
+		var keyHash string
+		err := tx.QueryRow(ctx, `
+			UPDATE api_keys
+			SET revoked_at = NOW()
+			WHERE id = $1 AND ($2 = 0 OR user_id = $2) AND revoked_at IS NULL
+			RETURNING key_hash`,
+			id, userID,
+		).Scan(&keyHash)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return ErrAPIKeyNotFound
+			}
+			return err
+		}
+		return pubsub.Publish(ctx, tx, APIKeysChannel, APIKeyChange{KeyHash: keyHash})
+	})
+	if err != nil {
+		if errors.Is(err, ErrAPIKeyNotFound) {
+			return err
+		}
+		return fmt.Errorf("failed to revoke API key: %w", err)
+	}
+	return nil
+}
+
+func (s *RealDBService) SetAPIKeyTier(ctx context.Context, id int64, tier string) (*models.APIKey, error) {
+	var k models.APIKey
+	err := pgx.BeginFunc(ctx, Conn, func(tx pgx.Tx) error {
+		err := scanAPIKey(tx.QueryRow(ctx, `
+			UPDATE api_keys
+			SET tier = $2
+			WHERE id = $1
+			RETURNING `+apiKeyColumns,
+			id, tier,
+		), &k)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return ErrAPIKeyNotFound
+			}
+			return err
+		}
+		return pubsub.Publish(ctx, tx, APIKeysChannel, APIKeyChange{KeyHash: k.KeyHash})
+	})
+	if err != nil {
+		if errors.Is(err, ErrAPIKeyNotFound) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to set API key tier: %w", err)
+	}
+	return &k, nil
+}
@@ -0,0 +1,340 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/manuel/make-it-rain/models"
+	"github.com/manuel/make-it-rain/pubsub"
+)
+
+// ErrTokenInvalid is returned for a token that doesn't exist, has expired or
+// was already used or revoked. Callers shouldn't tell clients which.
+var ErrTokenInvalid = errors.New("token is invalid or expired")
+
+// AuthStore keeps emailed user tokens and refresh tokens. Tokens are looked
+// up by hash; the plain values are never stored.
+type AuthStore interface {
+	// CreateUserToken stores t and invalidates any unused token the user has
+	// for the same purpose, so only the most recent email works.
+	CreateUserToken(ctx context.Context, t *models.UserToken) error
+	// CreateRefreshToken stores t, filling in its ID and timestamps.
+	CreateRefreshToken(ctx context.Context, t *models.RefreshToken) error
+	// GetRefreshToken returns a live refresh token without rotating it.
+	GetRefreshToken(ctx context.Context, tokenHash string) (*models.RefreshToken, error)
+	// RotateRefreshToken replaces a live refresh token with newHash, so the
+	// old token can't be used again, and records the client using it.
+	RotateRefreshToken(ctx context.Context, oldHash, newHash string, expiresAt time.Time, userAgent, ip string) (*models.RefreshToken, error)
+	// RevokeRefreshToken ends a session and notifies SessionsChannel.
+	// Unknown tokens are ignored.
+	RevokeRefreshToken(ctx context.Context, tokenHash string) error
+	SessionStore
+	// GetUserToken returns a live user token without using it up.
+	GetUserToken(ctx context.Context, purpose, tokenHash string) (*models.UserToken, error)
+	// ConsumeUserToken marks a live user token used.
+	ConsumeUserToken(ctx context.Context, purpose, tokenHash string) error
+}
+
+func NewAuthStore() AuthStore {
+	return &RealDBService{}
+}
+
+func (s *RealDBService) CreateUserToken(ctx context.Context, t *models.UserToken) error {
+	err := pgx.BeginFunc(ctx, Conn, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `
+			DELETE FROM user_tokens
+			WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL`,
+			t.UserID, t.Purpose)
+		if err != nil {
+			return err
+		}
+		return tx.QueryRow(ctx, `
+			INSERT INTO user_tokens (user_id, purpose, token_hash, email, expires_at)
+			VALUES ($1, $2, $3, $4, $5)
+			RETURNING id, created_at`,
+			t.UserID, t.Purpose, t.TokenHash, t.Email, t.ExpiresAt,
+		).Scan(&t.ID, &t.CreatedAt)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create %s token: %w", t.Purpose, err)
+	}
+	return nil
+}
+
+func (s *RealDBService) GetUserToken(ctx context.Context, purpose, tokenHash string) (*models.UserToken, error) {
+	query := `
+		SELECT id, user_id, purpose, token_hash, email, expires_at, used_at, created_at
+		FROM user_tokens
+		WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > NOW()`
+
+	var t models.UserToken
+	err := Conn.QueryRow(ctx, query, tokenHash, purpose).Scan(
+		&t.ID,
+		&t.UserID,
+		&t.Purpose,
+		&t.TokenHash,
+		&t.Email,
+		&t.ExpiresAt,
+		&t.UsedAt,
+		&t.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrTokenInvalid
+		}
+		return nil, fmt.Errorf("failed to get %s token: %w", purpose, err)
+	}
+	return &t, nil
+}
+
+func (s *RealDBService) ConsumeUserToken(ctx context.Context, purpose, tokenHash string) error {
+	err := pgx.BeginFunc(ctx, Conn, func(tx pgx.Tx) error {
+		_, _, err := consumeUserToken(ctx, tx, purpose, tokenHash)
+		return err
+	})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrTokenInvalid
+		}
+		return fmt.Errorf("failed to use %s token: %w", purpose, err)
+	}
+	return nil
+}
+
+const refreshTokenColumns = `id, user_id, token_hash, user_agent, ip, expires_at, created_at, last_used_at, revoked_at`
+
+func scanRefreshToken(row pgx.Row) (*models.RefreshToken, error) {
+	var t models.RefreshToken
+	err := row.Scan(
+		&t.ID,
+		&t.UserID,
+		&t.TokenHash,
+		&t.UserAgent,
+		&t.IP,
+		&t.ExpiresAt,
+		&t.CreatedAt,
+		&t.LastUsedAt,
+		&t.RevokedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (s *RealDBService) CreateRefreshToken(ctx context.Context, t *models.RefreshToken) error {
+	query := `
+		INSERT INTO refresh_tokens (user_id, token_hash, user_agent, ip, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at`
+
+	err := Conn.QueryRow(ctx, query, t.UserID, t.TokenHash, t.UserAgent, t.IP, t.ExpiresAt).Scan(&t.ID, &t.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create refresh token: %w", err)
+	}
+	return nil
+}
+
+func (s *RealDBService) GetRefreshToken(ctx context.Context, tokenHash string) (*models.RefreshToken, error) {
+	query := `
+		SELECT ` + refreshTokenColumns + `
+		FROM refresh_tokens
+		WHERE token_hash = $1 AND revoked_at IS NULL AND expires_at > NOW()`
+
+	t, err := scanRefreshToken(Conn.QueryRow(ctx, query, tokenHash))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrTokenInvalid
+		}
+		return nil, fmt.Errorf("failed to get refresh token: %w", err)
+	}
+	return t, nil
+}
+
+func (s *RealDBService) RotateRefreshToken(ctx context.Context, oldHash, newHash string, expiresAt time.Time, userAgent, ip string) (*models.RefreshToken, error) {
+	query := `
+		UPDATE refresh_tokens
+		SET token_hash = $2, expires_at = $3, user_agent = $4, ip = $5, last_used_at = NOW()
+		WHERE token_hash = $1 AND revoked_at IS NULL AND expires_at > NOW()
+		RETURNING ` + refreshTokenColumns
+
+	t, err := scanRefreshToken(Conn.QueryRow(ctx, query, oldHash, newHash, expiresAt, userAgent, ip))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrTokenInvalid
+		}
+		return nil, fmt.Errorf("failed to rotate refresh token: %w", err)
+	}
+	return t, nil
+}
+
+func (s *RealDBService) RevokeRefreshToken(ctx context.Context, tokenHash string) error {
+	err := pgx.BeginFunc(ctx, Conn, func(tx pgx.Tx) error {
+		var revocation SessionRevocation
+		err := tx.QueryRow(ctx, `
+			UPDATE refresh_tokens
+			SET revoked_at = NOW()
+			WHERE token_hash = $1 AND revoked_at IS NULL
+			RETURNING user_id, id`,
+			tokenHash,
+		).Scan(&revocation.UserID, &revocation.SessionID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return pubsub.Publish(ctx, tx, SessionsChannel, revocation)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to revoke refresh token: %w", err)
+	}
+	return nil
+}
+
+// consumeUserToken marks a live token used and returns who it was sent to.
+func consumeUserToken(ctx context.Context, tx pgx.Tx, purpose, tokenHash string) (userID int64, email string, err error) {
+	err = tx.QueryRow(ctx, `
+		UPDATE user_tokens
+		SET used_at = NOW()
+		WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > NOW()
+		RETURNING user_id, email`,
+		tokenHash, purpose,
+	).Scan(&userID, &email)
+	return userID, email, err
+}
+
+// userUpdateReturning is the RETURNING list scanned by scanUpdatedUser.
+const userUpdateReturning = `RETURNING id, email, name, is_active, locale, email_verified_at, role, mfa_enabled_at, created_at, updated_at`
+
+func scanUpdatedUser(row pgx.Row, u *User) error {
+	return row.Scan(
+		&u.ID,
+		&u.Email,
+		&u.Name,
+		&u.IsActive,
+		&u.Locale,
+		&u.EmailVerifiedAt,
+		&u.Role,
+		&u.MFAEnabledAt,
+		&u.CreatedAt,
+		&u.UpdatedAt,
+	)
+}
+
+// VerifyEmail consumes a verification token and marks the address it was
+// sent to as verified. A token for an address the user has since changed
+// away from is rejected.
+func (s *RealDBService) VerifyEmail(ctx context.Context, tokenHash string) (*User, error) {
+	var u User
+	err := pgx.BeginFunc(ctx, Conn, func(tx pgx.Tx) error {
+		userID, email, err := consumeUserToken(ctx, tx, models.TokenVerifyEmail, tokenHash)
+		if err != nil {
+			return err
+		}
+
+		err = scanUpdatedUser(tx.QueryRow(ctx, `
+			UPDATE users
+			SET email_verified_at = COALESCE(email_verified_at, NOW()), updated_at = NOW()
+			WHERE id = $1 AND email = $2
+			`+userUpdateReturning,
+			userID, email,
+		), &u)
+		if err != nil {
+			return err
+		}
+		return insertUserEvent(ctx, tx, models.EventUserUpdated, u.ID, &u)
+	})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrTokenInvalid
+		}
+		return nil, fmt.Errorf("failed to verify email: %w", err)
+	}
+
+	publishUserChange(ctx, UserUpdated, u.ID)
+	return &u, nil
+}
+
+// ResetPassword consumes a password reset token and sets the user's
+// password. The link reached the user's inbox, so the address is verified
+// as well. Every session is revoked.
+func (s *RealDBService) ResetPassword(ctx context.Context, tokenHash, passwordHash string) (*User, error) {
+	var u User
+	err := pgx.BeginFunc(ctx, Conn, func(tx pgx.Tx) error {
+		userID, email, err := consumeUserToken(ctx, tx, models.TokenResetPassword, tokenHash)
+		if err != nil {
+			return err
+		}
+
+		err = scanUpdatedUser(tx.QueryRow(ctx, `
+			UPDATE users
+			SET password = $3, email_verified_at = COALESCE(email_verified_at, NOW()), updated_at = NOW()
+			WHERE id = $1 AND email = $2
+			`+userUpdateReturning,
+			userID, email, passwordHash,
+		), &u)
+		if err != nil {
+			return err
+		}
+		if err := revokeCredentials(ctx, tx, u.ID); err != nil {
+			return err
+		}
+		return insertUserEvent(ctx, tx, models.EventUserUpdated, u.ID, &u)
+	})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrTokenInvalid
+		}
+		return nil, fmt.Errorf("failed to reset password: %w", err)
+	}
+
+	publishUserChange(ctx, UserUpdated, u.ID)
+	return &u, nil
+}
+
+// ChangePassword sets a user's password and revokes every session.
+func (s *RealDBService) ChangePassword(ctx context.Context, userID int64, passwordHash string) error {
+	err := pgx.BeginFunc(ctx, Conn, func(tx pgx.Tx) error {
+		var u User
+		err := scanUpdatedUser(tx.QueryRow(ctx, `
+			UPDATE users
+			SET password = $2, updated_at = NOW()
+			WHERE id = $1
+			`+userUpdateReturning,
+			userID, passwordHash,
+		), &u)
+		if err != nil {
+			return err
+		}
+		if err := revokeCredentials(ctx, tx, userID); err != nil {
+			return err
+		}
+		return insertUserEvent(ctx, tx, models.EventUserUpdated, userID, &u)
+	})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("user not found")
+		}
+		return fmt.Errorf("failed to change password: %w", err)
+	}
+
+	publishUserChange(ctx, UserUpdated, userID)
+	return nil
+}
+
+// revokeCredentials ends a user's sessions and drops their unused password
+// reset links after a password change.
+func revokeCredentials(ctx context.Context, tx pgx.Tx, userID int64) error {
+	if _, err := revokeUserSessions(ctx, tx, userID); err != nil {
+		return err
+	}
+	_, err := tx.Exec(ctx, `
+		DELETE FROM user_tokens
+		WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL`,
+		userID, models.TokenResetPassword)
+	return err
+}
@@ -0,0 +1,231 @@
+package db
+
+import (
+	"context"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/manuel/make-it-rain/data_structures"
+	"github.com/manuel/make-it-rain/logging"
+	"github.com/manuel/make-it-rain/metrics"
+	"github.com/manuel/make-it-rain/pubsub"
+	"golang.org/x/sync/singleflight"
+)
+
+const userCacheName = "users"
+
+// CachedDBService is a read-through cache in front of a DBService. Users are
+// cached by id; lookups by email go through an email -> id index that is
+// re-checked against the cached user, so a changed email never returns the
+// wrong account. Writes invalidate the affected user.
+type CachedDBService struct {
+	DBService
+
+	ttl     time.Duration
+	users   *data_structures.LRUCacheWithTTL[int64, cachedUser]
+	emails  *data_structures.LRUCacheWithTTL[string, int64]
+	loads   singleflight.Group
+	version atomic.Uint64
+	now     func() time.Time
+}
+
+type cachedUser struct {
+	user     *User
+	loadedAt time.Time
+}
+
+func NewCachedDBService(next DBService, capacity int, ttl time.Duration) *CachedDBService {
+	return &CachedDBService{
+		DBService: next,
+		ttl:       ttl,
+		users:     data_structures.NewLRUCacheWithTTL[int64, cachedUser](capacity, ttl),
+		emails:    data_structures.NewLRUCacheWithTTL[string, int64](capacity, ttl),
+		now:       time.Now,
+	}
+}
+
+// cached returns a user loaded less than ttl ago. The cache's own TTL slides
+// on every hit, so the load time is checked here to make busy users look
+// themselves up again.
+func (s *CachedDBService) cached(userID int64) (*User, bool) {
+	c, ok := s.users.Get(userID)
+	if !ok || s.now().Sub(c.loadedAt) >= s.ttl {
+		return nil, false
+	}
+	return c.user, true
+}
+
+func (s *CachedDBService) GetUser(ctx context.Context, userID int64) (*User, error) {
+	if u, ok := s.cached(userID); ok {
+		recordCacheResult(true)
+		return copyUser(u), nil
+	}
+	recordCacheResult(false)
+
+	return s.load(ctx, "id:"+strconv.FormatInt(userID, 10), func(ctx context.Context) (*User, error) {
+		return s.DBService.GetUser(ctx, userID)
+	})
+}
+
+func (s *CachedDBService) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	if id, ok := s.emails.Get(email); ok {
+		if u, ok := s.cached(id); ok && u.Email == email {
+			recordCacheResult(true)
+			return copyUser(u), nil
+		}
+	}
+	recordCacheResult(false)
+
+	return s.load(ctx, "email:"+email, func(ctx context.Context) (*User, error) {
+		return s.DBService.GetUserByEmail(ctx, email)
+	})
+}
+
+// load collapses concurrent misses for the same key into one query. The
+// result is only cached if nothing was invalidated while it was loading, so
+// a slow read can't put back a user that was just updated.
+func (s *CachedDBService) load(ctx context.Context, key string, fetch func(context.Context) (*User, error)) (*User, error) {
+	version := s.version.Load()
+	ch := s.loads.DoChan(key, func() (interface{}, error) {
+		// Detach from the first caller's cancellation so it doesn't fail the
+		// other callers sharing this load; each caller still stops waiting
+		// when its own context ends.
+		loadCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), loadTimeout(ctx))
+		defer cancel()
+
+		loadedAt := s.now()
+		u, err := fetch(loadCtx)
+		if err != nil {
+			return nil, err
+		}
+		if s.version.Load() == version {
+			s.users.Put(u.ID, cachedUser{user: u, loadedAt: loadedAt})
+			s.emails.Put(u.Email, u.ID)
+		}
+		return u, nil
+	})
+
+	select {
+	case res := <-ch:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return copyUser(res.Val.(*User)), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (s *CachedDBService) UpdateUser(ctx context.Context, userID int64, updates map[string]interface{}) error {
+	err := s.DBService.UpdateUser(ctx, userID, updates)
+	s.Invalidate(userID)
+	return err
+}
+
+func (s *CachedDBService) VerifyEmail(ctx context.Context, tokenHash string) (*User, error) {
+	u, err := s.DBService.VerifyEmail(ctx, tokenHash)
+	if err != nil {
+		return nil, err
+	}
+	s.Invalidate(u.ID)
+	return u, nil
+}
+
+func (s *CachedDBService) ResetPassword(ctx context.Context, tokenHash, passwordHash string) (*User, error) {
+	u, err := s.DBService.ResetPassword(ctx, tokenHash, passwordHash)
+	if err != nil {
+		return nil, err
+	}
+	s.Invalidate(u.ID)
+	return u, nil
+}
+
+func (s *CachedDBService) ChangePassword(ctx context.Context, userID int64, passwordHash string) error {
+	err := s.DBService.ChangePassword(ctx, userID, passwordHash)
+	s.Invalidate(userID)
+	return err
+}
+
+func (s *CachedDBService) SetUserRole(ctx context.Context, userID int64, role string) (*User, error) {
+	u, err := s.DBService.SetUserRole(ctx, userID, role)
+	s.Invalidate(userID)
+	return u, err
+}
+
+func (s *CachedDBService) ConfirmMFA(ctx context.Context, userID, step int64, codeHashes []string) (*User, error) {
+	u, err := s.DBService.ConfirmMFA(ctx, userID, step, codeHashes)
+	s.Invalidate(userID)
+	return u, err
+}
+
+func (s *CachedDBService) DisableMFA(ctx context.Context, userID int64) error {
+	err := s.DBService.DisableMFA(ctx, userID)
+	s.Invalidate(userID)
+	return err
+}
+
+func (s *CachedDBService) DeleteUser(ctx context.Context, userID int64) error {
+	err := s.DBService.DeleteUser(ctx, userID)
+	s.Invalidate(userID)
+	return err
+}
+
+// Invalidate drops a user from the cache. Stale email index entries are
+// ignored on lookup, so only the id entry has to go.
+func (s *CachedDBService) Invalidate(userID int64) {
+	s.version.Add(1)
+	s.users.Delete(userID)
+}
+
+// InvalidateAll empties the cache.
+func (s *CachedDBService) InvalidateAll() {
+	s.version.Add(1)
+	s.users.Clear()
+	s.emails.Clear()
+}
+
+// HandleUserChange invalidates the user named in a UserChange notification
+// published by another instance.
+func (s *CachedDBService) HandleUserChange(ctx context.Context, msg pubsub.Message) {
+	var change UserChange
+	if err := msg.Decode(&change); err != nil {
+		logging.Ctx(ctx, logging.DB).Warn().Err(err).Str("payload", msg.Payload).Msg("Ignoring malformed user change")
+		s.InvalidateAll()
+		return
+	}
+	s.Invalidate(change.UserID)
+}
+
+const defaultLoadTimeout = 5 * time.Second
+
+func loadTimeout(ctx context.Context) time.Duration {
+	if deadline, ok := ctx.Deadline(); ok {
+		if d := time.Until(deadline); d > 0 {
+			return d
+		}
+	}
+	return defaultLoadTimeout
+}
+
+func recordCacheResult(hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	metrics.CacheRequestsTotal.WithLabelValues(userCacheName, result).Inc()
+}
+
+// copyUser hands out copies so callers can't modify the cached value.
+func copyUser(u *User) *User {
+	c := *u
+	if u.EmailVerifiedAt != nil {
+		t := *u.EmailVerifiedAt
+		c.EmailVerifiedAt = &t
+	}
+	if u.MFAEnabledAt != nil {
+		t := *u.MFAEnabledAt
+		c.MFAEnabledAt = &t
+	}
+	return &c
+}
@@ -0,0 +1,153 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/manuel/make-it-rain/pubsub"
+)
+
+// fakeUserDB serves users from memory and counts lookups.
+type fakeUserDB struct {
+	DBService
+	mu      sync.Mutex
+	users   map[int64]User
+	lookups atomic.Int32
+	delay   time.Duration
+}
+
+func (f *fakeUserDB) GetUser(ctx context.Context, userID int64) (*User, error) {
+	f.lookups.Add(1)
+	time.Sleep(f.delay)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	u, ok := f.users[userID]
+	if !ok {
+		return nil, fmt.Errorf("user not found")
+	}
+	return &u, nil
+}
+
+func (f *fakeUserDB) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	f.lookups.Add(1)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, u := range f.users {
+		if u.Email == email {
+			return &u, nil
+		}
+	}
+	return nil, fmt.Errorf("user not found")
+}
+
+func (f *fakeUserDB) UpdateUser(ctx context.Context, userID int64, updates map[string]interface{}) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	u := f.users[userID]
+	if email, ok := updates["email"].(string); ok {
+		u.Email = email
+	}
+	f.users[userID] = u
+	return nil
+}
+
+func newFakeUserDB() *fakeUserDB {
+	return &fakeUserDB{users: map[int64]User{1: {ID: 1, Email: "a@example.com", Name: "A"}}}
+}
+
+func TestCachedDBServiceCachesById(t *testing.T) {
+	fake := newFakeUserDB()
+	cache := NewCachedDBService(fake, 10, time.Minute)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if _, err := cache.GetUser(ctx, 1); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+	if n := fake.lookups.Load(); n != 1 {
+		t.Errorf("Expected 1 database lookup, got %d", n)
+	}
+
+	u, _ := cache.GetUser(ctx, 1)
+	u.Name = "changed"
+	if cached, _ := cache.GetUser(ctx, 1); cached.Name != "A" {
+		t.Errorf("Expected cached user to be unaffected by caller changes, got %q", cached.Name)
+	}
+}
+
+func TestCachedDBServiceInvalidatesOnUpdate(t *testing.T) {
+	fake := newFakeUserDB()
+	cache := NewCachedDBService(fake, 10, time.Minute)
+	ctx := context.Background()
+
+	if _, err := cache.GetUserByEmail(ctx, "a@example.com"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := cache.UpdateUser(ctx, 1, map[string]interface{}{"email": "b@example.com"}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	u, err := cache.GetUser(ctx, 1)
+	if err != nil || u.Email != "b@example.com" {
+		t.Errorf("Expected updated email after invalidation, got %v, %v", u, err)
+	}
+	if _, err := cache.GetUserByEmail(ctx, "a@example.com"); err == nil {
+		t.Errorf("Expected old email lookup to miss, got a user")
+	}
+}
+
+func TestCachedDBServiceCollapsesConcurrentMisses(t *testing.T) {
+	fake := newFakeUserDB()
+	fake.delay = 20 * time.Millisecond
+	cache := NewCachedDBService(fake, 10, time.Minute)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cache.GetUser(context.Background(), 1)
+		}()
+	}
+	wg.Wait()
+
+	if n := fake.lookups.Load(); n != 1 {
+		t.Errorf("Expected concurrent misses to share 1 lookup, got %d", n)
+	}
+}
+
+func TestCachedDBServiceHandlesRemoteChanges(t *testing.T) {
+	fake := newFakeUserDB()
+	cache := NewCachedDBService(fake, 10, time.Minute)
+	ctx := context.Background()
+
+	cache.GetUser(ctx, 1)
+	fake.UpdateUser(ctx, 1, map[string]interface{}{"email": "b@example.com"})
+	cache.HandleUserChange(ctx, pubsub.Message{Channel: UserChangesChannel, Payload: `{"op":"updated","user_id":1}`})
+
+	if u, _ := cache.GetUser(ctx, 1); u.Email != "b@example.com" {
+		t.Errorf("Expected remote change to invalidate the cached user, got %q", u.Email)
+	}
+}
+
+func TestCachedDBServiceExpiresBusyUsers(t *testing.T) {
+	fake := newFakeUserDB()
+	cache := NewCachedDBService(fake, 10, time.Minute)
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	// Hits within the TTL don't extend it
+	for i := 0; i < 3; i++ {
+		cache.GetUser(ctx, 1)
+		now = now.Add(40 * time.Second)
+	}
+	if n := fake.lookups.Load(); n != 2 {
+		t.Errorf("Expected the user to be loaded again after the TTL, got %d lookups", n)
+	}
+}
@@ -7,6 +7,8 @@ import (
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
+	appconfig "github.com/manuel/make-it-rain/config"
+	"github.com/manuel/make-it-rain/secrets"
 )
 
 var Conn *pgxpool.Pool
@@ -18,6 +20,12 @@ type DBService interface {
 	UpdateUser(ctx context.Context, userID int64, updates map[string]interface{}) error
 	DeleteUser(ctx context.Context, userID int64) error
 	GetUserByEmail(ctx context.Context, email string) (*User, error)
+	VerifyEmail(ctx context.Context, tokenHash string) (*User, error)
+	ResetPassword(ctx context.Context, tokenHash, passwordHash string) (*User, error)
+	ChangePassword(ctx context.Context, userID int64, passwordHash string) error
+	SetUserRole(ctx context.Context, userID int64, role string) (*User, error)
+	ConfirmMFA(ctx context.Context, userID, step int64, codeHashes []string) (*User, error)
+	DisableMFA(ctx context.Context, userID int64) error
 
 	BeginTx(ctx context.Context) (pgx.Tx, error)
 }
@@ -38,6 +46,25 @@ func InitDB(connStr string) error {
 	config.MinConns = 2
 	config.MaxConnLifetime = 1 * time.Hour
 	config.MaxConnIdleTime = 30 * time.Minute
+	if appconfig.Cfg != nil {
+		dbCfg := appconfig.Cfg.Database
+		config.MaxConns = int32(dbCfg.MaxConnections)
+		config.MinConns = int32(dbCfg.MinConnections)
+		config.MaxConnLifetime = dbCfg.MaxConnLifetime
+		config.MaxConnIdleTime = dbCfg.MaxConnIdleTime
+	}
+
+	// Re-read the password for every new connection so a rotated secret
+	// is used without restarting; existing connections are unaffected.
+	config.BeforeConnect = func(ctx context.Context, cc *pgx.ConnConfig) error {
+		password, err := databasePassword(ctx, cc.Password)
+		if err != nil {
+			return err
+		}
+		cc.Password = password
+		return nil
+	}
+	config.ConnConfig.Tracer = &QueryTracer{}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -55,6 +82,21 @@ func InitDB(connStr string) error {
 	return nil
 }
 
+// databasePassword returns the password for a new connection: the secret
+// store's, else the live config's, which changes on reload, else the one
+// from the connection string.
+func databasePassword(ctx context.Context, startup string) (string, error) {
+	fallback := startup
+	if cfg := appconfig.Current(); cfg != nil && cfg.Database.Password != "" {
+		fallback = cfg.Database.Password
+	}
+	password, err := secrets.Resolve(ctx, appconfig.SecretStore, "DATABASE_PASSWORD", fallback)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve database password: %w", err)
+	}
+	return password, nil
+}
+
 func CloseDB() {
 	if Conn != nil {
 		Conn.Close()
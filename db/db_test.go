@@ -0,0 +1,37 @@
+package db
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	appconfig "github.com/manuel/make-it-rain/config"
+)
+
+func TestDatabasePasswordFollowsReloadedConfig(t *testing.T) {
+	t.Setenv("DATABASE_PASSWORD_FILE", "")
+	saved := appconfig.Cfg
+	t.Cleanup(func() { appconfig.Cfg = saved })
+	ctx := context.Background()
+
+	appconfig.Cfg = nil
+	if got, err := databasePassword(ctx, "startup"); err != nil || got != "startup" {
+		t.Errorf("Expected the connection string's password without config, got %q, %v", got, err)
+	}
+
+	appconfig.Cfg = &appconfig.Config{}
+	appconfig.Cfg.Database.Password = "reloaded"
+	if got, err := databasePassword(ctx, "startup"); err != nil || got != "reloaded" {
+		t.Errorf("Expected the live config's password, got %q, %v", got, err)
+	}
+
+	path := filepath.Join(t.TempDir(), "password")
+	if err := os.WriteFile(path, []byte("from-secret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("DATABASE_PASSWORD_FILE", path)
+	if got, err := databasePassword(ctx, "startup"); err != nil || got != "from-secret" {
+		t.Errorf("Expected the secret store's password, got %q, %v", got, err)
+	}
+}
@@ -0,0 +1,72 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/manuel/make-it-rain/models"
+)
+
+// MailLogStore keeps the outgoing mail log.
+type MailLogStore interface {
+	RecordSentEmail(ctx context.Context, e *models.SentEmail) error
+	// ListSentEmails returns the newest entries, optionally only those sent
+	// to recipient.
+	ListSentEmails(ctx context.Context, recipient string, limit int) ([]models.SentEmail, error)
+}
+
+func NewMailLogStore() MailLogStore {
+	return &RealDBService{}
+}
+
+func (s *RealDBService) RecordSentEmail(ctx context.Context, e *models.SentEmail) error {
+	query := `
+		INSERT INTO sent_emails (job_id, recipients, subject, template, locale, message_id, status, error, attempt, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, ''), $9, NOW())`
+
+	_, err := Conn.Exec(ctx, query, e.JobID, e.Recipients, e.Subject, e.Template, e.Locale,
+		e.MessageID, e.Status, e.Error, e.Attempt)
+	if err != nil {
+		return fmt.Errorf("failed to record sent email: %w", err)
+	}
+	return nil
+}
+
+func (s *RealDBService) ListSentEmails(ctx context.Context, recipient string, limit int) ([]models.SentEmail, error) {
+	query := `
+		SELECT id, job_id, recipients, subject, template, locale, message_id, status,
+			COALESCE(error, ''), attempt, created_at
+		FROM sent_emails
+		WHERE ($1 = '' OR recipients @> ARRAY[$1::text])
+		ORDER BY id DESC
+		LIMIT $2`
+
+	rows, err := Conn.Query(ctx, query, recipient, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sent emails: %w", err)
+	}
+	defer rows.Close()
+
+	var emails []models.SentEmail
+	for rows.Next() {
+		var e models.SentEmail
+		err := rows.Scan(
+			&e.ID,
+			&e.JobID,
+			&e.Recipients,
+			&e.Subject,
+			&e.Template,
+			&e.Locale,
+			&e.MessageID,
+			&e.Status,
+			&e.Error,
+			&e.Attempt,
+			&e.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan sent email: %w", err)
+		}
+		emails = append(emails, e)
+	}
+	return emails, rows.Err()
+}
@@ -0,0 +1,134 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	IdempotencyInProgress = "in_progress"
+	IdempotencyCompleted  = "completed"
+)
+
+// IdempotencyRecord is the stored outcome of the first request made with an
+// Idempotency-Key. The response fields are only set once it is completed.
+type IdempotencyRecord struct {
+	Scope               string
+	Key                 string
+	Fingerprint         string
+	Status              string
+	ResponseStatus      int
+	ResponseContentType string
+	ResponseBody        []byte
+	CreatedAt           time.Time
+	ExpiresAt           time.Time
+}
+
+// IdempotencyStore persists idempotency keys so retries are recognised across
+// instances and restarts.
+type IdempotencyStore interface {
+	// ClaimIdempotencyKey inserts an in-progress record and reports whether
+	// this call claimed it. Otherwise the existing record is returned. Stale
+	// in-progress records (older than lockTimeout) and expired records can be
+	// claimed again.
+	ClaimIdempotencyKey(ctx context.Context, scope, key, fingerprint string, lockTimeout, ttl time.Duration) (*IdempotencyRecord, bool, error)
+	CompleteIdempotencyKey(ctx context.Context, scope, key string, status int, contentType string, body []byte) error
+	// ReleaseIdempotencyKey drops an in-progress claim so the request can be retried.
+	ReleaseIdempotencyKey(ctx context.Context, scope, key string) error
+}
+
+func NewIdempotencyStore() IdempotencyStore {
+	return &RealDBService{}
+}
+
+func (s *RealDBService) ClaimIdempotencyKey(ctx context.Context, scope, key, fingerprint string, lockTimeout, ttl time.Duration) (*IdempotencyRecord, bool, error) {
+	claim := `
+		INSERT INTO idempotency_keys (scope, key, fingerprint, status, locked_at, created_at, expires_at)
+		VALUES ($1, $2, $3, 'in_progress', NOW(), NOW(), NOW() + make_interval(secs => $4))
+		ON CONFLICT (scope, key) DO UPDATE
+		SET fingerprint = EXCLUDED.fingerprint,
+			status = 'in_progress',
+			response_status = NULL,
+			response_content_type = NULL,
+			response_body = NULL,
+			locked_at = NOW(),
+			created_at = NOW(),
+			completed_at = NULL,
+			expires_at = EXCLUDED.expires_at
+		WHERE idempotency_keys.expires_at < NOW()
+			OR (idempotency_keys.status = 'in_progress' AND idempotency_keys.locked_at < NOW() - make_interval(secs => $5))
+		RETURNING scope, key, fingerprint, status, created_at, expires_at`
+
+	var r IdempotencyRecord
+	err := Conn.QueryRow(ctx, claim, scope, key, fingerprint, ttl.Seconds(), lockTimeout.Seconds()).Scan(
+		&r.Scope,
+		&r.Key,
+		&r.Fingerprint,
+		&r.Status,
+		&r.CreatedAt,
+		&r.ExpiresAt,
+	)
+	if err == nil {
+		return &r, true, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return nil, false, fmt.Errorf("failed to claim idempotency key: %w", err)
+	}
+
+	existing, err := s.getIdempotencyKey(ctx, scope, key)
+	if err != nil {
+		return nil, false, err
+	}
+	return existing, false, nil
+}
+
+func (s *RealDBService) getIdempotencyKey(ctx context.Context, scope, key string) (*IdempotencyRecord, error) {
+	query := `
+		SELECT scope, key, fingerprint, status, COALESCE(response_status, 0),
+			COALESCE(response_content_type, ''), response_body, created_at, expires_at
+		FROM idempotency_keys
+		WHERE scope = $1 AND key = $2`
+
+	var r IdempotencyRecord
+	err := Conn.QueryRow(ctx, query, scope, key).Scan(
+		&r.Scope,
+		&r.Key,
+		&r.Fingerprint,
+		&r.Status,
+		&r.ResponseStatus,
+		&r.ResponseContentType,
+		&r.ResponseBody,
+		&r.CreatedAt,
+		&r.ExpiresAt,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get idempotency key: %w", err)
+	}
+	return &r, nil
+}
+
+func (s *RealDBService) CompleteIdempotencyKey(ctx context.Context, scope, key string, status int, contentType string, body []byte) error {
+	query := `
+		UPDATE idempotency_keys
+		SET status = 'completed', response_status = $3, response_content_type = $4,
+			response_body = $5, completed_at = NOW()
+		WHERE scope = $1 AND key = $2`
+
+	if _, err := Conn.Exec(ctx, query, scope, key, status, contentType, body); err != nil {
+		return fmt.Errorf("failed to complete idempotency key: %w", err)
+	}
+	return nil
+}
+
+func (s *RealDBService) ReleaseIdempotencyKey(ctx context.Context, scope, key string) error {
+	query := `DELETE FROM idempotency_keys WHERE scope = $1 AND key = $2 AND status = 'in_progress'`
+
+	if _, err := Conn.Exec(ctx, query, scope, key); err != nil {
+		return fmt.Errorf("failed to release idempotency key: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/manuel/make-it-rain/models"
+)
+
+var (
+	ErrIdentityNotFound = errors.New("identity not found")
+	// ErrIdentityLinked is returned when a provider account is already
+	// linked to a user.
+	ErrIdentityLinked = errors.New("identity is already linked")
+)
+
+// IdentityStore keeps the links between users and their accounts at OpenID
+// Connect providers, and the logins in progress at those providers.
+type IdentityStore interface {
+	// CreateOIDCLogin stores l, filling in its ID and creation time.
+	CreateOIDCLogin(ctx context.Context, l *models.OIDCLogin) error
+	// ConsumeOIDCLogin deletes and returns the live login with stateHash, so
+	// it can't be finished twice. It returns ErrTokenInvalid when there's
+	// none.
+	ConsumeOIDCLogin(ctx context.Context, stateHash string) (*models.OIDCLogin, error)
+	// UseIdentity records a login with a provider account, along with the
+	// email the provider reported, and returns the linked user's ID. It
+	// returns ErrIdentityNotFound when the account isn't linked.
+	UseIdentity(ctx context.Context, provider, subject, email string) (int64, error)
+	// LinkIdentity links a provider account to an existing user, filling in
+	// i's ID and timestamps. It returns ErrIdentityLinked when the account is
+	// already linked.
+	LinkIdentity(ctx context.Context, i *models.UserIdentity) error
+	// CreateUserWithIdentity creates a user whose address is already
+	// verified, linked to the provider account i.
+	CreateUserWithIdentity(ctx context.Context, user *CreateUserRequest, i *models.UserIdentity) (*User, error)
+	ListIdentities(ctx context.Context, userID int64) ([]models.UserIdentity, error)
+	// DeleteIdentity unlinks one of a user's provider accounts. It returns
+	// ErrIdentityNotFound when the user has no such link.
+	DeleteIdentity(ctx context.Context, userID, id int64) error
+}
+
+func NewIdentityStore() IdentityStore {
+	return &RealDBService{}
+}
+
+func (s *RealDBService) CreateOIDCLogin(ctx context.Context, l *models.OIDCLogin) error {
+	query := `
+		INSERT INTO oidc_logins (provider, state_hash, code_verifier, nonce, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at`
+
+	err := Conn.QueryRow(ctx, query, l.Provider, l.StateHash, l.CodeVerifier, l.Nonce, l.ExpiresAt).Scan(&l.ID, &l.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create OIDC login: %w", err)
+	}
+	return nil
+}
+
+func (s *RealDBService) ConsumeOIDCLogin(ctx context.Context, stateHash string) (*models.OIDCLogin, error) {
+	query := `
+		DELETE FROM oidc_logins
+		WHERE state_hash = $1 AND expires_at > NOW()
+		RETURNING id, provider, state_hash, code_verifier, nonce, expires_at, created_at`
+
+	var l models.OIDCLogin
+	err := Conn.QueryRow(ctx, query, stateHash).Scan(
+		&l.ID,
+		&l.Provider,
+		&l.StateHash,
+		&l.CodeVerifier,
+		&l.Nonce,
+		&l.ExpiresAt,
+		&l.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrTokenInvalid
+		}
+		return nil, fmt.Errorf("failed to use OIDC login: %w", err)
+	}
+	return &l, nil
+}
+
+func (s *RealDBService) UseIdentity(ctx context.Context, provider, subject, email string) (int64, error) {
+	query := `
+		UPDATE user_identities
+		SET email = $3, last_login_at = NOW()
+		WHERE provider = $1 AND subject = $2
+		RETURNING user_id`
+
+	var userID int64
+	if err := Conn.QueryRow(ctx, query, provider, subject, email).Scan(&userID); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, ErrIdentityNotFound
+		}
+		return 0, fmt.Errorf("failed to use identity: %w", err)
+	}
+	return userID, nil
+}
+
+func (s *RealDBService) LinkIdentity(ctx context.Context, i *models.UserIdentity) error {
+	err := pgx.BeginFunc(ctx, Conn, func(tx pgx.Tx) error {
+		return linkIdentity(ctx, tx, i)
+	})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrIdentityLinked
+		}
+		return fmt.Errorf("failed to link identity: %w", err)
+	}
+	return nil
+}
+
+func (s *RealDBService) CreateUserWithIdentity(ctx context.Context, user *CreateUserRequest, i *models.UserIdentity) (*User, error) {
+	var u *User
+	err := pgx.BeginFunc(ctx, Conn, func(tx pgx.Tx) error {
+		var err error
+		u, err = insertUser(ctx, tx, user, true)
+		if err != nil {
+			return err
+		}
+		i.UserID = u.ID
+		return linkIdentity(ctx, tx, i)
+	})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrIdentityLinked
+		}
+		return nil, fmt.Errorf("failed to create user: %w", err)
+	}
+	return u, nil
+}
+
+// linkIdentity inserts i in tx, returning pgx.ErrNoRows when the provider
+// account is already linked.
+func linkIdentity(ctx context.Context, tx pgx.Tx, i *models.UserIdentity) error {
+	return tx.QueryRow(ctx, `
+		INSERT INTO user_identities (user_id, provider, subject, email, last_login_at)
+		VALUES ($1, $2, $3, $4, NOW())
+		ON CONFLICT (provider, subject) DO NOTHING
+		RETURNING id, last_login_at, created_at`,
+		i.UserID, i.Provider, i.Subject, i.Email,
+	).Scan(&i.ID, &i.LastLoginAt, &i.CreatedAt)
+}
+
+func (s *RealDBService) ListIdentities(ctx context.Context, userID int64) ([]models.UserIdentity, error) {
+	query := `
+		SELECT id, user_id, provider, subject, email, last_login_at, created_at
+		FROM user_identities
+		WHERE user_id = $1
+		ORDER BY created_at, id`
+
+	rows, err := Conn.Query(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list identities: %w", err)
+	}
+	defer rows.Close()
+
+	identities := []models.UserIdentity{}
+	for rows.Next() {
+		var i models.UserIdentity
+		if err := rows.Scan(&i.ID, &i.UserID, &i.Provider, &i.Subject, &i.Email, &i.LastLoginAt, &i.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan identity: %w", err)
+		}
+		identities = append(identities, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list identities: %w", err)
+	}
+	return identities, nil
+}
+
+func (s *RealDBService) DeleteIdentity(ctx context.Context, userID, id int64) error {
+	result, err := Conn.Exec(ctx, `DELETE FROM user_identities WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete identity: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return ErrIdentityNotFound
+	}
+	return nil
+}
@@ -0,0 +1,260 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/manuel/make-it-rain/models"
+	"github.com/manuel/make-it-rain/pubsub"
+)
+
+// JobsChannel is notified when a job is enqueued so idle workers can pick it
+// up without waiting for the next poll.
+const JobsChannel = "jobs"
+
+var (
+	ErrJobNotFound = errors.New("job not found")
+	// ErrJobState is returned when a job exists but can't make the requested
+	// transition, e.g. cancelling a job that is already running.
+	ErrJobState = errors.New("job is not in a state that allows this")
+)
+
+// JobStore is the worker pool's view of the jobs table.
+type JobStore interface {
+	EnqueueJob(ctx context.Context, job *models.Job) (*models.Job, error)
+	GetJob(ctx context.Context, id int64) (*models.Job, error)
+	// ClaimJobs marks up to limit due jobs as running for worker, lowest
+	// priority value first. A running job whose lock expired is claimed
+	// again, counting as a new attempt.
+	ClaimJobs(ctx context.Context, worker string, limit int, lockFor time.Duration) ([]models.Job, error)
+	CompleteJob(ctx context.Context, id int64, worker string) error
+	// FailJob records a failed attempt. The job runs again at retryAt, or is
+	// moved to the dead state when retryAt is nil.
+	FailJob(ctx context.Context, id int64, worker, reason string, retryAt *time.Time) error
+	ListJobs(ctx context.Context, status, jobType string, limit int) ([]models.Job, error)
+	// RetryJob queues a dead or cancelled job to run now with its attempts reset.
+	RetryJob(ctx context.Context, id int64) (*models.Job, error)
+	// CancelJob stops a queued job from running.
+	CancelJob(ctx context.Context, id int64) (*models.Job, error)
+}
+
+func NewJobStore() JobStore {
+	return &RealDBService{}
+}
+
+const jobColumns = `id, type, payload, priority, status, attempts, max_attempts, run_at,
+	COALESCE(last_error, ''), COALESCE(locked_by, ''), created_at, started_at, finished_at`
+
+func scanJob(row pgx.Row) (*models.Job, error) {
+	var j models.Job
+	err := row.Scan(
+		&j.ID,
+		&j.Type,
+		&j.Payload,
+		&j.Priority,
+		&j.Status,
+		&j.Attempts,
+		&j.MaxAttempts,
+		&j.RunAt,
+		&j.LastError,
+		&j.LockedBy,
+		&j.CreatedAt,
+		&j.StartedAt,
+		&j.FinishedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &j, nil
+}
+
+func scanJobs(rows pgx.Rows) ([]models.Job, error) {
+	defer rows.Close()
+
+	var jobs []models.Job
+	for rows.Next() {
+		j, err := scanJob(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan job: %w", err)
+		}
+		jobs = append(jobs, *j)
+	}
+	return jobs, rows.Err()
+}
+
+func (s *RealDBService) EnqueueJob(ctx context.Context, job *models.Job) (*models.Job, error) {
+	payload := job.Payload
+	if len(payload) == 0 {
+		payload = json.RawMessage(`{}`)
+	}
+	runAt := job.RunAt
+	if runAt.IsZero() {
+		runAt = time.Now()
+	}
+
+	query := `
+		INSERT INTO jobs (type, payload, priority, status, max_attempts, run_at, created_at)
+		VALUES ($1, $2, $3, 'queued', $4, $5, NOW())
+		RETURNING ` + jobColumns
+
+	var created *models.Job
+	err := pgx.BeginFunc(ctx, Conn, func(tx pgx.Tx) error {
+		var err error
+		created, err = scanJob(tx.QueryRow(ctx, query, job.Type, payload, job.Priority, job.MaxAttempts, runAt))
+		if err != nil {
+			return err
+		}
+		return pubsub.Publish(ctx, tx, JobsChannel, job.Type)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to enqueue job: %w", err)
+	}
+	return created, nil
+}
+
+func (s *RealDBService) GetJob(ctx context.Context, id int64) (*models.Job, error) {
+	query := `SELECT ` + jobColumns + ` FROM jobs WHERE id = $1`
+
+	j, err := scanJob(Conn.QueryRow(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrJobNotFound
+		}
+		return nil, fmt.Errorf("failed to get job: %w", err)
+	}
+	return j, nil
+}
+
+func (s *RealDBService) ClaimJobs(ctx context.Context, worker string, limit int, lockFor time.Duration) ([]models.Job, error) {
+	query := `
+		WITH next AS (
+			SELECT id
+			FROM jobs
+			WHERE (status = 'queued' AND run_at <= NOW())
+				OR (status = 'running' AND locked_until < NOW())
+			ORDER BY priority, run_at, id
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		UPDATE jobs
+		SET status = 'running',
+			attempts = jobs.attempts + 1,
+			locked_by = $1,
+			locked_until = NOW() + make_interval(secs => $3),
+			started_at = NOW()
+		FROM next
+		WHERE jobs.id = next.id
+		RETURNING jobs.id, jobs.type, jobs.payload, jobs.priority, jobs.status, jobs.attempts,
+			jobs.max_attempts, jobs.run_at, COALESCE(jobs.last_error, ''), COALESCE(jobs.locked_by, ''),
+			jobs.created_at, jobs.started_at, jobs.finished_at`
+
+	rows, err := Conn.Query(ctx, query, worker, limit, lockFor.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim jobs: %w", err)
+	}
+	return scanJobs(rows)
+}
+
+// CompleteJob and FailJob only apply while worker still holds the job, so a
+// worker that overran its lock can't overwrite the outcome of the retry.
+
+// CompleteJob also clears the payload: a succeeded job never runs again, and
+// payloads may hold secrets such as emailed links.
+func (s *RealDBService) CompleteJob(ctx context.Context, id int64, worker string) error {
+	query := `
+		UPDATE jobs
+		SET status = 'succeeded', payload = '{}', finished_at = NOW(), locked_by = NULL, locked_until = NULL, last_error = NULL
+		WHERE id = $1 AND status = 'running' AND locked_by = $2`
+
+	if _, err := Conn.Exec(ctx, query, id, worker); err != nil {
+		return fmt.Errorf("failed to complete job: %w", err)
+	}
+	return nil
+}
+
+func (s *RealDBService) FailJob(ctx context.Context, id int64, worker, reason string, retryAt *time.Time) error {
+	query := `
+		UPDATE jobs
+		SET status = CASE WHEN $4::timestamptz IS NULL THEN 'dead' ELSE 'queued' END,
+			run_at = COALESCE($4, run_at),
+			finished_at = CASE WHEN $4::timestamptz IS NULL THEN NOW() ELSE NULL END,
+			last_error = $3,
+			locked_by = NULL,
+			locked_until = NULL
+		WHERE id = $1 AND status = 'running' AND locked_by = $2`
+
+	if _, err := Conn.Exec(ctx, query, id, worker, reason, retryAt); err != nil {
+		return fmt.Errorf("failed to record job failure: %w", err)
+	}
+	return nil
+}
+
+func (s *RealDBService) ListJobs(ctx context.Context, status, jobType string, limit int) ([]models.Job, error) {
+	query := `
+		SELECT ` + jobColumns + `
+		FROM jobs
+		WHERE ($1 = '' OR status = $1) AND ($2 = '' OR type = $2)
+		ORDER BY id DESC
+		LIMIT $3`
+
+	rows, err := Conn.Query(ctx, query, status, jobType, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list jobs: %w", err)
+	}
+	return scanJobs(rows)
+}
+
+func (s *RealDBService) RetryJob(ctx context.Context, id int64) (*models.Job, error) {
+	query := `
+		UPDATE jobs
+		SET status = 'queued', attempts = 0, run_at = NOW(), finished_at = NULL
+		WHERE id = $1 AND status IN ('dead', 'cancelled')
+		RETURNING ` + jobColumns
+
+	var j *models.Job
+	err := pgx.BeginFunc(ctx, Conn, func(tx pgx.Tx) error {
+		var err error
+		j, err = scanJob(tx.QueryRow(ctx, query, id))
+		if err != nil {
+			return err
+		}
+		return pubsub.Publish(ctx, tx, JobsChannel, j.Type)
+	})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, s.jobStateError(ctx, id)
+		}
+		return nil, fmt.Errorf("failed to retry job: %w", err)
+	}
+	return j, nil
+}
+
+func (s *RealDBService) CancelJob(ctx context.Context, id int64) (*models.Job, error) {
+	query := `
+		UPDATE jobs
+		SET status = 'cancelled', finished_at = NOW()
+		WHERE id = $1 AND status = 'queued'
+		RETURNING ` + jobColumns
+
+	j, err := scanJob(Conn.QueryRow(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, s.jobStateError(ctx, id)
+		}
+		return nil, fmt.Errorf("failed to cancel job: %w", err)
+	}
+	return j, nil
+}
+
+// jobStateError tells a missing job apart from one in the wrong state after
+// a conditional update matched nothing.
+func (s *RealDBService) jobStateError(ctx context.Context, id int64) error {
+	if _, err := s.GetJob(ctx, id); err != nil {
+		return err
+	}
+	return ErrJobState
+}
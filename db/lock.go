@@ -0,0 +1,81 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// AdvisoryLock is a session-level Postgres advisory lock used to elect one
+// leader across replicas. The lock lives as long as the connection holding
+// it, so a crashed leader's lock is released by the server.
+type AdvisoryLock struct {
+	key int64
+
+	mu   sync.Mutex
+	conn *pgxpool.Conn
+}
+
+func NewAdvisoryLock(key int64) *AdvisoryLock {
+	return &AdvisoryLock{key: key}
+}
+
+// TryAcquire takes the lock if it is free and reports whether it is held
+// afterwards. While held it checks the connection is still alive, so a
+// leader that lost its connection finds out and steps down.
+func (l *AdvisoryLock) TryAcquire(ctx context.Context) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.conn != nil {
+		if err := l.conn.Ping(ctx); err != nil {
+			// The server drops the lock with the session.
+			l.conn.Conn().Close(ctx)
+			l.conn.Release()
+			l.conn = nil
+			return false, fmt.Errorf("lost advisory lock connection: %w", err)
+		}
+		return true, nil
+	}
+
+	conn, err := Conn.Acquire(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to acquire connection for advisory lock: %w", err)
+	}
+
+	var acquired bool
+	if err := conn.QueryRow(ctx, "SELECT pg_try_advisory_lock($1)", l.key).Scan(&acquired); err != nil {
+		conn.Release()
+		return false, fmt.Errorf("failed to try advisory lock: %w", err)
+	}
+	if !acquired {
+		conn.Release()
+		return false, nil
+	}
+
+	l.conn = conn
+	return true, nil
+}
+
+// Release gives up the lock if it is held.
+func (l *AdvisoryLock) Release(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.conn == nil {
+		return nil
+	}
+	defer func() {
+		l.conn.Release()
+		l.conn = nil
+	}()
+
+	if _, err := l.conn.Exec(ctx, "SELECT pg_advisory_unlock($1)", l.key); err != nil {
+		// Closing the session releases the lock as well.
+		l.conn.Conn().Close(ctx)
+		return fmt.Errorf("failed to release advisory lock: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/manuel/make-it-rain/models"
+	"github.com/manuel/make-it-rain/pubsub"
+)
+
+// LoginUnlocksChannel is notified when an admin unlocks a login key, so
+// every instance can drop its in-process failure count for it.
+const LoginUnlocksChannel = "login_unlocks"
+
+// LoginUnlock is the payload published on LoginUnlocksChannel.
+type LoginUnlock struct {
+	Key string `json:"key"`
+}
+
+var ErrLoginNotLocked = errors.New("login is not locked")
+
+// LoginAttemptStore counts failed logins per key across replicas. A key is
+// either an account or a client IP; see auth.LoginLimiter.
+type LoginAttemptStore interface {
+	// RecordLoginFailure counts a failure for key and returns the number of
+	// failures in the current window. A window older than window is
+	// restarted.
+	RecordLoginFailure(ctx context.Context, key string, window time.Duration) (int, error)
+	// LockLogin blocks key until until, or keeps a later existing lock.
+	// When userID is non-zero the key belongs to that user's account and a
+	// user.locked event is recorded.
+	LockLogin(ctx context.Context, key string, until time.Time, userID int64) error
+	// LoginLockedUntil returns the latest lock on any of keys, or the zero
+	// time when none is locked.
+	LoginLockedUntil(ctx context.Context, keys []string) (time.Time, error)
+	// ClearLoginFailures forgets key after a successful login.
+	ClearLoginFailures(ctx context.Context, key string) error
+	// UnlockLogin lifts a lock early and notifies LoginUnlocksChannel. When
+	// userID is non-zero a user.unlocked event is recorded. It returns
+	// ErrLoginNotLocked when key isn't locked.
+	UnlockLogin(ctx context.Context, key string, userID int64) error
+	ListLoginLocks(ctx context.Context, limit int) ([]models.LoginLock, error)
+}
+
+func NewLoginAttemptStore() LoginAttemptStore {
+	return &RealDBService{}
+}
+
+func (s *RealDBService) RecordLoginFailure(ctx context.Context, key string, window time.Duration) (int, error) {
+	query := `
+		INSERT INTO login_failures (key, failures, window_start, updated_at)
+		VALUES ($1, 1, NOW(), NOW())
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE
+				WHEN login_failures.window_start < NOW() - $2 * INTERVAL '1 millisecond' THEN 1
+				ELSE login_failures.failures + 1
+			END,
+			window_start = CASE
+				WHEN login_failures.window_start < NOW() - $2 * INTERVAL '1 millisecond' THEN NOW()
+				ELSE login_failures.window_start
+			END,
+			updated_at = NOW()
+		RETURNING failures`
+
+	var failures int
+	if err := Conn.QueryRow(ctx, query, key, window.Milliseconds()).Scan(&failures); err != nil {
+		return 0, fmt.Errorf("failed to record login failure: %w", err)
+	}
+	return failures, nil
+}
+
+func (s *RealDBService) LockLogin(ctx context.Context, key string, until time.Time, userID int64) error {
+	err := pgx.BeginFunc(ctx, Conn, func(tx pgx.Tx) error {
+		var lock models.LoginLock
+		err := tx.QueryRow(ctx, `
+			UPDATE login_failures
+			SET locked_until = GREATEST(COALESCE(locked_until, $2), $2), updated_at = NOW()
+			WHERE key = $1
+			RETURNING key, failures, locked_until, updated_at`,
+			key, until,
+		).Scan(&lock.Key, &lock.Failures, &lock.LockedUntil, &lock.UpdatedAt)
+		if err != nil {
+			return err
+		}
+		if userID == 0 {
+			return nil
+		}
+		return insertUserEvent(ctx, tx, models.EventUserLocked, userID, &lock)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to lock login: %w", err)
+	}
+	return nil
+}
+
+func (s *RealDBService) LoginLockedUntil(ctx context.Context, keys []string) (time.Time, error) {
+	query := `
+		SELECT MAX(locked_until)
+		FROM login_failures
+		WHERE key = ANY($1) AND locked_until > NOW()`
+
+	var until *time.Time
+	if err := Conn.QueryRow(ctx, query, keys).Scan(&until); err != nil {
+		return time.Time{}, fmt.Errorf("failed to check login lock: %w", err)
+	}
+	if until == nil {
+		return time.Time{}, nil
+	}
+	return *until, nil
+}
+
+func (s *RealDBService) ClearLoginFailures(ctx context.Context, key string) error {
+	if _, err := Conn.Exec(ctx, `DELETE FROM login_failures WHERE key = $1`, key); err != nil {
+		return fmt.Errorf("failed to clear login failures: %w", err)
+	}
+	return nil
+}
+
+func (s *RealDBService) UnlockLogin(ctx context.Context, key string, userID int64) error {
+	err := pgx.BeginFunc(ctx, Conn, func(tx pgx.Tx) error {
+		var lock models.LoginLock
+		err := tx.QueryRow(ctx, `
+			DELETE FROM login_failures
+			WHERE key = $1 AND locked_until > NOW()
+			RETURNING key, failures, locked_until, updated_at`,
+			key,
+		).Scan(&lock.Key, &lock.Failures, &lock.LockedUntil, &lock.UpdatedAt)
+		if err != nil {
+			return err
+		}
+		if userID != 0 {
+			if err := insertUserEvent(ctx, tx, models.EventUserUnlocked, userID, &lock); err != nil {
+				return err
+			}
+		}
+		return pubsub.Publish(ctx, tx, LoginUnlocksChannel, LoginUnlock{Key: key})
+	})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrLoginNotLocked
+		}
+		return fmt.Errorf("failed to unlock login: %w", err)
+	}
+	return nil
+}
+
+func (s *RealDBService) ListLoginLocks(ctx context.Context, limit int) ([]models.LoginLock, error) {
+	query := `
+		SELECT key, failures, locked_until, updated_at
+		FROM login_failures
+		WHERE locked_until > NOW()
+		ORDER BY locked_until DESC
+		LIMIT $1`
+
+	rows, err := Conn.Query(ctx, query, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list login locks: %w", err)
+	}
+	defer rows.Close()
+
+	locks := []models.LoginLock{}
+	for rows.Next() {
+		var lock models.LoginLock
+		if err := rows.Scan(&lock.Key, &lock.Failures, &lock.LockedUntil, &lock.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan login lock: %w", err)
+		}
+		locks = append(locks, lock)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list login locks: %w", err)
+	}
+	return locks, nil
+}
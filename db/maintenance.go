@@ -0,0 +1,133 @@
+package db
+
+import (
+	"context"
+	"fmt"
+)
+
+// MaintenanceStore deletes rows that are no longer needed. Each call removes
+// at most batchSize rows so a large backlog doesn't hold locks for long;
+// callers repeat until fewer than batchSize are deleted.
+type MaintenanceStore interface {
+	PurgeExpiredIdempotencyKeys(ctx context.Context, batchSize int) (int64, error)
+	// PurgeExpiredRefreshTokens deletes refresh tokens that expired or were
+	// revoked more than a day ago.
+	PurgeExpiredRefreshTokens(ctx context.Context, batchSize int) (int64, error)
+	PurgeExpiredUserTokens(ctx context.Context, batchSize int) (int64, error)
+	// PurgeStaleLoginFailures deletes failure counts that haven't changed
+	// for a day and aren't holding a lock.
+	PurgeStaleLoginFailures(ctx context.Context, batchSize int) (int64, error)
+	// PurgeExpiredAPIKeys deletes API keys that expired or were revoked
+	// more than 30 days ago; until then users still see them listed.
+	PurgeExpiredAPIKeys(ctx context.Context, batchSize int) (int64, error)
+	// PurgeExpiredOIDCLogins deletes logins sent to an identity provider
+	// that were never finished.
+	PurgeExpiredOIDCLogins(ctx context.Context, batchSize int) (int64, error)
+}
+
+func NewMaintenanceStore() MaintenanceStore {
+	return &RealDBService{}
+}
+
+func (s *RealDBService) PurgeExpiredIdempotencyKeys(ctx context.Context, batchSize int) (int64, error) {
+	query := `
+		DELETE FROM idempotency_keys
+		WHERE (scope, key) IN (
+			SELECT scope, key FROM idempotency_keys
+			WHERE expires_at < NOW()
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)`
+
+	result, err := Conn.Exec(ctx, query, batchSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge idempotency keys: %w", err)
+	}
+	return result.RowsAffected(), nil
+}
+
+func (s *RealDBService) PurgeExpiredRefreshTokens(ctx context.Context, batchSize int) (int64, error) {
+	query := `
+		DELETE FROM refresh_tokens
+		WHERE id IN (
+			SELECT id FROM refresh_tokens
+			WHERE expires_at < NOW() - INTERVAL '1 day' OR revoked_at < NOW() - INTERVAL '1 day'
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)`
+
+	result, err := Conn.Exec(ctx, query, batchSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge refresh tokens: %w", err)
+	}
+	return result.RowsAffected(), nil
+}
+
+func (s *RealDBService) PurgeExpiredUserTokens(ctx context.Context, batchSize int) (int64, error) {
+	query := `
+		DELETE FROM user_tokens
+		WHERE id IN (
+			SELECT id FROM user_tokens
+			WHERE expires_at < NOW() - INTERVAL '1 day'
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)`
+
+	result, err := Conn.Exec(ctx, query, batchSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge user tokens: %w", err)
+	}
+	return result.RowsAffected(), nil
+}
+
+func (s *RealDBService) PurgeStaleLoginFailures(ctx context.Context, batchSize int) (int64, error) {
+	query := `
+		DELETE FROM login_failures
+		WHERE key IN (
+			SELECT key FROM login_failures
+			WHERE updated_at < NOW() - INTERVAL '1 day'
+			AND (locked_until IS NULL OR locked_until < NOW())
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)`
+
+	result, err := Conn.Exec(ctx, query, batchSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge login failures: %w", err)
+	}
+	return result.RowsAffected(), nil
+}
+
+func (s *RealDBService) PurgeExpiredAPIKeys(ctx context.Context, batchSize int) (int64, error) {
+	query := `
+		DELETE FROM api_keys
+		WHERE id IN (
+			SELECT id FROM api_keys
+			WHERE expires_at < NOW() - INTERVAL '30 days' OR revoked_at < NOW() - INTERVAL '30 days'
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)`
+
+	result, err := Conn.Exec(ctx, query, batchSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge API keys: %w", err)
+	}
+	return result.RowsAffected(), nil
+}
+
+func (s *RealDBService) PurgeExpiredOIDCLogins(ctx context.Context, batchSize int) (int64, error) {
+	query := `
+		DELETE FROM oidc_logins
+		WHERE id IN (
+			SELECT id FROM oidc_logins
+			WHERE expires_at < NOW()
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)`
+
+	result, err := Conn.Exec(ctx, query, batchSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge OIDC logins: %w", err)
+	}
+	return result.RowsAffected(), nil
+}
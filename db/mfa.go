@@ -0,0 +1,211 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/manuel/make-it-rain/models"
+)
+
+var (
+	// ErrMFANotEnrolled is returned when a user has no TOTP secret, or none
+	// in the expected state.
+	ErrMFANotEnrolled    = errors.New("two-factor authentication is not set up")
+	ErrMFAAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+)
+
+// MFAStore keeps TOTP secrets and recovery codes. Enabling and disabling
+// MFA change the user, so those are on DBService.
+type MFAStore interface {
+	// SaveMFASecret starts enrollment with a new secret, replacing any
+	// pending one. A confirmed secret is kept and ErrMFAAlreadyEnabled
+	// returned; it has to be removed with DisableMFA first.
+	SaveMFASecret(ctx context.Context, userID int64, secret []byte) error
+	GetMFASecret(ctx context.Context, userID int64) (*models.MFASecret, error)
+	// UseMFAStep records that the code for step was used. It returns
+	// ErrTokenInvalid if that step or a later one was used already.
+	UseMFAStep(ctx context.Context, userID, step int64) error
+	// UseRecoveryCode marks an unused recovery code used, or returns
+	// ErrTokenInvalid.
+	UseRecoveryCode(ctx context.Context, userID int64, codeHash string) error
+	// ReplaceRecoveryCodes invalidates every recovery code of the user and
+	// stores new ones.
+	ReplaceRecoveryCodes(ctx context.Context, userID int64, codeHashes []string) error
+}
+
+func NewMFAStore() MFAStore {
+	return &RealDBService{}
+}
+
+func (s *RealDBService) SaveMFASecret(ctx context.Context, userID int64, secret []byte) error {
+	query := `
+		INSERT INTO user_mfa (user_id, secret)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE
+		SET secret = EXCLUDED.secret, last_used_step = 0, created_at = NOW()
+		WHERE user_mfa.confirmed_at IS NULL`
+
+	result, err := Conn.Exec(ctx, query, userID, secret)
+	if err != nil {
+		return fmt.Errorf("failed to save MFA secret: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return ErrMFAAlreadyEnabled
+	}
+	return nil
+}
+
+func (s *RealDBService) GetMFASecret(ctx context.Context, userID int64) (*models.MFASecret, error) {
+	query := `
+		SELECT user_id, secret, confirmed_at, last_used_step, created_at
+		FROM user_mfa
+		WHERE user_id = $1`
+
+	var m models.MFASecret
+	err := Conn.QueryRow(ctx, query, userID).Scan(
+		&m.UserID,
+		&m.Secret,
+		&m.ConfirmedAt,
+		&m.LastUsedStep,
+		&m.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrMFANotEnrolled
+		}
+		return nil, fmt.Errorf("failed to get MFA secret: %w", err)
+	}
+	return &m, nil
+}
+
+func (s *RealDBService) UseMFAStep(ctx context.Context, userID, step int64) error {
+	query := `
+		UPDATE user_mfa
+		SET last_used_step = $2
+		WHERE user_id = $1 AND last_used_step < $2`
+
+	result, err := Conn.Exec(ctx, query, userID, step)
+	if err != nil {
+		return fmt.Errorf("failed to record MFA code use: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return ErrTokenInvalid
+	}
+	return nil
+}
+
+func (s *RealDBService) UseRecoveryCode(ctx context.Context, userID int64, codeHash string) error {
+	query := `
+		UPDATE mfa_recovery_codes
+		SET used_at = NOW()
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`
+
+	result, err := Conn.Exec(ctx, query, userID, codeHash)
+	if err != nil {
+		return fmt.Errorf("failed to use recovery code: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return ErrTokenInvalid
+	}
+	return nil
+}
+
+func (s *RealDBService) ReplaceRecoveryCodes(ctx context.Context, userID int64, codeHashes []string) error {
+	err := pgx.BeginFunc(ctx, Conn, func(tx pgx.Tx) error {
+		return replaceRecoveryCodes(ctx, tx, userID, codeHashes)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to replace recovery codes: %w", err)
+	}
+	return nil
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx pgx.Tx, userID int64, codeHashes []string) error {
+	if _, err := tx.Exec(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	_, err := tx.Exec(ctx, `
+		INSERT INTO mfa_recovery_codes (user_id, code_hash)
+		SELECT $1, unnest($2::text[])`,
+		userID, codeHashes)
+	return err
+}
+
+// ConfirmMFA enables MFA for a user whose pending secret produced the code
+// for step, and stores their first recovery codes.
+func (s *RealDBService) ConfirmMFA(ctx context.Context, userID, step int64, codeHashes []string) (*User, error) {
+	var u User
+	err := pgx.BeginFunc(ctx, Conn, func(tx pgx.Tx) error {
+		result, err := tx.Exec(ctx, `
+			UPDATE user_mfa
+			SET confirmed_at = NOW(), last_used_step = $2
+			WHERE user_id = $1 AND confirmed_at IS NULL`,
+			userID, step)
+		if err != nil {
+			return err
+		}
+		if result.RowsAffected() == 0 {
+			return ErrMFANotEnrolled
+		}
+		if err := replaceRecoveryCodes(ctx, tx, userID, codeHashes); err != nil {
+			return err
+		}
+
+		err = scanUpdatedUser(tx.QueryRow(ctx, `
+			UPDATE users
+			SET mfa_enabled_at = NOW(), updated_at = NOW()
+			WHERE id = $1
+			`+userUpdateReturning,
+			userID,
+		), &u)
+		if err != nil {
+			return err
+		}
+		return insertUserEvent(ctx, tx, models.EventUserUpdated, userID, &u)
+	})
+	if err != nil {
+		if errors.Is(err, ErrMFANotEnrolled) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to confirm MFA: %w", err)
+	}
+
+	publishUserChange(ctx, UserUpdated, userID)
+	return &u, nil
+}
+
+// DisableMFA removes a user's TOTP secret and recovery codes.
+func (s *RealDBService) DisableMFA(ctx context.Context, userID int64) error {
+	err := pgx.BeginFunc(ctx, Conn, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `DELETE FROM user_mfa WHERE user_id = $1`, userID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID); err != nil {
+			return err
+		}
+
+		var u User
+		err := scanUpdatedUser(tx.QueryRow(ctx, `
+			UPDATE users
+			SET mfa_enabled_at = NULL, updated_at = NOW()
+			WHERE id = $1
+			`+userUpdateReturning,
+			userID,
+		), &u)
+		if err != nil {
+			return err
+		}
+		return insertUserEvent(ctx, tx, models.EventUserUpdated, userID, &u)
+	})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("user not found")
+		}
+		return fmt.Errorf("failed to disable MFA: %w", err)
+	}
+
+	publishUserChange(ctx, UserUpdated, userID)
+	return nil
+}
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_outbox_status_created_at;
+DROP INDEX IF EXISTS idx_outbox_aggregate;
+DROP INDEX IF EXISTS idx_outbox_pending;
+DROP TABLE IF EXISTS outbox;
\ No newline at end of file
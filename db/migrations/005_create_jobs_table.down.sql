@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_jobs_status_created_at;
+DROP INDEX IF EXISTS idx_jobs_running;
+DROP INDEX IF EXISTS idx_jobs_queued;
+DROP TABLE IF EXISTS jobs;
\ No newline at end of file
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS scheduled_tasks;
\ No newline at end of file
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS scheduled_tasks (
+    name VARCHAR(128) PRIMARY KEY,
+    schedule VARCHAR(255) NOT NULL,
+    last_started_at TIMESTAMP WITH TIME ZONE,
+    last_finished_at TIMESTAMP WITH TIME ZONE,
+    last_status VARCHAR(16),
+    last_error TEXT,
+    last_duration_ms BIGINT NOT NULL DEFAULT 0,
+    last_run_by VARCHAR(255),
+    next_run_at TIMESTAMP WITH TIME ZONE,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
\ No newline at end of file
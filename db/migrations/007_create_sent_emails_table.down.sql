@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_sent_emails_recipients;
+DROP INDEX IF EXISTS idx_sent_emails_created_at;
+DROP TABLE IF EXISTS sent_emails;
\ No newline at end of file
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS sent_emails (
+    id BIGSERIAL PRIMARY KEY,
+    job_id BIGINT REFERENCES jobs(id) ON DELETE SET NULL,
+    recipients TEXT[] NOT NULL,
+    subject TEXT NOT NULL,
+    template VARCHAR(128) NOT NULL,
+    locale VARCHAR(16) NOT NULL,
+    message_id VARCHAR(255) NOT NULL,
+    status VARCHAR(16) NOT NULL,
+    error TEXT,
+    attempt INTEGER NOT NULL DEFAULT 1,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX idx_sent_emails_created_at ON sent_emails(created_at);
+CREATE INDEX idx_sent_emails_recipients ON sent_emails USING GIN (recipients);
\ No newline at end of file
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_user_tokens_expires_at;
+DROP INDEX IF EXISTS idx_user_tokens_user_purpose;
+DROP TABLE IF EXISTS user_tokens;
+ALTER TABLE users DROP COLUMN IF EXISTS locale;
+ALTER TABLE users DROP COLUMN IF EXISTS email_verified_at;
\ No newline at end of file
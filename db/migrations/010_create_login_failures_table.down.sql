@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_login_failures_updated_at;
+DROP INDEX IF EXISTS idx_login_failures_locked_until;
+DROP TABLE IF EXISTS login_failures;
\ No newline at end of file
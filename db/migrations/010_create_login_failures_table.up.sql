@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS login_failures (
+    key VARCHAR(320) PRIMARY KEY,
+    failures INTEGER NOT NULL DEFAULT 0,
+    window_start TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    locked_until TIMESTAMP WITH TIME ZONE,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX idx_login_failures_locked_until ON login_failures(locked_until) WHERE locked_until IS NOT NULL;
+CREATE INDEX idx_login_failures_updated_at ON login_failures(updated_at);
\ No newline at end of file
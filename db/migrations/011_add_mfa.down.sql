@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_mfa_recovery_codes_user_hash;
+DROP TABLE IF EXISTS mfa_recovery_codes;
+DROP TABLE IF EXISTS user_mfa;
+ALTER TABLE users DROP COLUMN IF EXISTS mfa_enabled_at;
+ALTER TABLE users DROP COLUMN IF EXISTS role;
\ No newline at end of file
@@ -0,0 +1,2 @@
+ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS ip;
+ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS user_agent;
\ No newline at end of file
@@ -0,0 +1,2 @@
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS user_agent TEXT NOT NULL DEFAULT '';
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS ip VARCHAR(45) NOT NULL DEFAULT '';
\ No newline at end of file
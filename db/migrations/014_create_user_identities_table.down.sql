@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_oidc_logins_expires_at;
+DROP TABLE IF EXISTS oidc_logins;
+DROP INDEX IF EXISTS idx_user_identities_user_id;
+DROP TABLE IF EXISTS user_identities;
\ No newline at end of file
@@ -0,0 +1,31 @@
+package db
+
+import (
+	"context"
+
+	"github.com/manuel/make-it-rain/logging"
+	"github.com/manuel/make-it-rain/pubsub"
+)
+
+// UserChangesChannel carries UserChange events between instances so each
+// can drop stale cache entries.
+const UserChangesChannel = "user_changes"
+
+const (
+	UserUpdated = "updated"
+	UserDeleted = "deleted"
+)
+
+type UserChange struct {
+	Op     string `json:"op"`
+	UserID int64  `json:"user_id"`
+}
+
+// publishUserChange notifies other instances after a committed write. The
+// write has already succeeded, so a failure is logged rather than returned;
+// listeners resynchronise when they reconnect.
+func publishUserChange(ctx context.Context, op string, userID int64) {
+	if err := pubsub.Publish(ctx, Conn, UserChangesChannel, UserChange{Op: op, UserID: userID}); err != nil {
+		logging.Ctx(ctx, logging.DB).Warn().Err(err).Int64("user_id", userID).Msg("Failed to publish user change")
+	}
+}
@@ -0,0 +1,178 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/manuel/make-it-rain/models"
+	"github.com/manuel/make-it-rain/pubsub"
+)
+
+// OutboxChannel is notified when events are committed so dispatchers can
+// pick them up without waiting for the next poll.
+const OutboxChannel = "outbox_events"
+
+// OutboxStore is the dispatcher's view of the outbox table.
+type OutboxStore interface {
+	// ClaimOutboxEvents locks up to limit deliverable events for lockFor.
+	// Only the oldest pending event of each aggregate is deliverable, so
+	// events for one aggregate are handled in order.
+	ClaimOutboxEvents(ctx context.Context, limit int, lockFor time.Duration) ([]models.Event, error)
+	MarkOutboxDelivered(ctx context.Context, id int64) error
+	// MarkOutboxFailed records a failed attempt. The event is retried at
+	// retryAt, or moved to the dead-letter state when retryAt is nil.
+	MarkOutboxFailed(ctx context.Context, id int64, reason string, retryAt *time.Time) error
+	ListOutboxEvents(ctx context.Context, status string, limit int) ([]models.Event, error)
+	// RequeueOutboxEvent moves a dead event back to pending with its attempts reset.
+	RequeueOutboxEvent(ctx context.Context, id int64) error
+}
+
+func NewOutboxStore() OutboxStore {
+	return &RealDBService{}
+}
+
+// insertOutboxEvent records an event in the caller's transaction, so it is
+// published if and only if the change it describes commits.
+func insertOutboxEvent(ctx context.Context, tx pgx.Tx, aggregateType, aggregateID, eventType string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s event: %w", eventType, err)
+	}
+
+	query := `
+		INSERT INTO outbox (aggregate_type, aggregate_id, event_type, payload, status, available_at, created_at)
+		VALUES ($1, $2, $3, $4, 'pending', NOW(), NOW())`
+
+	if _, err := tx.Exec(ctx, query, aggregateType, aggregateID, eventType, data); err != nil {
+		return fmt.Errorf("failed to insert %s event: %w", eventType, err)
+	}
+	return pubsub.Publish(ctx, tx, OutboxChannel, eventType)
+}
+
+var ErrOutboxEventNotDead = errors.New("outbox event not found or not dead-lettered")
+
+const outboxColumns = `id, aggregate_type, aggregate_id, event_type, payload, status, attempts,
+	COALESCE(last_error, ''), available_at, created_at, delivered_at`
+
+func scanOutboxEvents(rows pgx.Rows) ([]models.Event, error) {
+	defer rows.Close()
+
+	var events []models.Event
+	for rows.Next() {
+		var e models.Event
+		err := rows.Scan(
+			&e.ID,
+			&e.AggregateType,
+			&e.AggregateID,
+			&e.Type,
+			&e.Payload,
+			&e.Status,
+			&e.Attempts,
+			&e.LastError,
+			&e.AvailableAt,
+			&e.CreatedAt,
+			&e.DeliveredAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan outbox event: %w", err)
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+func (s *RealDBService) ClaimOutboxEvents(ctx context.Context, limit int, lockFor time.Duration) ([]models.Event, error) {
+	query := `
+		WITH next AS (
+			SELECT o.id
+			FROM outbox o
+			WHERE o.status = 'pending'
+				AND o.available_at <= NOW()
+				AND (o.locked_until IS NULL OR o.locked_until < NOW())
+				AND NOT EXISTS (
+					SELECT 1 FROM outbox p
+					WHERE p.aggregate_type = o.aggregate_type
+						AND p.aggregate_id = o.aggregate_id
+						AND p.status = 'pending'
+						AND p.id < o.id
+				)
+			ORDER BY o.id
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		UPDATE outbox
+		SET locked_until = NOW() + make_interval(secs => $2), attempts = outbox.attempts + 1
+		FROM next
+		WHERE outbox.id = next.id
+		RETURNING outbox.id, outbox.aggregate_type, outbox.aggregate_id, outbox.event_type,
+			outbox.payload, outbox.status, outbox.attempts, COALESCE(outbox.last_error, ''),
+			outbox.available_at, outbox.created_at, outbox.delivered_at`
+
+	rows, err := Conn.Query(ctx, query, limit, lockFor.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim outbox events: %w", err)
+	}
+	return scanOutboxEvents(rows)
+}
+
+func (s *RealDBService) MarkOutboxDelivered(ctx context.Context, id int64) error {
+	query := `
+		UPDATE outbox
+		SET status = 'delivered', delivered_at = NOW(), locked_until = NULL, last_error = NULL
+		WHERE id = $1`
+
+	if _, err := Conn.Exec(ctx, query, id); err != nil {
+		return fmt.Errorf("failed to mark outbox event delivered: %w", err)
+	}
+	return nil
+}
+
+func (s *RealDBService) MarkOutboxFailed(ctx context.Context, id int64, reason string, retryAt *time.Time) error {
+	query := `
+		UPDATE outbox
+		SET status = CASE WHEN $3::timestamptz IS NULL THEN 'dead' ELSE 'pending' END,
+			available_at = COALESCE($3, available_at),
+			last_error = $2,
+			locked_until = NULL
+		WHERE id = $1`
+
+	if _, err := Conn.Exec(ctx, query, id, reason, retryAt); err != nil {
+		return fmt.Errorf("failed to mark outbox event failed: %w", err)
+	}
+	return nil
+}
+
+func (s *RealDBService) ListOutboxEvents(ctx context.Context, status string, limit int) ([]models.Event, error) {
+	query := `
+		SELECT ` + outboxColumns + `
+		FROM outbox
+		WHERE ($1 = '' OR status = $1)
+		ORDER BY id DESC
+		LIMIT $2`
+
+	rows, err := Conn.Query(ctx, query, status, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list outbox events: %w", err)
+	}
+	return scanOutboxEvents(rows)
+}
+
+func (s *RealDBService) RequeueOutboxEvent(ctx context.Context, id int64) error {
+	query := `
+		UPDATE outbox
+		SET status = 'pending', attempts = 0, available_at = NOW(), locked_until = NULL
+		WHERE id = $1 AND status = 'dead'`
+
+	result, err := Conn.Exec(ctx, query, id)
+	if err != nil {
+		return fmt.Errorf("failed to requeue outbox event: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return ErrOutboxEventNotDead
+	}
+	return nil
+}
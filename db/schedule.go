@@ -0,0 +1,92 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/manuel/make-it-rain/models"
+)
+
+// ScheduleStore records when recurring tasks ran and how it went.
+type ScheduleStore interface {
+	// RegisterScheduledTask creates or updates a task's row with its
+	// current schedule and next run, keeping its run history.
+	RegisterScheduledTask(ctx context.Context, name, schedule string, nextRunAt time.Time) error
+	// RecordTaskRun stores the outcome of a finished run.
+	RecordTaskRun(ctx context.Context, run models.ScheduledTask) error
+	ListScheduledTasks(ctx context.Context) ([]models.ScheduledTask, error)
+}
+
+func NewScheduleStore() ScheduleStore {
+	return &RealDBService{}
+}
+
+func (s *RealDBService) RegisterScheduledTask(ctx context.Context, name, schedule string, nextRunAt time.Time) error {
+	query := `
+		INSERT INTO scheduled_tasks (name, schedule, next_run_at, updated_at)
+		VALUES ($1, $2, $3, NOW())
+		ON CONFLICT (name) DO UPDATE
+		SET schedule = EXCLUDED.schedule, next_run_at = EXCLUDED.next_run_at, updated_at = NOW()`
+
+	if _, err := Conn.Exec(ctx, query, name, schedule, nextRunAt); err != nil {
+		return fmt.Errorf("failed to register scheduled task: %w", err)
+	}
+	return nil
+}
+
+func (s *RealDBService) RecordTaskRun(ctx context.Context, run models.ScheduledTask) error {
+	query := `
+		UPDATE scheduled_tasks
+		SET last_started_at = $2,
+			last_finished_at = $3,
+			last_status = $4,
+			last_error = NULLIF($5, ''),
+			last_duration_ms = $6,
+			last_run_by = $7,
+			next_run_at = $8,
+			updated_at = NOW()
+		WHERE name = $1`
+
+	_, err := Conn.Exec(ctx, query, run.Name, run.LastStartedAt, run.LastFinishedAt, run.LastStatus,
+		run.LastError, run.LastDurationMS, run.LastRunBy, run.NextRunAt)
+	if err != nil {
+		return fmt.Errorf("failed to record task run: %w", err)
+	}
+	return nil
+}
+
+func (s *RealDBService) ListScheduledTasks(ctx context.Context) ([]models.ScheduledTask, error) {
+	query := `
+		SELECT name, schedule, last_started_at, last_finished_at, COALESCE(last_status, ''),
+			COALESCE(last_error, ''), last_duration_ms, COALESCE(last_run_by, ''), next_run_at
+		FROM scheduled_tasks
+		ORDER BY name`
+
+	rows, err := Conn.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list scheduled tasks: %w", err)
+	}
+	defer rows.Close()
+
+	var tasks []models.ScheduledTask
+	for rows.Next() {
+		var t models.ScheduledTask
+		err := rows.Scan(
+			&t.Name,
+			&t.Schedule,
+			&t.LastStartedAt,
+			&t.LastFinishedAt,
+			&t.LastStatus,
+			&t.LastError,
+			&t.LastDurationMS,
+			&t.LastRunBy,
+			&t.NextRunAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan scheduled task: %w", err)
+		}
+		tasks = append(tasks, t)
+	}
+	return tasks, rows.Err()
+}
@@ -0,0 +1,139 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/manuel/make-it-rain/models"
+	"github.com/manuel/make-it-rain/pubsub"
+)
+
+// SessionsChannel is notified when sessions are revoked, so every instance
+// stops accepting access tokens issued from them.
+const SessionsChannel = "session_revocations"
+
+// SessionRevocation is the payload published on SessionsChannel. A zero
+// SessionID means every session of the user.
+type SessionRevocation struct {
+	UserID    int64 `json:"user_id"`
+	SessionID int64 `json:"session_id,omitempty"`
+}
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// SessionStore lists and ends login sessions, which are the refresh tokens
+// in AuthStore.
+type SessionStore interface {
+	// ListSessions returns a user's sessions that are neither revoked nor
+	// expired, most recently used first.
+	ListSessions(ctx context.Context, userID int64) ([]models.RefreshToken, error)
+	// SessionActive returns ErrTokenInvalid unless the session is live and
+	// its user active.
+	SessionActive(ctx context.Context, sessionID int64) error
+	// RevokeSession ends one of a user's sessions and notifies
+	// SessionsChannel. It returns ErrSessionNotFound when the user has no
+	// such live session.
+	RevokeSession(ctx context.Context, userID, sessionID int64) error
+	// RevokeUserSessions ends every session of a user, notifies
+	// SessionsChannel and returns how many were live.
+	RevokeUserSessions(ctx context.Context, userID int64) (int64, error)
+}
+
+func (s *RealDBService) ListSessions(ctx context.Context, userID int64) ([]models.RefreshToken, error) {
+	query := `
+		SELECT ` + refreshTokenColumns + `
+		FROM refresh_tokens
+		WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+		ORDER BY COALESCE(last_used_at, created_at) DESC, id DESC`
+
+	rows, err := Conn.Query(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	defer rows.Close()
+
+	sessions := []models.RefreshToken{}
+	for rows.Next() {
+		t, err := scanRefreshToken(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan session: %w", err)
+		}
+		sessions = append(sessions, *t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	return sessions, nil
+}
+
+func (s *RealDBService) SessionActive(ctx context.Context, sessionID int64) error {
+	query := `
+		SELECT 1
+		FROM refresh_tokens t
+		JOIN users u ON u.id = t.user_id
+		WHERE t.id = $1 AND t.revoked_at IS NULL AND t.expires_at > NOW() AND u.is_active`
+
+	var one int
+	if err := Conn.QueryRow(ctx, query, sessionID).Scan(&one); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrTokenInvalid
+		}
+		return fmt.Errorf("failed to check session: %w", err)
+	}
+	return nil
+}
+
+func (s *RealDBService) RevokeSession(ctx context.Context, userID, sessionID int64) error {
+	err := pgx.BeginFunc(ctx, Conn, func(tx pgx.Tx) error {
+		result, err := tx.Exec(ctx, `
+			UPDATE refresh_tokens
+			SET revoked_at = NOW()
+			WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL AND expires_at > NOW()`,
+			sessionID, userID)
+		if err != nil {
+			return err
+		}
+		if result.RowsAffected() == 0 {
+			return ErrSessionNotFound
+		}
+		return pubsub.Publish(ctx, tx, SessionsChannel, SessionRevocation{UserID: userID, SessionID: sessionID})
+	})
+	if err != nil {
+		if errors.Is(err, ErrSessionNotFound) {
+			return err
+		}
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+	return nil
+}
+
+func (s *RealDBService) RevokeUserSessions(ctx context.Context, userID int64) (int64, error) {
+	var revoked int64
+	err := pgx.BeginFunc(ctx, Conn, func(tx pgx.Tx) error {
+		var err error
+		revoked, err = revokeUserSessions(ctx, tx, userID)
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+	return revoked, nil
+}
+
+// revokeUserSessions ends every session of a user when tx commits.
+func revokeUserSessions(ctx context.Context, tx pgx.Tx, userID int64) (int64, error) {
+	result, err := tx.Exec(ctx, `
+		UPDATE refresh_tokens
+		SET revoked_at = NOW()
+		WHERE user_id = $1 AND revoked_at IS NULL`,
+		userID)
+	if err != nil {
+		return 0, err
+	}
+	if err := pubsub.Publish(ctx, tx, SessionsChannel, SessionRevocation{UserID: userID}); err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
@@ -0,0 +1,97 @@
+package db
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/manuel/make-it-rain/logging"
+	"github.com/manuel/make-it-rain/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	sqlStringLiteral  = regexp.MustCompile(`'(?:[^']|'')*'`)
+	sqlNumericLiteral = regexp.MustCompile(`\b\d+(?:\.\d+)?\b`)
+	sqlWhitespace     = regexp.MustCompile(`\s+`)
+)
+
+type queryStartKey struct{}
+
+type queryStart struct {
+	statement string
+	at        time.Time
+}
+
+// QueryTracer implements pgx.QueryTracer. It records a client span for every
+// query and logs it at debug level through the request-scoped logger.
+// Statements are sanitized so literal values never reach the exporter or logs.
+type QueryTracer struct{}
+
+func (t *QueryTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	statement := sanitizeSQL(data.SQL)
+	ctx = context.WithValue(ctx, queryStartKey{}, queryStart{statement: statement, at: time.Now()})
+
+	ctx, _ = tracing.Tracer().Start(ctx, spanName(statement),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "postgresql"),
+			attribute.String("db.statement", statement),
+			attribute.Int("db.args_count", len(data.Args)),
+		),
+	)
+	return ctx
+}
+
+func (t *QueryTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	span := trace.SpanFromContext(ctx)
+	defer span.End()
+
+	tracing.RecordError(span, data.Err)
+	span.SetAttributes(attribute.Int64("db.rows_affected", data.CommandTag.RowsAffected()))
+
+	start, _ := ctx.Value(queryStartKey{}).(queryStart)
+	logger := logging.Ctx(ctx, logging.DB)
+	event := logger.Debug()
+	if data.Err != nil {
+		event = logger.Warn().Err(data.Err)
+	}
+	event.
+		Str("statement", start.statement).
+		Int64("rows", data.CommandTag.RowsAffected()).
+		Dur("duration", time.Since(start.at)).
+		Msg("Query executed")
+}
+
+// sanitizeSQL collapses whitespace and replaces string and numeric literals
+// with '?'. Bind parameters ($1, $2, ...) are kept as-is.
+func sanitizeSQL(sql string) string {
+	sql = sqlStringLiteral.ReplaceAllString(sql, "?")
+
+	var b strings.Builder
+	last := 0
+	for _, loc := range sqlNumericLiteral.FindAllStringIndex(sql, -1) {
+		if loc[0] > 0 && sql[loc[0]-1] == '$' {
+			continue
+		}
+		b.WriteString(sql[last:loc[0]])
+		b.WriteString("?")
+		last = loc[1]
+	}
+	b.WriteString(sql[last:])
+
+	return strings.TrimSpace(sqlWhitespace.ReplaceAllString(b.String(), " "))
+}
+
+// spanName uses the leading SQL keyword (SELECT, INSERT, ...) so span names
+// stay low-cardinality.
+func spanName(statement string) string {
+	op, _, _ := strings.Cut(statement, " ")
+	if op == "" {
+		return "db.query"
+	}
+	return "db." + strings.ToUpper(op)
+}
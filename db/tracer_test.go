@@ -0,0 +1,38 @@
+package db
+
+import "testing"
+
+func TestSanitizeSQL(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{
+			in:   "SELECT id FROM users\n\t\tWHERE id = $1",
+			want: "SELECT id FROM users WHERE id = $1",
+		},
+		{
+			in:   "SELECT * FROM users WHERE email = 'a@b.com' AND id = 42 LIMIT $2 OFFSET $10",
+			want: "SELECT * FROM users WHERE email = ? AND id = ? LIMIT $2 OFFSET $10",
+		},
+		{
+			in:   "UPDATE users SET name = 'O''Brien', score = 3.5 WHERE id = $1",
+			want: "UPDATE users SET name = ?, score = ? WHERE id = $1",
+		},
+	}
+
+	for _, tt := range tests {
+		if got := sanitizeSQL(tt.in); got != tt.want {
+			t.Errorf("sanitizeSQL(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSpanName(t *testing.T) {
+	if got := spanName("select 1"); got != "db.SELECT" {
+		t.Errorf("Expected db.SELECT, got %s", got)
+	}
+	if got := spanName(""); got != "db.query" {
+		t.Errorf("Expected db.query, got %s", got)
+	}
+}
@@ -4,15 +4,18 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strconv"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/manuel/make-it-rain/models"
+	"github.com/manuel/make-it-rain/pubsub"
 )
 
 type CreateUserRequest struct {
 	Email    string `json:"email" binding:"required,email"`
 	Name     string `json:"name" binding:"required"`
 	Password string `json:"password" binding:"required,min=8"`
+	Locale   string `json:"locale" binding:"omitempty,max=35"`
 }
 
 type User = models.User
@@ -25,36 +28,66 @@ type PaginatedUsers struct {
 }
 
 func (s *RealDBService) CreateUser(ctx context.Context, user *CreateUserRequest) (*User, error) {
+	var u *User
+	err := pgx.BeginFunc(ctx, Conn, func(tx pgx.Tx) error {
+		var err error
+		u, err = insertUser(ctx, tx, user, false)
+		return err
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to create user: %w", err)
+	}
+
+	return u, nil
+}
+
+// insertUser creates a user in tx and records the event. verified marks the
+// address verified, for users whose identity provider vouched for it.
+func insertUser(ctx context.Context, tx pgx.Tx, user *CreateUserRequest, verified bool) (*User, error) {
 	query := `
-		INSERT INTO users (email, name, password, is_active, created_at, updated_at)
-		VALUES ($1, $2, $3, true, NOW(), NOW())
-		RETURNING id, email, name, password, is_active, created_at, updated_at`
+		INSERT INTO users (email, name, password, locale, is_active, email_verified_at, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, true, CASE WHEN $5::boolean THEN NOW() END, NOW(), NOW())
+		RETURNING id, email, name, password, is_active, locale, email_verified_at, role, mfa_enabled_at, created_at, updated_at`
 
 	var u User
-	err := Conn.QueryRow(ctx, query,
+	err := tx.QueryRow(ctx, query,
 		user.Email,
 		user.Name,
 		user.Password,
+		user.Locale,
+		verified,
 	).Scan(
 		&u.ID,
 		&u.Email,
 		&u.Name,
 		&u.Password,
 		&u.IsActive,
+		&u.Locale,
+		&u.EmailVerifiedAt,
+		&u.Role,
+		&u.MFAEnabledAt,
 		&u.CreatedAt,
 		&u.UpdatedAt,
 	)
-
 	if err != nil {
-		return nil, fmt.Errorf("failed to create user: %w", err)
+		return nil, err
+	}
+	if err := insertUserEvent(ctx, tx, models.EventUserCreated, u.ID, &u); err != nil {
+		return nil, err
 	}
-
 	return &u, nil
 }
 
+// insertUserEvent records a user lifecycle event in tx. The payload is the
+// user as serialised by the API, so it never contains the password hash.
+func insertUserEvent(ctx context.Context, tx pgx.Tx, eventType string, userID int64, payload interface{}) error {
+	return insertOutboxEvent(ctx, tx, models.AggregateUser, strconv.FormatInt(userID, 10), eventType, payload)
+}
+
 func (s *RealDBService) GetUser(ctx context.Context, userID int64) (*User, error) {
 	query := `
-		SELECT id, email, name, password, is_active, created_at, updated_at
+		SELECT id, email, name, password, is_active, locale, email_verified_at, role, mfa_enabled_at, created_at, updated_at
 		FROM users
 		WHERE id = $1`
 
@@ -65,6 +98,10 @@ func (s *RealDBService) GetUser(ctx context.Context, userID int64) (*User, error
 		&u.Name,
 		&u.Password,
 		&u.IsActive,
+		&u.Locale,
+		&u.EmailVerifiedAt,
+		&u.Role,
+		&u.MFAEnabledAt,
 		&u.CreatedAt,
 		&u.UpdatedAt,
 	)
@@ -81,7 +118,7 @@ func (s *RealDBService) GetUser(ctx context.Context, userID int64) (*User, error
 
 func (s *RealDBService) GetUserByEmail(ctx context.Context, email string) (*User, error) {
 	query := `
-		SELECT id, email, name, password, is_active, created_at, updated_at
+		SELECT id, email, name, password, is_active, locale, email_verified_at, role, mfa_enabled_at, created_at, updated_at
 		FROM users
 		WHERE email = $1`
 
@@ -92,6 +129,10 @@ func (s *RealDBService) GetUserByEmail(ctx context.Context, email string) (*User
 		&u.Name,
 		&u.Password,
 		&u.IsActive,
+		&u.Locale,
+		&u.EmailVerifiedAt,
+		&u.Role,
+		&u.MFAEnabledAt,
 		&u.CreatedAt,
 		&u.UpdatedAt,
 	)
@@ -116,7 +157,7 @@ func (s *RealDBService) GetUsers(ctx context.Context, page, pageSize int, sortBy
 
 	offset := (page - 1) * pageSize
 	query := fmt.Sprintf(`
-		SELECT id, email, name, password, is_active, created_at, updated_at
+		SELECT id, email, name, password, is_active, locale, email_verified_at, role, mfa_enabled_at, created_at, updated_at
 		FROM users
 		ORDER BY %s %s
 		LIMIT $1 OFFSET $2`, sortBy, sortOrder)
@@ -136,6 +177,10 @@ func (s *RealDBService) GetUsers(ctx context.Context, page, pageSize int, sortBy
 			&u.Name,
 			&u.Password,
 			&u.IsActive,
+			&u.Locale,
+			&u.EmailVerifiedAt,
+			&u.Role,
+			&u.MFAEnabledAt,
 			&u.CreatedAt,
 			&u.UpdatedAt,
 		)
@@ -153,6 +198,16 @@ func (s *RealDBService) GetUsers(ctx context.Context, page, pageSize int, sortBy
 	}, nil
 }
 
+// userUpdateColumns are the columns UpdateUser may set. Field names end up
+// in the query, so anything else is refused.
+var userUpdateColumns = map[string]bool{
+	"email":             true,
+	"name":              true,
+	"locale":            true,
+	"is_active":         true,
+	"email_verified_at": true,
+}
+
 func (s *RealDBService) UpdateUser(ctx context.Context, userID int64, updates map[string]interface{}) error {
 	if len(updates) == 0 {
 		return nil
@@ -163,42 +218,123 @@ func (s *RealDBService) UpdateUser(ctx context.Context, userID int64, updates ma
 	argCount := 1
 
 	for field, value := range updates {
+		if !userUpdateColumns[field] {
+			return fmt.Errorf("failed to update user: column %q can't be updated", field)
+		}
 		argCount++
 		setClauses = append(setClauses, fmt.Sprintf("%s = $%d", field, argCount))
 		args = append(args, value)
 	}
 
+	// The old row comes along so a deactivation can be told from an update
+	// that leaves an inactive user inactive
 	query := fmt.Sprintf(`
-		UPDATE users
+		UPDATE users u
 		SET %s, updated_at = NOW()
-		WHERE id = $1`,
+		FROM (SELECT id, is_active FROM users WHERE id = $1 FOR UPDATE) old
+		WHERE u.id = old.id
+		RETURNING u.id, u.email, u.name, u.is_active, u.locale, u.email_verified_at, u.role, u.mfa_enabled_at, u.created_at, u.updated_at, old.is_active`,
 		joinStrings(setClauses, ", "))
 
-	result, err := Conn.Exec(ctx, query, args...)
+	err := pgx.BeginFunc(ctx, Conn, func(tx pgx.Tx) error {
+		var u User
+		var wasActive bool
+		err := tx.QueryRow(ctx, query, args...).Scan(
+			&u.ID,
+			&u.Email,
+			&u.Name,
+			&u.IsActive,
+			&u.Locale,
+			&u.EmailVerifiedAt,
+			&u.Role,
+			&u.MFAEnabledAt,
+			&u.CreatedAt,
+			&u.UpdatedAt,
+			&wasActive,
+		)
+		if err != nil {
+			return err
+		}
+		// Deactivating a user logs them out everywhere at once, and their
+		// API keys stop working
+		if wasActive && !u.IsActive {
+			if _, err := revokeUserSessions(ctx, tx, userID); err != nil {
+				return err
+			}
+			if err := pubsub.Publish(ctx, tx, APIKeysChannel, APIKeyChange{UserID: userID}); err != nil {
+				return err
+			}
+		}
+		return insertUserEvent(ctx, tx, models.EventUserUpdated, userID, &u)
+	})
 	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("user not found")
+		}
 		return fmt.Errorf("failed to update user: %w", err)
 	}
 
-	if result.RowsAffected() == 0 {
-		return fmt.Errorf("user not found")
-	}
-
+	publishUserChange(ctx, UserUpdated, userID)
 	return nil
 }
 
 func (s *RealDBService) DeleteUser(ctx context.Context, userID int64) error {
 	query := `DELETE FROM users WHERE id = $1`
 
-	result, err := Conn.Exec(ctx, query, userID)
+	err := pgx.BeginFunc(ctx, Conn, func(tx pgx.Tx) error {
+		result, err := tx.Exec(ctx, query, userID)
+		if err != nil {
+			return err
+		}
+		if result.RowsAffected() == 0 {
+			return pgx.ErrNoRows
+		}
+		// Sessions and API keys are deleted with the user, but instances may
+		// have them cached
+		if err := pubsub.Publish(ctx, tx, SessionsChannel, SessionRevocation{UserID: userID}); err != nil {
+			return err
+		}
+		if err := pubsub.Publish(ctx, tx, APIKeysChannel, APIKeyChange{UserID: userID}); err != nil {
+			return err
+		}
+		return insertUserEvent(ctx, tx, models.EventUserDeleted, userID, map[string]int64{"id": userID})
+	})
 	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("user not found")
+		}
 		return fmt.Errorf("failed to delete user: %w", err)
 	}
 
-	if result.RowsAffected() == 0 {
-		return fmt.Errorf("user not found")
+	publishUserChange(ctx, UserDeleted, userID)
+	return nil
+}
+
+// SetUserRole changes a user's role.
+func (s *RealDBService) SetUserRole(ctx context.Context, userID int64, role string) (*User, error) {
+	var u User
+	err := pgx.BeginFunc(ctx, Conn, func(tx pgx.Tx) error {
+		err := scanUpdatedUser(tx.QueryRow(ctx, `
+			UPDATE users
+			SET role = $2, updated_at = NOW()
+			WHERE id = $1
+			`+userUpdateReturning,
+			userID, role,
+		), &u)
+		if err != nil {
+			return err
+		}
+		return insertUserEvent(ctx, tx, models.EventUserUpdated, userID, &u)
+	})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("user not found")
+		}
+		return nil, fmt.Errorf("failed to set user role: %w", err)
 	}
 
-	return nil
+	publishUserChange(ctx, UserUpdated, userID)
+	return &u, nil
 }
 
 func joinStrings(strs []string, sep string) string {
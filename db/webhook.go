@@ -0,0 +1,288 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/manuel/make-it-rain/models"
+)
+
+var (
+	ErrWebhookNotFound         = errors.New("webhook subscription not found")
+	ErrWebhookDeliveryNotFound = errors.New("webhook delivery not found")
+)
+
+type WebhookStore interface {
+	CreateWebhook(ctx context.Context, w *models.WebhookSubscription) (*models.WebhookSubscription, error)
+	GetWebhook(ctx context.Context, id int64) (*models.WebhookSubscription, error)
+	ListWebhooks(ctx context.Context) ([]models.WebhookSubscription, error)
+	UpdateWebhook(ctx context.Context, w *models.WebhookSubscription) error
+	DeleteWebhook(ctx context.Context, id int64) error
+
+	// CreateWebhookDeliveries queues event for every active subscription
+	// whose filter matches. Calling it again for the same event is a no-op.
+	CreateWebhookDeliveries(ctx context.Context, event models.Event, payload []byte) (int64, error)
+	// ClaimWebhookDeliveries locks up to limit due deliveries for lockFor,
+	// filling in each delivery's URL and secret.
+	ClaimWebhookDeliveries(ctx context.Context, limit int, lockFor time.Duration) ([]models.WebhookDelivery, error)
+	// RecordWebhookAttempt stores the outcome of a send. A nil retryAt on a
+	// failure dead-letters the delivery.
+	RecordWebhookAttempt(ctx context.Context, id int64, succeeded bool, responseStatus *int, responseBody, lastError string, retryAt *time.Time) error
+	// PostponeWebhookDelivery releases a delivery without counting an
+	// attempt, e.g. while the endpoint's circuit is open.
+	PostponeWebhookDelivery(ctx context.Context, id int64, until time.Time) error
+	ListWebhookDeliveries(ctx context.Context, subscriptionID int64, limit int) ([]models.WebhookDelivery, error)
+	// RedeliverWebhook queues a fresh copy of a past delivery.
+	RedeliverWebhook(ctx context.Context, deliveryID int64) (*models.WebhookDelivery, error)
+}
+
+func NewWebhookStore() WebhookStore {
+	return &RealDBService{}
+}
+
+const webhookColumns = `id, url, secret, event_types, is_active, created_at, updated_at`
+
+func scanWebhook(row pgx.Row) (*models.WebhookSubscription, error) {
+	var w models.WebhookSubscription
+	err := row.Scan(
+		&w.ID,
+		&w.URL,
+		&w.Secret,
+		&w.EventTypes,
+		&w.IsActive,
+		&w.CreatedAt,
+		&w.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
+
+func (s *RealDBService) CreateWebhook(ctx context.Context, w *models.WebhookSubscription) (*models.WebhookSubscription, error) {
+	query := `
+		INSERT INTO webhook_subscriptions (url, secret, event_types, is_active, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, NOW(), NOW())
+		RETURNING ` + webhookColumns
+
+	created, err := scanWebhook(Conn.QueryRow(ctx, query, w.URL, w.Secret, w.EventTypes, w.IsActive))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create webhook: %w", err)
+	}
+	return created, nil
+}
+
+func (s *RealDBService) GetWebhook(ctx context.Context, id int64) (*models.WebhookSubscription, error) {
+	query := `SELECT ` + webhookColumns + ` FROM webhook_subscriptions WHERE id = $1`
+
+	w, err := scanWebhook(Conn.QueryRow(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrWebhookNotFound
+		}
+		return nil, fmt.Errorf("failed to get webhook: %w", err)
+	}
+	return w, nil
+}
+
+func (s *RealDBService) ListWebhooks(ctx context.Context) ([]models.WebhookSubscription, error) {
+	query := `SELECT ` + webhookColumns + ` FROM webhook_subscriptions ORDER BY id`
+
+	rows, err := Conn.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list webhooks: %w", err)
+	}
+	defer rows.Close()
+
+	var webhooks []models.WebhookSubscription
+	for rows.Next() {
+		w, err := scanWebhook(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan webhook: %w", err)
+		}
+		webhooks = append(webhooks, *w)
+	}
+	return webhooks, rows.Err()
+}
+
+func (s *RealDBService) UpdateWebhook(ctx context.Context, w *models.WebhookSubscription) error {
+	query := `
+		UPDATE webhook_subscriptions
+		SET url = $2, secret = $3, event_types = $4, is_active = $5, updated_at = NOW()
+		WHERE id = $1`
+
+	result, err := Conn.Exec(ctx, query, w.ID, w.URL, w.Secret, w.EventTypes, w.IsActive)
+	if err != nil {
+		return fmt.Errorf("failed to update webhook: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return ErrWebhookNotFound
+	}
+	return nil
+}
+
+func (s *RealDBService) DeleteWebhook(ctx context.Context, id int64) error {
+	result, err := Conn.Exec(ctx, `DELETE FROM webhook_subscriptions WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete webhook: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return ErrWebhookNotFound
+	}
+	return nil
+}
+
+func (s *RealDBService) CreateWebhookDeliveries(ctx context.Context, event models.Event, payload []byte) (int64, error) {
+	query := `
+		INSERT INTO webhook_deliveries (subscription_id, event_id, event_type, payload, status, next_attempt_at, created_at)
+		SELECT id, $1, $2, $3, 'pending', NOW(), NOW()
+		FROM webhook_subscriptions
+		WHERE is_active AND (cardinality(event_types) = 0 OR $2 = ANY(event_types))
+		ON CONFLICT (subscription_id, event_id) WHERE redelivery_of IS NULL DO NOTHING`
+
+	result, err := Conn.Exec(ctx, query, event.ID, event.Type, payload)
+	if err != nil {
+		return 0, fmt.Errorf("failed to queue webhook deliveries: %w", err)
+	}
+	return result.RowsAffected(), nil
+}
+
+const webhookDeliveryColumns = `d.id, d.subscription_id, d.event_id, d.event_type, d.payload, d.status,
+	d.attempts, d.response_status, COALESCE(d.response_body, ''), COALESCE(d.last_error, ''),
+	d.redelivery_of, d.next_attempt_at, d.created_at, d.delivered_at`
+
+func scanWebhookDelivery(row pgx.Row, extra ...interface{}) (*models.WebhookDelivery, error) {
+	var d models.WebhookDelivery
+	dest := []interface{}{
+		&d.ID,
+		&d.SubscriptionID,
+		&d.EventID,
+		&d.EventType,
+		&d.Payload,
+		&d.Status,
+		&d.Attempts,
+		&d.ResponseStatus,
+		&d.ResponseBody,
+		&d.LastError,
+		&d.RedeliveryOf,
+		&d.NextAttemptAt,
+		&d.CreatedAt,
+		&d.DeliveredAt,
+	}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+func (s *RealDBService) ClaimWebhookDeliveries(ctx context.Context, limit int, lockFor time.Duration) ([]models.WebhookDelivery, error) {
+	query := `
+		WITH next AS (
+			SELECT wd.id
+			FROM webhook_deliveries wd
+			JOIN webhook_subscriptions ws ON ws.id = wd.subscription_id AND ws.is_active
+			WHERE wd.status = 'pending'
+				AND wd.next_attempt_at <= NOW()
+				AND (wd.locked_until IS NULL OR wd.locked_until < NOW())
+			ORDER BY wd.next_attempt_at, wd.id
+			LIMIT $1
+			FOR UPDATE OF wd SKIP LOCKED
+		)
+		UPDATE webhook_deliveries d
+		SET locked_until = NOW() + make_interval(secs => $2)
+		FROM next, webhook_subscriptions s
+		WHERE d.id = next.id AND s.id = d.subscription_id
+		RETURNING ` + webhookDeliveryColumns + `, s.url, s.secret`
+
+	rows, err := Conn.Query(ctx, query, limit, lockFor.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim webhook deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	var deliveries []models.WebhookDelivery
+	for rows.Next() {
+		var url, secret string
+		d, err := scanWebhookDelivery(rows, &url, &secret)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan webhook delivery: %w", err)
+		}
+		d.URL, d.Secret = url, secret
+		deliveries = append(deliveries, *d)
+	}
+	return deliveries, rows.Err()
+}
+
+func (s *RealDBService) RecordWebhookAttempt(ctx context.Context, id int64, succeeded bool, responseStatus *int, responseBody, lastError string, retryAt *time.Time) error {
+	query := `
+		UPDATE webhook_deliveries
+		SET attempts = attempts + 1,
+			status = CASE WHEN $2 THEN 'succeeded' WHEN $6::timestamptz IS NULL THEN 'dead' ELSE 'pending' END,
+			delivered_at = CASE WHEN $2 THEN NOW() ELSE delivered_at END,
+			next_attempt_at = COALESCE($6, next_attempt_at),
+			response_status = $3,
+			response_body = $4,
+			last_error = NULLIF($5, ''),
+			locked_until = NULL
+		WHERE id = $1`
+
+	if _, err := Conn.Exec(ctx, query, id, succeeded, responseStatus, responseBody, lastError, retryAt); err != nil {
+		return fmt.Errorf("failed to record webhook attempt: %w", err)
+	}
+	return nil
+}
+
+func (s *RealDBService) PostponeWebhookDelivery(ctx context.Context, id int64, until time.Time) error {
+	query := `UPDATE webhook_deliveries SET next_attempt_at = $2, locked_until = NULL WHERE id = $1`
+
+	if _, err := Conn.Exec(ctx, query, id, until); err != nil {
+		return fmt.Errorf("failed to postpone webhook delivery: %w", err)
+	}
+	return nil
+}
+
+func (s *RealDBService) ListWebhookDeliveries(ctx context.Context, subscriptionID int64, limit int) ([]models.WebhookDelivery, error) {
+	query := `
+		SELECT ` + webhookDeliveryColumns + `
+		FROM webhook_deliveries d
+		WHERE d.subscription_id = $1
+		ORDER BY d.id DESC
+		LIMIT $2`
+
+	rows, err := Conn.Query(ctx, query, subscriptionID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list webhook deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	var deliveries []models.WebhookDelivery
+	for rows.Next() {
+		d, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan webhook delivery: %w", err)
+		}
+		deliveries = append(deliveries, *d)
+	}
+	return deliveries, rows.Err()
+}
+
+func (s *RealDBService) RedeliverWebhook(ctx context.Context, deliveryID int64) (*models.WebhookDelivery, error) {
+	query := `
+		INSERT INTO webhook_deliveries AS d (subscription_id, event_id, event_type, payload, status, redelivery_of, next_attempt_at, created_at)
+		SELECT subscription_id, event_id, event_type, payload, 'pending', id, NOW(), NOW()
+		FROM webhook_deliveries
+		WHERE id = $1
+		RETURNING ` + webhookDeliveryColumns
+
+	d, err := scanWebhookDelivery(Conn.QueryRow(ctx, query, deliveryID))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrWebhookDeliveryNotFound
+		}
+		return nil, fmt.Errorf("failed to redeliver webhook: %w", err)
+	}
+	return d, nil
+}
@@ -0,0 +1,233 @@
+// Package events delivers domain events from the transactional outbox to
+// in-process handlers and sinks.
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/manuel/make-it-rain/config"
+	"github.com/manuel/make-it-rain/db"
+	"github.com/manuel/make-it-rain/logging"
+	"github.com/manuel/make-it-rain/metrics"
+	"github.com/manuel/make-it-rain/models"
+)
+
+// Handler reacts to one event type. Delivery is at least once, so handlers
+// must tolerate seeing the same event (same ID) more than once.
+type Handler func(ctx context.Context, event models.Event) error
+
+// Sink receives every event, typically to forward it to another system.
+type Sink interface {
+	Name() string
+	Deliver(ctx context.Context, event models.Event) error
+}
+
+// Dispatcher polls the outbox and delivers claimed events. An event counts
+// as delivered once every handler and sink has accepted it; if any fails the
+// whole event is retried with exponential backoff until MaxAttempts, after
+// which it is dead-lettered.
+type Dispatcher struct {
+	store db.OutboxStore
+	cfg   config.OutboxConfig
+
+	mu       sync.RWMutex
+	handlers map[string][]Handler
+	sinks    []Sink
+
+	wake chan struct{}
+	stop context.CancelFunc
+	done chan struct{}
+}
+
+func NewDispatcher(store db.OutboxStore, cfg config.OutboxConfig) *Dispatcher {
+	return &Dispatcher{
+		store:    store,
+		cfg:      cfg,
+		handlers: make(map[string][]Handler),
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// Subscribe registers a handler for an event type such as models.EventUserCreated.
+func (d *Dispatcher) Subscribe(eventType string, h Handler) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.handlers[eventType] = append(d.handlers[eventType], h)
+}
+
+// AddSink registers a sink that receives every event.
+func (d *Dispatcher) AddSink(s Sink) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.sinks = append(d.sinks, s)
+}
+
+// Wake triggers a poll without waiting for the interval, e.g. when an outbox
+// notification arrives.
+func (d *Dispatcher) Wake() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Start runs the dispatch loop in the background until Stop is called.
+func (d *Dispatcher) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	d.stop = cancel
+	d.done = make(chan struct{})
+
+	go func() {
+		defer close(d.done)
+		d.run(ctx)
+	}()
+}
+
+// Stop lets the current batch finish and waits for the loop to exit, or for
+// ctx to expire. Events left claimed are picked up again once their lock
+// times out.
+func (d *Dispatcher) Stop(ctx context.Context) error {
+	if d.stop == nil {
+		return nil
+	}
+	d.stop()
+	select {
+	case <-d.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (d *Dispatcher) run(ctx context.Context) {
+	ticker := time.NewTicker(d.cfg.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		// Keep draining while full batches come back.
+		for ctx.Err() == nil {
+			n, err := d.DispatchBatch(context.WithoutCancel(ctx))
+			if err != nil {
+				logging.Ctx(ctx, logging.Services).Error().Err(err).Msg("Failed to dispatch outbox events")
+				break
+			}
+			if n < d.cfg.BatchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+	}
+}
+
+// DispatchBatch claims and delivers one batch, returning how many events
+// were claimed.
+func (d *Dispatcher) DispatchBatch(ctx context.Context) (int, error) {
+	batch, err := d.store.ClaimOutboxEvents(ctx, d.cfg.BatchSize, d.cfg.LockTimeout)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, event := range batch {
+		d.process(ctx, event)
+	}
+	return len(batch), nil
+}
+
+func (d *Dispatcher) process(ctx context.Context, event models.Event) {
+	logger := logging.Ctx(ctx, logging.Services).With().
+		Int64("event_id", event.ID).
+		Str("event_type", event.Type).
+		Str("aggregate_id", event.AggregateID).
+		Int("attempt", event.Attempts).
+		Logger()
+
+	deliverCtx, cancel := context.WithTimeout(ctx, d.cfg.LockTimeout)
+	err := d.deliver(deliverCtx, event)
+	cancel()
+
+	if err == nil {
+		if err := d.store.MarkOutboxDelivered(ctx, event.ID); err != nil {
+			logger.Error().Err(err).Msg("Failed to mark event delivered")
+			return
+		}
+		metrics.OutboxEventsTotal.WithLabelValues(event.Type, "delivered").Inc()
+		return
+	}
+
+	var retryAt *time.Time
+	result := "dead"
+	if event.Attempts < d.cfg.MaxAttempts {
+		next := time.Now().Add(Backoff(event.Attempts, d.cfg.RetryBackoff, d.cfg.MaxRetryBackoff))
+		retryAt = &next
+		result = "retry"
+	}
+
+	if err := d.store.MarkOutboxFailed(ctx, event.ID, err.Error(), retryAt); err != nil {
+		logger.Error().Err(err).Msg("Failed to record event failure")
+		return
+	}
+	metrics.OutboxEventsTotal.WithLabelValues(event.Type, result).Inc()
+
+	if retryAt == nil {
+		logger.Error().Err(err).Msg("Event moved to dead letter after final attempt")
+	} else {
+		logger.Warn().Err(err).Time("retry_at", *retryAt).Msg("Event delivery failed, will retry")
+	}
+}
+
+// deliver runs every handler and sink, collecting their errors.
+func (d *Dispatcher) deliver(ctx context.Context, event models.Event) error {
+	d.mu.RLock()
+	handlers := d.handlers[event.Type]
+	sinks := d.sinks
+	d.mu.RUnlock()
+
+	var errs []error
+	for _, h := range handlers {
+		if err := safeCall(func() error { return h(ctx, event) }); err != nil {
+			errs = append(errs, fmt.Errorf("handler: %w", err))
+		}
+	}
+	for _, s := range sinks {
+		if err := safeCall(func() error { return s.Deliver(ctx, event) }); err != nil {
+			errs = append(errs, fmt.Errorf("sink %s: %w", s.Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// safeCall turns a panicking handler into a failed delivery instead of
+// taking down the dispatcher.
+func safeCall(fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return fn()
+}
+
+// Backoff returns the delay before retry number attempt: base doubled per
+// attempt, capped at max, with up to 20% jitter so retries from a burst of
+// failures spread out.
+func Backoff(attempt int, base, max time.Duration) time.Duration {
+	d := base
+	for i := 1; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d - time.Duration(rand.Int63n(int64(d)/5+1))
+}
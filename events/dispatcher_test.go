@@ -0,0 +1,133 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/manuel/make-it-rain/config"
+	"github.com/manuel/make-it-rain/models"
+)
+
+type memoryOutbox struct {
+	events []*models.Event
+}
+
+func (m *memoryOutbox) add(eventType string) *models.Event {
+	e := &models.Event{ID: int64(len(m.events) + 1), Type: eventType, Status: models.EventPending, Payload: []byte(`{}`)}
+	m.events = append(m.events, e)
+	return e
+}
+
+func (m *memoryOutbox) ClaimOutboxEvents(ctx context.Context, limit int, lockFor time.Duration) ([]models.Event, error) {
+	var batch []models.Event
+	for _, e := range m.events {
+		if e.Status == models.EventPending && len(batch) < limit {
+			e.Attempts++
+			batch = append(batch, *e)
+		}
+	}
+	return batch, nil
+}
+
+func (m *memoryOutbox) MarkOutboxDelivered(ctx context.Context, id int64) error {
+	m.events[id-1].Status = models.EventDelivered
+	return nil
+}
+
+func (m *memoryOutbox) MarkOutboxFailed(ctx context.Context, id int64, reason string, retryAt *time.Time) error {
+	e := m.events[id-1]
+	e.LastError = reason
+	if retryAt == nil {
+		e.Status = models.EventDead
+	}
+	return nil
+}
+
+func (m *memoryOutbox) ListOutboxEvents(ctx context.Context, status string, limit int) ([]models.Event, error) {
+	return nil, nil
+}
+
+func (m *memoryOutbox) RequeueOutboxEvent(ctx context.Context, id int64) error { return nil }
+
+func testOutboxConfig() config.OutboxConfig {
+	return config.OutboxConfig{
+		PollInterval:    time.Second,
+		BatchSize:       10,
+		LockTimeout:     time.Second,
+		MaxAttempts:     3,
+		RetryBackoff:    time.Second,
+		MaxRetryBackoff: time.Minute,
+	}
+}
+
+func TestDispatcherDeliversToHandlersAndSinks(t *testing.T) {
+	store := &memoryOutbox{}
+	created := store.add(models.EventUserCreated)
+	deleted := store.add(models.EventUserDeleted)
+
+	d := NewDispatcher(store, testOutboxConfig())
+	var handled, sunk []string
+	d.Subscribe(models.EventUserCreated, func(ctx context.Context, e models.Event) error {
+		handled = append(handled, e.Type)
+		return nil
+	})
+	d.AddSink(SinkFunc{SinkName: "test", Fn: func(ctx context.Context, e models.Event) error {
+		sunk = append(sunk, e.Type)
+		return nil
+	}})
+
+	if n, err := d.DispatchBatch(context.Background()); err != nil || n != 2 {
+		t.Fatalf("Expected 2 events dispatched, got %d, %v", n, err)
+	}
+	if len(handled) != 1 || handled[0] != models.EventUserCreated {
+		t.Errorf("Expected only the user.created handler to run, got %v", handled)
+	}
+	if len(sunk) != 2 {
+		t.Errorf("Expected the sink to receive every event, got %v", sunk)
+	}
+	if created.Status != models.EventDelivered || deleted.Status != models.EventDelivered {
+		t.Errorf("Expected both events delivered, got %s and %s", created.Status, deleted.Status)
+	}
+}
+
+func TestDispatcherRetriesThenDeadLetters(t *testing.T) {
+	store := &memoryOutbox{}
+	event := store.add(models.EventUserUpdated)
+
+	d := NewDispatcher(store, testOutboxConfig())
+	d.Subscribe(models.EventUserUpdated, func(ctx context.Context, e models.Event) error {
+		return errors.New("downstream unavailable")
+	})
+	d.Subscribe(models.EventUserUpdated, func(ctx context.Context, e models.Event) error {
+		panic("boom")
+	})
+
+	for i := 0; i < 2; i++ {
+		d.DispatchBatch(context.Background())
+		if event.Status != models.EventPending {
+			t.Fatalf("Expected event to stay pending after attempt %d, got %s", i+1, event.Status)
+		}
+	}
+	d.DispatchBatch(context.Background())
+
+	if event.Status != models.EventDead {
+		t.Errorf("Expected event dead-lettered after max attempts, got %s", event.Status)
+	}
+	if event.LastError == "" {
+		t.Errorf("Expected the failure reason to be recorded")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	if d := Backoff(1, time.Second, time.Minute); d < 800*time.Millisecond || d > time.Second {
+		t.Errorf("Expected first retry after about 1s, got %s", d)
+	}
+	if d := Backoff(4, time.Second, time.Minute); d < 6400*time.Millisecond || d > 8*time.Second {
+		t.Errorf("Expected fourth retry after about 8s, got %s", d)
+	}
+	if d := Backoff(50, time.Second, time.Minute); d > time.Minute {
+		t.Errorf("Expected backoff capped at 1m, got %s", d)
+	}
+}
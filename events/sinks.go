@@ -0,0 +1,37 @@
+package events
+
+import (
+	"context"
+
+	"github.com/manuel/make-it-rain/logging"
+	"github.com/manuel/make-it-rain/models"
+)
+
+// LogSink writes every event to the service log. It is useful on its own in
+// development and as an audit trail next to other sinks.
+type LogSink struct{}
+
+func (LogSink) Name() string { return "log" }
+
+func (LogSink) Deliver(ctx context.Context, event models.Event) error {
+	logging.Ctx(ctx, logging.Services).Info().
+		Int64("event_id", event.ID).
+		Str("event_type", event.Type).
+		Str("aggregate_type", event.AggregateType).
+		Str("aggregate_id", event.AggregateID).
+		RawJSON("payload", event.Payload).
+		Msg("Domain event")
+	return nil
+}
+
+// SinkFunc adapts a function to the Sink interface.
+type SinkFunc struct {
+	SinkName string
+	Fn       func(ctx context.Context, event models.Event) error
+}
+
+func (s SinkFunc) Name() string { return s.SinkName }
+
+func (s SinkFunc) Deliver(ctx context.Context, event models.Event) error {
+	return s.Fn(ctx, event)
+}
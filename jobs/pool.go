@@ -0,0 +1,312 @@
+// Package jobs runs background work persisted in the jobs table on a pool of
+// workers shared by every instance.
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/manuel/make-it-rain/config"
+	"github.com/manuel/make-it-rain/db"
+	"github.com/manuel/make-it-rain/events"
+	"github.com/manuel/make-it-rain/logging"
+	"github.com/manuel/make-it-rain/metrics"
+	"github.com/manuel/make-it-rain/models"
+)
+
+// lockGrace is added to the job timeout when claiming, so a job that is
+// still finishing up at its deadline isn't handed to another worker.
+const lockGrace = 30 * time.Second
+
+// Handler runs one job. Jobs are retried after a crash or lost lock, so
+// handlers must be safe to run more than once for the same job.
+type Handler func(ctx context.Context, job models.Job) error
+
+// Option adjusts a job before it is enqueued.
+type Option func(*models.Job)
+
+// WithPriority sets the job's priority; lower values run first.
+func WithPriority(priority int) Option {
+	return func(j *models.Job) { j.Priority = priority }
+}
+
+// RunAt schedules the job to run no earlier than t.
+func RunAt(t time.Time) Option {
+	return func(j *models.Job) { j.RunAt = t }
+}
+
+// MaxAttempts overrides the configured number of attempts for the job.
+func MaxAttempts(n int) Option {
+	return func(j *models.Job) { j.MaxAttempts = n }
+}
+
+// Enqueuer is implemented by Pool; packages that only schedule work depend
+// on it instead.
+type Enqueuer interface {
+	Enqueue(ctx context.Context, jobType string, payload interface{}, opts ...Option) (*models.Job, error)
+}
+
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent marks a handler error as not worth retrying, e.g. an invalid
+// payload. The job is moved to the dead state straight away.
+func Permanent(err error) error {
+	return permanentError{err: err}
+}
+
+// Pool claims due jobs and runs them on up to cfg.Workers goroutines. Failed
+// jobs are retried with exponential backoff until their max attempts, after
+// which they are dead-lettered.
+type Pool struct {
+	store  db.JobStore
+	cfg    config.JobsConfig
+	worker string
+
+	mu       sync.RWMutex
+	handlers map[string]Handler
+
+	slots   chan struct{}
+	running sync.WaitGroup
+	// jobCtx is the parent of every running job; abort cancels it when a
+	// drain runs out of time.
+	jobCtx context.Context
+	abort  context.CancelFunc
+
+	wake chan struct{}
+	stop context.CancelFunc
+	done chan struct{}
+}
+
+func NewPool(store db.JobStore, cfg config.JobsConfig) *Pool {
+	jobCtx, abort := context.WithCancel(context.Background())
+	return &Pool{
+		store:    store,
+		cfg:      cfg,
+		worker:   workerID(),
+		handlers: make(map[string]Handler),
+		slots:    make(chan struct{}, cfg.Workers),
+		jobCtx:   jobCtx,
+		abort:    abort,
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// workerID identifies this process in locked_by.
+func workerID() string {
+	host, _ := os.Hostname()
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), hex.EncodeToString(suffix))
+}
+
+// Register sets the handler for a job type. Jobs of a type with no handler
+// fail permanently.
+func (p *Pool) Register(jobType string, h Handler) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.handlers[jobType] = h
+}
+
+// Enqueue persists a job with payload encoded as JSON. It runs as soon as a
+// worker is free unless scheduled later with RunAt.
+func (p *Pool) Enqueue(ctx context.Context, jobType string, payload interface{}, opts ...Option) (*models.Job, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s job: %w", jobType, err)
+	}
+
+	job := &models.Job{
+		Type:        jobType,
+		Payload:     data,
+		Priority:    models.JobPriorityDefault,
+		MaxAttempts: p.cfg.MaxAttempts,
+	}
+	for _, opt := range opts {
+		opt(job)
+	}
+
+	created, err := p.store.EnqueueJob(ctx, job)
+	if err != nil {
+		return nil, err
+	}
+	p.Wake()
+	return created, nil
+}
+
+// Wake triggers a poll without waiting for the interval, e.g. when a job
+// notification arrives.
+func (p *Pool) Wake() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Start runs the claim loop in the background until Stop is called.
+func (p *Pool) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	p.stop = cancel
+	p.done = make(chan struct{})
+
+	go func() {
+		defer close(p.done)
+		p.run(ctx)
+	}()
+}
+
+// Stop stops claiming jobs and waits for running ones to finish. If ctx
+// expires first, running jobs are cancelled and Stop returns ctx's error;
+// they are retried once their lock times out.
+func (p *Pool) Stop(ctx context.Context) error {
+	if p.stop == nil {
+		return nil
+	}
+	p.stop()
+
+	drained := make(chan struct{})
+	go func() {
+		<-p.done
+		p.running.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		p.abort()
+		return ctx.Err()
+	}
+}
+
+func (p *Pool) run(ctx context.Context) {
+	ticker := time.NewTicker(p.cfg.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		// Keep claiming while there are free workers and full batches.
+		for ctx.Err() == nil {
+			free := cap(p.slots) - len(p.slots)
+			if free == 0 {
+				break
+			}
+			n, err := p.claim(context.WithoutCancel(ctx), free)
+			if err != nil {
+				logging.Ctx(ctx, logging.Services).Error().Err(err).Msg("Failed to claim jobs")
+				break
+			}
+			if n < free {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-p.wake:
+		}
+	}
+}
+
+// claim starts up to limit due jobs on free workers, returning how many
+// were claimed.
+func (p *Pool) claim(ctx context.Context, limit int) (int, error) {
+	batch, err := p.store.ClaimJobs(ctx, p.worker, limit, p.cfg.Timeout+lockGrace)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, job := range batch {
+		p.slots <- struct{}{}
+		p.running.Add(1)
+		go func(job models.Job) {
+			defer func() {
+				<-p.slots
+				p.running.Done()
+				// A worker is free again; see if more work is waiting.
+				p.Wake()
+			}()
+			p.Process(ctx, job)
+		}(job)
+	}
+	return len(batch), nil
+}
+
+// Process runs a claimed job and records the outcome.
+func (p *Pool) Process(ctx context.Context, job models.Job) {
+	logger := logging.Ctx(ctx, logging.Services).With().
+		Int64("job_id", job.ID).
+		Str("job_type", job.Type).
+		Int("attempt", job.Attempts).
+		Logger()
+
+	start := time.Now()
+	err := p.execute(job)
+	metrics.JobDuration.WithLabelValues(job.Type).Observe(time.Since(start).Seconds())
+
+	if err == nil {
+		if err := p.store.CompleteJob(ctx, job.ID, p.worker); err != nil {
+			logger.Error().Err(err).Msg("Failed to mark job succeeded")
+			return
+		}
+		metrics.JobsTotal.WithLabelValues(job.Type, "succeeded").Inc()
+		return
+	}
+
+	var retryAt *time.Time
+	result := "dead"
+	var permanent permanentError
+	if job.Attempts < job.MaxAttempts && !errors.As(err, &permanent) {
+		next := time.Now().Add(events.Backoff(job.Attempts, p.cfg.RetryBackoff, p.cfg.MaxRetryBackoff))
+		retryAt = &next
+		result = "retry"
+	}
+
+	if err := p.store.FailJob(ctx, job.ID, p.worker, err.Error(), retryAt); err != nil {
+		logger.Error().Err(err).Msg("Failed to record job failure")
+		return
+	}
+	metrics.JobsTotal.WithLabelValues(job.Type, result).Inc()
+
+	if retryAt == nil {
+		logger.Error().Err(err).Msg("Job failed permanently")
+	} else {
+		logger.Warn().Err(err).Time("retry_at", *retryAt).Msg("Job failed, will retry")
+	}
+}
+
+// execute calls the job's handler with the job timeout applied, turning a
+// panic into an error.
+func (p *Pool) execute(job models.Job) (err error) {
+	p.mu.RLock()
+	h, ok := p.handlers[job.Type]
+	p.mu.RUnlock()
+	if !ok {
+		return Permanent(fmt.Errorf("no handler registered for job type %q", job.Type))
+	}
+	// A job reclaimed after its worker died has already used its attempts.
+	if job.Attempts > job.MaxAttempts {
+		return Permanent(fmt.Errorf("exceeded %d attempts", job.MaxAttempts))
+	}
+
+	ctx, cancel := context.WithTimeout(p.jobCtx, p.cfg.Timeout)
+	defer cancel()
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return h(ctx, job)
+}
@@ -0,0 +1,286 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/manuel/make-it-rain/config"
+	"github.com/manuel/make-it-rain/db"
+	"github.com/manuel/make-it-rain/models"
+)
+
+type memoryJobs struct {
+	db.JobStore
+
+	mu   sync.Mutex
+	jobs []*models.Job
+}
+
+func (m *memoryJobs) EnqueueJob(ctx context.Context, job *models.Job) (*models.Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	j := *job
+	j.ID = int64(len(m.jobs) + 1)
+	j.Status = models.JobQueued
+	if j.RunAt.IsZero() {
+		j.RunAt = time.Now()
+	}
+	m.jobs = append(m.jobs, &j)
+	return &j, nil
+}
+
+func (m *memoryJobs) ClaimJobs(ctx context.Context, worker string, limit int, lockFor time.Duration) ([]models.Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var due []*models.Job
+	for _, j := range m.jobs {
+		if j.Status == models.JobQueued && !j.RunAt.After(time.Now()) {
+			due = append(due, j)
+		}
+	}
+	sort.SliceStable(due, func(a, b int) bool { return due[a].Priority < due[b].Priority })
+
+	var batch []models.Job
+	for _, j := range due {
+		if len(batch) == limit {
+			break
+		}
+		j.Status = models.JobRunning
+		j.Attempts++
+		j.LockedBy = worker
+		batch = append(batch, *j)
+	}
+	return batch, nil
+}
+
+func (m *memoryJobs) CompleteJob(ctx context.Context, id int64, worker string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.jobs[id-1].Status = models.JobSucceeded
+	return nil
+}
+
+func (m *memoryJobs) FailJob(ctx context.Context, id int64, worker, reason string, retryAt *time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	j := m.jobs[id-1]
+	j.LastError = reason
+	if retryAt == nil {
+		j.Status = models.JobDead
+	} else {
+		j.Status = models.JobQueued
+		j.RunAt = *retryAt
+	}
+	return nil
+}
+
+func (m *memoryJobs) get(id int64) models.Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return *m.jobs[id-1]
+}
+
+func testJobsConfig() config.JobsConfig {
+	return config.JobsConfig{
+		Workers:         2,
+		PollInterval:    10 * time.Millisecond,
+		Timeout:         time.Second,
+		MaxAttempts:     3,
+		RetryBackoff:    time.Second,
+		MaxRetryBackoff: time.Minute,
+	}
+}
+
+func TestEnqueueAppliesDefaultsAndOptions(t *testing.T) {
+	p := NewPool(&memoryJobs{}, testJobsConfig())
+
+	job, err := p.Enqueue(context.Background(), "export", map[string]int{"user_id": 7})
+	if err != nil {
+		t.Fatalf("Enqueue failed: %v", err)
+	}
+	if job.Priority != models.JobPriorityDefault || job.MaxAttempts != 3 {
+		t.Errorf("Expected default priority and max attempts, got %d and %d", job.Priority, job.MaxAttempts)
+	}
+	if string(job.Payload) != `{"user_id":7}` {
+		t.Errorf("Expected JSON payload, got %s", job.Payload)
+	}
+
+	at := time.Now().Add(time.Hour)
+	job, err = p.Enqueue(context.Background(), "export", nil, WithPriority(models.JobPriorityHigh), RunAt(at), MaxAttempts(1))
+	if err != nil {
+		t.Fatalf("Enqueue failed: %v", err)
+	}
+	if job.Priority != models.JobPriorityHigh || !job.RunAt.Equal(at) || job.MaxAttempts != 1 {
+		t.Errorf("Expected options to be applied, got %+v", job)
+	}
+}
+
+func TestProcessRetriesThenDeadLetters(t *testing.T) {
+	store := &memoryJobs{}
+	p := NewPool(store, testJobsConfig())
+	p.Register("flaky", func(ctx context.Context, job models.Job) error {
+		return errors.New("boom")
+	})
+	job, _ := p.Enqueue(context.Background(), "flaky", nil)
+
+	for attempt := 1; attempt <= 3; attempt++ {
+		j := store.get(job.ID)
+		j.Attempts = attempt
+		p.Process(context.Background(), j)
+
+		got := store.get(job.ID)
+		if attempt < 3 {
+			if got.Status != models.JobQueued || !got.RunAt.After(time.Now()) {
+				t.Errorf("Attempt %d: expected a delayed retry, got %s at %s", attempt, got.Status, got.RunAt)
+			}
+		} else if got.Status != models.JobDead {
+			t.Errorf("Expected job to be dead after the last attempt, got %s", got.Status)
+		}
+		if got.LastError != "boom" {
+			t.Errorf("Expected last error to be recorded, got %q", got.LastError)
+		}
+	}
+}
+
+func TestProcessDeadLettersWithoutRetry(t *testing.T) {
+	store := &memoryJobs{}
+	p := NewPool(store, testJobsConfig())
+	p.Register("bad-payload", func(ctx context.Context, job models.Job) error {
+		return Permanent(errors.New("invalid payload"))
+	})
+	p.Register("panics", func(ctx context.Context, job models.Job) error {
+		panic("handler bug")
+	})
+
+	permanent, _ := p.Enqueue(context.Background(), "bad-payload", nil)
+	unknown, _ := p.Enqueue(context.Background(), "unknown", nil)
+	panicking, _ := p.Enqueue(context.Background(), "panics", nil)
+
+	for _, id := range []int64{permanent.ID, unknown.ID} {
+		j := store.get(id)
+		j.Attempts = 1
+		p.Process(context.Background(), j)
+		if got := store.get(id); got.Status != models.JobDead {
+			t.Errorf("Expected %s job to be dead, got %s", got.Type, got.Status)
+		}
+	}
+
+	j := store.get(panicking.ID)
+	j.Attempts = 1
+	p.Process(context.Background(), j)
+	if got := store.get(panicking.ID); got.Status != models.JobQueued || got.LastError != "panic: handler bug" {
+		t.Errorf("Expected a panic to be retried like an error, got %s (%q)", got.Status, got.LastError)
+	}
+}
+
+func TestPoolRunsLowerPriorityValuesFirst(t *testing.T) {
+	store := &memoryJobs{}
+	cfg := testJobsConfig()
+	cfg.Workers = 1
+	p := NewPool(store, cfg)
+
+	var mu sync.Mutex
+	var order []string
+	done := make(chan struct{})
+	p.Register("record", func(ctx context.Context, job models.Job) error {
+		mu.Lock()
+		defer mu.Unlock()
+		order = append(order, string(job.Payload))
+		if len(order) == 3 {
+			close(done)
+		}
+		return nil
+	})
+
+	p.Enqueue(context.Background(), "record", "low", WithPriority(models.JobPriorityLow))
+	p.Enqueue(context.Background(), "record", "default")
+	p.Enqueue(context.Background(), "record", "critical", WithPriority(models.JobPriorityCritical))
+
+	p.Start()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for jobs to run")
+	}
+	if err := p.Stop(context.Background()); err != nil {
+		t.Errorf("Expected clean stop, got %v", err)
+	}
+
+	want := []string{`"critical"`, `"default"`, `"low"`}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("Expected order %v, got %v", want, order)
+		}
+	}
+}
+
+func TestStopWaitsForRunningJobs(t *testing.T) {
+	store := &memoryJobs{}
+	p := NewPool(store, testJobsConfig())
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	p.Register("slow", func(ctx context.Context, job models.Job) error {
+		close(started)
+		<-release
+		return nil
+	})
+	job, _ := p.Enqueue(context.Background(), "slow", nil)
+
+	p.Start()
+	<-started
+
+	stopped := make(chan error, 1)
+	go func() { stopped <- p.Stop(context.Background()) }()
+
+	select {
+	case <-stopped:
+		t.Fatal("Expected Stop to wait for the running job")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	if err := <-stopped; err != nil {
+		t.Errorf("Expected clean stop, got %v", err)
+	}
+	if got := store.get(job.ID); got.Status != models.JobSucceeded {
+		t.Errorf("Expected drained job to succeed, got %s", got.Status)
+	}
+}
+
+func TestStopCancelsJobsWhenDrainTimesOut(t *testing.T) {
+	store := &memoryJobs{}
+	p := NewPool(store, testJobsConfig())
+
+	started := make(chan struct{})
+	cancelled := make(chan struct{})
+	p.Register("stuck", func(ctx context.Context, job models.Job) error {
+		close(started)
+		<-ctx.Done()
+		close(cancelled)
+		return ctx.Err()
+	})
+	p.Enqueue(context.Background(), "stuck", nil)
+
+	p.Start()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := p.Stop(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected deadline exceeded, got %v", err)
+	}
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Error("Expected the running job's context to be cancelled")
+	}
+}
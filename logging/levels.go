@@ -0,0 +1,251 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// Components with independently adjustable log levels. Default covers log
+// lines that aren't tagged with a component.
+const (
+	Default  = "default"
+	HTTP     = "http"
+	DB       = "db"
+	Services = "services"
+)
+
+var Components = []string{Default, HTTP, DB, Services}
+
+type override struct {
+	level    zerolog.Level
+	previous zerolog.Level
+	expires  time.Time
+	timer    *time.Timer
+}
+
+// LevelController holds per-component log levels and keeps zerolog's global
+// level at the most verbose of them, so that a debug component isn't filtered
+// out globally while the others stay at info.
+type LevelController struct {
+	mu        sync.RWMutex
+	defaults  map[string]zerolog.Level
+	levels    map[string]zerolog.Level
+	overrides map[string]*override
+}
+
+// Levels is the process-wide controller used by Ctx and the admin endpoint.
+var Levels = NewLevelController(zerolog.InfoLevel, nil)
+
+// NewLevelController creates a controller where components without an
+// explicit level use defaultLevel.
+func NewLevelController(defaultLevel zerolog.Level, components map[string]zerolog.Level) *LevelController {
+	lc := &LevelController{
+		defaults:  make(map[string]zerolog.Level),
+		levels:    make(map[string]zerolog.Level),
+		overrides: make(map[string]*override),
+	}
+	for _, name := range Components {
+		level, ok := components[name]
+		if !ok {
+			level = defaultLevel
+		}
+		lc.defaults[name] = level
+		lc.levels[name] = level
+	}
+	return lc
+}
+
+// Level returns the effective level for a component. Unknown components use
+// the default level.
+func (lc *LevelController) Level(component string) zerolog.Level {
+	lc.mu.RLock()
+	defer lc.mu.RUnlock()
+
+	if level, ok := lc.levels[component]; ok {
+		return level
+	}
+	return lc.levels[Default]
+}
+
+// Set changes a component's level; an empty component changes all of them.
+// A positive ttl reverts the change once it elapses.
+func (lc *LevelController) Set(component string, level zerolog.Level, ttl time.Duration) error {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+
+	targets := []string{component}
+	if component == "" {
+		targets = Components
+	} else if _, ok := lc.levels[component]; !ok {
+		return fmt.Errorf("unknown log component %q", component)
+	}
+
+	for _, name := range targets {
+		lc.setLocked(name, level, ttl)
+	}
+	lc.applyGlobalLocked()
+	return nil
+}
+
+func (lc *LevelController) setLocked(component string, level zerolog.Level, ttl time.Duration) {
+	previous := lc.levels[component]
+	if ov, ok := lc.overrides[component]; ok {
+		// Revert to what was in place before the first temporary change,
+		// not to another temporary level.
+		ov.timer.Stop()
+		previous = ov.previous
+		delete(lc.overrides, component)
+	}
+
+	lc.levels[component] = level
+	if ttl <= 0 {
+		return
+	}
+
+	ov := &override{level: level, previous: previous, expires: time.Now().Add(ttl)}
+	ov.timer = time.AfterFunc(ttl, func() { lc.revert(component, ov) })
+	lc.overrides[component] = ov
+}
+
+func (lc *LevelController) revert(component string, ov *override) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+
+	if lc.overrides[component] != ov {
+		return
+	}
+	delete(lc.overrides, component)
+	lc.levels[component] = ov.previous
+	lc.applyGlobalLocked()
+}
+
+// SetDefaults replaces the configured levels, e.g. after a config reload.
+// Components with an active temporary override keep it and revert to the new
+// configured level when it expires.
+func (lc *LevelController) SetDefaults(defaultLevel zerolog.Level, components map[string]zerolog.Level) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+
+	for _, name := range Components {
+		level, ok := components[name]
+		if !ok {
+			level = defaultLevel
+		}
+		lc.defaults[name] = level
+
+		if ov, ok := lc.overrides[name]; ok {
+			ov.previous = level
+			continue
+		}
+		lc.levels[name] = level
+	}
+	lc.applyGlobalLocked()
+}
+
+// Reset restores the configured levels and cancels pending reverts.
+func (lc *LevelController) Reset() {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+
+	for name, ov := range lc.overrides {
+		ov.timer.Stop()
+		delete(lc.overrides, name)
+	}
+	for name, level := range lc.defaults {
+		lc.levels[name] = level
+	}
+	lc.applyGlobalLocked()
+}
+
+// Apply installs the controller's current levels as zerolog's global level.
+func (lc *LevelController) Apply() {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	lc.applyGlobalLocked()
+}
+
+func (lc *LevelController) applyGlobalLocked() {
+	min := zerolog.Disabled
+	for _, level := range lc.levels {
+		if level < min {
+			min = level
+		}
+	}
+	zerolog.SetGlobalLevel(min)
+}
+
+// ComponentStatus describes a component's level for the admin endpoint.
+type ComponentStatus struct {
+	Component string     `json:"component"`
+	Level     string     `json:"level"`
+	Default   string     `json:"default"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+func (lc *LevelController) Status() []ComponentStatus {
+	lc.mu.RLock()
+	defer lc.mu.RUnlock()
+
+	status := make([]ComponentStatus, 0, len(lc.levels))
+	for name, level := range lc.levels {
+		s := ComponentStatus{
+			Component: name,
+			Level:     level.String(),
+			Default:   lc.defaults[name].String(),
+		}
+		if ov, ok := lc.overrides[name]; ok {
+			expires := ov.expires
+			s.ExpiresAt = &expires
+		}
+		status = append(status, s)
+	}
+	sort.Slice(status, func(i, j int) bool { return status[i].Component < status[j].Component })
+	return status
+}
+
+// Ctx returns the request-scoped logger from ctx tagged with the component
+// and filtered at that component's level.
+func Ctx(ctx context.Context, component string) *zerolog.Logger {
+	logger := zerolog.Ctx(ctx).Level(Levels.Level(component)).With().Str("component", component).Logger()
+	return &logger
+}
+
+// ParseLevel parses a level name, accepting "warning" as an alias for "warn".
+func ParseLevel(s string) (zerolog.Level, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "warning" {
+		s = "warn"
+	}
+	level, err := zerolog.ParseLevel(s)
+	if err != nil || s == "" {
+		return zerolog.NoLevel, fmt.Errorf("invalid log level %q", s)
+	}
+	return level, nil
+}
+
+// ParseComponentLevels parses "db=debug,http=warn" style specs.
+func ParseComponentLevels(spec string) (map[string]zerolog.Level, error) {
+	levels := make(map[string]zerolog.Level)
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid component level %q, expected component=level", pair)
+		}
+		level, err := ParseLevel(value)
+		if err != nil {
+			return nil, err
+		}
+		levels[strings.TrimSpace(name)] = level
+	}
+	return levels, nil
+}
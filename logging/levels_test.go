@@ -0,0 +1,64 @@
+package logging
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func TestLevelControllerComponentLevels(t *testing.T) {
+	lc := NewLevelController(zerolog.InfoLevel, map[string]zerolog.Level{DB: zerolog.DebugLevel})
+	lc.Apply()
+	defer zerolog.SetGlobalLevel(zerolog.TraceLevel)
+
+	if lc.Level(DB) != zerolog.DebugLevel {
+		t.Errorf("Expected db at debug, got %s", lc.Level(DB))
+	}
+	if lc.Level(HTTP) != zerolog.InfoLevel {
+		t.Errorf("Expected http at info, got %s", lc.Level(HTTP))
+	}
+	if zerolog.GlobalLevel() != zerolog.DebugLevel {
+		t.Errorf("Expected global level to follow the most verbose component, got %s", zerolog.GlobalLevel())
+	}
+
+	if err := lc.Set("nope", zerolog.DebugLevel, 0); err == nil {
+		t.Error("Expected error for unknown component")
+	}
+}
+
+func TestLevelControllerTemporaryOverride(t *testing.T) {
+	lc := NewLevelController(zerolog.InfoLevel, nil)
+	defer zerolog.SetGlobalLevel(zerolog.TraceLevel)
+
+	if err := lc.Set(HTTP, zerolog.DebugLevel, 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	// A second temporary change must still revert to the original level
+	if err := lc.Set(HTTP, zerolog.TraceLevel, 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if lc.Level(HTTP) != zerolog.TraceLevel {
+		t.Errorf("Expected http at trace, got %s", lc.Level(HTTP))
+	}
+
+	time.Sleep(150 * time.Millisecond)
+
+	if lc.Level(HTTP) != zerolog.InfoLevel {
+		t.Errorf("Expected http to revert to info, got %s", lc.Level(HTTP))
+	}
+}
+
+func TestParseComponentLevels(t *testing.T) {
+	levels, err := ParseComponentLevels("db=debug, http=warning")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if levels[DB] != zerolog.DebugLevel || levels[HTTP] != zerolog.WarnLevel {
+		t.Errorf("Unexpected levels: %v", levels)
+	}
+
+	if _, err := ParseComponentLevels("db"); err == nil {
+		t.Error("Expected error for missing level")
+	}
+}
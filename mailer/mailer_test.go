@@ -0,0 +1,360 @@
+package mailer
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/manuel/make-it-rain/config"
+	"github.com/manuel/make-it-rain/jobs"
+	"github.com/manuel/make-it-rain/models"
+)
+
+func loadTestTemplates(t *testing.T) *Templates {
+	t.Helper()
+	templates, err := LoadTemplates("Make It Rain", "en")
+	if err != nil {
+		t.Fatalf("LoadTemplates failed: %v", err)
+	}
+	return templates
+}
+
+func TestRenderUsesLocaleWithFallback(t *testing.T) {
+	templates := loadTestTemplates(t)
+	data := map[string]string{"SentAt": "now"}
+
+	tests := []struct {
+		locale      string
+		wantLocale  string
+		wantSubject string
+	}{
+		{"en", "en", "Make It Rain test email"},
+		{"es", "es", "Correo de prueba de Make It Rain"},
+		{"es_MX", "es", "Correo de prueba de Make It Rain"},
+		{"fr", "en", "Make It Rain test email"},
+		{"", "en", "Make It Rain test email"},
+	}
+	for _, tt := range tests {
+		msg, used, err := templates.Render("test", tt.locale, data)
+		if err != nil {
+			t.Errorf("Render(%q) failed: %v", tt.locale, err)
+			continue
+		}
+		if used != tt.wantLocale || msg.Subject != tt.wantSubject {
+			t.Errorf("Render(%q): expected %s %q, got %s %q", tt.locale, tt.wantLocale, tt.wantSubject, used, msg.Subject)
+		}
+		if !strings.Contains(msg.Text, "now") || !strings.Contains(msg.HTML, "<html lang=\""+tt.wantLocale+"\">") {
+			t.Errorf("Render(%q): expected data in the text and the layout around the HTML", tt.locale)
+		}
+	}
+
+	if _, _, err := templates.Render("missing", "en", data); err == nil {
+		t.Error("Expected an unknown template to fail")
+	}
+	if _, _, err := templates.Render("test", "en", map[string]string{}); err == nil {
+		t.Error("Expected missing template data to fail")
+	}
+}
+
+func TestAccountTemplatesRender(t *testing.T) {
+	templates := loadTestTemplates(t)
+
+	for _, name := range []string{"verify_email", "reset_password"} {
+		for _, locale := range []string{"en", "es"} {
+			for hours, want := range map[int]map[string]string{
+				1:  {"en": "1 hour ", "es": "1 hora "},
+				24: {"en": "24 hours", "es": "24 horas"},
+			} {
+				data := map[string]interface{}{
+					"Name":  "Ana",
+					"Email": "ana@example.com",
+					"URL":   "https://app.example.com/x?token=abc&y=1",
+					"Hours": hours,
+				}
+				msg, used, err := templates.Render(name, locale, data)
+				if err != nil {
+					t.Errorf("%s/%s: render failed: %v", name, locale, err)
+					continue
+				}
+				if used != locale || !strings.Contains(msg.Text, data["URL"].(string)) || !strings.Contains(msg.HTML, "token=abc&amp;y=1") {
+					t.Errorf("%s/%s: expected the link in both parts", name, locale)
+				}
+				if !strings.Contains(msg.Text, want[locale]) {
+					t.Errorf("%s/%s: expected %q in %q", name, locale, want[locale], msg.Text)
+				}
+			}
+		}
+	}
+}
+
+func TestRenderEscapesHTML(t *testing.T) {
+	templates := loadTestTemplates(t)
+
+	msg, _, err := templates.Render("test", "en", map[string]string{"SentAt": "<script>"})
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+	if strings.Contains(msg.HTML, "<script>") || !strings.Contains(msg.Text, "<script>") {
+		t.Error("Expected data to be escaped in HTML only")
+	}
+}
+
+// readParts parses a built message and returns its headers and its parts
+// keyed by media type.
+func readParts(t *testing.T, raw []byte) (mail.Header, map[string]string) {
+	t.Helper()
+	parsed, err := mail.ReadMessage(strings.NewReader(string(raw)))
+	if err != nil {
+		t.Fatalf("Built message doesn't parse: %v", err)
+	}
+	mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Expected multipart/alternative, got %q", parsed.Header.Get("Content-Type"))
+	}
+
+	parts := make(map[string]string)
+	r := multipart.NewReader(parsed.Body, params["boundary"])
+	for {
+		p, err := r.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Failed to read part: %v", err)
+		}
+		body, _ := io.ReadAll(p)
+		partType, _, _ := mime.ParseMediaType(p.Header.Get("Content-Type"))
+		parts[partType] = string(body)
+	}
+	return parsed.Header, parts
+}
+
+func TestBuildEncodesHeadersAndParts(t *testing.T) {
+	msg := &Message{
+		From:    "Make It Rain <no-reply@example.com>",
+		To:      []string{"ana@example.com"},
+		Subject: "Olá\r\nBcc: victim@example.com",
+		Text:    "plain body",
+		HTML:    "<p>html body</p>",
+	}
+	raw, err := Build(msg, time.Date(2024, time.March, 1, 10, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+
+	header, parts := readParts(t, raw)
+	if header.Get("Bcc") != "" {
+		t.Error("Expected a newline in the subject not to inject headers")
+	}
+	subject, _ := new(mime.WordDecoder).DecodeHeader(header.Get("Subject"))
+	if subject != "Olá\r\nBcc: victim@example.com" {
+		t.Errorf("Expected subject to round-trip, got %q", subject)
+	}
+	if header.Get("Message-ID") == "" || !strings.HasSuffix(msg.MessageID, "@example.com>") {
+		t.Errorf("Expected a Message-ID on the sender's domain, got %q", msg.MessageID)
+	}
+	if parts["text/plain"] != "plain body" || parts["text/html"] != "<p>html body</p>" {
+		t.Errorf("Expected text and HTML parts, got %v", parts)
+	}
+
+	msg.To = []string{"not an address"}
+	if _, err := Build(msg, time.Now()); err == nil {
+		t.Error("Expected an invalid recipient to fail")
+	}
+}
+
+type memoryQueue struct {
+	jobs []models.Job
+}
+
+func (q *memoryQueue) Enqueue(ctx context.Context, jobType string, payload interface{}, opts ...jobs.Option) (*models.Job, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	job := models.Job{ID: int64(len(q.jobs) + 1), Type: jobType, Payload: data, Attempts: 1}
+	for _, opt := range opts {
+		opt(&job)
+	}
+	q.jobs = append(q.jobs, job)
+	return &job, nil
+}
+
+type memoryMailLog struct {
+	mu      sync.Mutex
+	entries []models.SentEmail
+}
+
+func (l *memoryMailLog) RecordSentEmail(ctx context.Context, e *models.SentEmail) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = append(l.entries, *e)
+	return nil
+}
+
+func (l *memoryMailLog) ListSentEmails(ctx context.Context, recipient string, limit int) ([]models.SentEmail, error) {
+	return nil, nil
+}
+
+func TestSenderQueuesAndDeliversThroughJobs(t *testing.T) {
+	queue := &memoryQueue{}
+	log := &memoryMailLog{}
+	mailer := NewMemoryMailer()
+	sender := NewSender(mailer, loadTestTemplates(t), queue, log, "Make It Rain <no-reply@example.com>")
+
+	if err := sender.Send(context.Background(), "ana@example.com", "test", "es", map[string]string{"SentAt": "hoy"}); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if len(mailer.Messages()) != 0 {
+		t.Fatal("Expected Send to queue the message, not deliver it")
+	}
+	if len(queue.jobs) != 1 || queue.jobs[0].Type != JobType || queue.jobs[0].Priority != models.JobPriorityHigh {
+		t.Fatalf("Expected one high-priority %s job, got %+v", JobType, queue.jobs)
+	}
+
+	mailer.Err = io.ErrUnexpectedEOF
+	if err := sender.HandleJob(context.Background(), queue.jobs[0]); err == nil {
+		t.Fatal("Expected a failed delivery to return an error so the job is retried")
+	}
+	mailer.Err = nil
+	if err := sender.HandleJob(context.Background(), queue.jobs[0]); err != nil {
+		t.Fatalf("HandleJob failed: %v", err)
+	}
+
+	msg, ok := mailer.Last("ana@example.com")
+	if !ok || msg.Subject != "Correo de prueba de Make It Rain" || !strings.Contains(msg.Text, "hoy") {
+		t.Errorf("Expected the rendered Spanish message to be delivered, got %+v", msg)
+	}
+
+	if len(log.entries) != 2 {
+		t.Fatalf("Expected both attempts to be logged, got %d", len(log.entries))
+	}
+	failed, sent := log.entries[0], log.entries[1]
+	if failed.Status != models.EmailFailed || failed.Error == "" || sent.Status != models.EmailSent {
+		t.Errorf("Expected a failed then a sent entry, got %+v and %+v", failed, sent)
+	}
+	if failed.MessageID == "" || failed.MessageID != sent.MessageID {
+		t.Errorf("Expected retries to keep the same Message-ID, got %q and %q", failed.MessageID, sent.MessageID)
+	}
+	if sent.Template != "test" || sent.Locale != "es" || *sent.JobID != 1 {
+		t.Errorf("Expected template, locale and job to be logged, got %+v", sent)
+	}
+
+	if err := sender.Send(context.Background(), "not an address", "test", "en", map[string]string{"SentAt": "now"}); err == nil {
+		t.Error("Expected an invalid recipient to be rejected before queueing")
+	}
+}
+
+func TestFileMailerWritesEML(t *testing.T) {
+	dir := t.TempDir()
+	m, err := NewFileMailer(filepath.Join(dir, "mail"))
+	if err != nil {
+		t.Fatalf("NewFileMailer failed: %v", err)
+	}
+
+	msg := Message{From: "no-reply@example.com", To: []string{"ana@example.com"}, Subject: "Hi", Text: "text", HTML: "<p>html</p>"}
+	if err := m.Send(context.Background(), msg); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "mail", "*.eml"))
+	if len(files) != 1 {
+		t.Fatalf("Expected one .eml file, got %v", files)
+	}
+	raw, _ := os.ReadFile(files[0])
+	if _, parts := readParts(t, raw); parts["text/plain"] != "text" {
+		t.Errorf("Expected the file to hold the message, got %v", parts)
+	}
+}
+
+// fakeSMTPServer accepts one plain-text SMTP session and records the
+// envelope and data.
+type fakeSMTPServer struct {
+	addr string
+	done chan struct{}
+	from string
+	to   []string
+	data string
+}
+
+func startFakeSMTPServer(t *testing.T) *fakeSMTPServer {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	s := &fakeSMTPServer{addr: ln.Addr().String(), done: make(chan struct{})}
+
+	go func() {
+		defer close(s.done)
+		defer ln.Close()
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		tp := textproto.NewConn(conn)
+		tp.PrintfLine("220 fake ESMTP")
+		for {
+			line, err := tp.ReadLine()
+			if err != nil {
+				return
+			}
+			cmd := strings.ToUpper(line)
+			switch {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				tp.PrintfLine("250 fake")
+			case strings.HasPrefix(cmd, "MAIL FROM:"):
+				s.from = strings.Trim(line[len("MAIL FROM:"):], "<>")
+				tp.PrintfLine("250 OK")
+			case strings.HasPrefix(cmd, "RCPT TO:"):
+				s.to = append(s.to, strings.Trim(line[len("RCPT TO:"):], "<>"))
+				tp.PrintfLine("250 OK")
+			case cmd == "DATA":
+				tp.PrintfLine("354 go ahead")
+				data, _ := io.ReadAll(tp.DotReader())
+				s.data = string(data)
+				tp.PrintfLine("250 queued")
+			case cmd == "QUIT":
+				tp.PrintfLine("221 bye")
+				return
+			default:
+				tp.PrintfLine("502 not implemented")
+			}
+		}
+	}()
+	return s
+}
+
+func TestSMTPMailerSends(t *testing.T) {
+	server := startFakeSMTPServer(t)
+	host, port, _ := net.SplitHostPort(server.addr)
+	portNum, _ := strconv.Atoi(port)
+
+	m := NewSMTPMailer(config.SMTPConfig{Host: host, Port: portNum, TLS: "none", Timeout: 5 * time.Second})
+	msg := Message{From: "Make It Rain <no-reply@example.com>", To: []string{"ana@example.com"}, Subject: "Hi", Text: "text", HTML: "<p>html</p>"}
+	if err := m.Send(context.Background(), msg); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	<-server.done
+
+	if server.from != "no-reply@example.com" || len(server.to) != 1 || server.to[0] != "ana@example.com" {
+		t.Errorf("Expected the envelope to use bare addresses, got %q -> %v", server.from, server.to)
+	}
+	if _, parts := readParts(t, []byte(server.data)); parts["text/html"] != "<p>html</p>" {
+		t.Errorf("Expected the message to be delivered intact, got %v", parts)
+	}
+}
@@ -0,0 +1,114 @@
+package mailer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/manuel/make-it-rain/config"
+	"github.com/manuel/make-it-rain/db"
+	"github.com/manuel/make-it-rain/jobs"
+	"github.com/manuel/make-it-rain/logging"
+	"github.com/manuel/make-it-rain/models"
+)
+
+// JobType is the job that delivers a queued message.
+const JobType = "mail.send"
+
+// sendJob is the job payload: the message is rendered when it is queued so
+// template errors surface to the caller. It holds the links in the message,
+// so the job store clears it once the message is delivered.
+type sendJob struct {
+	Message  Message `json:"message"`
+	Template string  `json:"template"`
+	Locale   string  `json:"locale"`
+}
+
+// Sender renders templates and queues the result for delivery. Each attempt
+// is written to the mail log.
+type Sender struct {
+	mailer    Mailer
+	templates *Templates
+	queue     jobs.Enqueuer
+	log       db.MailLogStore
+	from      string
+}
+
+func NewSender(m Mailer, templates *Templates, queue jobs.Enqueuer, log db.MailLogStore, from string) *Sender {
+	return &Sender{
+		mailer:    m,
+		templates: templates,
+		queue:     queue,
+		log:       log,
+		from:      from,
+	}
+}
+
+// New returns the Mailer selected by cfg.Driver.
+func New(cfg config.MailConfig) (Mailer, error) {
+	switch cfg.Driver {
+	case "smtp":
+		return NewSMTPMailer(cfg.SMTP), nil
+	case "file":
+		return NewFileMailer(cfg.FileDir)
+	case "memory":
+		return NewMemoryMailer(), nil
+	default:
+		return nil, fmt.Errorf("unknown mail driver %q", cfg.Driver)
+	}
+}
+
+// Send renders template in the recipient's locale and queues it for
+// delivery to `to`.
+func (s *Sender) Send(ctx context.Context, to, template, locale string, data interface{}) error {
+	msg, used, err := s.templates.Render(template, locale, data)
+	if err != nil {
+		return err
+	}
+	msg.From = s.from
+	msg.To = []string{to}
+	// Assigned now so every retry carries the same Message-ID, letting
+	// receivers drop a duplicate of a delivered but unacknowledged message.
+	msg.MessageID = newMessageID(s.from)
+	if _, err := Build(msg, time.Now()); err != nil {
+		return err
+	}
+
+	payload := sendJob{Message: *msg, Template: template, Locale: used}
+	if _, err := s.queue.Enqueue(ctx, JobType, payload, jobs.WithPriority(models.JobPriorityHigh)); err != nil {
+		return fmt.Errorf("failed to queue %s email: %w", template, err)
+	}
+	return nil
+}
+
+// HandleJob delivers a queued message. Register it on the job pool for
+// JobType.
+func (s *Sender) HandleJob(ctx context.Context, job models.Job) error {
+	var p sendJob
+	if err := json.Unmarshal(job.Payload, &p); err != nil {
+		return jobs.Permanent(fmt.Errorf("invalid mail job payload: %w", err))
+	}
+
+	msg := p.Message
+	sendErr := s.mailer.Send(ctx, msg)
+
+	entry := &models.SentEmail{
+		JobID:      &job.ID,
+		Recipients: msg.To,
+		Subject:    msg.Subject,
+		Template:   p.Template,
+		Locale:     p.Locale,
+		MessageID:  msg.MessageID,
+		Status:     models.EmailSent,
+		Attempt:    job.Attempts,
+	}
+	if sendErr != nil {
+		entry.Status = models.EmailFailed
+		entry.Error = sendErr.Error()
+	}
+	if err := s.log.RecordSentEmail(context.WithoutCancel(ctx), entry); err != nil {
+		logging.Ctx(ctx, logging.Services).Error().Err(err).Int64("job_id", job.ID).Msg("Failed to record sent email")
+	}
+	return sendErr
+}
@@ -0,0 +1,181 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"path"
+	"strings"
+	texttemplate "text/template"
+)
+
+//go:embed templates
+var templateFS embed.FS
+
+// Each message is made of three files under templates/<locale>/:
+// <name>.subject.tmpl, <name>.txt.tmpl and <name>.html.tmpl. The HTML part
+// defines "content", which is rendered inside templates/layout.html.tmpl.
+const (
+	subjectSuffix = ".subject.tmpl"
+	textSuffix    = ".txt.tmpl"
+	htmlSuffix    = ".html.tmpl"
+	layoutFile    = "templates/layout.html.tmpl"
+)
+
+type messageTemplate struct {
+	subject *texttemplate.Template
+	text    *texttemplate.Template
+	html    *htmltemplate.Template
+}
+
+// Templates renders the embedded message templates.
+type Templates struct {
+	appName       string
+	defaultLocale string
+	byLocale      map[string]map[string]*messageTemplate
+}
+
+// templateData is what every template sees: .AppName, .Locale, .Subject
+// (in the layout) and the caller's .Data.
+type templateData struct {
+	AppName string
+	Locale  string
+	Subject string
+	Data    interface{}
+}
+
+// LoadTemplates parses every embedded template, failing on the first that
+// doesn't parse or is missing one of its three parts.
+func LoadTemplates(appName, defaultLocale string) (*Templates, error) {
+	t := &Templates{
+		appName:       appName,
+		defaultLocale: normalizeLocale(defaultLocale),
+		byLocale:      make(map[string]map[string]*messageTemplate),
+	}
+
+	layout, err := fs.ReadFile(templateFS, layoutFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read email layout: %w", err)
+	}
+
+	locales, err := fs.ReadDir(templateFS, "templates")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read email templates: %w", err)
+	}
+	for _, dir := range locales {
+		if !dir.IsDir() {
+			continue
+		}
+		locale := normalizeLocale(dir.Name())
+		files, err := fs.ReadDir(templateFS, path.Join("templates", dir.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s email templates: %w", locale, err)
+		}
+
+		t.byLocale[locale] = make(map[string]*messageTemplate)
+		for _, f := range files {
+			name, ok := strings.CutSuffix(f.Name(), subjectSuffix)
+			if !ok {
+				continue
+			}
+			mt, err := parseMessageTemplate(path.Join("templates", dir.Name()), name, layout)
+			if err != nil {
+				return nil, fmt.Errorf("email template %s/%s: %w", locale, name, err)
+			}
+			t.byLocale[locale][name] = mt
+		}
+	}
+
+	if _, ok := t.byLocale[t.defaultLocale]; !ok {
+		return nil, fmt.Errorf("no email templates for default locale %q", defaultLocale)
+	}
+	return t, nil
+}
+
+func parseMessageTemplate(dir, name string, layout []byte) (*messageTemplate, error) {
+	read := func(suffix string) (string, error) {
+		b, err := fs.ReadFile(templateFS, path.Join(dir, name+suffix))
+		return string(b), err
+	}
+
+	subject, err := read(subjectSuffix)
+	if err != nil {
+		return nil, err
+	}
+	text, err := read(textSuffix)
+	if err != nil {
+		return nil, err
+	}
+	html, err := read(htmlSuffix)
+	if err != nil {
+		return nil, err
+	}
+
+	var mt messageTemplate
+	if mt.subject, err = texttemplate.New("subject").Option("missingkey=error").Parse(subject); err != nil {
+		return nil, err
+	}
+	if mt.text, err = texttemplate.New("text").Option("missingkey=error").Parse(text); err != nil {
+		return nil, err
+	}
+	if mt.html, err = htmltemplate.New("layout").Option("missingkey=error").Parse(string(layout)); err != nil {
+		return nil, err
+	}
+	if _, err = mt.html.Parse(html); err != nil {
+		return nil, err
+	}
+	return &mt, nil
+}
+
+// Render builds the subject and bodies of template name for locale. A
+// regional locale such as "es-MX" falls back to "es", then to the default
+// locale.
+func (t *Templates) Render(name, locale string, data interface{}) (*Message, string, error) {
+	mt, used := t.lookup(name, locale)
+	if mt == nil {
+		return nil, "", fmt.Errorf("unknown email template %q", name)
+	}
+
+	view := templateData{AppName: t.appName, Locale: used, Data: data}
+
+	var subject, text, html bytes.Buffer
+	if err := mt.subject.Execute(&subject, view); err != nil {
+		return nil, "", fmt.Errorf("failed to render %s subject: %w", name, err)
+	}
+	view.Subject = strings.TrimSpace(subject.String())
+	if err := mt.text.Execute(&text, view); err != nil {
+		return nil, "", fmt.Errorf("failed to render %s text: %w", name, err)
+	}
+	if err := mt.html.Execute(&html, view); err != nil {
+		return nil, "", fmt.Errorf("failed to render %s html: %w", name, err)
+	}
+
+	return &Message{
+		Subject: view.Subject,
+		Text:    text.String(),
+		HTML:    html.String(),
+	}, used, nil
+}
+
+func (t *Templates) lookup(name, locale string) (*messageTemplate, string) {
+	locale = normalizeLocale(locale)
+	candidates := []string{locale}
+	if lang, _, ok := strings.Cut(locale, "-"); ok {
+		candidates = append(candidates, lang)
+	}
+	candidates = append(candidates, t.defaultLocale)
+
+	for _, l := range candidates {
+		if mt, ok := t.byLocale[l][name]; ok {
+			return mt, l
+		}
+	}
+	return nil, ""
+}
+
+// normalizeLocale turns "pt_BR" and "PT-br" into "pt-br".
+func normalizeLocale(locale string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(locale), "_", "-"))
+}
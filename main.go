@@ -2,6 +2,9 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"os"
@@ -11,9 +14,21 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
+	"github.com/manuel/make-it-rain/auth"
 	"github.com/manuel/make-it-rain/config"
+	"github.com/manuel/make-it-rain/controllers"
 	"github.com/manuel/make-it-rain/db"
+	"github.com/manuel/make-it-rain/events"
+	"github.com/manuel/make-it-rain/jobs"
+	"github.com/manuel/make-it-rain/logging"
+	"github.com/manuel/make-it-rain/mailer"
+	"github.com/manuel/make-it-rain/metrics"
+	"github.com/manuel/make-it-rain/pubsub"
 	"github.com/manuel/make-it-rain/routes"
+	"github.com/manuel/make-it-rain/scheduler"
+	"github.com/manuel/make-it-rain/secrets"
+	"github.com/manuel/make-it-rain/tracing"
+	"github.com/manuel/make-it-rain/webhooks"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
@@ -24,11 +39,36 @@ func main() {
 		log.Warn().Err(err).Msg("No .env file found, using environment variables")
 	}
 
+	if len(os.Args) > 1 && os.Args[1] == "secrets" {
+		os.Exit(runSecretsCommand(os.Args[2:]))
+	}
+
 	if err := config.LoadConfig("."); err != nil {
 		log.Fatal().Err(err).Msg("Failed to load configuration")
 	}
 
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		os.Exit(runConfigCommand(os.Args[2:]))
+	}
+
+	if err := config.Cfg.Validate(); err != nil {
+		log.Fatal().Msg(err.Error())
+	}
+
 	setupLogger()
+	go watchLogSignals()
+
+	config.OnChange(func(old, new *config.Config) {
+		if old.App.LogLevel != new.App.LogLevel || old.Log.ComponentLevels != new.Log.ComponentLevels {
+			applyLogLevels(new)
+		}
+	})
+
+	watchCtx, stopWatching := context.WithCancel(context.Background())
+	defer stopWatching()
+	if err := config.Watch(watchCtx, "."); err != nil {
+		log.Warn().Err(err).Msg("Config hot reload disabled")
+	}
 
 	log.Info().
 		Str("app", config.Cfg.App.Name).
@@ -36,24 +76,89 @@ func main() {
 		Str("env", config.Cfg.Server.Environment).
 		Msg("Starting application")
 
+	shutdownTracing, err := tracing.Init(context.Background(), config.Cfg.Tracing, config.Cfg.App.Name, config.Cfg.App.Version)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to initialize tracing")
+	}
+
 	connStr := config.Cfg.Database.GetConnectionString()
 	if err := db.InitDB(connStr); err != nil {
 		log.Fatal().Err(err).Msg("Failed to initialize database")
 	}
 	defer db.CloseDB()
 
+	metrics.Registry.MustRegister(metrics.NewPoolCollector(db.Conn.Stat))
+
 	if config.Cfg.Server.Environment != "test" {
 		if err := db.RunMigrations(connStr); err != nil {
 			log.Fatal().Err(err).Msg("Failed to run migrations")
 		}
 	}
 
+	listenCtx, stopListening := context.WithCancel(context.Background())
+	defer stopListening()
+	listener := pubsub.NewListener(pubsub.PoolConnector(db.Conn))
+
+	dbService := db.NewDBService()
+	if userCache := config.Cfg.Cache.Users; userCache.Enabled {
+		cached := db.NewCachedDBService(dbService, userCache.Capacity, userCache.TTL)
+		// Other instances publish their writes; anything missed while the
+		// listener was disconnected is dropped wholesale on reconnect.
+		listener.Subscribe(db.UserChangesChannel, cached.HandleUserChange)
+		listener.OnConnect(func(context.Context) { cached.InvalidateAll() })
+		dbService = cached
+	}
+
+	dispatcher := events.NewDispatcher(db.NewOutboxStore(), config.Cfg.Outbox)
+	dispatcher.AddSink(events.LogSink{})
+
+	deliverer := webhooks.NewDeliverer(db.NewWebhookStore(), config.Cfg.Webhooks)
+	dispatcher.AddSink(webhooks.NewSink(db.NewWebhookStore(), deliverer.Wake))
+	deliverer.Start()
+	listener.Subscribe(db.OutboxChannel, func(context.Context, pubsub.Message) { dispatcher.Wake() })
+	dispatcher.Start()
+
+	jobPool := jobs.NewPool(db.NewJobStore(), config.Cfg.Jobs)
+
+	mailSender, err := newMailSender(jobPool)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to set up mail")
+	}
+	jobPool.Register(mailer.JobType, mailSender.HandleJob)
+
+	limiter := auth.NewLoginLimiter(db.NewLoginAttemptStore(), dbService, config.Cfg.Auth.Lockout)
+	listener.Subscribe(db.LoginUnlocksChannel, limiter.HandleUnlock)
+	box, err := auth.NewSecretBox(config.Cfg.Auth.MFA, config.Cfg.JWT)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid MFA encryption key")
+	}
+	controllers.InitServices(dbService, mailSender, limiter, box)
+	sessions := auth.NewSessions(db.NewAuthStore(), config.Cfg.Auth.SessionCacheTTL)
+	listener.Subscribe(db.SessionsChannel, sessions.HandleRevocation)
+	listener.OnConnect(func(context.Context) { sessions.InvalidateAll() })
+	apiKeys := auth.NewAPIKeys(db.NewAPIKeyStore(), config.Cfg.Auth.APIKeys)
+	listener.Subscribe(db.APIKeysChannel, apiKeys.HandleChange)
+	listener.OnConnect(func(context.Context) { apiKeys.InvalidateAll() })
+
+	listener.Subscribe(db.JobsChannel, func(context.Context, pubsub.Message) { jobPool.Wake() })
+	jobPool.Start()
+
+	sched := scheduler.New(db.NewAdvisoryLock(config.Cfg.Scheduler.LockKey), db.NewScheduleStore(), config.Cfg.Scheduler)
+	if config.Cfg.Scheduler.Enabled {
+		if err := registerMaintenanceTasks(sched); err != nil {
+			log.Fatal().Err(err).Msg("Invalid scheduled task")
+		}
+		sched.Start()
+	}
+
+	go listener.Run(listenCtx)
+
 	if config.Cfg.Server.Environment == "production" {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
 	router := gin.New()
-	routes.SetupRoutes(router)
+	routes.SetupRoutes(router, sessions, apiKeys)
 
 	srv := &http.Server{
 		Addr:         fmt.Sprintf(":%s", config.Cfg.Server.Port),
@@ -62,6 +167,16 @@ func main() {
 		WriteTimeout: config.Cfg.Server.WriteTimeout,
 	}
 
+	adminRouter := gin.New()
+	routes.SetupAdminRoutes(adminRouter)
+
+	adminSrv := &http.Server{
+		Addr:         fmt.Sprintf(":%s", config.Cfg.Admin.Port),
+		Handler:      adminRouter,
+		ReadTimeout:  config.Cfg.Server.ReadTimeout,
+		WriteTimeout: config.Cfg.Server.WriteTimeout,
+	}
+
 	go func() {
 		log.Info().Str("port", config.Cfg.Server.Port).Msg("Server starting")
 		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
@@ -69,6 +184,13 @@ func main() {
 		}
 	}()
 
+	go func() {
+		log.Info().Str("port", config.Cfg.Admin.Port).Msg("Admin server starting")
+		if err := adminSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal().Err(err).Msg("Failed to start admin server")
+		}
+	}()
+
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
@@ -82,26 +204,221 @@ func main() {
 		log.Fatal().Err(err).Msg("Server forced to shutdown")
 	}
 
+	if err := adminSrv.Shutdown(ctx); err != nil {
+		log.Error().Err(err).Msg("Admin server forced to shutdown")
+	}
+
+	if err := dispatcher.Stop(ctx); err != nil {
+		log.Error().Err(err).Msg("Outbox dispatcher did not stop in time")
+	}
+
+	if err := deliverer.Stop(ctx); err != nil {
+		log.Error().Err(err).Msg("Webhook deliverer did not stop in time")
+	}
+
+	if err := jobPool.Stop(ctx); err != nil {
+		log.Error().Err(err).Msg("Job workers did not drain in time")
+	}
+
+	if err := sched.Stop(ctx); err != nil {
+		log.Error().Err(err).Msg("Scheduler did not stop in time")
+	}
+
+	if err := shutdownTracing(ctx); err != nil {
+		log.Error().Err(err).Msg("Failed to flush traces")
+	}
+
 	log.Info().Msg("Server shutdown completed")
 }
 
 func setupLogger() {
 	zerolog.TimeFieldFormat = time.RFC3339
+	// zerolog.Ctx falls back to the global logger outside of a request
+	zerolog.DefaultContextLogger = &log.Logger
 
 	if config.Cfg.Server.Environment == "development" {
 		log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+		zerolog.DefaultContextLogger = &log.Logger
 	}
 
-	switch config.Cfg.App.LogLevel {
-	case "debug":
-		zerolog.SetGlobalLevel(zerolog.DebugLevel)
-	case "info":
-		zerolog.SetGlobalLevel(zerolog.InfoLevel)
-	case "warn":
-		zerolog.SetGlobalLevel(zerolog.WarnLevel)
-	case "error":
-		zerolog.SetGlobalLevel(zerolog.ErrorLevel)
+	level, components := parseLogLevels(config.Cfg)
+	logging.Levels = logging.NewLevelController(level, components)
+	logging.Levels.Apply()
+}
+
+// applyLogLevels installs the levels from a reloaded configuration.
+func applyLogLevels(cfg *config.Config) {
+	level, components := parseLogLevels(cfg)
+	logging.Levels.SetDefaults(level, components)
+}
+
+func parseLogLevels(cfg *config.Config) (zerolog.Level, map[string]zerolog.Level) {
+	level, err := logging.ParseLevel(cfg.App.LogLevel)
+	if err != nil {
+		log.Warn().Err(err).Msg("Falling back to info log level")
+		level = zerolog.InfoLevel
+	}
+
+	components, err := logging.ParseComponentLevels(cfg.Log.ComponentLevels)
+	if err != nil {
+		log.Warn().Err(err).Msg("Ignoring invalid component log levels")
+		components = nil
+	}
+
+	return level, components
+}
+
+// watchLogSignals toggles debug logging for every component on SIGHUP. The
+// change reverts on its own after LOG_SIGNAL_DEBUG_DURATION, or on the next
+// SIGHUP.
+func watchLogSignals() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	debugOn := false
+	for range hup {
+		if debugOn && logging.Levels.Level(logging.Default) == zerolog.DebugLevel {
+			logging.Levels.Reset()
+			debugOn = false
+			log.Warn().Msg("SIGHUP received, log levels restored")
+			continue
+		}
+
+		duration := config.Cfg.Log.SignalDebugDuration
+		logging.Levels.Set("", zerolog.DebugLevel, duration)
+		debugOn = true
+		log.Warn().Dur("duration", duration).Msg("SIGHUP received, debug logging enabled")
+	}
+}
+
+// newMailSender builds the configured mailer and queues its messages on pool.
+func newMailSender(pool *jobs.Pool) (*mailer.Sender, error) {
+	cfg := config.Cfg.Mail
+	m, err := mailer.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	templates, err := mailer.LoadTemplates(config.Cfg.App.Name, cfg.DefaultLocale)
+	if err != nil {
+		return nil, err
+	}
+	return mailer.NewSender(m, templates, pool, db.NewMailLogStore(), cfg.From), nil
+}
+
+// registerMaintenanceTasks adds the recurring cleanup tasks. Their default
+// schedules can be overridden by name in scheduler.tasks.
+func registerMaintenanceTasks(s *scheduler.Scheduler) error {
+	maintenance := db.NewMaintenanceStore()
+	batch := config.Cfg.Scheduler.PurgeBatchSize
+
+	tasks := []scheduler.Task{
+		scheduler.PurgeTask("purge_idempotency_keys", "*/15 * * * *", batch, maintenance.PurgeExpiredIdempotencyKeys),
+		scheduler.PurgeTask("purge_refresh_tokens", "0 * * * *", batch, maintenance.PurgeExpiredRefreshTokens),
+		scheduler.PurgeTask("purge_user_tokens", "30 * * * *", batch, maintenance.PurgeExpiredUserTokens),
+		scheduler.PurgeTask("purge_login_failures", "45 * * * *", batch, maintenance.PurgeStaleLoginFailures),
+		scheduler.PurgeTask("purge_api_keys", "50 3 * * *", batch, maintenance.PurgeExpiredAPIKeys),
+		scheduler.PurgeTask("purge_oidc_logins", "20 * * * *", batch, maintenance.PurgeExpiredOIDCLogins),
+	}
+	for _, t := range tasks {
+		if err := s.Register(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runConfigCommand implements "config print" and "config validate".
+func runConfigCommand(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: main config [print|validate]")
+		return 2
+	}
+
+	switch args[0] {
+	case "print":
+		if err := config.Cfg.Print(os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to print configuration: %v\n", err)
+			return 1
+		}
+		if err := config.Cfg.Validate(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		return 0
+
+	case "validate":
+		if err := config.Cfg.Validate(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Println("Configuration is valid")
+		return 0
+
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown config command %q. Use 'print' or 'validate'\n", args[0])
+		return 2
+	}
+}
+
+// runSecretsCommand implements "secrets keygen" and
+// "secrets encrypt <input.json> <output>" for the encrypted_file provider.
+func runSecretsCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: main secrets [keygen|encrypt <input.json> <output>]")
+		return 2
+	}
+
+	switch args[0] {
+	case "keygen":
+		key := make([]byte, secrets.KeySize)
+		if _, err := rand.Read(key); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to generate key: %v\n", err)
+			return 1
+		}
+		fmt.Println(base64.StdEncoding.EncodeToString(key))
+		return 0
+
+	case "encrypt":
+		if len(args) != 3 {
+			fmt.Fprintln(os.Stderr, "Usage: main secrets encrypt <input.json> <output>")
+			return 2
+		}
+
+		encoded, err := secrets.Resolve(context.Background(), secrets.FileEnvProvider{}, "SECRETS_KEY", os.Getenv("SECRETS_KEY"))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		key, err := secrets.ParseKey(encoded)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+
+		plaintext, err := os.ReadFile(args[1])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read input: %v\n", err)
+			return 1
+		}
+		var check map[string]string
+		if err := json.Unmarshal(plaintext, &check); err != nil {
+			fmt.Fprintf(os.Stderr, "Input must be a JSON object of string values: %v\n", err)
+			return 1
+		}
+
+		sealed, err := secrets.Encrypt(key, plaintext)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if err := os.WriteFile(args[2], sealed, 0o600); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write output: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Encrypted %d secrets to %s\n", len(check), args[2])
+		return 0
+
 	default:
-		zerolog.SetGlobalLevel(zerolog.InfoLevel)
+		fmt.Fprintf(os.Stderr, "Unknown secrets command %q. Use 'keygen' or 'encrypt'\n", args[0])
+		return 2
 	}
 }
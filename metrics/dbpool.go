@@ -0,0 +1,70 @@
+package metrics
+
+import (
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// PoolCollector exports pgxpool statistics. Values are read from the pool on
+// every scrape, so nothing has to be updated on the query path.
+type PoolCollector struct {
+	stat func() *pgxpool.Stat
+
+	acquiredConns        *prometheus.Desc
+	idleConns            *prometheus.Desc
+	totalConns           *prometheus.Desc
+	maxConns             *prometheus.Desc
+	acquireCount         *prometheus.Desc
+	emptyAcquireCount    *prometheus.Desc
+	canceledAcquireCount *prometheus.Desc
+	acquireWaitSeconds   *prometheus.Desc
+}
+
+// NewPoolCollector creates a collector reading stats from the given function,
+// typically db.Conn.Stat.
+func NewPoolCollector(stat func() *pgxpool.Stat) *PoolCollector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "db_pool", name), help, nil, nil)
+	}
+
+	return &PoolCollector{
+		stat:                 stat,
+		acquiredConns:        desc("acquired_connections", "Number of currently acquired connections."),
+		idleConns:            desc("idle_connections", "Number of currently idle connections."),
+		totalConns:           desc("total_connections", "Total number of connections in the pool."),
+		maxConns:             desc("max_connections", "Maximum size of the pool."),
+		acquireCount:         desc("acquire_total", "Cumulative count of successful acquires."),
+		emptyAcquireCount:    desc("empty_acquire_total", "Cumulative count of acquires that had to wait for a connection."),
+		canceledAcquireCount: desc("canceled_acquire_total", "Cumulative count of acquires canceled by context."),
+		acquireWaitSeconds:   desc("acquire_wait_seconds_total", "Cumulative time spent waiting to acquire a connection."),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (pc *PoolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- pc.acquiredConns
+	ch <- pc.idleConns
+	ch <- pc.totalConns
+	ch <- pc.maxConns
+	ch <- pc.acquireCount
+	ch <- pc.emptyAcquireCount
+	ch <- pc.canceledAcquireCount
+	ch <- pc.acquireWaitSeconds
+}
+
+// Collect implements prometheus.Collector
+func (pc *PoolCollector) Collect(ch chan<- prometheus.Metric) {
+	s := pc.stat()
+	if s == nil {
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(pc.acquiredConns, prometheus.GaugeValue, float64(s.AcquiredConns()))
+	ch <- prometheus.MustNewConstMetric(pc.idleConns, prometheus.GaugeValue, float64(s.IdleConns()))
+	ch <- prometheus.MustNewConstMetric(pc.totalConns, prometheus.GaugeValue, float64(s.TotalConns()))
+	ch <- prometheus.MustNewConstMetric(pc.maxConns, prometheus.GaugeValue, float64(s.MaxConns()))
+	ch <- prometheus.MustNewConstMetric(pc.acquireCount, prometheus.CounterValue, float64(s.AcquireCount()))
+	ch <- prometheus.MustNewConstMetric(pc.emptyAcquireCount, prometheus.CounterValue, float64(s.EmptyAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(pc.canceledAcquireCount, prometheus.CounterValue, float64(s.CanceledAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(pc.acquireWaitSeconds, prometheus.CounterValue, s.AcquireDuration().Seconds())
+}
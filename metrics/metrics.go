@@ -0,0 +1,143 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "make_it_rain"
+
+// Registry holds every collector exposed on the admin /metrics endpoint.
+// A dedicated registry keeps the output free of anything registered on
+// prometheus.DefaultRegisterer by third-party packages.
+var Registry = prometheus.NewRegistry()
+
+var (
+	HTTPRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "http",
+			Name:      "requests_total",
+			Help:      "Total number of HTTP requests by method, route template and status code.",
+		},
+		[]string{"method", "route", "status"},
+	)
+
+	HTTPRequestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "http",
+			Name:      "request_duration_seconds",
+			Help:      "HTTP request latency by method and route template.",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"method", "route"},
+	)
+
+	HTTPRequestsInFlight = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "http",
+			Name:      "requests_in_flight",
+			Help:      "Number of HTTP requests currently being served.",
+		},
+	)
+
+	CacheRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "cache",
+			Name:      "requests_total",
+			Help:      "Cache lookups by cache name and result (hit or miss).",
+		},
+		[]string{"cache", "result"},
+	)
+
+	OutboxEventsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "outbox",
+			Name:      "events_total",
+			Help:      "Outbox delivery attempts by event type and result (delivered, retry or dead).",
+		},
+		[]string{"type", "result"},
+	)
+
+	JobsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "jobs",
+			Name:      "total",
+			Help:      "Finished job attempts by job type and result (succeeded, retry or dead).",
+		},
+		[]string{"type", "result"},
+	)
+
+	JobDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "jobs",
+			Name:      "duration_seconds",
+			Help:      "Job run time by job type.",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"type"},
+	)
+
+	ScheduledTaskRunsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "scheduler",
+			Name:      "task_runs_total",
+			Help:      "Scheduled task runs by task name and result (succeeded, failed or skipped).",
+		},
+		[]string{"task", "result"},
+	)
+
+	LoginAttemptsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "auth",
+			Name:      "login_attempts_total",
+			Help:      "Login attempts by result (succeeded, failed or throttled).",
+		},
+		[]string{"result"},
+	)
+
+	LoginLockoutsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "auth",
+			Name:      "lockouts_total",
+			Help:      "Temporary login lockouts by scope (account or ip).",
+		},
+		[]string{"scope"},
+	)
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequestsTotal,
+		HTTPRequestDuration,
+		HTTPRequestsInFlight,
+		CacheRequestsTotal,
+		OutboxEventsTotal,
+		JobsTotal,
+		JobDuration,
+		ScheduledTaskRunsTotal,
+		LoginAttemptsTotal,
+		LoginLockoutsTotal,
+	)
+}
+
+// Handler returns the Prometheus text-format handler for Registry.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{
+		Registry: Registry,
+	})
+}
@@ -0,0 +1,125 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/manuel/make-it-rain/auth"
+	"github.com/manuel/make-it-rain/logging"
+	"github.com/manuel/make-it-rain/models"
+)
+
+// SessionIDKey is the gin context key holding the refresh token (session)
+// the request's access token was issued from.
+const SessionIDKey = "session_id"
+
+// APIKeyKey is the gin context key holding the *models.APIKey a request
+// authenticated with, if it used one instead of an access token.
+const APIKeyKey = "api_key"
+
+// TokenVerifier checks an access token; *auth.Signer implements it.
+type TokenVerifier interface {
+	Verify(token string) (*auth.Claims, error)
+}
+
+// APIKeyResolver looks up a personal API key; *auth.APIKeys implements it.
+type APIKeyResolver interface {
+	Resolve(ctx context.Context, key string) (*models.APIKey, error)
+}
+
+// SessionChecker confirms the session behind an access token hasn't been
+// revoked; *auth.Sessions implements it.
+type SessionChecker interface {
+	CheckSession(ctx context.Context, sessionID int64) error
+}
+
+// Auth requires "Authorization: Bearer <access token>" and sets UserIDKey
+// and SessionIDKey for the handlers that follow. When sessions is set,
+// tokens from revoked sessions are refused. When keys is set, the bearer can
+// also be a "mir_" API key, which sets UserIDKey and APIKeyKey.
+func Auth(verifier TokenVerifier, sessions SessionChecker, keys APIKeyResolver) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			c.Header("WWW-Authenticate", "Bearer")
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+			return
+		}
+
+		if keys != nil && auth.IsAPIKey(token) {
+			key, err := keys.Resolve(c.Request.Context(), token)
+			if err != nil {
+				if !errors.Is(err, auth.ErrInvalidToken) {
+					logging.Ctx(c.Request.Context(), logging.HTTP).Error().Err(err).Msg("Failed to resolve API key")
+				}
+				c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+				return
+			}
+			c.Set(UserIDKey, key.UserID)
+			c.Set(APIKeyKey, key)
+			c.Next()
+			return
+		}
+
+		claims, err := verifier.Verify(token)
+		if err == nil && sessions != nil {
+			err = sessions.CheckSession(c.Request.Context(), claims.SessionID)
+			if err != nil && !errors.Is(err, auth.ErrInvalidToken) {
+				logging.Ctx(c.Request.Context(), logging.HTTP).Error().Err(err).Msg("Failed to check session")
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to check session"})
+				return
+			}
+		}
+		if err != nil {
+			c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+			return
+		}
+		userID, _ := claims.UserID()
+
+		c.Set(UserIDKey, userID)
+		c.Set(SessionIDKey, claims.SessionID)
+		c.Next()
+	}
+}
+
+// RequireScope lets requests authenticated with an API key through only if
+// the key was granted scope. Access tokens have every scope. It goes after
+// Auth.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if key, ok := apiKey(c); ok && !slices.Contains(key.Scopes, scope) {
+			c.Header("WWW-Authenticate", `Bearer error="insufficient_scope", scope="`+scope+`"`)
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "API key lacks the " + scope + " scope"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireSession refuses requests authenticated with an API key, for
+// account management that needs the user to have logged in. It goes after
+// Auth.
+func RequireSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := apiKey(c); ok {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "This endpoint can't be used with an API key"})
+			return
+		}
+		c.Next()
+	}
+}
+
+func apiKey(c *gin.Context) (*models.APIKey, bool) {
+	v, ok := c.Get(APIKeyKey)
+	if !ok {
+		return nil, false
+	}
+	key, ok := v.(*models.APIKey)
+	return key, ok
+}
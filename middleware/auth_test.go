@@ -0,0 +1,146 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/manuel/make-it-rain/auth"
+	"github.com/manuel/make-it-rain/config"
+	"github.com/manuel/make-it-rain/models"
+)
+
+func TestAuthSetsUserFromBearerToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	signer := auth.NewSigner(config.JWTConfig{SecretKey: "test-secret", ExpiryDuration: time.Minute})
+
+	r := gin.New()
+	r.Use(Auth(signer, nil, nil))
+	var userID, sessionID int64
+	r.GET("/", func(c *gin.Context) {
+		userID = c.GetInt64(UserIDKey)
+		sessionID = c.GetInt64(SessionIDKey)
+		c.Status(http.StatusOK)
+	})
+
+	token, _, _ := signer.Sign(42, 7)
+	tests := []struct {
+		header string
+		want   int
+	}{
+		{"", http.StatusUnauthorized},
+		{"Basic abc", http.StatusUnauthorized},
+		{"Bearer not-a-token", http.StatusUnauthorized},
+		{"Bearer " + token, http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", tt.header)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != tt.want {
+			t.Errorf("Authorization %q: expected %d, got %d", tt.header, tt.want, w.Code)
+		}
+		if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("Authorization %q: expected a WWW-Authenticate challenge", tt.header)
+		}
+	}
+	if userID != 42 || sessionID != 7 {
+		t.Errorf("Expected user 42 and session 7 in the context, got %d and %d", userID, sessionID)
+	}
+}
+
+// staticKeys resolves and caches a fixed set of API keys.
+type staticKeys map[string]*models.APIKey
+
+func (k staticKeys) Resolve(ctx context.Context, key string) (*models.APIKey, error) {
+	if found, ok := k[key]; ok {
+		return found, nil
+	}
+	return nil, auth.ErrInvalidToken
+}
+
+func (k staticKeys) Cached(key string) (*models.APIKey, bool) {
+	found, ok := k[key]
+	return found, ok
+}
+
+func TestAuthAcceptsAPIKeys(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	signer := auth.NewSigner(config.JWTConfig{SecretKey: "test-secret", ExpiryDuration: time.Minute})
+	keys := staticKeys{
+		"mir_reader": {ID: 1, UserID: 42, Scopes: []string{models.ScopeUsersRead}},
+		"mir_none":   {ID: 2, UserID: 42},
+	}
+
+	r := gin.New()
+	r.Use(Auth(signer, nil, keys))
+	r.GET("/me", RequireScope(models.ScopeUsersRead), func(c *gin.Context) {
+		if c.GetInt64(UserIDKey) != 42 {
+			t.Errorf("Expected user 42 in the context, got %d", c.GetInt64(UserIDKey))
+		}
+		c.Status(http.StatusOK)
+	})
+	r.POST("/me/password", RequireSession(), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	token, _, _ := signer.Sign(42, 7)
+	tests := []struct {
+		method, path, bearer string
+		want                 int
+	}{
+		{http.MethodGet, "/me", "mir_reader", http.StatusOK},
+		{http.MethodGet, "/me", "mir_none", http.StatusForbidden},
+		{http.MethodGet, "/me", "mir_unknown", http.StatusUnauthorized},
+		{http.MethodGet, "/me", token, http.StatusOK},
+		{http.MethodPost, "/me/password", "mir_reader", http.StatusForbidden},
+		{http.MethodPost, "/me/password", token, http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		req.Header.Set("Authorization", "Bearer "+tt.bearer)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != tt.want {
+			t.Errorf("%s %s with %.10s: expected %d, got %d", tt.method, tt.path, tt.bearer, tt.want, w.Code)
+		}
+	}
+}
+
+// revokedSessions refuses the sessions in it.
+type revokedSessions map[int64]bool
+
+func (r revokedSessions) CheckSession(ctx context.Context, sessionID int64) error {
+	if r[sessionID] {
+		return auth.ErrInvalidToken
+	}
+	return nil
+}
+
+func TestAuthRefusesRevokedSessions(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	signer := auth.NewSigner(config.JWTConfig{SecretKey: "test-secret", ExpiryDuration: time.Minute})
+
+	r := gin.New()
+	r.Use(Auth(signer, revokedSessions{8: true}, nil))
+	r.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	live, _, _ := signer.Sign(42, 7)
+	revoked, _, _ := signer.Sign(42, 8)
+	for token, want := range map[string]int{live: http.StatusOK, revoked: http.StatusUnauthorized} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != want {
+			t.Errorf("Expected %d, got %d", want, w.Code)
+		}
+	}
+}
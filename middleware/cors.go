@@ -1,21 +1,208 @@
 package middleware
 
 import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
 	"github.com/gin-gonic/gin"
+	"github.com/manuel/make-it-rain/config"
 )
 
+// CORSPolicy is a compiled CORS policy.
+type CORSPolicy struct {
+	exactOrigins     map[string]bool
+	wildcardSuffixes []originSuffix
+	allowAny         bool
+	allowMethods     string
+	allowHeaders     string
+	exposeHeaders    string
+	allowCredentials bool
+	maxAge           string
+}
+
+// originSuffix matches "https://*.example.com" as scheme "https://" plus any
+// host ending in ".example.com".
+type originSuffix struct {
+	scheme string
+	suffix string
+}
+
+// NewCORSPolicy compiles a policy from configuration.
+func NewCORSPolicy(cfg config.CORSPolicyConfig) *CORSPolicy {
+	p := &CORSPolicy{
+		exactOrigins:     make(map[string]bool),
+		allowMethods:     strings.Join(cfg.AllowedMethods, ", "),
+		allowHeaders:     strings.Join(cfg.AllowedHeaders, ", "),
+		exposeHeaders:    strings.Join(cfg.ExposedHeaders, ", "),
+		allowCredentials: cfg.AllowCredentials,
+	}
+	if cfg.MaxAge > 0 {
+		p.maxAge = strconv.Itoa(int(cfg.MaxAge.Seconds()))
+	}
+
+	for _, origin := range cfg.AllowedOrigins {
+		origin = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(origin), "/"))
+		switch {
+		case origin == "*":
+			p.allowAny = true
+		case strings.Contains(origin, "://*."):
+			scheme, host, _ := strings.Cut(origin, "://*")
+			p.wildcardSuffixes = append(p.wildcardSuffixes, originSuffix{scheme: scheme + "://", suffix: host})
+		default:
+			p.exactOrigins[origin] = true
+		}
+	}
+
+	return p
+}
+
+// match reports whether origin is allowed and whether it was allowlisted
+// explicitly (as opposed to only through "*").
+func (p *CORSPolicy) match(origin string) (allowed, listed bool) {
+	o := strings.ToLower(origin)
+	if p.exactOrigins[o] {
+		return true, true
+	}
+	for _, w := range p.wildcardSuffixes {
+		if strings.HasPrefix(o, w.scheme) && strings.HasSuffix(o, w.suffix) && len(o) > len(w.scheme)+len(w.suffix) {
+			return true, true
+		}
+	}
+	return p.allowAny, false
+}
+
+// Handle applies the policy to the request. It returns false if the request
+// was a preflight that has been answered and must not continue.
+func (p *CORSPolicy) Handle(c *gin.Context) bool {
+	h := c.Writer.Header()
+	h.Add("Vary", "Origin")
+
+	origin := c.GetHeader("Origin")
+	preflight := c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != ""
+	if preflight {
+		h.Add("Vary", "Access-Control-Request-Method")
+		h.Add("Vary", "Access-Control-Request-Headers")
+	}
+
+	if origin == "" {
+		return true
+	}
+
+	allowed, listed := p.match(origin)
+	if !allowed {
+		if preflight {
+			c.AbortWithStatus(http.StatusForbidden)
+			return false
+		}
+		// Without CORS headers the browser hides the response from the page
+		return true
+	}
+
+	// Credentials are only allowed for origins on the allowlist; "*" may
+	// never be combined with credentials.
+	if listed {
+		h.Set("Access-Control-Allow-Origin", origin)
+		if p.allowCredentials {
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+	} else {
+		h.Set("Access-Control-Allow-Origin", "*")
+	}
+
+	if !preflight {
+		if p.exposeHeaders != "" {
+			h.Set("Access-Control-Expose-Headers", p.exposeHeaders)
+		}
+		return true
+	}
+
+	h.Set("Access-Control-Allow-Methods", p.allowMethods)
+	h.Set("Access-Control-Allow-Headers", p.allowHeaders)
+	if p.maxAge != "" {
+		h.Set("Access-Control-Max-Age", p.maxAge)
+	}
+	c.AbortWithStatus(http.StatusNoContent)
+	return false
+}
+
+// corsRoutes maps route-group path prefixes to policies, longest prefix first.
+type corsRoutes struct {
+	fallback *CORSPolicy
+	prefixes []string
+	policies map[string]*CORSPolicy
+}
+
+func newCORSRoutes(cfg config.CORSConfig) *corsRoutes {
+	r := &corsRoutes{
+		fallback: NewCORSPolicy(cfg.CORSPolicyConfig),
+		policies: make(map[string]*CORSPolicy),
+	}
+
+	for _, rp := range cfg.RoutePolicies {
+		r.prefixes = append(r.prefixes, rp.PathPrefix)
+		r.policies[rp.PathPrefix] = NewCORSPolicy(inheritCORSPolicy(rp.CORSPolicyConfig, cfg.CORSPolicyConfig))
+	}
+	sort.Slice(r.prefixes, func(i, j int) bool { return len(r.prefixes[i]) > len(r.prefixes[j]) })
+
+	return r
+}
+
+func (r *corsRoutes) policyFor(path string) *CORSPolicy {
+	for _, prefix := range r.prefixes {
+		if strings.HasPrefix(path, prefix) {
+			return r.policies[prefix]
+		}
+	}
+	return r.fallback
+}
+
+func inheritCORSPolicy(p, base config.CORSPolicyConfig) config.CORSPolicyConfig {
+	if len(p.AllowedOrigins) == 0 {
+		p.AllowedOrigins = base.AllowedOrigins
+	}
+	if len(p.AllowedMethods) == 0 {
+		p.AllowedMethods = base.AllowedMethods
+	}
+	if len(p.AllowedHeaders) == 0 {
+		p.AllowedHeaders = base.AllowedHeaders
+	}
+	if len(p.ExposedHeaders) == 0 {
+		p.ExposedHeaders = base.ExposedHeaders
+	}
+	if p.MaxAge == 0 {
+		p.MaxAge = base.MaxAge
+	}
+	return p
+}
+
+// CORS applies the configured CORS policy, selecting a route-group policy by
+// path prefix when one matches. Policies are rebuilt on config reload.
 func CORS() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE, PATCH")
+	var routes atomic.Pointer[corsRoutes]
+	routes.Store(newCORSRoutes(config.Current().CORS))
+
+	config.OnChange(func(_, new *config.Config) {
+		routes.Store(newCORSRoutes(new.CORS))
+	})
 
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(204)
+	return func(c *gin.Context) {
+		if !routes.Load().policyFor(c.Request.URL.Path).Handle(c) {
 			return
 		}
+		c.Next()
+	}
+}
 
+// CORSWithPolicy applies a fixed policy, for route groups registered on a
+// router without the global CORS middleware.
+func CORSWithPolicy(p *CORSPolicy) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !p.Handle(c) {
+			return
+		}
 		c.Next()
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,115 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/manuel/make-it-rain/config"
+)
+
+func newCORSTestRouter(p *CORSPolicy) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(CORSWithPolicy(p))
+	r.GET("/users", func(c *gin.Context) { c.Status(http.StatusOK) })
+	return r
+}
+
+func corsRequest(r *gin.Engine, method, origin string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/users", nil)
+	if origin != "" {
+		req.Header.Set("Origin", origin)
+	}
+	if method == http.MethodOptions {
+		req.Header.Set("Access-Control-Request-Method", "GET")
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestCORSAllowlist(t *testing.T) {
+	r := newCORSTestRouter(NewCORSPolicy(config.CORSPolicyConfig{
+		AllowedOrigins:   []string{"https://app.example.com", "https://*.example.org"},
+		AllowedMethods:   []string{"GET"},
+		AllowedHeaders:   []string{"Authorization"},
+		ExposedHeaders:   []string{"X-Request-ID"},
+		AllowCredentials: true,
+		MaxAge:           time.Hour,
+	}))
+
+	tests := []struct {
+		origin  string
+		allowed bool
+	}{
+		{"https://app.example.com", true},
+		{"https://api.example.org", true},
+		{"https://a.b.example.org", true},
+		{"https://example.org", false},
+		{"http://api.example.org", false},
+		{"https://evil.com", false},
+	}
+
+	for _, tt := range tests {
+		w := corsRequest(r, http.MethodGet, tt.origin)
+		got := w.Header().Get("Access-Control-Allow-Origin")
+		if tt.allowed && got != tt.origin {
+			t.Errorf("Expected %s to be echoed, got %q", tt.origin, got)
+		}
+		if !tt.allowed && got != "" {
+			t.Errorf("Expected %s to be rejected, got %q", tt.origin, got)
+		}
+		if tt.allowed && w.Header().Get("Access-Control-Allow-Credentials") != "true" {
+			t.Errorf("Expected credentials for allowlisted origin %s", tt.origin)
+		}
+		if w.Header().Get("Vary") != "Origin" {
+			t.Errorf("Expected Vary: Origin, got %q", w.Header().Get("Vary"))
+		}
+	}
+
+	w := corsRequest(r, http.MethodOptions, "https://app.example.com")
+	if w.Code != http.StatusNoContent {
+		t.Errorf("Expected 204 for preflight, got %d", w.Code)
+	}
+	if w.Header().Get("Access-Control-Max-Age") != "3600" {
+		t.Errorf("Expected Max-Age 3600, got %q", w.Header().Get("Access-Control-Max-Age"))
+	}
+
+	if w := corsRequest(r, http.MethodOptions, "https://evil.com"); w.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for disallowed preflight, got %d", w.Code)
+	}
+}
+
+func TestCORSWildcardNeverAllowsCredentials(t *testing.T) {
+	r := newCORSTestRouter(NewCORSPolicy(config.CORSPolicyConfig{
+		AllowedOrigins:   []string{"*"},
+		AllowCredentials: true,
+	}))
+
+	w := corsRequest(r, http.MethodGet, "https://anything.test")
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("Expected *, got %q", got)
+	}
+	if w.Header().Get("Access-Control-Allow-Credentials") != "" {
+		t.Error("Expected no credentials with a wildcard origin")
+	}
+}
+
+func TestCORSRoutePolicies(t *testing.T) {
+	routes := newCORSRoutes(config.CORSConfig{
+		CORSPolicyConfig: config.CORSPolicyConfig{AllowedOrigins: []string{"https://app.example.com"}},
+		RoutePolicies: []config.CORSRoutePolicy{
+			{PathPrefix: "/api/v1/public", CORSPolicyConfig: config.CORSPolicyConfig{AllowedOrigins: []string{"*"}}},
+		},
+	})
+
+	if allowed, _ := routes.policyFor("/api/v1/public/stats").match("https://other.test"); !allowed {
+		t.Error("Expected public route policy to allow any origin")
+	}
+	if allowed, _ := routes.policyFor("/api/v1/users").match("https://other.test"); allowed {
+		t.Error("Expected default policy to reject unknown origin")
+	}
+}
@@ -0,0 +1,201 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/manuel/make-it-rain/config"
+	"github.com/manuel/make-it-rain/data_structures"
+	"github.com/manuel/make-it-rain/db"
+	"github.com/manuel/make-it-rain/logging"
+)
+
+const (
+	IdempotencyKeyHeader      = "Idempotency-Key"
+	IdempotencyReplayedHeader = "Idempotent-Replayed"
+
+	maxIdempotencyKeyLength = 255
+	// idempotencyCacheTTL bounds how long a replay is served from memory;
+	// records also carry their own expiry, which is checked on every hit.
+	idempotencyCacheTTL = 10 * time.Minute
+	// idempotencyStoreTimeout bounds bookkeeping writes, which run detached
+	// from the request context so they still happen after a timeout.
+	idempotencyStoreTimeout = 5 * time.Second
+)
+
+// bodyCaptureWriter keeps a copy of the response body so it can be stored.
+type bodyCaptureWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *bodyCaptureWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *bodyCaptureWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Idempotency makes POST requests carrying an Idempotency-Key safe to retry.
+// The first response for a key is stored and replayed for later requests with
+// the same key and body. Reusing a key with a different body returns 422, and
+// a retry that arrives while the first request is still running returns 409.
+// Server errors are not stored, so the client can retry them, and neither are
+// responses marked Cache-Control: no-store, which hold secrets. Requests
+// without the header are passed through unchanged.
+//
+// Keys are scoped to the route and, when it runs after Auth, to the user.
+// Anonymous keys are shared by everyone calling the route, so the body
+// fingerprint is what keeps one caller from receiving another's response.
+func Idempotency(store db.IdempotencyStore, cfg config.IdempotencyConfig) gin.HandlerFunc {
+	cacheTTL := idempotencyCacheTTL
+	if cfg.TTL < cacheTTL {
+		cacheTTL = cfg.TTL
+	}
+	cache := data_structures.NewLRUCacheWithTTL[string, *db.IdempotencyRecord](cfg.CacheSize, cacheTTL)
+
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyKeyHeader)
+		if c.Request.Method != http.MethodPost || key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("%s must be at most %d characters", IdempotencyKeyHeader, maxIdempotencyKeyLength),
+			})
+			return
+		}
+
+		fingerprint, err := requestFingerprint(c)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+			return
+		}
+
+		ctx := c.Request.Context()
+		logger := logging.Ctx(ctx, logging.HTTP)
+		scope := c.Request.Method + " " + c.FullPath()
+		if userID, ok := c.Get(UserIDKey); ok {
+			scope += fmt.Sprintf(" user:%v", userID)
+		}
+		cacheKey := scope + "\x00" + key
+
+		if record, ok := cache.Get(cacheKey); ok && time.Now().Before(record.ExpiresAt) {
+			replayIdempotent(c, record, fingerprint)
+			return
+		}
+
+		record, claimed, err := store.ClaimIdempotencyKey(ctx, scope, key, fingerprint, cfg.LockTimeout, cfg.TTL)
+		if err != nil {
+			logger.Error().Err(err).Str("idempotency_key", key).Msg("Failed to claim idempotency key")
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to process idempotency key"})
+			return
+		}
+		if !claimed {
+			if record.Status == db.IdempotencyCompleted {
+				cache.Put(cacheKey, record)
+			}
+			replayIdempotent(c, record, fingerprint)
+			return
+		}
+
+		writer := &bodyCaptureWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+
+		completed := false
+		defer func() {
+			if completed {
+				return
+			}
+			// The handler panicked or failed, or the response can't be
+			// stored; let the client retry.
+			storeCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), idempotencyStoreTimeout)
+			defer cancel()
+			if err := store.ReleaseIdempotencyKey(storeCtx, scope, key); err != nil {
+				logger.Error().Err(err).Str("idempotency_key", key).Msg("Failed to release idempotency key")
+			}
+		}()
+
+		c.Next()
+
+		// An unwritten response after the deadline becomes a 504 further up
+		// the chain, so it must not be stored as the default 200.
+		status := writer.Status()
+		if status >= http.StatusInternalServerError || (!writer.Written() && ctx.Err() != nil) {
+			return
+		}
+		if strings.Contains(writer.Header().Get("Cache-Control"), "no-store") {
+			return
+		}
+
+		storeCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), idempotencyStoreTimeout)
+		defer cancel()
+		contentType := writer.Header().Get("Content-Type")
+		body := writer.body.Bytes()
+		if err := store.CompleteIdempotencyKey(storeCtx, scope, key, status, contentType, body); err != nil {
+			logger.Error().Err(err).Str("idempotency_key", key).Msg("Failed to store idempotent response")
+			return
+		}
+		completed = true
+
+		record.Status = db.IdempotencyCompleted
+		record.ResponseStatus = status
+		record.ResponseContentType = contentType
+		record.ResponseBody = append([]byte(nil), body...)
+		cache.Put(cacheKey, record)
+	}
+}
+
+// replayIdempotent answers a request whose key has been seen before.
+func replayIdempotent(c *gin.Context, record *db.IdempotencyRecord, fingerprint string) {
+	switch {
+	case record.Fingerprint != fingerprint:
+		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{
+			"error": IdempotencyKeyHeader + " was already used with a different request",
+		})
+	case record.Status != db.IdempotencyCompleted:
+		c.Header("Retry-After", "1")
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{
+			"error": "A request with this " + IdempotencyKeyHeader + " is still in progress",
+		})
+	default:
+		c.Header(IdempotencyReplayedHeader, "true")
+		contentType := record.ResponseContentType
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		c.Data(record.ResponseStatus, contentType, record.ResponseBody)
+		c.Abort()
+	}
+}
+
+// requestFingerprint hashes the method, path, query and body, restoring the
+// body for the handler.
+func requestFingerprint(c *gin.Context) (string, error) {
+	var body []byte
+	if c.Request.Body != nil {
+		var err error
+		body, err = io.ReadAll(c.Request.Body)
+		if err != nil {
+			return "", err
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	h := sha256.New()
+	h.Write([]byte(c.Request.Method + " " + c.Request.URL.RequestURI() + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
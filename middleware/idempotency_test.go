@@ -0,0 +1,212 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/manuel/make-it-rain/config"
+	"github.com/manuel/make-it-rain/db"
+)
+
+type memoryIdempotencyStore struct {
+	mu      sync.Mutex
+	records map[string]*db.IdempotencyRecord
+}
+
+func newMemoryIdempotencyStore() *memoryIdempotencyStore {
+	return &memoryIdempotencyStore{records: make(map[string]*db.IdempotencyRecord)}
+}
+
+func (s *memoryIdempotencyStore) ClaimIdempotencyKey(ctx context.Context, scope, key, fingerprint string, lockTimeout, ttl time.Duration) (*db.IdempotencyRecord, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r, ok := s.records[scope+key]; ok {
+		copied := *r
+		return &copied, false, nil
+	}
+	r := &db.IdempotencyRecord{Scope: scope, Key: key, Fingerprint: fingerprint, Status: db.IdempotencyInProgress, ExpiresAt: time.Now().Add(ttl)}
+	s.records[scope+key] = r
+	copied := *r
+	return &copied, true, nil
+}
+
+func (s *memoryIdempotencyStore) CompleteIdempotencyKey(ctx context.Context, scope, key string, status int, contentType string, body []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := s.records[scope+key]
+	r.Status = db.IdempotencyCompleted
+	r.ResponseStatus = status
+	r.ResponseContentType = contentType
+	r.ResponseBody = body
+	return nil
+}
+
+func (s *memoryIdempotencyStore) ReleaseIdempotencyKey(ctx context.Context, scope, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, scope+key)
+	return nil
+}
+
+// testUserHeader stands in for Auth in these tests: it names the user the
+// request is authenticated as.
+const testUserHeader = "X-Test-User"
+
+func newIdempotencyTestRouter(store db.IdempotencyStore, handler gin.HandlerFunc) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		if user := c.GetHeader(testUserHeader); user != "" {
+			userID, _ := strconv.ParseInt(user, 10, 64)
+			c.Set(UserIDKey, userID)
+		}
+	})
+	r.Use(Idempotency(store, config.IdempotencyConfig{TTL: time.Hour, LockTimeout: time.Minute, CacheSize: 10}))
+	r.POST("/users", handler)
+	return r
+}
+
+func postWithKey(r http.Handler, key, body string) *httptest.ResponseRecorder {
+	return postAs(r, "1", key, body)
+}
+
+func postAs(r http.Handler, user, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(body))
+	req.Header.Set(IdempotencyKeyHeader, key)
+	if user != "" {
+		req.Header.Set(testUserHeader, user)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestIdempotencyReplaysFirstResponse(t *testing.T) {
+	calls := 0
+	r := newIdempotencyTestRouter(newMemoryIdempotencyStore(), func(c *gin.Context) {
+		calls++
+		c.JSON(http.StatusCreated, gin.H{"id": calls})
+	})
+
+	first := postWithKey(r, "abc", `{"name":"a"}`)
+	second := postWithKey(r, "abc", `{"name":"a"}`)
+
+	if calls != 1 {
+		t.Errorf("Expected handler to run once, ran %d times", calls)
+	}
+	if second.Code != http.StatusCreated || second.Body.String() != first.Body.String() {
+		t.Errorf("Expected replay of %d %s, got %d %s", first.Code, first.Body.String(), second.Code, second.Body.String())
+	}
+	if second.Header().Get(IdempotencyReplayedHeader) != "true" {
+		t.Errorf("Expected replayed header on retry")
+	}
+
+	if w := postWithKey(r, "abc", `{"name":"b"}`); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected 422 for key reuse with a different body, got %d", w.Code)
+	}
+}
+
+func TestIdempotencyConflictWhileInFlight(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	r := newIdempotencyTestRouter(newMemoryIdempotencyStore(), func(c *gin.Context) {
+		close(started)
+		<-release
+		c.Status(http.StatusCreated)
+	})
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- postWithKey(r, "abc", "{}") }()
+	<-started
+
+	if w := postWithKey(r, "abc", "{}"); w.Code != http.StatusConflict {
+		t.Errorf("Expected 409 while the first request is in flight, got %d", w.Code)
+	}
+	close(release)
+	if w := <-done; w.Code != http.StatusCreated {
+		t.Errorf("Expected first request to succeed, got %d", w.Code)
+	}
+}
+
+func TestIdempotencyReleasesKeyOnServerError(t *testing.T) {
+	calls := 0
+	r := newIdempotencyTestRouter(newMemoryIdempotencyStore(), func(c *gin.Context) {
+		calls++
+		if calls == 1 {
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		c.Status(http.StatusCreated)
+	})
+
+	postWithKey(r, "abc", "{}")
+	if w := postWithKey(r, "abc", "{}"); w.Code != http.StatusCreated || calls != 2 {
+		t.Errorf("Expected retry after a server error to run the handler again, got %d after %d calls", w.Code, calls)
+	}
+}
+
+func TestIdempotencyReplaysAnonymousRequests(t *testing.T) {
+	calls := 0
+	r := newIdempotencyTestRouter(newMemoryIdempotencyStore(), func(c *gin.Context) {
+		calls++
+		c.JSON(http.StatusCreated, gin.H{"id": calls})
+	})
+
+	first := postAs(r, "", "abc", `{"email":"ana@example.com"}`)
+	second := postAs(r, "", "abc", `{"email":"ana@example.com"}`)
+	if calls != 1 || second.Body.String() != first.Body.String() {
+		t.Errorf("Expected anonymous retry to replay %s, got %s after %d calls", first.Body.String(), second.Body.String(), calls)
+	}
+	if second.Header().Get(IdempotencyReplayedHeader) != "true" {
+		t.Errorf("Expected replayed header on anonymous retry")
+	}
+
+	// Anonymous keys are shared across callers; another body is refused
+	if w := postAs(r, "", "abc", `{"email":"bob@example.com"}`); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected 422 for anonymous key reuse with a different body, got %d", w.Code)
+	}
+	// and a signed-in caller's key lives in its own scope
+	if w := postAs(r, "1", "abc", `{"email":"ana@example.com"}`); calls != 2 || w.Header().Get(IdempotencyReplayedHeader) != "" {
+		t.Errorf("Expected an authenticated request not to replay an anonymous response, ran %d times", calls)
+	}
+}
+
+func TestIdempotencyScopesKeysToUser(t *testing.T) {
+	calls := 0
+	r := newIdempotencyTestRouter(newMemoryIdempotencyStore(), func(c *gin.Context) {
+		calls++
+		c.JSON(http.StatusCreated, gin.H{"id": calls})
+	})
+
+	first := postAs(r, "1", "abc", "{}")
+	other := postAs(r, "2", "abc", "{}")
+	if calls != 2 || other.Body.String() == first.Body.String() {
+		t.Errorf("Expected another user's key not to replay, ran %d times", calls)
+	}
+}
+
+func TestIdempotencyDoesNotStoreNoStoreResponses(t *testing.T) {
+	calls := 0
+	store := newMemoryIdempotencyStore()
+	r := newIdempotencyTestRouter(store, func(c *gin.Context) {
+		calls++
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusCreated, gin.H{"key": "secret"})
+	})
+
+	postWithKey(r, "abc", "{}")
+	second := postWithKey(r, "abc", "{}")
+	if calls != 2 || second.Header().Get(IdempotencyReplayedHeader) != "" {
+		t.Errorf("Expected a no-store response not to be replayed, ran %d times", calls)
+	}
+	if len(store.records) != 0 {
+		t.Errorf("Expected the key to be released, got %d records", len(store.records))
+	}
+}
@@ -0,0 +1,115 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/manuel/make-it-rain/config"
+	"github.com/manuel/make-it-rain/utils"
+)
+
+// routeLimits resolves the body size cap and deadline for a route, keyed by
+// "METHOD /route/:template". Keys are matched case-insensitively because
+// viper lowercases map keys.
+type routeLimits struct {
+	timeout    time.Duration
+	maxBody    int64
+	timeouts   map[string]time.Duration
+	bodyLimits map[string]int64
+}
+
+func newRouteLimits(cfg config.SecurityConfig) *routeLimits {
+	rl := &routeLimits{
+		timeout:    cfg.RequestTimeout,
+		maxBody:    cfg.MaxBodyBytes,
+		timeouts:   make(map[string]time.Duration, len(cfg.RouteTimeouts)),
+		bodyLimits: make(map[string]int64, len(cfg.RouteBodyLimits)),
+	}
+	for route, d := range cfg.RouteTimeouts {
+		rl.timeouts[normalizeRouteKey(route)] = d
+	}
+	for route, n := range cfg.RouteBodyLimits {
+		rl.bodyLimits[normalizeRouteKey(route)] = n
+	}
+	return rl
+}
+
+func normalizeRouteKey(route string) string {
+	return strings.ToLower(strings.Join(strings.Fields(route), " "))
+}
+
+func (rl *routeLimits) forRoute(method, route string) (time.Duration, int64) {
+	key := normalizeRouteKey(method + " " + route)
+	timeout, maxBody := rl.timeout, rl.maxBody
+	if d, ok := rl.timeouts[key]; ok {
+		timeout = d
+	}
+	if n, ok := rl.bodyLimits[key]; ok {
+		maxBody = n
+	}
+	return timeout, maxBody
+}
+
+// RouteLimits caps the request body size and sets a context deadline per
+// route. Oversized bodies are rejected with 413 before the handler runs. The
+// deadline propagates to pgx, so slow queries are cancelled; if the handler
+// returns without responding after the deadline, a 504 is sent.
+func RouteLimits(cfg config.SecurityConfig) gin.HandlerFunc {
+	rl := newRouteLimits(cfg)
+
+	return func(c *gin.Context) {
+		timeout, maxBody := rl.forRoute(c.Request.Method, c.FullPath())
+
+		if !readBody(c, maxBody) {
+			return
+		}
+
+		if timeout > 0 {
+			ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+			defer cancel()
+			c.Request = c.Request.WithContext(ctx)
+		}
+
+		c.Next()
+
+		if !c.Writer.Written() {
+			utils.RespondWithContextError(c, c.Request.Context().Err())
+		}
+	}
+}
+
+// readBody buffers up to maxBody bytes of the request body so the limit is
+// enforced the same way for every handler, whatever it uses to decode.
+func readBody(c *gin.Context, maxBody int64) bool {
+	if c.Request.Body == nil || c.Request.Body == http.NoBody || maxBody <= 0 {
+		return true
+	}
+	if c.Request.ContentLength > maxBody {
+		abortTooLarge(c)
+		return false
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxBody))
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			abortTooLarge(c)
+		} else {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+		}
+		return false
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	return true
+}
+
+func abortTooLarge(c *gin.Context) {
+	c.Header("Connection", "close")
+	c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Request body too large"})
+}
@@ -0,0 +1,120 @@
+package middleware
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/manuel/make-it-rain/config"
+)
+
+func newLimitsTestRouter(cfg config.SecurityConfig) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(SecurityHeaders(cfg))
+	r.Use(RouteLimits(cfg))
+	r.POST("/api/v1/echo", func(c *gin.Context) {
+		body, _ := io.ReadAll(c.Request.Body)
+		c.String(http.StatusOK, string(body))
+	})
+	r.GET("/api/v1/slow", func(c *gin.Context) {
+		<-c.Request.Context().Done()
+	})
+	r.GET("/app", func(c *gin.Context) { c.Status(http.StatusOK) })
+	return r
+}
+
+func testSecurityConfig() config.SecurityConfig {
+	return config.SecurityConfig{
+		HSTSMaxAge:               time.Hour,
+		FrameOptions:             "DENY",
+		ContentSecurityPolicy:    "default-src 'self'",
+		APIContentSecurityPolicy: "default-src 'none'",
+		RequestTimeout:           time.Second,
+		MaxBodyBytes:             16,
+		RouteTimeouts:            map[string]time.Duration{"get /api/v1/slow": 20 * time.Millisecond},
+		RouteBodyLimits:          map[string]int64{"POST /api/v1/echo": 8},
+	}
+}
+
+func TestRouteLimitsRejectsLargeBodies(t *testing.T) {
+	r := newLimitsTestRouter(testSecurityConfig())
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/echo", strings.NewReader("12345678")))
+	if w.Code != http.StatusOK || w.Body.String() != "12345678" {
+		t.Errorf("Expected body within the limit to pass through, got %d %q", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/echo", strings.NewReader("123456789")))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected 413 for oversized body, got %d", w.Code)
+	}
+
+	// Chunked bodies have no Content-Length and are cut off while reading.
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/echo", io.MultiReader(strings.NewReader("12345"), strings.NewReader("67890")))
+	req.ContentLength = -1
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected 413 for oversized chunked body, got %d", w.Code)
+	}
+}
+
+func TestRouteLimitsTimesOut(t *testing.T) {
+	r := newLimitsTestRouter(testSecurityConfig())
+
+	w := httptest.NewRecorder()
+	start := time.Now()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/slow", nil))
+	if w.Code != http.StatusGatewayTimeout {
+		t.Errorf("Expected 504 after the route deadline, got %d", w.Code)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Expected the per-route timeout to apply, took %s", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/slow", nil).WithContext(ctx))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 for a cancelled request, got %d", w.Code)
+	}
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	r := newLimitsTestRouter(testSecurityConfig())
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/app", nil))
+	if got := w.Header().Get("X-Content-Type-Options"); got != "nosniff" {
+		t.Errorf("Expected nosniff, got %q", got)
+	}
+	if got := w.Header().Get("X-Frame-Options"); got != "DENY" {
+		t.Errorf("Expected X-Frame-Options DENY, got %q", got)
+	}
+	if got := w.Header().Get("Content-Security-Policy"); got != "default-src 'self'" {
+		t.Errorf("Expected SPA CSP, got %q", got)
+	}
+	if got := w.Header().Get("Strict-Transport-Security"); got != "" {
+		t.Errorf("Expected no HSTS over plain HTTP, got %q", got)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/echo", strings.NewReader("{}"))
+	req.Header.Set("X-Forwarded-Proto", "https")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if got := w.Header().Get("Content-Security-Policy"); got != "default-src 'none'" {
+		t.Errorf("Expected API CSP, got %q", got)
+	}
+	if got := w.Header().Get("Strict-Transport-Security"); got != "max-age=3600; includeSubDomains" {
+		t.Errorf("Expected HSTS behind a TLS proxy, got %q", got)
+	}
+}
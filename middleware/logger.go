@@ -1,18 +1,65 @@
 package middleware
 
 import (
+	"bytes"
+	"io"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/rs/zerolog/log"
+	"github.com/manuel/make-it-rain/config"
+	"github.com/manuel/make-it-rain/logging"
+	"github.com/manuel/make-it-rain/utils"
+	"github.com/rs/zerolog"
 )
 
+// UserIDKey is the gin context key under which authentication middleware
+// stores the authenticated user's ID.
+const UserIDKey = "user_id"
+
+// maxLoggedBodySize caps how much of a request body is buffered for logging.
+const maxLoggedBodySize = 64 << 10
+
+// Logger writes the access log using the settings in config.Cfg.Log.
 func Logger() gin.HandlerFunc {
+	if config.Cfg != nil {
+		return LoggerWithConfig(config.Cfg.Log)
+	}
+	return LoggerWithConfig(config.DefaultLogConfig)
+}
+
+// LoggerWithConfig writes one access-log line per request. Sensitive query
+// parameters, headers and JSON body fields are redacted. Successful requests
+// are sampled (1 in SuccessSampleEvery) and skipped entirely for SkipPaths;
+// 4xx and 5xx responses are always logged.
+func LoggerWithConfig(cfg config.LogConfig) gin.HandlerFunc {
+	redactor := utils.NewRedactor(cfg.RedactQueryParams, cfg.RedactHeaders, cfg.RedactBodyFields)
+
+	fields := make(map[string]bool, len(cfg.AccessLogFields))
+	for _, f := range cfg.AccessLogFields {
+		fields[strings.TrimSpace(f)] = true
+	}
+
+	skip := make(map[string]bool, len(cfg.SkipPaths))
+	for _, p := range cfg.SkipPaths {
+		skip[p] = true
+	}
+
+	sampleEvery := uint64(max(cfg.SuccessSampleEvery, 1))
+	var successCount atomic.Uint64
+
 	return func(c *gin.Context) {
 		start := time.Now()
 		path := c.Request.URL.Path
 		raw := c.Request.URL.RawQuery
 
+		var body []byte
+		if fields["request_body"] && c.Request.Body != nil && strings.HasPrefix(c.ContentType(), "application/json") {
+			body, _ = io.ReadAll(io.LimitReader(c.Request.Body, maxLoggedBodySize))
+			c.Request.Body = readCloser{io.MultiReader(bytes.NewReader(body), c.Request.Body), c.Request.Body}
+		}
+
 		c.Next()
 
 		latency := time.Since(start)
@@ -20,35 +67,70 @@ func Logger() gin.HandlerFunc {
 		method := c.Request.Method
 		statusCode := c.Writer.Status()
 
+		if statusCode < 400 {
+			if skip[path] {
+				return
+			}
+			if successCount.Add(1)%sampleEvery != 0 {
+				return
+			}
+		}
+
 		if raw != "" {
-			path = path + "?" + raw
+			path = path + "?" + redactor.Query(raw)
 		}
 
+		// The request-scoped logger set by RequestID already carries the
+		// request and trace IDs.
+		logger := logging.Ctx(c.Request.Context(), logging.HTTP)
+
+		var event *zerolog.Event
+		var msg string
 		switch {
 		case statusCode >= 500:
-			log.Error().
-				Str("method", method).
-				Str("path", path).
-				Str("ip", clientIP).
-				Int("status", statusCode).
-				Dur("latency", latency).
-				Msg("Server error")
+			event, msg = logger.Error(), "Server error"
 		case statusCode >= 400:
-			log.Warn().
-				Str("method", method).
-				Str("path", path).
-				Str("ip", clientIP).
-				Int("status", statusCode).
-				Dur("latency", latency).
-				Msg("Client error")
+			event, msg = logger.Warn(), "Client error"
 		default:
-			log.Info().
-				Str("method", method).
-				Str("path", path).
-				Str("ip", clientIP).
-				Int("status", statusCode).
-				Dur("latency", latency).
-				Msg("Request processed")
+			event, msg = logger.Info(), "Request processed"
+		}
+
+		event = event.
+			Str("method", method).
+			Str("path", path).
+			Str("ip", clientIP).
+			Int("status", statusCode).
+			Dur("latency", latency)
+
+		if fields["route"] {
+			event = event.Str("route", c.FullPath())
+		}
+		if fields["user_id"] {
+			if userID, ok := c.Get(UserIDKey); ok {
+				event = event.Interface("user_id", userID)
+			}
 		}
+		if fields["response_size"] {
+			event = event.Int("response_size", max(c.Writer.Size(), 0))
+		}
+		if fields["user_agent"] {
+			event = event.Str("user_agent", c.Request.UserAgent())
+		}
+		if fields["headers"] {
+			event = event.Interface("headers", redactor.Headers(c.Request.Header))
+		}
+		if len(body) > 0 {
+			if redacted := redactor.JSON(body); redacted != nil {
+				event = event.RawJSON("request_body", redacted)
+			}
+		}
+
+		event.Msg(msg)
 	}
-}
\ No newline at end of file
+}
+
+// readCloser re-attaches the original body's Close to a replayed reader.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
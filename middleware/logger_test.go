@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/manuel/make-it-rain/config"
+	"github.com/rs/zerolog"
+)
+
+func newLoggerTestRouter(buf *bytes.Buffer, cfg config.LogConfig) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		logger := zerolog.New(buf)
+		c.Request = c.Request.WithContext(logger.WithContext(c.Request.Context()))
+	})
+	r.Use(LoggerWithConfig(cfg))
+	r.GET("/health", func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.GET("/ok", func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.GET("/fail", func(c *gin.Context) { c.Status(http.StatusInternalServerError) })
+	return r
+}
+
+func TestLoggerRedactsQuery(t *testing.T) {
+	var buf bytes.Buffer
+	r := newLoggerTestRouter(&buf, config.DefaultLogConfig)
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/ok?token=s3cr3t&page=1", nil))
+
+	out := buf.String()
+	if strings.Contains(out, "s3cr3t") {
+		t.Errorf("Expected token to be redacted, got %s", out)
+	}
+	if !strings.Contains(out, `"route":"/ok"`) {
+		t.Errorf("Expected route template field, got %s", out)
+	}
+}
+
+func TestLoggerSkipsAndSamplesSuccesses(t *testing.T) {
+	var buf bytes.Buffer
+	cfg := config.DefaultLogConfig
+	cfg.SuccessSampleEvery = 2
+	r := newLoggerTestRouter(&buf, cfg)
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/health", nil))
+	if buf.Len() != 0 {
+		t.Errorf("Expected /health to be skipped, got %s", buf.String())
+	}
+
+	for i := 0; i < 4; i++ {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/ok", nil))
+	}
+	if n := strings.Count(buf.String(), "\n"); n != 2 {
+		t.Errorf("Expected 2 of 4 successful requests to be logged, got %d", n)
+	}
+
+	buf.Reset()
+	for i := 0; i < 3; i++ {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/fail", nil))
+	}
+	if n := strings.Count(buf.String(), "\n"); n != 3 {
+		t.Errorf("Expected every error to be logged, got %d", n)
+	}
+}
@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/manuel/make-it-rain/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetricsUsesRouteTemplate(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(Metrics())
+	r.GET("/users/:id", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	for _, id := range []string{"1", "2", "3"} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/users/"+id, nil))
+	}
+
+	got := testutil.ToFloat64(metrics.HTTPRequestsTotal.WithLabelValues("GET", "/users/:id", "200"))
+	if got != 3 {
+		t.Errorf("Expected 3 requests for route template, got %v", got)
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/nope", nil))
+
+	got = testutil.ToFloat64(metrics.HTTPRequestsTotal.WithLabelValues("GET", "unmatched", "404"))
+	if got != 1 {
+		t.Errorf("Expected unmatched request to be counted once, got %v", got)
+	}
+}
@@ -0,0 +1,102 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/manuel/make-it-rain/config"
+	"github.com/manuel/make-it-rain/data_structures"
+	"github.com/manuel/make-it-rain/models"
+)
+
+// maxTrackedClients bounds the per-client limiter table; the least recently
+// seen clients are evicted first.
+const maxTrackedClients = 10000
+
+// clientLimiter keeps one token bucket per client, refilled at rps per second
+// with a burst of rps. Clients with their own rate, such as API keys, pass
+// it to Allow.
+type clientLimiter struct {
+	rps     int
+	buckets *data_structures.LRUCache[string, *data_structures.TokenBucket]
+	mu      sync.Mutex
+}
+
+func newClientLimiter(rps int) *clientLimiter {
+	return &clientLimiter{
+		rps:     rps,
+		buckets: data_structures.NewLRUCache[string, *data_structures.TokenBucket](maxTrackedClients),
+	}
+}
+
+// Allow uses rps for a new bucket; callers put the rate in key so a changed
+// rate gets a fresh bucket.
+func (cl *clientLimiter) Allow(key string, rps int) bool {
+	cl.mu.Lock()
+	bucket, ok := cl.buckets.Get(key)
+	if !ok {
+		bucket = data_structures.NewTokenBucket(rps, rps, time.Second)
+		cl.buckets.Put(key, bucket)
+	}
+	cl.mu.Unlock()
+
+	return bucket.Allow()
+}
+
+// APIKeyCache returns API keys resolved recently, without a database query;
+// *auth.APIKeys implements it.
+type APIKeyCache interface {
+	Cached(key string) (*models.APIKey, bool)
+}
+
+// RateLimit limits each client IP to APP_RATE_LIMIT_RPS requests per second.
+// The limit follows config reloads; buckets are reset when it changes.
+//
+// Requests bearing an API key are limited per key instead, at the rate of
+// the key's tier in cfg.Tiers. Only keys already in the cache count: a key's
+// first request is limited by IP, so made-up keys can't skip the IP limit
+// or cost a query before it.
+func RateLimit(keys APIKeyCache, cfg config.APIKeysConfig) gin.HandlerFunc {
+	var mu sync.RWMutex
+	limiter := newClientLimiter(config.Current().App.RateLimitRPS)
+
+	config.OnChange(func(old, new *config.Config) {
+		if old.App.RateLimitRPS == new.App.RateLimitRPS {
+			return
+		}
+		mu.Lock()
+		limiter = newClientLimiter(new.App.RateLimitRPS)
+		mu.Unlock()
+	})
+
+	return func(c *gin.Context) {
+		mu.RLock()
+		l := limiter
+		mu.RUnlock()
+
+		client, rps := "ip:"+c.ClientIP(), l.rps
+		if token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); ok && keys != nil {
+			if key, ok := keys.Cached(token); ok {
+				if tierRPS, ok := cfg.Tiers[key.Tier]; ok {
+					rps = tierRPS
+				}
+				client = fmt.Sprintf("key:%d:%d", key.ID, rps)
+			}
+		}
+
+		c.Header("X-RateLimit-Limit", strconv.Itoa(rps))
+
+		if !l.Allow(client, rps) {
+			c.Header("Retry-After", "1")
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "Rate limit exceeded"})
+			return
+		}
+
+		c.Next()
+	}
+}
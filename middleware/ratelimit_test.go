@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/manuel/make-it-rain/config"
+)
+
+func TestRateLimitUsesAPIKeyTier(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	saved := config.Cfg
+	config.Cfg = &config.Config{App: config.AppConfig{RateLimitRPS: 1}}
+	defer func() { config.Cfg = saved }()
+
+	keys := staticKeys{"mir_batch": {ID: 1, UserID: 42, Tier: "batch"}}
+	r := gin.New()
+	r.Use(RateLimit(keys, config.APIKeysConfig{Tiers: map[string]int{"standard": 1, "batch": 3}}))
+	r.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	send := func(bearer string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if bearer != "" {
+			req.Header.Set("Authorization", "Bearer "+bearer)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	for i := 0; i < 3; i++ {
+		if w := send("mir_batch"); w.Code != http.StatusOK || w.Header().Get("X-RateLimit-Limit") != "3" {
+			t.Fatalf("Request %d with the batch key: expected 200 at limit 3, got %d at %s", i+1, w.Code, w.Header().Get("X-RateLimit-Limit"))
+		}
+	}
+	if w := send("mir_batch"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected the batch key to be limited after 3 requests, got %d", w.Code)
+	}
+
+	// The key has its own bucket, so the client IP still has its allowance,
+	// and keys that haven't been resolved share the IP's bucket
+	if w := send(""); w.Code != http.StatusOK {
+		t.Errorf("Expected the IP to be unaffected by the key, got %d", w.Code)
+	}
+	if w := send("mir_unknown"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected an unknown key to be limited by IP, got %d", w.Code)
+	}
+}
@@ -4,14 +4,14 @@ import (
 	"net/http"
 
 	"github.com/gin-gonic/gin"
-	"github.com/rs/zerolog/log"
+	"github.com/manuel/make-it-rain/logging"
 )
 
 func Recovery() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		defer func() {
 			if err := recover(); err != nil {
-				log.Error().
+				logging.Ctx(c.Request.Context(), logging.HTTP).Error().
 					Interface("error", err).
 					Str("path", c.Request.URL.Path).
 					Msg("Panic recovered")
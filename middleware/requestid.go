@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/gin-gonic/gin"
+	"github.com/manuel/make-it-rain/logging"
+	"github.com/manuel/make-it-rain/tracing"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	RequestIDHeader = "X-Request-ID"
+	RequestIDKey    = "request_id"
+
+	maxRequestIDLength = 128
+)
+
+// RequestID accepts a well-formed X-Request-ID from the client or generates a
+// new one, echoes it in the response and stores a child logger carrying the
+// ID (and trace ID, when tracing is active) in the request context. Code
+// further down the chain logs through zerolog.Ctx(ctx).
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+
+		c.Set(RequestIDKey, id)
+		c.Header(RequestIDHeader, id)
+
+		ctx := c.Request.Context()
+		logCtx := log.With().Str(RequestIDKey, id)
+		if traceID, spanID := tracing.IDs(ctx); traceID != "" {
+			logCtx = logCtx.Str("trace_id", traceID).Str("span_id", spanID)
+			trace.SpanFromContext(ctx).SetAttributes(attribute.String("request.id", id))
+		}
+		// Untagged lines follow the default level, which may be changed at
+		// runtime; logging.Ctx overrides it per component.
+		logger := logCtx.Logger().Level(logging.Levels.Level(logging.Default))
+
+		c.Request = c.Request.WithContext(logger.WithContext(ctx))
+
+		c.Next()
+	}
+}
+
+// GetRequestID returns the request ID assigned by the RequestID middleware.
+func GetRequestID(c *gin.Context) string {
+	return c.GetString(RequestIDKey)
+}
+
+// validRequestID only accepts short, printable IDs without separators so a
+// client can't inject fields or newlines into log lines.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '-', r == '_', r == '.', r == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
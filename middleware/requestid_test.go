@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+)
+
+func TestRequestIDEchoesValidHeader(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(RequestID())
+
+	var ctxLogger *zerolog.Logger
+	r.GET("/", func(c *gin.Context) {
+		ctxLogger = zerolog.Ctx(c.Request.Context())
+		c.Status(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(RequestIDHeader, "abc-123")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if got := w.Header().Get(RequestIDHeader); got != "abc-123" {
+		t.Errorf("Expected request ID abc-123 to be echoed, got %q", got)
+	}
+	if ctxLogger == nil || ctxLogger.GetLevel() == zerolog.Disabled {
+		t.Error("Expected a request-scoped logger in the context")
+	}
+}
+
+func TestRequestIDReplacesInvalidHeader(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(RequestID())
+	r.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	for _, bad := range []string{"", "has space", "line\nbreak"} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(RequestIDHeader, bad)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		got := w.Header().Get(RequestIDHeader)
+		if got == "" || got == bad {
+			t.Errorf("Expected a generated request ID for %q, got %q", bad, got)
+		}
+	}
+}
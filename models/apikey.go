@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+// Scopes an API key can be granted. Keys only reach routes that accept one
+// of their scopes; account management always needs a login session.
+const (
+	ScopeUsersRead = "users:read"
+)
+
+var APIKeyScopes = []string{ScopeUsersRead}
+
+// APIKey is a personal key for non-interactive access on behalf of a user.
+// Only a hash of the key is stored; Prefix is kept so the user can tell
+// their keys apart.
+type APIKey struct {
+	ID      int64    `json:"id"`
+	UserID  int64    `json:"user_id"`
+	Name    string   `json:"name"`
+	Prefix  string   `json:"prefix"`
+	KeyHash string   `json:"-"`
+	Scopes  []string `json:"scopes"`
+	// Tier selects the key's rate limit from auth.api_keys.tiers
+	Tier       string     `json:"tier"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
\ No newline at end of file
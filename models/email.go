@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+const (
+	EmailSent   = "sent"
+	EmailFailed = "failed"
+)
+
+// SentEmail is one delivery attempt in the outgoing mail log. Bodies are not
+// kept since they may contain single-use links.
+type SentEmail struct {
+	ID         int64     `json:"id"`
+	JobID      *int64    `json:"job_id,omitempty"`
+	Recipients []string  `json:"recipients"`
+	Subject    string    `json:"subject"`
+	Template   string    `json:"template"`
+	Locale     string    `json:"locale"`
+	MessageID  string    `json:"message_id"`
+	Status     string    `json:"status"`
+	Error      string    `json:"error,omitempty"`
+	Attempt    int       `json:"attempt"`
+	CreatedAt  time.Time `json:"created_at"`
+}
\ No newline at end of file
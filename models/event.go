@@ -0,0 +1,42 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	EventUserCreated = "user.created"
+	EventUserUpdated = "user.updated"
+	EventUserDeleted = "user.deleted"
+	// EventUserLocked and EventUserUnlocked audit login lockouts; the
+	// payload is a LoginLock
+	EventUserLocked   = "user.locked"
+	EventUserUnlocked = "user.unlocked"
+
+	AggregateUser = "user"
+)
+
+// EventTypes lists every event type, e.g. for validating webhook filters.
+var EventTypes = []string{EventUserCreated, EventUserUpdated, EventUserDeleted, EventUserLocked, EventUserUnlocked}
+
+const (
+	EventPending   = "pending"
+	EventDelivered = "delivered"
+	EventDead      = "dead"
+)
+
+// Event is a domain event recorded in the outbox.
+type Event struct {
+	ID            int64           `json:"id"`
+	AggregateType string          `json:"aggregate_type"`
+	AggregateID   string          `json:"aggregate_id"`
+	Type          string          `json:"type"`
+	Payload       json.RawMessage `json:"payload"`
+	Status        string          `json:"status"`
+	Attempts      int             `json:"attempts"`
+	LastError     string          `json:"last_error,omitempty"`
+	AvailableAt   time.Time       `json:"available_at"`
+	CreatedAt     time.Time       `json:"created_at"`
+	DeliveredAt   *time.Time      `json:"delivered_at,omitempty"`
+}
\ No newline at end of file
@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// UserIdentity links a user to their account at an external OpenID Connect
+// provider, so later logins find the user even if the email changes.
+type UserIdentity struct {
+	ID       int64  `json:"id"`
+	UserID   int64  `json:"user_id"`
+	Provider string `json:"provider"`
+	// Subject is the provider's stable ID for the account
+	Subject string `json:"subject"`
+	// Email is the address the provider last reported
+	Email       string     `json:"email"`
+	LastLoginAt *time.Time `json:"last_login_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// OIDCLogin is a login sent to a provider and not finished yet. Only the
+// hash of its state is stored; the code verifier and nonce are checked when
+// the user comes back.
+type OIDCLogin struct {
+	ID           int64
+	Provider     string
+	StateHash    string
+	CodeVerifier string
+	Nonce        string
+	ExpiresAt    time.Time
+	CreatedAt    time.Time
+}
\ No newline at end of file
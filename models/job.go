@@ -0,0 +1,41 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobDead      = "dead"
+	JobCancelled = "cancelled"
+)
+
+// Job priorities follow data_structures.PriorityQueue: lower values run first.
+const (
+	JobPriorityCritical = 0
+	JobPriorityHigh     = 10
+	JobPriorityDefault  = 50
+	JobPriorityLow      = 100
+)
+
+// Job is a unit of background work persisted in the jobs table. The payload
+// may hold secrets, such as the links in queued emails, so it is never
+// encoded for API clients.
+type Job struct {
+	ID          int64           `json:"id"`
+	Type        string          `json:"type"`
+	Payload     json.RawMessage `json:"-"`
+	Priority    int             `json:"priority"`
+	Status      string          `json:"status"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"max_attempts"`
+	RunAt       time.Time       `json:"run_at"`
+	LastError   string          `json:"last_error,omitempty"`
+	LockedBy    string          `json:"locked_by,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+	StartedAt   *time.Time      `json:"started_at,omitempty"`
+	FinishedAt  *time.Time      `json:"finished_at,omitempty"`
+}
\ No newline at end of file
@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// LoginLock is a login key, "account:<email>" or "ip:<address>", that
+// failed too often and is blocked until LockedUntil.
+type LoginLock struct {
+	Key         string    `json:"key"`
+	Failures    int       `json:"failures"`
+	LockedUntil time.Time `json:"locked_until"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
\ No newline at end of file
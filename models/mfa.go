@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// MFASecret is a user's TOTP authenticator. It is pending until the user
+// confirms it with a first code.
+type MFASecret struct {
+	UserID int64 `json:"user_id"`
+	// Secret is encrypted with auth.SecretBox
+	Secret      []byte     `json:"-"`
+	ConfirmedAt *time.Time `json:"confirmed_at,omitempty"`
+	// LastUsedStep is the TOTP time step of the last accepted code, so a
+	// code can't be used twice
+	LastUsedStep int64     `json:"-"`
+	CreatedAt    time.Time `json:"created_at"`
+}
\ No newline at end of file
@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+const (
+	TaskSucceeded = "succeeded"
+	TaskFailed    = "failed"
+)
+
+// ScheduledTask records the most recent run of a recurring task.
+type ScheduledTask struct {
+	Name           string     `json:"name"`
+	Schedule       string     `json:"schedule"`
+	LastStartedAt  *time.Time `json:"last_started_at,omitempty"`
+	LastFinishedAt *time.Time `json:"last_finished_at,omitempty"`
+	LastStatus     string     `json:"last_status,omitempty"`
+	LastError      string     `json:"last_error,omitempty"`
+	LastDurationMS int64      `json:"last_duration_ms"`
+	LastRunBy      string     `json:"last_run_by,omitempty"`
+	NextRunAt      *time.Time `json:"next_run_at,omitempty"`
+}
\ No newline at end of file
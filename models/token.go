@@ -0,0 +1,41 @@
+package models
+
+import "time"
+
+// Purposes of a UserToken.
+const (
+	TokenVerifyEmail   = "verify_email"
+	TokenResetPassword = "reset_password"
+	// TokenMFAChallenge is handed out after a correct password when a
+	// second factor is still needed; it isn't emailed
+	TokenMFAChallenge = "mfa_challenge"
+)
+
+// UserToken is a single-use token emailed to a user, e.g. to verify an
+// address. Only its hash is stored.
+type UserToken struct {
+	ID        int64  `json:"id"`
+	UserID    int64  `json:"user_id"`
+	Purpose   string `json:"purpose"`
+	TokenHash string `json:"-"`
+	// Email is the address the token was sent to
+	Email     string     `json:"email"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// RefreshToken is a login session. The token is rotated on every refresh, so
+// the hash changes while the ID stays the same.
+type RefreshToken struct {
+	ID        int64  `json:"id"`
+	UserID    int64  `json:"user_id"`
+	TokenHash string `json:"-"`
+	// UserAgent and IP are of the client that last logged in or refreshed
+	UserAgent  string     `json:"user_agent"`
+	IP         string     `json:"ip"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
\ No newline at end of file
@@ -2,12 +2,27 @@ package models
 
 import "time"
 
+// Roles a user can have. auth.mfa.required_roles lists the ones that must
+// use a second factor.
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+var Roles = []string{RoleUser, RoleAdmin}
+
 type User struct {
-	ID        int64     `json:"id"`
-	Email     string    `json:"email"`
-	Name      string    `json:"name"`
-	Password  string    `json:"-"`
-	IsActive  bool      `json:"is_active"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID       int64  `json:"id"`
+	Email    string `json:"email"`
+	Name     string `json:"name"`
+	Password string `json:"-"`
+	IsActive bool   `json:"is_active"`
+	// Locale is the preferred language for email, e.g. "es"
+	Locale          string     `json:"locale"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	Role            string     `json:"role"`
+	// MFAEnabledAt is set once the user has confirmed a TOTP authenticator
+	MFAEnabledAt *time.Time `json:"mfa_enabled_at"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
 }
\ No newline at end of file
@@ -0,0 +1,196 @@
+// Package pubsub is a small publish/subscribe layer over Postgres
+// LISTEN/NOTIFY, used to fan out change events between instances without
+// extra infrastructure.
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/manuel/make-it-rain/logging"
+)
+
+const (
+	minBackoff = 500 * time.Millisecond
+	maxBackoff = 30 * time.Second
+)
+
+// Message is a notification received on a channel.
+type Message struct {
+	Channel string
+	Payload string
+}
+
+// Decode unmarshals a JSON payload.
+func (m Message) Decode(v interface{}) error {
+	return json.Unmarshal([]byte(m.Payload), v)
+}
+
+type Handler func(ctx context.Context, msg Message)
+
+// Execer is satisfied by *pgxpool.Pool, *pgx.Conn and pgx.Tx. Publishing
+// inside a transaction delivers the notification only if it commits.
+type Execer interface {
+	Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error)
+}
+
+// Publish sends payload, encoded as JSON, to every listener on channel.
+func Publish(ctx context.Context, db Execer, channel string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode notification: %w", err)
+	}
+	if _, err := db.Exec(ctx, "SELECT pg_notify($1, $2)", channel, string(data)); err != nil {
+		return fmt.Errorf("failed to publish to %s: %w", channel, err)
+	}
+	return nil
+}
+
+// Connector opens the dedicated connection a Listener holds.
+type Connector func(ctx context.Context) (*pgx.Conn, error)
+
+// PoolConnector takes a connection out of pool for listening, so it gets the
+// pool's connection settings (including credential rotation) without
+// counting against its size.
+func PoolConnector(pool *pgxpool.Pool) Connector {
+	return func(ctx context.Context) (*pgx.Conn, error) {
+		c, err := pool.Acquire(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return c.Hijack(), nil
+	}
+}
+
+// Listener delivers notifications to subscribed handlers. When the
+// connection drops it reconnects with exponential backoff and listens on
+// every channel again.
+type Listener struct {
+	connect Connector
+
+	mu        sync.RWMutex
+	handlers  map[string][]Handler
+	onConnect []func(ctx context.Context)
+}
+
+func NewListener(connect Connector) *Listener {
+	return &Listener{
+		connect:  connect,
+		handlers: make(map[string][]Handler),
+	}
+}
+
+// Subscribe registers a handler for channel. Channels subscribed after Run
+// has started are picked up on the next reconnect.
+func (l *Listener) Subscribe(channel string, h Handler) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.handlers[channel] = append(l.handlers[channel], h)
+}
+
+// OnConnect registers fn to run every time listening starts, including after
+// a reconnect. Notifications sent while disconnected are lost, so subscribers
+// that keep derived state (like caches) should resynchronise here.
+func (l *Listener) OnConnect(fn func(ctx context.Context)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.onConnect = append(l.onConnect, fn)
+}
+
+// Run listens until ctx is cancelled.
+func (l *Listener) Run(ctx context.Context) {
+	logger := logging.Ctx(ctx, logging.DB)
+	backoff := minBackoff
+	connected := false
+
+	for ctx.Err() == nil {
+		err := l.listen(ctx, func() {
+			if connected {
+				logger.Info().Msg("Notification listener reconnected")
+			}
+			connected = true
+			backoff = minBackoff
+
+			l.mu.RLock()
+			hooks := append([]func(context.Context){}, l.onConnect...)
+			l.mu.RUnlock()
+			for _, fn := range hooks {
+				fn(ctx)
+			}
+		})
+		if ctx.Err() != nil {
+			return
+		}
+
+		wait := jitter(backoff)
+		logger.Warn().Err(err).Dur("retry_in", wait).Msg("Notification listener disconnected")
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return
+		}
+		backoff = nextBackoff(backoff)
+	}
+}
+
+// listen runs one connection's lifetime; ready is called once every
+// channel is being listened on.
+func (l *Listener) listen(ctx context.Context, ready func()) error {
+	conn, err := l.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.WithoutCancel(ctx))
+
+	l.mu.RLock()
+	channels := make([]string, 0, len(l.handlers))
+	for channel := range l.handlers {
+		channels = append(channels, channel)
+	}
+	l.mu.RUnlock()
+
+	for _, channel := range channels {
+		if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{channel}.Sanitize()); err != nil {
+			return fmt.Errorf("failed to listen on %s: %w", channel, err)
+		}
+	}
+	ready()
+
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		l.dispatch(ctx, Message{Channel: n.Channel, Payload: n.Payload})
+	}
+}
+
+func (l *Listener) dispatch(ctx context.Context, msg Message) {
+	l.mu.RLock()
+	handlers := l.handlers[msg.Channel]
+	l.mu.RUnlock()
+
+	for _, h := range handlers {
+		h(ctx, msg)
+	}
+}
+
+func nextBackoff(d time.Duration) time.Duration {
+	d *= 2
+	if d > maxBackoff {
+		return maxBackoff
+	}
+	return d
+}
+
+// jitter spreads reconnects from several instances over [d/2, d).
+func jitter(d time.Duration) time.Duration {
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)))
+}
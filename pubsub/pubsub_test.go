@@ -0,0 +1,41 @@
+package pubsub
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBackoffIsBoundedAndJittered(t *testing.T) {
+	d := minBackoff
+	for i := 0; i < 20; i++ {
+		d = nextBackoff(d)
+	}
+	if d != maxBackoff {
+		t.Errorf("Expected backoff to cap at %s, got %s", maxBackoff, d)
+	}
+
+	for i := 0; i < 100; i++ {
+		if j := jitter(time.Second); j < 500*time.Millisecond || j >= time.Second {
+			t.Errorf("Expected jitter within [500ms, 1s), got %s", j)
+		}
+	}
+}
+
+func TestListenerDispatchesByChannel(t *testing.T) {
+	l := NewListener(nil)
+	var got []string
+	l.Subscribe("a", func(ctx context.Context, msg Message) { got = append(got, "a:"+msg.Payload) })
+	l.Subscribe("b", func(ctx context.Context, msg Message) { got = append(got, "b:"+msg.Payload) })
+
+	l.dispatch(context.Background(), Message{Channel: "a", Payload: `{"x":1}`})
+
+	if len(got) != 1 || got[0] != `a:{"x":1}` {
+		t.Errorf("Expected only the a handler to run, got %v", got)
+	}
+
+	var v struct{ X int }
+	if err := (Message{Payload: `{"x":1}`}).Decode(&v); err != nil || v.X != 1 {
+		t.Errorf("Expected payload to decode, got %+v, %v", v, err)
+	}
+}
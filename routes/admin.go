@@ -0,0 +1,62 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/manuel/make-it-rain/config"
+	"github.com/manuel/make-it-rain/controllers"
+	"github.com/manuel/make-it-rain/metrics"
+	"github.com/manuel/make-it-rain/middleware"
+)
+
+// SetupAdminRoutes registers operational endpoints on the admin router. The
+// admin router listens on its own port so it can be kept off the public network.
+func SetupAdminRoutes(r *gin.Engine) {
+	r.Use(middleware.RequestID())
+	r.Use(middleware.Recovery())
+
+	r.GET("/metrics", gin.WrapH(metrics.Handler()))
+	r.GET("/health", HealthCheck)
+
+	admin := r.Group("/admin", middleware.AdminAuth(config.Cfg.Admin.Token))
+	{
+		admin.GET("/log-level", controllers.GetLogLevels)
+		admin.PUT("/log-level", controllers.SetLogLevel)
+		admin.DELETE("/log-level", controllers.ResetLogLevels)
+
+		admin.GET("/outbox", controllers.ListOutboxEvents)
+		admin.POST("/outbox/:id/requeue", controllers.RequeueOutboxEvent)
+
+		admin.GET("/jobs", controllers.ListJobs)
+		admin.GET("/jobs/:id", controllers.GetJob)
+		admin.POST("/jobs/:id/retry", controllers.RetryJob)
+		admin.POST("/jobs/:id/cancel", controllers.CancelJob)
+
+		admin.GET("/scheduled-tasks", controllers.ListScheduledTasks)
+
+		admin.GET("/emails", controllers.ListSentEmails)
+		admin.POST("/emails/test", controllers.SendTestEmail)
+
+		admin.GET("/login-locks", controllers.ListLoginLocks)
+		admin.DELETE("/login-locks", controllers.DeleteLoginLock)
+		admin.POST("/users/:id/unlock", controllers.UnlockUser)
+		admin.PUT("/users/:id/role", controllers.SetUserRole)
+		admin.DELETE("/users/:id/mfa", controllers.ResetUserMFA)
+		admin.GET("/users/:id/sessions", controllers.ListUserSessions)
+		admin.DELETE("/users/:id/sessions", controllers.RevokeUserSessions)
+
+		admin.GET("/api-keys", controllers.ListAllAPIKeys)
+		admin.DELETE("/api-keys/:id", controllers.AdminRevokeAPIKey)
+		admin.PUT("/api-keys/:id/tier", controllers.SetAPIKeyTier)
+
+		webhooks := admin.Group("/webhooks")
+		{
+			webhooks.POST("", controllers.CreateWebhook)
+			webhooks.GET("", controllers.GetWebhooks)
+			webhooks.GET("/:id", controllers.GetWebhook)
+			webhooks.PUT("/:id", controllers.UpdateWebhook)
+			webhooks.DELETE("/:id", controllers.DeleteWebhook)
+			webhooks.GET("/:id/deliveries", controllers.GetWebhookDeliveries)
+			webhooks.POST("/deliveries/:id/redeliver", controllers.RedeliverWebhook)
+		}
+	}
+}
@@ -4,27 +4,85 @@ import (
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/manuel/make-it-rain/auth"
+	"github.com/manuel/make-it-rain/config"
 	"github.com/manuel/make-it-rain/controllers"
+	"github.com/manuel/make-it-rain/db"
 	"github.com/manuel/make-it-rain/middleware"
+	"github.com/manuel/make-it-rain/models"
 )
 
-func SetupRoutes(r *gin.Engine) {
+// SetupRoutes registers the public API. sessions checks that access tokens
+// come from live sessions; apiKeys resolves personal API keys for
+// authentication and per-key rate limits.
+func SetupRoutes(r *gin.Engine, sessions *auth.Sessions, apiKeys *auth.APIKeys) {
+	r.Use(middleware.Tracing())
+	r.Use(middleware.RequestID())
 	r.Use(middleware.Logger())
+	r.Use(middleware.Metrics())
 	r.Use(middleware.Recovery())
+	r.Use(middleware.SecurityHeaders(config.Cfg.Security))
 	r.Use(middleware.CORS())
+	r.Use(middleware.RateLimit(apiKeys, config.Cfg.Auth.APIKeys))
+	r.Use(middleware.RouteLimits(config.Cfg.Security))
 
 	r.GET("/health", HealthCheck)
 	r.GET("/ready", ReadinessCheck)
 
+	requireUser := middleware.Auth(auth.NewSigner(config.Cfg.JWT), sessions, apiKeys)
+	// Account management can't be done with an API key
+	requireSession := []gin.HandlerFunc{requireUser, middleware.RequireSession()}
+	// Idempotency keys are scoped to the user, so the middleware goes after
+	// authentication
+	idempotent := middleware.Idempotency(db.NewIdempotencyStore(), config.Cfg.Idempotency)
+	// Responses holding tokens, keys or MFA secrets are never stored
+	noStore := middleware.NoStore()
+
 	api := r.Group("/api/v1")
 	{
+		authRoutes := api.Group("/auth", noStore)
+		{
+			authRoutes.POST("/login", controllers.Login)
+			authRoutes.POST("/refresh", controllers.RefreshToken)
+			authRoutes.POST("/logout", controllers.Logout)
+			authRoutes.POST("/verify-email", controllers.VerifyEmail)
+			authRoutes.POST("/verify-email/resend", controllers.ResendVerification)
+			authRoutes.POST("/password/forgot", controllers.ForgotPassword)
+			authRoutes.POST("/password/reset", controllers.ResetPassword)
+			authRoutes.POST("/mfa/verify", controllers.VerifyMFA)
+			authRoutes.POST("/mfa/enroll", controllers.EnrollMFA)
+			authRoutes.GET("/oidc", controllers.ListOIDCProviders)
+			authRoutes.POST("/oidc/:provider/login", controllers.StartOIDCLogin)
+			authRoutes.POST("/oidc/:provider/callback", controllers.FinishOIDCLogin)
+		}
+
 		users := api.Group("/users")
 		{
-			users.POST("", controllers.CreateUser)
-			users.GET("/:id", controllers.GetUser)
-			users.GET("", controllers.GetUsers)
-			users.PUT("/:id", controllers.UpdateUser)
-			users.DELETE("/:id", controllers.DeleteUser)
+			users.POST("", idempotent, controllers.CreateUser)
+			users.GET("/me", requireUser, middleware.RequireScope(models.ScopeUsersRead), controllers.GetCurrentUser)
+
+			account := users.Group("/me", requireSession...)
+			account.Use(idempotent)
+			{
+				account.POST("/password", noStore, controllers.ChangePassword)
+				account.POST("/mfa", noStore, controllers.StartMFAEnrollment)
+				account.POST("/mfa/confirm", noStore, controllers.ConfirmMFA)
+				account.DELETE("/mfa", controllers.DisableMFA)
+				account.POST("/mfa/recovery-codes", noStore, controllers.RegenerateRecoveryCodes)
+				account.POST("/api-keys", noStore, controllers.CreateAPIKey)
+				account.GET("/api-keys", controllers.ListAPIKeys)
+				account.DELETE("/api-keys/:id", controllers.RevokeAPIKey)
+				account.GET("/sessions", controllers.ListSessions)
+				account.DELETE("/sessions", controllers.RevokeAllSessions)
+				account.DELETE("/sessions/:id", controllers.RevokeSession)
+				account.GET("/identities", controllers.ListIdentities)
+				account.DELETE("/identities/:id", controllers.UnlinkIdentity)
+			}
+			// Users can read and change their own account; admins any account
+			users.GET("/:id", requireUser, middleware.RequireScope(models.ScopeUsersRead), controllers.GetUser)
+			users.GET("", requireUser, middleware.RequireScope(models.ScopeUsersRead), controllers.GetUsers)
+			users.PUT("/:id", requireUser, middleware.RequireSession(), controllers.UpdateUser)
+			users.DELETE("/:id", requireUser, middleware.RequireSession(), controllers.DeleteUser)
 		}
 	}
 
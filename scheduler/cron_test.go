@@ -0,0 +1,70 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronNext(t *testing.T) {
+	from := time.Date(2024, time.January, 31, 10, 7, 30, 0, time.UTC) // a Wednesday
+
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2024, time.January, 31, 10, 8, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, time.January, 31, 10, 15, 0, 0, time.UTC)},
+		{"5/20 * * * *", time.Date(2024, time.January, 31, 10, 25, 0, 0, time.UTC)},
+		{"0 3 * * *", time.Date(2024, time.February, 1, 3, 0, 0, 0, time.UTC)},
+		{"30 9-17/4 * * *", time.Date(2024, time.January, 31, 13, 30, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 feb *", time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 12 * * mon-fri", time.Date(2024, time.January, 31, 12, 0, 0, 0, time.UTC)},
+		{"0 12 * * 7", time.Date(2024, time.February, 4, 12, 0, 0, 0, time.UTC)},
+		{"0 0 * * 0,6", time.Date(2024, time.February, 3, 0, 0, 0, 0, time.UTC)},
+		// Both day fields restricted: the 15th or any Monday.
+		{"0 0 15 * 1", time.Date(2024, time.February, 5, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2024, time.January, 31, 11, 0, 0, 0, time.UTC)},
+		{"@weekly", time.Date(2024, time.February, 4, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		s, err := ParseCron(tt.expr)
+		if err != nil {
+			t.Errorf("ParseCron(%q) failed: %v", tt.expr, err)
+			continue
+		}
+		if got := s.Next(from); !got.Equal(tt.want) {
+			t.Errorf("Next(%q): expected %s, got %s", tt.expr, tt.want, got)
+		}
+	}
+}
+
+func TestParseCronRejectsInvalidExpressions(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"10-5 * * * *",
+		"a * * * *",
+	} {
+		if _, err := ParseCron(expr); err == nil {
+			t.Errorf("Expected ParseCron(%q) to fail", expr)
+		}
+	}
+}
+
+func TestNextReturnsZeroForImpossibleDates(t *testing.T) {
+	s, err := ParseCron("0 0 30 feb *")
+	if err != nil {
+		t.Fatalf("ParseCron failed: %v", err)
+	}
+	if got := s.Next(time.Now()); !got.IsZero() {
+		t.Errorf("Expected no next run, got %s", got)
+	}
+}
@@ -0,0 +1,304 @@
+// Package scheduler runs recurring maintenance tasks on cron schedules. One
+// replica at a time is the leader, elected with a Postgres advisory lock, and
+// only the leader runs tasks.
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/manuel/make-it-rain/config"
+	"github.com/manuel/make-it-rain/data_structures"
+	"github.com/manuel/make-it-rain/db"
+	"github.com/manuel/make-it-rain/logging"
+	"github.com/manuel/make-it-rain/metrics"
+	"github.com/manuel/make-it-rain/models"
+)
+
+// Disabled as a schedule override in scheduler.tasks turns a task off.
+const Disabled = "off"
+
+// Task is a recurring piece of work. Schedule is its default cron
+// expression, which scheduler.tasks can override by name.
+type Task struct {
+	Name     string
+	Schedule string
+	Run      func(ctx context.Context) error
+}
+
+// Locker elects the leader; *db.AdvisoryLock implements it. TryAcquire is
+// called periodically and must keep reporting true while the lock is held.
+type Locker interface {
+	TryAcquire(ctx context.Context) (bool, error)
+	Release(ctx context.Context) error
+}
+
+type entry struct {
+	task     Task
+	expr     string
+	schedule Schedule
+	next     time.Time
+}
+
+// Scheduler keeps registered tasks in a heap ordered by next run time and
+// runs each one when it comes due. A run still going when the task is due
+// again is not overlapped; that occurrence is skipped.
+type Scheduler struct {
+	lock   Locker
+	store  db.ScheduleStore
+	cfg    config.SchedulerConfig
+	worker string
+	now    func() time.Time
+
+	entries []*entry
+	queue   *data_structures.Heap[*entry]
+	leader  atomic.Bool
+
+	mu      sync.Mutex
+	running map[string]bool
+	wg      sync.WaitGroup
+	// taskCtx is the parent of every run; abort cancels it when Stop runs
+	// out of time.
+	taskCtx context.Context
+	abort   context.CancelFunc
+
+	stop context.CancelFunc
+	done chan struct{}
+}
+
+func New(lock Locker, store db.ScheduleStore, cfg config.SchedulerConfig) *Scheduler {
+	host, _ := os.Hostname()
+	taskCtx, abort := context.WithCancel(context.Background())
+	return &Scheduler{
+		lock:   lock,
+		store:  store,
+		cfg:    cfg,
+		worker: fmt.Sprintf("%s-%d", host, os.Getpid()),
+		now:    time.Now,
+		queue: data_structures.NewHeapWithComparator(func(a, b *entry) bool {
+			return a.next.Before(b.next)
+		}),
+		running: make(map[string]bool),
+		taskCtx: taskCtx,
+		abort:   abort,
+	}
+}
+
+// Register adds a task. It must be called before Start and fails if the
+// task's schedule doesn't parse.
+func (s *Scheduler) Register(t Task) error {
+	expr := t.Schedule
+	if override, ok := s.cfg.Tasks[t.Name]; ok {
+		expr = override
+	}
+	if expr == Disabled {
+		logging.Ctx(context.Background(), logging.Services).Info().Str("task", t.Name).Msg("Scheduled task disabled")
+		return nil
+	}
+
+	schedule, err := ParseCron(expr)
+	if err != nil {
+		return fmt.Errorf("task %s: %w", t.Name, err)
+	}
+	s.entries = append(s.entries, &entry{task: t, expr: expr, schedule: schedule})
+	return nil
+}
+
+// IsLeader reports whether this instance currently runs the tasks.
+func (s *Scheduler) IsLeader() bool {
+	return s.leader.Load()
+}
+
+// Start runs the scheduling loop in the background until Stop is called.
+func (s *Scheduler) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.stop = cancel
+	s.done = make(chan struct{})
+
+	go func() {
+		defer close(s.done)
+		s.run(ctx)
+	}()
+}
+
+// Stop stops scheduling, waits for running tasks and gives up leadership.
+// If ctx expires first, running tasks are cancelled and Stop returns ctx's
+// error.
+func (s *Scheduler) Stop(ctx context.Context) error {
+	if s.stop == nil {
+		return nil
+	}
+	s.stop()
+
+	finished := make(chan struct{})
+	go func() {
+		<-s.done
+		s.wg.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+	case <-ctx.Done():
+		s.abort()
+		return ctx.Err()
+	}
+
+	s.leader.Store(false)
+	return s.lock.Release(ctx)
+}
+
+func (s *Scheduler) run(ctx context.Context) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		s.checkLeadership(ctx)
+
+		wait := s.cfg.LeaderCheckInterval
+		if s.IsLeader() {
+			s.RunDue(ctx)
+			if e, ok := s.queue.Peek(); ok {
+				if d := e.next.Sub(s.now()); d < wait {
+					wait = d
+				}
+			}
+		}
+		timer.Reset(wait)
+	}
+}
+
+func (s *Scheduler) checkLeadership(ctx context.Context) {
+	logger := logging.Ctx(ctx, logging.Services)
+
+	held, err := s.lock.TryAcquire(ctx)
+	if err != nil {
+		logger.Error().Err(err).Msg("Scheduler leader election failed")
+	}
+
+	switch {
+	case held && !s.IsLeader():
+		logger.Info().Str("worker", s.worker).Msg("Scheduler acquired leadership")
+		s.leader.Store(true)
+		s.reset(ctx)
+	case !held && s.IsLeader():
+		logger.Warn().Str("worker", s.worker).Msg("Scheduler lost leadership")
+		s.leader.Store(false)
+	}
+}
+
+// reset schedules every task from now. Runs missed while no instance was
+// leader are skipped rather than caught up.
+func (s *Scheduler) reset(ctx context.Context) {
+	now := s.now()
+	s.queue.Clear()
+	for _, e := range s.entries {
+		e.next = e.schedule.Next(now)
+		if e.next.IsZero() {
+			continue
+		}
+		s.queue.Push(e)
+		if err := s.store.RegisterScheduledTask(ctx, e.task.Name, e.expr, e.next); err != nil {
+			logging.Ctx(ctx, logging.Services).Error().Err(err).Str("task", e.task.Name).Msg("Failed to register scheduled task")
+		}
+	}
+}
+
+// RunDue starts every task whose next run time has passed and schedules its
+// following run.
+func (s *Scheduler) RunDue(ctx context.Context) {
+	now := s.now()
+	for {
+		e, ok := s.queue.Peek()
+		if !ok || e.next.After(now) {
+			return
+		}
+		s.queue.Pop()
+
+		e.next = e.schedule.Next(now)
+		if !e.next.IsZero() {
+			s.queue.Push(e)
+		}
+		s.start(ctx, e.task, e.expr, e.next)
+	}
+}
+
+func (s *Scheduler) start(ctx context.Context, task Task, expr string, next time.Time) {
+	s.mu.Lock()
+	if s.running[task.Name] {
+		s.mu.Unlock()
+		logging.Ctx(ctx, logging.Services).Warn().Str("task", task.Name).Msg("Skipping scheduled task, previous run still in progress")
+		metrics.ScheduledTaskRunsTotal.WithLabelValues(task.Name, "skipped").Inc()
+		return
+	}
+	s.running[task.Name] = true
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+	go func() {
+		defer func() {
+			s.mu.Lock()
+			delete(s.running, task.Name)
+			s.mu.Unlock()
+			s.wg.Done()
+		}()
+		s.execute(ctx, task, expr, next)
+	}()
+}
+
+func (s *Scheduler) execute(ctx context.Context, task Task, expr string, next time.Time) {
+	logger := logging.Ctx(ctx, logging.Services).With().Str("task", task.Name).Logger()
+
+	started := s.now()
+	err := s.call(task)
+	finished := s.now()
+
+	run := models.ScheduledTask{
+		Name:           task.Name,
+		Schedule:       expr,
+		LastStartedAt:  &started,
+		LastFinishedAt: &finished,
+		LastStatus:     models.TaskSucceeded,
+		LastDurationMS: finished.Sub(started).Milliseconds(),
+		LastRunBy:      s.worker,
+	}
+	if !next.IsZero() {
+		run.NextRunAt = &next
+	}
+	if err != nil {
+		run.LastStatus = models.TaskFailed
+		run.LastError = err.Error()
+		logger.Error().Err(err).Dur("duration", finished.Sub(started)).Msg("Scheduled task failed")
+	} else {
+		logger.Info().Dur("duration", finished.Sub(started)).Msg("Scheduled task finished")
+	}
+	metrics.ScheduledTaskRunsTotal.WithLabelValues(task.Name, run.LastStatus).Inc()
+
+	if err := s.store.RecordTaskRun(context.WithoutCancel(ctx), run); err != nil {
+		logger.Error().Err(err).Msg("Failed to record scheduled task run")
+	}
+}
+
+// call runs the task with the task timeout applied, turning a panic into an
+// error.
+func (s *Scheduler) call(task Task) (err error) {
+	ctx, cancel := context.WithTimeout(s.taskCtx, s.cfg.TaskTimeout)
+	defer cancel()
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return task.Run(ctx)
+}
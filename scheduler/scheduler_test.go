@@ -0,0 +1,311 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/manuel/make-it-rain/config"
+	"github.com/manuel/make-it-rain/models"
+)
+
+type fakeLock struct {
+	mu       sync.Mutex
+	free     bool
+	held     bool
+	released bool
+}
+
+func (l *fakeLock) TryAcquire(ctx context.Context) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.free {
+		l.held = true
+	}
+	return l.held, nil
+}
+
+func (l *fakeLock) Release(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.held = false
+	l.released = true
+	return nil
+}
+
+type memorySchedules struct {
+	mu   sync.Mutex
+	runs []models.ScheduledTask
+}
+
+func (m *memorySchedules) RegisterScheduledTask(ctx context.Context, name, schedule string, nextRunAt time.Time) error {
+	return nil
+}
+
+func (m *memorySchedules) RecordTaskRun(ctx context.Context, run models.ScheduledTask) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.runs = append(m.runs, run)
+	return nil
+}
+
+func (m *memorySchedules) ListScheduledTasks(ctx context.Context) ([]models.ScheduledTask, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]models.ScheduledTask(nil), m.runs...), nil
+}
+
+func testSchedulerConfig() config.SchedulerConfig {
+	return config.SchedulerConfig{
+		Enabled:             true,
+		LeaderCheckInterval: 10 * time.Millisecond,
+		TaskTimeout:         time.Second,
+		PurgeBatchSize:      10,
+	}
+}
+
+type testClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *testClock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = t
+}
+
+func (c *testClock) Add(d time.Duration) {
+	c.Set(c.Now().Add(d))
+}
+
+// newTestScheduler returns a scheduler on a fixed clock that can take the lock.
+func newTestScheduler(store *memorySchedules, clock *testClock) *Scheduler {
+	s := New(&fakeLock{free: true}, store, testSchedulerConfig())
+	s.now = clock.Now
+	return s
+}
+
+func TestRunDueRunsTasksInScheduleOrder(t *testing.T) {
+	store := &memorySchedules{}
+	clock := &testClock{now: time.Date(2024, time.March, 1, 10, 0, 30, 0, time.UTC)}
+	s := newTestScheduler(store, clock)
+
+	var mu sync.Mutex
+	var ran []string
+	record := func(name string) func(context.Context) error {
+		return func(context.Context) error {
+			mu.Lock()
+			defer mu.Unlock()
+			ran = append(ran, name)
+			return nil
+		}
+	}
+	s.Register(Task{Name: "every-minute", Schedule: "* * * * *", Run: record("every-minute")})
+	s.Register(Task{Name: "hourly", Schedule: "@hourly", Run: record("hourly")})
+
+	s.checkLeadership(context.Background())
+	if !s.IsLeader() {
+		t.Fatal("Expected scheduler to become leader")
+	}
+
+	s.RunDue(context.Background())
+	s.wg.Wait()
+	if len(ran) != 0 {
+		t.Fatalf("Expected nothing due yet, got %v", ran)
+	}
+
+	clock.Add(time.Minute)
+	s.RunDue(context.Background())
+	s.wg.Wait()
+	if len(ran) != 1 || ran[0] != "every-minute" {
+		t.Fatalf("Expected only every-minute to run, got %v", ran)
+	}
+
+	clock.Set(time.Date(2024, time.March, 1, 11, 0, 0, 0, time.UTC))
+	s.RunDue(context.Background())
+	s.wg.Wait()
+	if len(ran) != 3 {
+		t.Fatalf("Expected both tasks to run at the hour, got %v", ran)
+	}
+
+	next, _ := s.queue.Peek()
+	if want := time.Date(2024, time.March, 1, 11, 1, 0, 0, time.UTC); !next.next.Equal(want) {
+		t.Errorf("Expected next run at %s, got %s", want, next.next)
+	}
+}
+
+func TestTaskOutcomeIsRecorded(t *testing.T) {
+	store := &memorySchedules{}
+	clock := &testClock{now: time.Date(2024, time.March, 1, 10, 0, 0, 0, time.UTC)}
+	s := newTestScheduler(store, clock)
+
+	s.Register(Task{Name: "ok", Schedule: "* * * * *", Run: func(context.Context) error { return nil }})
+	s.Register(Task{Name: "fails", Schedule: "* * * * *", Run: func(context.Context) error { return errors.New("disk full") }})
+	s.Register(Task{Name: "panics", Schedule: "* * * * *", Run: func(context.Context) error { panic("bug") }})
+	s.checkLeadership(context.Background())
+
+	clock.Add(time.Minute)
+	s.RunDue(context.Background())
+	s.wg.Wait()
+
+	runs, _ := store.ListScheduledTasks(context.Background())
+	results := make(map[string]models.ScheduledTask)
+	for _, r := range runs {
+		results[r.Name] = r
+	}
+	if r := results["ok"]; r.LastStatus != models.TaskSucceeded || r.NextRunAt == nil || r.LastRunBy == "" {
+		t.Errorf("Expected a recorded success with next run and worker, got %+v", r)
+	}
+	if r := results["fails"]; r.LastStatus != models.TaskFailed || r.LastError != "disk full" {
+		t.Errorf("Expected a recorded failure, got %+v", r)
+	}
+	if r := results["panics"]; r.LastStatus != models.TaskFailed || r.LastError != "panic: bug" {
+		t.Errorf("Expected a panic to be recorded as a failure, got %+v", r)
+	}
+}
+
+func TestRunDueSkipsOverlappingRuns(t *testing.T) {
+	store := &memorySchedules{}
+	clock := &testClock{now: time.Date(2024, time.March, 1, 10, 0, 0, 0, time.UTC)}
+	s := newTestScheduler(store, clock)
+
+	release := make(chan struct{})
+	var mu sync.Mutex
+	runs := 0
+	s.Register(Task{Name: "slow", Schedule: "* * * * *", Run: func(context.Context) error {
+		mu.Lock()
+		runs++
+		mu.Unlock()
+		<-release
+		return nil
+	}})
+	s.checkLeadership(context.Background())
+
+	clock.Add(time.Minute)
+	s.RunDue(context.Background())
+	// Wait until the first run has registered as running.
+	for {
+		s.mu.Lock()
+		running := s.running["slow"]
+		s.mu.Unlock()
+		if running {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	clock.Add(time.Minute)
+	s.RunDue(context.Background())
+	close(release)
+	s.wg.Wait()
+
+	if runs != 1 {
+		t.Errorf("Expected the overlapping run to be skipped, got %d runs", runs)
+	}
+}
+
+func TestLeadershipFollowsLock(t *testing.T) {
+	store := &memorySchedules{}
+	lock := &fakeLock{}
+	s := New(lock, store, testSchedulerConfig())
+	s.Register(Task{Name: "task", Schedule: "* * * * *", Run: func(context.Context) error { return nil }})
+
+	s.checkLeadership(context.Background())
+	if s.IsLeader() {
+		t.Fatal("Expected scheduler not to lead while the lock is taken")
+	}
+
+	lock.mu.Lock()
+	lock.free = true
+	lock.mu.Unlock()
+	s.checkLeadership(context.Background())
+	if !s.IsLeader() || s.queue.Size() != 1 {
+		t.Errorf("Expected scheduler to lead with its task queued once the lock is free")
+	}
+
+	lock.mu.Lock()
+	lock.free, lock.held = false, false
+	lock.mu.Unlock()
+	s.checkLeadership(context.Background())
+	if s.IsLeader() {
+		t.Error("Expected scheduler to step down after losing the lock")
+	}
+}
+
+func TestStopWaitsForRunningTaskAndReleasesLock(t *testing.T) {
+	store := &memorySchedules{}
+	lock := &fakeLock{free: true}
+	s := New(lock, store, testSchedulerConfig())
+
+	started := make(chan struct{})
+	s.Register(Task{Name: "cleanup", Schedule: "* * * * *", Run: func(ctx context.Context) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	}})
+	s.checkLeadership(context.Background())
+	s.now = func() time.Time { return time.Now().Add(time.Minute) }
+	s.Start()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := s.Stop(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected deadline exceeded while the task runs, got %v", err)
+	}
+	s.wg.Wait()
+
+	if err := s.Stop(context.Background()); err != nil {
+		t.Errorf("Expected clean stop once the task returned, got %v", err)
+	}
+	if !lock.released {
+		t.Error("Expected the leader lock to be released")
+	}
+}
+
+func TestRegisterHonoursOverrides(t *testing.T) {
+	cfg := testSchedulerConfig()
+	cfg.Tasks = map[string]string{"off-task": Disabled, "bad-task": "every day"}
+	s := New(&fakeLock{}, &memorySchedules{}, cfg)
+
+	noop := func(context.Context) error { return nil }
+	if err := s.Register(Task{Name: "off-task", Schedule: "@daily", Run: noop}); err != nil {
+		t.Errorf("Expected disabled task to register without error, got %v", err)
+	}
+	if len(s.entries) != 0 {
+		t.Errorf("Expected disabled task to be skipped")
+	}
+	if err := s.Register(Task{Name: "bad-task", Schedule: "@daily", Run: noop}); err == nil {
+		t.Error("Expected an invalid override to fail")
+	}
+}
+
+func TestPurgeTaskRepeatsFullBatches(t *testing.T) {
+	remaining := int64(25)
+	calls := 0
+	task := PurgeTask("purge", "@daily", 10, func(ctx context.Context, batch int) (int64, error) {
+		calls++
+		n := remaining
+		if n > int64(batch) {
+			n = int64(batch)
+		}
+		remaining -= n
+		return n, nil
+	})
+
+	if err := task.Run(context.Background()); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if remaining != 0 || calls != 3 {
+		t.Errorf("Expected 3 batches to clear 25 rows, got %d calls and %d left", calls, remaining)
+	}
+}
@@ -0,0 +1,38 @@
+package scheduler
+
+import (
+	"context"
+
+	"github.com/manuel/make-it-rain/logging"
+)
+
+// PurgeFunc deletes up to batchSize rows and returns how many it deleted,
+// like the methods of db.MaintenanceStore.
+type PurgeFunc func(ctx context.Context, batchSize int) (int64, error)
+
+// PurgeTask builds a task that calls purge in batches until a batch comes
+// back short, so a large backlog is cleared in one run without long locks.
+func PurgeTask(name, schedule string, batchSize int, purge PurgeFunc) Task {
+	return Task{
+		Name:     name,
+		Schedule: schedule,
+		Run: func(ctx context.Context) error {
+			var total int64
+			for {
+				n, err := purge(ctx, batchSize)
+				total += n
+				if err != nil {
+					return err
+				}
+				if n < int64(batchSize) {
+					break
+				}
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+			}
+			logging.Ctx(ctx, logging.Services).Info().Str("task", name).Int64("deleted", total).Msg("Purge complete")
+			return nil
+		},
+	}
+}
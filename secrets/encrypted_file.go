@@ -0,0 +1,145 @@
+package secrets
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// KeySize is the AES-256 key length expected by EncryptedFileProvider.
+const KeySize = 32
+
+// EncryptedFileProvider reads secrets from a local file holding a JSON object
+// of name/value pairs sealed with AES-256-GCM (nonce || ciphertext). The file
+// is decrypted again whenever its modification time changes.
+type EncryptedFileProvider struct {
+	path string
+	key  []byte
+
+	mu      sync.Mutex
+	modTime time.Time
+	values  map[string]string
+}
+
+func NewEncryptedFileProvider(path string, key []byte) (*EncryptedFileProvider, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("secrets key must be %d bytes, got %d", KeySize, len(key))
+	}
+
+	p := &EncryptedFileProvider{path: path, key: key}
+	if err := p.refresh(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *EncryptedFileProvider) Get(_ context.Context, name string) (string, error) {
+	if err := p.refresh(); err != nil {
+		return "", err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	value, ok := p.values[name]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return value, nil
+}
+
+func (p *EncryptedFileProvider) refresh() error {
+	info, err := os.Stat(p.path)
+	if err != nil {
+		return fmt.Errorf("failed to stat secrets file: %w", err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.values != nil && info.ModTime().Equal(p.modTime) {
+		return nil
+	}
+
+	sealed, err := os.ReadFile(p.path)
+	if err != nil {
+		return fmt.Errorf("failed to read secrets file: %w", err)
+	}
+
+	plaintext, err := Decrypt(p.key, sealed)
+	if err != nil {
+		return err
+	}
+
+	values := make(map[string]string)
+	if err := json.Unmarshal(plaintext, &values); err != nil {
+		return fmt.Errorf("failed to parse secrets file: %w", err)
+	}
+
+	p.values = values
+	p.modTime = info.ModTime()
+	return nil
+}
+
+// Encrypt seals plaintext with AES-256-GCM, prepending a random nonce.
+func Encrypt(key, plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// Decrypt opens data produced by Encrypt.
+func Decrypt(key, sealed []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("secrets file is truncated")
+	}
+
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, errors.New("failed to decrypt secrets file: wrong key or corrupted file")
+	}
+	return plaintext, nil
+}
+
+// ParseKey decodes a base64-encoded AES-256 key.
+func ParseKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("secrets key is not valid base64: %w", err)
+	}
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("secrets key must be %d bytes, got %d", KeySize, len(key))
+	}
+	return key, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid secrets key: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,61 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ErrNotFound is returned by a Provider that doesn't hold the named secret.
+var ErrNotFound = errors.New("secret not found")
+
+// Provider resolves secrets by name. Names use the environment variable
+// spelling, e.g. DATABASE_PASSWORD. Implementations must return the current
+// value on every call so that rotated secrets are picked up.
+type Provider interface {
+	Get(ctx context.Context, name string) (string, error)
+}
+
+// FileEnvProvider reads secrets from the file named by NAME_FILE, the
+// convention used by Docker and Kubernetes secrets. The file is re-read on
+// every call; one trailing newline is stripped.
+type FileEnvProvider struct{}
+
+func (FileEnvProvider) Get(_ context.Context, name string) (string, error) {
+	path := os.Getenv(name + "_FILE")
+	if path == "" {
+		return "", ErrNotFound
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s_FILE: %w", name, err)
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(string(data), "\n"), "\r"), nil
+}
+
+// Chain asks each provider in turn and returns the first value found.
+type Chain []Provider
+
+func (c Chain) Get(ctx context.Context, name string) (string, error) {
+	for _, p := range c {
+		value, err := p.Get(ctx, name)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		return value, err
+	}
+	return "", ErrNotFound
+}
+
+// Resolve returns the provider's value for name, or fallback if no provider
+// holds it.
+func Resolve(ctx context.Context, p Provider, name, fallback string) (string, error) {
+	value, err := p.Get(ctx, name)
+	if errors.Is(err, ErrNotFound) {
+		return fallback, nil
+	}
+	return value, err
+}
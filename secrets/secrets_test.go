@@ -0,0 +1,73 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileEnvProvider(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db_password")
+	if err := os.WriteFile(path, []byte("s3cr3t\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("DATABASE_PASSWORD_FILE", path)
+
+	got, err := FileEnvProvider{}.Get(context.Background(), "DATABASE_PASSWORD")
+	if err != nil || got != "s3cr3t" {
+		t.Errorf("Expected s3cr3t, got %q (%v)", got, err)
+	}
+
+	// Rotation: the next read returns the new value
+	if err := os.WriteFile(path, []byte("rotated"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	got, _ = FileEnvProvider{}.Get(context.Background(), "DATABASE_PASSWORD")
+	if got != "rotated" {
+		t.Errorf("Expected rotated value, got %q", got)
+	}
+
+	if _, err := (FileEnvProvider{}).Get(context.Background(), "JWT_SECRET_KEY"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestEncryptedFileProvider(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, KeySize)
+	path := filepath.Join(t.TempDir(), "secrets.enc")
+
+	write := func(plaintext string, modTime time.Time) {
+		sealed, err := Encrypt(key, []byte(plaintext))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, sealed, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(path, modTime, modTime)
+	}
+
+	write(`{"DATABASE_PASSWORD":"first"}`, time.Now().Add(-time.Minute))
+	p, err := NewEncryptedFileProvider(path, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chain := Chain{FileEnvProvider{}, p}
+	if got, _ := chain.Get(context.Background(), "DATABASE_PASSWORD"); got != "first" {
+		t.Errorf("Expected first, got %q", got)
+	}
+
+	write(`{"DATABASE_PASSWORD":"second"}`, time.Now())
+	if got, _ := chain.Get(context.Background(), "DATABASE_PASSWORD"); got != "second" {
+		t.Errorf("Expected rotated value second, got %q", got)
+	}
+
+	if _, err := NewEncryptedFileProvider(path, bytes.Repeat([]byte{8}, KeySize)); err == nil {
+		t.Error("Expected wrong key to fail")
+	}
+}
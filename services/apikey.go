@@ -0,0 +1,114 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/manuel/make-it-rain/auth"
+	"github.com/manuel/make-it-rain/config"
+	"github.com/manuel/make-it-rain/db"
+	"github.com/manuel/make-it-rain/logging"
+	"github.com/manuel/make-it-rain/models"
+	"github.com/manuel/make-it-rain/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// ErrInvalidAPIKey wraps validation failures so controllers can return 400.
+var ErrInvalidAPIKey = errors.New("invalid API key")
+
+// CreatedAPIKey is returned once, when a key is created. Key isn't stored
+// and can't be shown again.
+type CreatedAPIKey struct {
+	models.APIKey
+	Key string `json:"key"`
+}
+
+// APIKeyService manages personal API keys. New keys get the default rate
+// limit tier; only admins can move a key to another tier.
+type APIKeyService struct {
+	store db.APIKeyStore
+	cfg   config.APIKeysConfig
+}
+
+func NewAPIKeyService(store db.APIKeyStore, cfg config.APIKeysConfig) *APIKeyService {
+	return &APIKeyService{store: store, cfg: cfg}
+}
+
+// Create issues a key for a user with the given scopes. A nil expiresAt
+// means cfg.DefaultTTL from now.
+func (s *APIKeyService) Create(ctx context.Context, userID int64, name string, scopes []string, expiresAt *time.Time) (created *CreatedAPIKey, err error) {
+	ctx, span := tracing.Start(ctx, "APIKeyService.Create", attribute.Int64("user.id", userID))
+	defer func() { tracing.RecordError(span, err); span.End() }()
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, fmt.Errorf("%w: name is required", ErrInvalidAPIKey)
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(models.APIKeyScopes, scope) {
+			return nil, fmt.Errorf("%w: unknown scope %q", ErrInvalidAPIKey, scope)
+		}
+	}
+	now := time.Now()
+	expires := now.Add(s.cfg.DefaultTTL)
+	if expiresAt != nil {
+		expires = *expiresAt
+	}
+	if !expires.After(now) || expires.After(now.Add(s.cfg.MaxTTL)) {
+		return nil, fmt.Errorf("%w: expires_at must be in the future and within %s", ErrInvalidAPIKey, s.cfg.MaxTTL)
+	}
+
+	key, prefix, hash, err := auth.NewAPIKey()
+	if err != nil {
+		return nil, err
+	}
+	k := models.APIKey{
+		UserID:    userID,
+		Name:      name,
+		Prefix:    prefix,
+		KeyHash:   hash,
+		Scopes:    slices.Compact(slices.Sorted(slices.Values(scopes))),
+		Tier:      s.cfg.DefaultTier,
+		ExpiresAt: expires,
+	}
+	if k.Scopes == nil {
+		k.Scopes = []string{}
+	}
+	if err = s.store.CreateAPIKey(ctx, &k, s.cfg.MaxPerUser); err != nil {
+		return nil, err
+	}
+
+	logging.Ctx(ctx, logging.Services).Info().Int64("user_id", userID).Int64("api_key_id", k.ID).Msg("API key created")
+	return &CreatedAPIKey{APIKey: k, Key: key}, nil
+}
+
+// List returns a user's keys, or every user's when userID is 0.
+func (s *APIKeyService) List(ctx context.Context, userID int64, limit int) ([]models.APIKey, error) {
+	return s.store.ListAPIKeys(ctx, userID, limit)
+}
+
+// Revoke revokes a key of the user, or any key when userID is 0. Cached
+// copies on every instance are dropped.
+func (s *APIKeyService) Revoke(ctx context.Context, userID, id int64) (err error) {
+	ctx, span := tracing.Start(ctx, "APIKeyService.Revoke", attribute.Int64("user.id", userID), attribute.Int64("api_key.id", id))
+	defer func() { tracing.RecordError(span, err); span.End() }()
+
+	if err = s.store.RevokeAPIKey(ctx, id, userID); err != nil {
+		return err
+	}
+
+	logging.Ctx(ctx, logging.Services).Info().Int64("user_id", userID).Int64("api_key_id", id).Msg("API key revoked")
+	return nil
+}
+
+// SetTier moves a key to another rate limit tier from auth.api_keys.tiers.
+func (s *APIKeyService) SetTier(ctx context.Context, id int64, tier string) (*models.APIKey, error) {
+	if _, ok := s.cfg.Tiers[tier]; !ok {
+		return nil, fmt.Errorf("%w: unknown tier %q", ErrInvalidAPIKey, tier)
+	}
+	return s.store.SetAPIKeyTier(ctx, id, tier)
+}
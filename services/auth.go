@@ -0,0 +1,320 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/manuel/make-it-rain/auth"
+	"github.com/manuel/make-it-rain/config"
+	"github.com/manuel/make-it-rain/db"
+	"github.com/manuel/make-it-rain/logging"
+	"github.com/manuel/make-it-rain/models"
+	"github.com/manuel/make-it-rain/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// ErrEmailNotVerified is returned by Login and Refresh when
+// auth.require_verified_email is set and the address isn't verified yet.
+var ErrEmailNotVerified = errors.New("email address is not verified")
+
+// MFARequiredError is returned by Login when the password was right but a
+// second factor is needed. Token is exchanged for tokens by VerifyMFA. With
+// EnrollmentRequired the user's role requires MFA and they haven't set it
+// up yet, so they first enroll with EnrollMFA.
+type MFARequiredError struct {
+	Token              string
+	EnrollmentRequired bool
+}
+
+func (e *MFARequiredError) Error() string {
+	return "a second factor is required"
+}
+
+// TokenPair is returned on login and refresh.
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	// RecoveryCodes are returned once, when MFA is enabled during login
+	RecoveryCodes []string `json:"recovery_codes,omitempty"`
+}
+
+// AuthService logs users in with a short-lived access token and a refresh
+// token. Refresh tokens are rotated on every use.
+type AuthService struct {
+	users      *UserService
+	store      db.AuthStore
+	limiter    *auth.LoginLimiter
+	mfa        *MFAService
+	signer     *auth.Signer
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+	cfg        config.AuthConfig
+}
+
+func NewAuthService(users *UserService, store db.AuthStore, limiter *auth.LoginLimiter, mfa *MFAService, jwt config.JWTConfig, cfg config.AuthConfig) *AuthService {
+	return &AuthService{
+		users:      users,
+		store:      store,
+		limiter:    limiter,
+		mfa:        mfa,
+		signer:     auth.NewSigner(jwt),
+		accessTTL:  jwt.ExpiryDuration,
+		refreshTTL: jwt.RefreshDuration,
+		cfg:        cfg,
+	}
+}
+
+// Login checks email and password. Repeated failures from the same account
+// or client IP are delayed and then locked out, see auth.LoginLimiter, and
+// every attempt takes at least cfg.Lockout.MinResponseTime so the response
+// time doesn't tell whether the account exists.
+func (s *AuthService) Login(ctx context.Context, email, password string, client Client) (tokens *TokenPair, err error) {
+	ctx, span := tracing.Start(ctx, "AuthService.Login")
+	defer func() { tracing.RecordError(span, err); span.End() }()
+
+	deadline := time.Now().Add(s.cfg.Lockout.MinResponseTime)
+	defer waitUntil(ctx, deadline)
+
+	if err := s.limiter.Check(ctx, email, client.IP); err != nil {
+		return nil, err
+	}
+
+	user, err := s.users.AuthenticateUser(ctx, email, password)
+	if errors.Is(err, ErrInvalidCredentials) {
+		if err := s.limiter.RecordFailure(ctx, email, client.IP); err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	if s.cfg.RequireVerifiedEmail && user.EmailVerifiedAt == nil {
+		return nil, ErrEmailNotVerified
+	}
+	// Failures are only cleared once the second factor is verified too,
+	// or the password alone would buy unlimited guesses at codes
+	if user.MFAEnabledAt != nil || s.mfa.Required(user) {
+		return nil, s.mfaChallenge(ctx, user)
+	}
+	if err := s.limiter.RecordSuccess(ctx, email); err != nil {
+		return nil, err
+	}
+
+	tokens, err = s.StartSession(ctx, user.ID, client)
+	if err != nil {
+		return nil, err
+	}
+
+	logging.Ctx(ctx, logging.Services).Info().Int64("user_id", user.ID).Msg("User logged in")
+	return tokens, nil
+}
+
+// VerifyMFA completes a login that returned *MFARequiredError with a code
+// from the user's authenticator or a recovery code. For a user who was
+// required to enroll, the code confirms the new authenticator and the
+// recovery codes are returned with the tokens.
+func (s *AuthService) VerifyMFA(ctx context.Context, mfaToken, code string, client Client) (tokens *TokenPair, err error) {
+	ctx, span := tracing.Start(ctx, "AuthService.VerifyMFA")
+	defer func() { tracing.RecordError(span, err); span.End() }()
+
+	user, err := s.challengeUser(ctx, mfaToken)
+	if err != nil {
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int64("user.id", user.ID))
+
+	var recovery []string
+	if user.MFAEnabledAt == nil {
+		recovery, err = s.mfa.Confirm(ctx, user.ID, code, client.IP)
+	} else {
+		err = s.mfa.Verify(ctx, user.ID, code, client.IP)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = s.store.ConsumeUserToken(ctx, models.TokenMFAChallenge, auth.HashToken(mfaToken)); err != nil {
+		return nil, err
+	}
+	if err = s.limiter.RecordSuccess(ctx, user.Email); err != nil {
+		return nil, err
+	}
+
+	tokens, err = s.StartSession(ctx, user.ID, client)
+	if err != nil {
+		return nil, err
+	}
+	tokens.RecoveryCodes = recovery
+
+	logging.Ctx(ctx, logging.Services).Info().Int64("user_id", user.ID).Msg("User logged in with second factor")
+	return tokens, nil
+}
+
+// EnrollMFA starts authenticator setup during login for a user whose role
+// requires MFA. The login is then completed with VerifyMFA.
+func (s *AuthService) EnrollMFA(ctx context.Context, mfaToken string) (enrollment *MFAEnrollment, err error) {
+	ctx, span := tracing.Start(ctx, "AuthService.EnrollMFA")
+	defer func() { tracing.RecordError(span, err); span.End() }()
+
+	user, err := s.challengeUser(ctx, mfaToken)
+	if err != nil {
+		return nil, err
+	}
+	return s.mfa.Enroll(ctx, user.ID)
+}
+
+func (s *AuthService) mfaChallenge(ctx context.Context, user *models.User) error {
+	token, hash, err := auth.NewToken()
+	if err != nil {
+		return err
+	}
+	err = s.store.CreateUserToken(ctx, &models.UserToken{
+		UserID:    user.ID,
+		Purpose:   models.TokenMFAChallenge,
+		TokenHash: hash,
+		Email:     user.Email,
+		ExpiresAt: time.Now().Add(s.cfg.MFA.ChallengeTTL),
+	})
+	if err != nil {
+		return err
+	}
+	return &MFARequiredError{Token: token, EnrollmentRequired: user.MFAEnabledAt == nil}
+}
+
+// challengeUser returns the active user an MFA challenge token was issued
+// to.
+func (s *AuthService) challengeUser(ctx context.Context, mfaToken string) (*models.User, error) {
+	challenge, err := s.store.GetUserToken(ctx, models.TokenMFAChallenge, auth.HashToken(mfaToken))
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.users.GetUser(ctx, challenge.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if !user.IsActive {
+		return nil, db.ErrTokenInvalid
+	}
+	return user, nil
+}
+
+// StartSession creates a refresh token for a user who has already proved
+// who they are and returns it with an access token.
+func (s *AuthService) StartSession(ctx context.Context, userID int64, client Client) (*TokenPair, error) {
+	refresh, hash, err := auth.NewToken()
+	if err != nil {
+		return nil, err
+	}
+	session := models.RefreshToken{
+		UserID:    userID,
+		TokenHash: hash,
+		UserAgent: client.UserAgent,
+		IP:        client.IP,
+		ExpiresAt: time.Now().Add(s.refreshTTL),
+	}
+	if err := s.store.CreateRefreshToken(ctx, &session); err != nil {
+		return nil, err
+	}
+	return s.tokenPair(userID, session.ID, refresh)
+}
+
+// Refresh exchanges a refresh token for a new access token and a new refresh
+// token. The old refresh token stops working.
+func (s *AuthService) Refresh(ctx context.Context, refreshToken string, client Client) (tokens *TokenPair, err error) {
+	ctx, span := tracing.Start(ctx, "AuthService.Refresh")
+	defer func() { tracing.RecordError(span, err); span.End() }()
+
+	// Check the user before rotating, so a refused refresh leaves the token
+	// usable once the user is allowed back in
+	oldHash := auth.HashToken(refreshToken)
+	current, err := s.store.GetRefreshToken(ctx, oldHash)
+	if err != nil {
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int64("user.id", current.UserID))
+
+	user, err := s.users.GetUser(ctx, current.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if !user.IsActive {
+		if err := s.store.RevokeRefreshToken(ctx, oldHash); err != nil {
+			return nil, err
+		}
+		return nil, db.ErrTokenInvalid
+	}
+	if s.cfg.RequireVerifiedEmail && user.EmailVerifiedAt == nil {
+		return nil, ErrEmailNotVerified
+	}
+
+	refresh, hash, err := auth.NewToken()
+	if err != nil {
+		return nil, err
+	}
+	session, err := s.store.RotateRefreshToken(ctx, oldHash, hash, time.Now().Add(s.refreshTTL), client.UserAgent, client.IP)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.tokenPair(user.ID, session.ID, refresh)
+}
+
+// Logout revokes a refresh token. Access tokens already issued from it stop
+// working too.
+func (s *AuthService) Logout(ctx context.Context, refreshToken string) (err error) {
+	ctx, span := tracing.Start(ctx, "AuthService.Logout")
+	defer func() { tracing.RecordError(span, err); span.End() }()
+
+	return s.store.RevokeRefreshToken(ctx, auth.HashToken(refreshToken))
+}
+
+// UnlockUser lifts a login lockout on a user's account.
+func (s *AuthService) UnlockUser(ctx context.Context, userID int64) (err error) {
+	ctx, span := tracing.Start(ctx, "AuthService.UnlockUser", attribute.Int64("user.id", userID))
+	defer func() { tracing.RecordError(span, err); span.End() }()
+
+	user, err := s.users.GetUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	return s.limiter.UnlockUser(ctx, user)
+}
+
+// UnlockLogin lifts the lockout on an account or IP key as listed by
+// ListLoginLocks.
+func (s *AuthService) UnlockLogin(ctx context.Context, key string) (err error) {
+	ctx, span := tracing.Start(ctx, "AuthService.UnlockLogin")
+	defer func() { tracing.RecordError(span, err); span.End() }()
+
+	return s.limiter.Unlock(ctx, key)
+}
+
+func (s *AuthService) ListLoginLocks(ctx context.Context, limit int) ([]models.LoginLock, error) {
+	return s.limiter.ListLocks(ctx, limit)
+}
+
+func (s *AuthService) tokenPair(userID, sessionID int64, refresh string) (*TokenPair, error) {
+	access, _, err := s.signer.Sign(userID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	return &TokenPair{
+		AccessToken:  access,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(s.accessTTL.Seconds()),
+		RefreshToken: refresh,
+	}, nil
+}
+
+// waitUntil sleeps until t or until ctx is done.
+func waitUntil(ctx context.Context, t time.Time) {
+	timer := time.NewTimer(time.Until(t))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
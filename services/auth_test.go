@@ -0,0 +1,38 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/manuel/make-it-rain/auth"
+	"github.com/manuel/make-it-rain/models"
+)
+
+func TestRefreshKeepsTokenOfUnverifiedUser(t *testing.T) {
+	store := newMemoryAccounts(newTestUser(models.RoleUser))
+	now := time.Now()
+	authService, _ := newTestAuth(t, store, &now)
+	authService.cfg.RequireVerifiedEmail = true
+	if err := store.CreateRefreshToken(context.Background(), &models.RefreshToken{UserID: 1, TokenHash: auth.HashToken("refresh-token")}); err != nil {
+		t.Fatalf("CreateRefreshToken failed: %v", err)
+	}
+
+	if _, err := authService.Refresh(context.Background(), "refresh-token", Client{}); !errors.Is(err, ErrEmailNotVerified) {
+		t.Fatalf("Expected ErrEmailNotVerified, got %v", err)
+	}
+
+	// Once the address is verified the same token works
+	store.users[1].EmailVerifiedAt = &now
+	tokens, err := authService.Refresh(context.Background(), "refresh-token", Client{})
+	if err != nil {
+		t.Fatalf("Expected the refused token to still refresh, got %v", err)
+	}
+	if tokens.RefreshToken == "" || tokens.RefreshToken == "refresh-token" {
+		t.Errorf("Expected a new refresh token, got %q", tokens.RefreshToken)
+	}
+	if _, err := authService.Refresh(context.Background(), "refresh-token", Client{}); err == nil {
+		t.Error("Expected the rotated token to be refused")
+	}
+}
@@ -0,0 +1,264 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/manuel/make-it-rain/auth"
+	"github.com/manuel/make-it-rain/config"
+	"github.com/manuel/make-it-rain/db"
+	"github.com/manuel/make-it-rain/logging"
+	"github.com/manuel/make-it-rain/models"
+	"github.com/manuel/make-it-rain/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// ErrMFARequired is returned when a user tries to turn off a second factor
+// that their role requires.
+var ErrMFARequired = errors.New("two-factor authentication is required for this account")
+
+// MFAEnrollment is returned when a user starts setting up an authenticator
+// app. The URI is usually shown as a QR code.
+type MFAEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+// MFAService manages TOTP second factors. Wrong codes count as failed logins
+// for the account, so guessing codes runs into the same lockout as guessing
+// passwords.
+type MFAService struct {
+	users   db.DBService
+	store   db.MFAStore
+	limiter *auth.LoginLimiter
+	box     *auth.SecretBox
+	issuer  string
+	cfg     config.MFAConfig
+	now     func() time.Time
+}
+
+func NewMFAService(users db.DBService, store db.MFAStore, limiter *auth.LoginLimiter, box *auth.SecretBox, issuer string, cfg config.MFAConfig) *MFAService {
+	if cfg.Issuer != "" {
+		issuer = cfg.Issuer
+	}
+	return &MFAService{
+		users:   users,
+		store:   store,
+		limiter: limiter,
+		box:     box,
+		issuer:  issuer,
+		cfg:     cfg,
+		now:     time.Now,
+	}
+}
+
+// Required reports whether user's role has to use a second factor.
+func (s *MFAService) Required(user *models.User) bool {
+	return slices.Contains(s.cfg.RequiredRoles, user.Role)
+}
+
+// Enroll creates a new pending authenticator secret for a user without MFA.
+// It takes effect once confirmed with a code.
+func (s *MFAService) Enroll(ctx context.Context, userID int64) (enrollment *MFAEnrollment, err error) {
+	ctx, span := tracing.Start(ctx, "MFAService.Enroll", attribute.Int64("user.id", userID))
+	defer func() { tracing.RecordError(span, err); span.End() }()
+
+	user, err := s.users.GetUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.MFAEnabledAt != nil {
+		return nil, db.ErrMFAAlreadyEnabled
+	}
+
+	secret, err := auth.NewTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := s.box.Seal(secret)
+	if err != nil {
+		return nil, err
+	}
+	if err = s.store.SaveMFASecret(ctx, userID, sealed); err != nil {
+		return nil, err
+	}
+
+	return &MFAEnrollment{Secret: secret, URI: auth.TOTPURI(s.issuer, user.Email, secret)}, nil
+}
+
+// Confirm checks the first code from a pending authenticator and enables
+// MFA. It returns the recovery codes, which are only shown this once.
+func (s *MFAService) Confirm(ctx context.Context, userID int64, code, clientIP string) (codes []string, err error) {
+	ctx, span := tracing.Start(ctx, "MFAService.Confirm", attribute.Int64("user.id", userID))
+	defer func() { tracing.RecordError(span, err); span.End() }()
+
+	user, err := s.users.GetUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	m, err := s.store.GetMFASecret(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if m.ConfirmedAt != nil {
+		return nil, db.ErrMFAAlreadyEnabled
+	}
+
+	var step int64
+	err = s.limit(ctx, user, clientIP, func() error {
+		secret, err := s.box.Open(m.Secret)
+		if err != nil {
+			return err
+		}
+		step, err = auth.VerifyTOTP(secret, code, s.now(), s.cfg.Skew)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	codes, hashes, err := auth.NewRecoveryCodes(s.cfg.RecoveryCodes)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = s.users.ConfirmMFA(ctx, userID, step, hashes); err != nil {
+		return nil, err
+	}
+
+	logging.Ctx(ctx, logging.Services).Info().Int64("user_id", userID).Msg("Two-factor authentication enabled")
+	return codes, nil
+}
+
+// Verify checks a second factor of a user with MFA enabled: a code from the
+// authenticator, or a recovery code, which is used up.
+func (s *MFAService) Verify(ctx context.Context, userID int64, code, clientIP string) (err error) {
+	ctx, span := tracing.Start(ctx, "MFAService.Verify", attribute.Int64("user.id", userID))
+	defer func() { tracing.RecordError(span, err); span.End() }()
+
+	user, err := s.users.GetUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if user.MFAEnabledAt == nil {
+		return db.ErrMFANotEnrolled
+	}
+	return s.limit(ctx, user, clientIP, func() error {
+		return s.verify(ctx, user, code)
+	})
+}
+
+// Disable turns MFA off after checking a code. Roles that require MFA can't
+// turn it off.
+func (s *MFAService) Disable(ctx context.Context, userID int64, code, clientIP string) (err error) {
+	ctx, span := tracing.Start(ctx, "MFAService.Disable", attribute.Int64("user.id", userID))
+	defer func() { tracing.RecordError(span, err); span.End() }()
+
+	user, err := s.users.GetUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if s.Required(user) {
+		return ErrMFARequired
+	}
+	if err = s.Verify(ctx, userID, code, clientIP); err != nil {
+		return err
+	}
+	if err = s.users.DisableMFA(ctx, userID); err != nil {
+		return err
+	}
+
+	logging.Ctx(ctx, logging.Services).Info().Int64("user_id", userID).Msg("Two-factor authentication disabled")
+	return nil
+}
+
+// Reset removes a user's second factor without a code, for an admin helping
+// someone who lost their device. Users whose role requires MFA enroll again
+// at their next login.
+func (s *MFAService) Reset(ctx context.Context, userID int64) (err error) {
+	ctx, span := tracing.Start(ctx, "MFAService.Reset", attribute.Int64("user.id", userID))
+	defer func() { tracing.RecordError(span, err); span.End() }()
+
+	if err = s.users.DisableMFA(ctx, userID); err != nil {
+		return err
+	}
+
+	logging.Ctx(ctx, logging.Services).Warn().Int64("user_id", userID).Msg("Two-factor authentication reset")
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces a user's recovery codes after checking a
+// code, for when the old ones are used up or lost.
+func (s *MFAService) RegenerateRecoveryCodes(ctx context.Context, userID int64, code, clientIP string) (codes []string, err error) {
+	ctx, span := tracing.Start(ctx, "MFAService.RegenerateRecoveryCodes", attribute.Int64("user.id", userID))
+	defer func() { tracing.RecordError(span, err); span.End() }()
+
+	if err = s.Verify(ctx, userID, code, clientIP); err != nil {
+		return nil, err
+	}
+	codes, hashes, err := auth.NewRecoveryCodes(s.cfg.RecoveryCodes)
+	if err != nil {
+		return nil, err
+	}
+	if err = s.store.ReplaceRecoveryCodes(ctx, userID, hashes); err != nil {
+		return nil, err
+	}
+
+	logging.Ctx(ctx, logging.Services).Info().Int64("user_id", userID).Msg("Recovery codes regenerated")
+	return codes, nil
+}
+
+// verify accepts a current TOTP code that hasn't been used yet, or an unused
+// recovery code. Codes with letters or dashes are recovery codes.
+func (s *MFAService) verify(ctx context.Context, user *models.User, code string) error {
+	code = strings.TrimSpace(code)
+	if strings.Trim(code, "0123456789") != "" {
+		if err := s.store.UseRecoveryCode(ctx, user.ID, auth.HashRecoveryCode(code)); err != nil {
+			if errors.Is(err, db.ErrTokenInvalid) {
+				return auth.ErrInvalidCode
+			}
+			return err
+		}
+		logging.Ctx(ctx, logging.Services).Warn().Int64("user_id", user.ID).Msg("Recovery code used")
+		return nil
+	}
+
+	m, err := s.store.GetMFASecret(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	secret, err := s.box.Open(m.Secret)
+	if err != nil {
+		return err
+	}
+	step, err := auth.VerifyTOTP(secret, code, s.now(), s.cfg.Skew)
+	if err != nil {
+		return err
+	}
+	// A code seen before, e.g. over someone's shoulder, is refused
+	if err := s.store.UseMFAStep(ctx, user.ID, step); err != nil {
+		if errors.Is(err, db.ErrTokenInvalid) {
+			return auth.ErrInvalidCode
+		}
+		return err
+	}
+	return nil
+}
+
+// limit runs check unless the account or client IP is locked out, and
+// records an auth.ErrInvalidCode result as a failed login.
+func (s *MFAService) limit(ctx context.Context, user *models.User, clientIP string, check func() error) error {
+	if err := s.limiter.Check(ctx, user.Email, clientIP); err != nil {
+		return err
+	}
+	err := check()
+	if errors.Is(err, auth.ErrInvalidCode) {
+		logging.Ctx(ctx, logging.Services).Warn().Int64("user_id", user.ID).Msg("Wrong two-factor code")
+		if err := s.limiter.RecordFailure(ctx, user.Email, clientIP); err != nil {
+			return err
+		}
+	}
+	return err
+}
@@ -0,0 +1,377 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/manuel/make-it-rain/auth"
+	"github.com/manuel/make-it-rain/config"
+	"github.com/manuel/make-it-rain/db"
+	"github.com/manuel/make-it-rain/models"
+)
+
+// memoryAccounts keeps users, their second factors, tokens and failed logins
+// in memory. Methods the tests don't reach fall through to the nil embedded
+// interfaces.
+type memoryAccounts struct {
+	db.DBService
+	db.MFAStore
+	db.AuthStore
+	db.LoginAttemptStore
+
+	users    map[int64]*models.User
+	mfa      map[int64]*models.MFASecret
+	recovery map[int64]map[string]bool
+	tokens   map[string]*models.UserToken
+	sessions int64
+	refresh  map[string]*models.RefreshToken
+	failures map[string]int
+}
+
+func newMemoryAccounts(users ...*models.User) *memoryAccounts {
+	s := &memoryAccounts{
+		users:    make(map[int64]*models.User),
+		mfa:      make(map[int64]*models.MFASecret),
+		recovery: make(map[int64]map[string]bool),
+		tokens:   make(map[string]*models.UserToken),
+		refresh:  make(map[string]*models.RefreshToken),
+		failures: make(map[string]int),
+	}
+	for _, u := range users {
+		s.users[u.ID] = u
+	}
+	return s
+}
+
+func (s *memoryAccounts) GetUser(ctx context.Context, userID int64) (*models.User, error) {
+	u, ok := s.users[userID]
+	if !ok {
+		return nil, errors.New("user not found")
+	}
+	copied := *u
+	return &copied, nil
+}
+
+func (s *memoryAccounts) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	for _, u := range s.users {
+		if u.Email == email {
+			copied := *u
+			return &copied, nil
+		}
+	}
+	return nil, errors.New("user not found")
+}
+
+func (s *memoryAccounts) ConfirmMFA(ctx context.Context, userID, step int64, codeHashes []string) (*models.User, error) {
+	m, ok := s.mfa[userID]
+	if !ok || m.ConfirmedAt != nil {
+		return nil, db.ErrMFANotEnrolled
+	}
+	now := time.Now()
+	m.ConfirmedAt = &now
+	m.LastUsedStep = step
+	s.ReplaceRecoveryCodes(ctx, userID, codeHashes)
+	s.users[userID].MFAEnabledAt = &now
+	return s.GetUser(ctx, userID)
+}
+
+func (s *memoryAccounts) DisableMFA(ctx context.Context, userID int64) error {
+	delete(s.mfa, userID)
+	delete(s.recovery, userID)
+	s.users[userID].MFAEnabledAt = nil
+	return nil
+}
+
+func (s *memoryAccounts) SaveMFASecret(ctx context.Context, userID int64, secret []byte) error {
+	if m, ok := s.mfa[userID]; ok && m.ConfirmedAt != nil {
+		return db.ErrMFAAlreadyEnabled
+	}
+	s.mfa[userID] = &models.MFASecret{UserID: userID, Secret: secret}
+	return nil
+}
+
+func (s *memoryAccounts) GetMFASecret(ctx context.Context, userID int64) (*models.MFASecret, error) {
+	m, ok := s.mfa[userID]
+	if !ok {
+		return nil, db.ErrMFANotEnrolled
+	}
+	copied := *m
+	return &copied, nil
+}
+
+func (s *memoryAccounts) UseMFAStep(ctx context.Context, userID, step int64) error {
+	m, ok := s.mfa[userID]
+	if !ok || m.LastUsedStep >= step {
+		return db.ErrTokenInvalid
+	}
+	m.LastUsedStep = step
+	return nil
+}
+
+func (s *memoryAccounts) UseRecoveryCode(ctx context.Context, userID int64, codeHash string) error {
+	if unused, ok := s.recovery[userID][codeHash]; !ok || !unused {
+		return db.ErrTokenInvalid
+	}
+	s.recovery[userID][codeHash] = false
+	return nil
+}
+
+func (s *memoryAccounts) ReplaceRecoveryCodes(ctx context.Context, userID int64, codeHashes []string) error {
+	s.recovery[userID] = make(map[string]bool, len(codeHashes))
+	for _, h := range codeHashes {
+		s.recovery[userID][h] = true
+	}
+	return nil
+}
+
+func (s *memoryAccounts) CreateUserToken(ctx context.Context, t *models.UserToken) error {
+	s.tokens[t.TokenHash] = t
+	return nil
+}
+
+func (s *memoryAccounts) GetUserToken(ctx context.Context, purpose, tokenHash string) (*models.UserToken, error) {
+	t, ok := s.tokens[tokenHash]
+	if !ok || t.Purpose != purpose || t.UsedAt != nil {
+		return nil, db.ErrTokenInvalid
+	}
+	return t, nil
+}
+
+func (s *memoryAccounts) ConsumeUserToken(ctx context.Context, purpose, tokenHash string) error {
+	t, err := s.GetUserToken(ctx, purpose, tokenHash)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	t.UsedAt = &now
+	return nil
+}
+
+func (s *memoryAccounts) CreateRefreshToken(ctx context.Context, t *models.RefreshToken) error {
+	s.sessions++
+	t.ID = s.sessions
+	s.refresh[t.TokenHash] = t
+	return nil
+}
+
+func (s *memoryAccounts) GetRefreshToken(ctx context.Context, tokenHash string) (*models.RefreshToken, error) {
+	t, ok := s.refresh[tokenHash]
+	if !ok {
+		return nil, db.ErrTokenInvalid
+	}
+	return t, nil
+}
+
+func (s *memoryAccounts) RotateRefreshToken(ctx context.Context, oldHash, newHash string, expiresAt time.Time, userAgent, ip string) (*models.RefreshToken, error) {
+	t, err := s.GetRefreshToken(ctx, oldHash)
+	if err != nil {
+		return nil, err
+	}
+	delete(s.refresh, oldHash)
+	t.TokenHash, t.ExpiresAt = newHash, expiresAt
+	s.refresh[newHash] = t
+	return t, nil
+}
+
+func (s *memoryAccounts) RevokeRefreshToken(ctx context.Context, tokenHash string) error {
+	delete(s.refresh, tokenHash)
+	return nil
+}
+
+func (s *memoryAccounts) RecordLoginFailure(ctx context.Context, key string, window time.Duration) (int, error) {
+	s.failures[key]++
+	return s.failures[key], nil
+}
+
+func (s *memoryAccounts) LockLogin(ctx context.Context, key string, until time.Time, userID int64) error {
+	return nil
+}
+
+func (s *memoryAccounts) LoginLockedUntil(ctx context.Context, keys []string) (time.Time, error) {
+	return time.Time{}, nil
+}
+
+func (s *memoryAccounts) ClearLoginFailures(ctx context.Context, key string) error {
+	delete(s.failures, key)
+	return nil
+}
+
+const testPassword = "password123"
+
+func newTestUser(role string) *models.User {
+	return &models.User{ID: 1, Email: "ana@example.com", Password: hashPassword(testPassword), IsActive: true, Role: role}
+}
+
+// newTestAuth wires an AuthService and its MFAService to store, with the
+// MFA clock reading *now.
+func newTestAuth(t *testing.T, store *memoryAccounts, now *time.Time, requiredRoles ...string) (*AuthService, *MFAService) {
+	jwt := config.JWTConfig{SecretKey: "test-secret", ExpiryDuration: time.Minute, RefreshDuration: time.Hour}
+	mfaCfg := config.MFAConfig{RequiredRoles: requiredRoles, ChallengeTTL: 5 * time.Minute, Skew: 1, RecoveryCodes: 4}
+	box, err := auth.NewSecretBox(mfaCfg, jwt)
+	if err != nil {
+		t.Fatalf("NewSecretBox failed: %v", err)
+	}
+	limiter := auth.NewLoginLimiter(store, store, config.LockoutConfig{
+		Window:      time.Minute,
+		DelayAfter:  100,
+		Threshold:   100,
+		Duration:    time.Minute,
+		IPThreshold: 100,
+	})
+
+	mfa := NewMFAService(store, store, limiter, box, "make-it-rain", mfaCfg)
+	mfa.now = func() time.Time { return *now }
+	authService := NewAuthService(NewUserService(store, nil), store, limiter, mfa, jwt, config.AuthConfig{MFA: mfaCfg})
+	return authService, mfa
+}
+
+// enableMFA enrolls user 1 and confirms with the current code, returning the
+// secret and recovery codes.
+func enableMFA(t *testing.T, mfa *MFAService, now time.Time) (string, []string) {
+	enrollment, err := mfa.Enroll(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("Enroll failed: %v", err)
+	}
+	codes, err := mfa.Confirm(context.Background(), 1, totpCode(t, enrollment.Secret, now), "")
+	if err != nil {
+		t.Fatalf("Confirm failed: %v", err)
+	}
+	return enrollment.Secret, codes
+}
+
+func totpCode(t *testing.T, secret string, at time.Time) string {
+	code, err := auth.TOTP(secret, at)
+	if err != nil {
+		t.Fatalf("TOTP failed: %v", err)
+	}
+	return code
+}
+
+// loginChallenge logs user 1 in with the right password and returns the MFA
+// challenge.
+func loginChallenge(t *testing.T, authService *AuthService) *MFARequiredError {
+	_, err := authService.Login(context.Background(), "ana@example.com", testPassword, Client{IP: "192.0.2.1"})
+	var challenge *MFARequiredError
+	if !errors.As(err, &challenge) {
+		t.Fatalf("Expected a second factor to be required, got %v", err)
+	}
+	return challenge
+}
+
+func TestMFAChallengeThenVerify(t *testing.T) {
+	now := time.Date(2024, time.March, 1, 10, 0, 0, 0, time.UTC)
+	store := newMemoryAccounts(newTestUser(models.RoleUser))
+	authService, mfa := newTestAuth(t, store, &now)
+	secret, _ := enableMFA(t, mfa, now)
+
+	now = now.Add(30 * time.Second)
+	challenge := loginChallenge(t, authService)
+	if challenge.EnrollmentRequired {
+		t.Error("Expected no enrollment for a user with MFA enabled")
+	}
+
+	tokens, err := authService.VerifyMFA(context.Background(), challenge.Token, totpCode(t, secret, now), Client{})
+	if err != nil {
+		t.Fatalf("VerifyMFA failed: %v", err)
+	}
+	if tokens.AccessToken == "" || tokens.RefreshToken == "" {
+		t.Errorf("Expected tokens, got %+v", tokens)
+	}
+	if len(tokens.RecoveryCodes) != 0 {
+		t.Errorf("Expected no recovery codes, got %d", len(tokens.RecoveryCodes))
+	}
+
+	now = now.Add(30 * time.Second)
+	if _, err := authService.VerifyMFA(context.Background(), challenge.Token, totpCode(t, secret, now), Client{}); !errors.Is(err, db.ErrTokenInvalid) {
+		t.Errorf("Expected a used challenge to be refused, got %v", err)
+	}
+}
+
+func TestMFARefusesReusedStep(t *testing.T) {
+	now := time.Date(2024, time.March, 1, 10, 0, 0, 0, time.UTC)
+	store := newMemoryAccounts(newTestUser(models.RoleUser))
+	authService, mfa := newTestAuth(t, store, &now)
+	secret, _ := enableMFA(t, mfa, now)
+
+	// The code that confirmed the authenticator is used up
+	challenge := loginChallenge(t, authService)
+	if _, err := authService.VerifyMFA(context.Background(), challenge.Token, totpCode(t, secret, now), Client{}); !errors.Is(err, auth.ErrInvalidCode) {
+		t.Errorf("Expected the confirmation code to be refused, got %v", err)
+	}
+
+	now = now.Add(30 * time.Second)
+	code := totpCode(t, secret, now)
+	if err := mfa.Verify(context.Background(), 1, code, ""); err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if err := mfa.Verify(context.Background(), 1, code, ""); !errors.Is(err, auth.ErrInvalidCode) {
+		t.Errorf("Expected a reused code to be refused, got %v", err)
+	}
+	// An earlier step still within the skew is refused too
+	if err := mfa.Verify(context.Background(), 1, totpCode(t, secret, now.Add(-30*time.Second)), ""); !errors.Is(err, auth.ErrInvalidCode) {
+		t.Errorf("Expected an earlier code to be refused, got %v", err)
+	}
+	if failures := store.failures[auth.AccountKey("ana@example.com")]; failures != 3 {
+		t.Errorf("Expected 3 failed logins recorded, got %d", failures)
+	}
+}
+
+func TestMFARecoveryCodesAreSingleUse(t *testing.T) {
+	now := time.Date(2024, time.March, 1, 10, 0, 0, 0, time.UTC)
+	store := newMemoryAccounts(newTestUser(models.RoleUser))
+	authService, mfa := newTestAuth(t, store, &now)
+	_, codes := enableMFA(t, mfa, now)
+	if len(codes) != 4 {
+		t.Fatalf("Expected 4 recovery codes, got %d", len(codes))
+	}
+
+	challenge := loginChallenge(t, authService)
+	if _, err := authService.VerifyMFA(context.Background(), challenge.Token, codes[0], Client{}); err != nil {
+		t.Fatalf("Expected a recovery code to complete the login, got %v", err)
+	}
+
+	challenge = loginChallenge(t, authService)
+	if _, err := authService.VerifyMFA(context.Background(), challenge.Token, codes[0], Client{}); !errors.Is(err, auth.ErrInvalidCode) {
+		t.Errorf("Expected a used recovery code to be refused, got %v", err)
+	}
+	if _, err := authService.VerifyMFA(context.Background(), challenge.Token, codes[1], Client{}); err != nil {
+		t.Errorf("Expected another recovery code to work, got %v", err)
+	}
+}
+
+func TestMFARequiredRoleEnrollsAtLogin(t *testing.T) {
+	now := time.Date(2024, time.March, 1, 10, 0, 0, 0, time.UTC)
+	store := newMemoryAccounts(newTestUser(models.RoleAdmin))
+	authService, mfa := newTestAuth(t, store, &now, models.RoleAdmin)
+
+	challenge := loginChallenge(t, authService)
+	if !challenge.EnrollmentRequired {
+		t.Fatal("Expected an admin without MFA to have to enroll")
+	}
+	if _, err := authService.VerifyMFA(context.Background(), challenge.Token, "123456", Client{}); !errors.Is(err, db.ErrMFANotEnrolled) {
+		t.Errorf("Expected a code before enrolling to be refused, got %v", err)
+	}
+
+	enrollment, err := authService.EnrollMFA(context.Background(), challenge.Token)
+	if err != nil {
+		t.Fatalf("EnrollMFA failed: %v", err)
+	}
+	code := totpCode(t, enrollment.Secret, now)
+	tokens, err := authService.VerifyMFA(context.Background(), challenge.Token, code, Client{})
+	if err != nil {
+		t.Fatalf("VerifyMFA failed: %v", err)
+	}
+	if len(tokens.RecoveryCodes) != 4 {
+		t.Errorf("Expected the recovery codes with the tokens, got %d", len(tokens.RecoveryCodes))
+	}
+	if store.users[1].MFAEnabledAt == nil {
+		t.Error("Expected MFA to be enabled")
+	}
+
+	now = now.Add(30 * time.Second)
+	if err := mfa.Disable(context.Background(), 1, totpCode(t, enrollment.Secret, now), ""); !errors.Is(err, ErrMFARequired) {
+		t.Errorf("Expected an admin to be refused turning MFA off, got %v", err)
+	}
+}
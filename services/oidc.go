@@ -0,0 +1,261 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/manuel/make-it-rain/auth"
+	"github.com/manuel/make-it-rain/config"
+	"github.com/manuel/make-it-rain/db"
+	"github.com/manuel/make-it-rain/logging"
+	"github.com/manuel/make-it-rain/models"
+	"github.com/manuel/make-it-rain/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var (
+	ErrUnknownProvider = errors.New("unknown identity provider")
+	// ErrProviderEmailUnverified is returned on first login when the
+	// provider doesn't vouch for the user's address, which is needed to
+	// link or create an account.
+	ErrProviderEmailUnverified = errors.New("identity provider did not return a verified email address")
+	// ErrAccountNotLinkable is returned when an account uses the provider's
+	// address but never verified it. Linking it could hand the account to
+	// whoever registered it.
+	ErrAccountNotLinkable = errors.New("account email address is not verified")
+	// ErrSignupDisabled is returned when no account uses the provider's
+	// address and the provider doesn't allow signups.
+	ErrSignupDisabled = errors.New("no account uses this email address")
+)
+
+// maxNameLen matches the users.name column.
+const maxNameLen = 255
+
+// OIDCAuthorization is where to send the user to log in at a provider.
+type OIDCAuthorization struct {
+	URL string `json:"authorization_url"`
+	// State comes back with the code; clients should check it matches the
+	// one they started with before finishing the login
+	State string `json:"state"`
+}
+
+// OIDCService logs users in through external OpenID Connect providers. On
+// first login a provider account is linked to the user with the same
+// verified address, or a user is created for it when the provider allows
+// signups. Later logins find the user through the link.
+type OIDCService struct {
+	auth      *AuthService
+	dbService db.DBService
+	store     db.IdentityStore
+	providers map[string]*auth.OIDCProvider
+	cfg       config.OIDCConfig
+}
+
+func NewOIDCService(authService *AuthService, dbService db.DBService, store db.IdentityStore, cfg config.OIDCConfig) *OIDCService {
+	providers := make(map[string]*auth.OIDCProvider, len(cfg.Providers))
+	for name, p := range cfg.Providers {
+		providers[name] = auth.NewOIDCProvider(name, p, cfg.Timeout)
+	}
+	return &OIDCService{
+		auth:      authService,
+		dbService: dbService,
+		store:     store,
+		providers: providers,
+		cfg:       cfg,
+	}
+}
+
+// Providers returns the names of the configured providers.
+func (s *OIDCService) Providers() []string {
+	names := make([]string, 0, len(s.providers))
+	for name := range s.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// StartLogin returns the provider URL to send the user to. The PKCE code
+// verifier and nonce stay on the server until FinishLogin.
+func (s *OIDCService) StartLogin(ctx context.Context, provider string) (authorization *OIDCAuthorization, err error) {
+	ctx, span := tracing.Start(ctx, "OIDCService.StartLogin", attribute.String("oidc.provider", provider))
+	defer func() { tracing.RecordError(span, err); span.End() }()
+
+	p, ok := s.providers[provider]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+
+	state, stateHash, err := auth.NewToken()
+	if err != nil {
+		return nil, err
+	}
+	nonce, _, err := auth.NewToken()
+	if err != nil {
+		return nil, err
+	}
+	verifier, _, err := auth.NewToken()
+	if err != nil {
+		return nil, err
+	}
+
+	authURL, err := p.AuthCodeURL(ctx, state, nonce, verifier)
+	if err != nil {
+		return nil, err
+	}
+	err = s.store.CreateOIDCLogin(ctx, &models.OIDCLogin{
+		Provider:     provider,
+		StateHash:    stateHash,
+		CodeVerifier: verifier,
+		Nonce:        nonce,
+		ExpiresAt:    time.Now().Add(s.cfg.LoginTTL),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &OIDCAuthorization{URL: authURL, State: state}, nil
+}
+
+// FinishLogin exchanges the code the provider sent the user back with and
+// logs the user in. Like Login, it returns *MFARequiredError when the user
+// needs a second factor.
+func (s *OIDCService) FinishLogin(ctx context.Context, provider, code, state string, client Client) (tokens *TokenPair, err error) {
+	ctx, span := tracing.Start(ctx, "OIDCService.FinishLogin", attribute.String("oidc.provider", provider))
+	defer func() { tracing.RecordError(span, err); span.End() }()
+
+	p, ok := s.providers[provider]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+	login, err := s.store.ConsumeOIDCLogin(ctx, auth.HashToken(state))
+	if err != nil {
+		return nil, err
+	}
+	if login.Provider != provider {
+		return nil, db.ErrTokenInvalid
+	}
+
+	claims, err := p.Exchange(ctx, code, login.CodeVerifier, login.Nonce)
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.identityUser(ctx, p, claims)
+	if err != nil {
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int64("user.id", user.ID))
+
+	if !user.IsActive {
+		return nil, ErrUserInactive
+	}
+	if s.auth.cfg.RequireVerifiedEmail && user.EmailVerifiedAt == nil {
+		return nil, ErrEmailNotVerified
+	}
+	if user.MFAEnabledAt != nil || s.auth.mfa.Required(user) {
+		return nil, s.auth.mfaChallenge(ctx, user)
+	}
+
+	tokens, err = s.auth.StartSession(ctx, user.ID, client)
+	if err != nil {
+		return nil, err
+	}
+
+	logging.Ctx(ctx, logging.Services).Info().Int64("user_id", user.ID).Str("provider", provider).Msg("User logged in with identity provider")
+	return tokens, nil
+}
+
+// identityUser returns the user linked to the provider account, linking
+// or creating one by verified address on first login.
+func (s *OIDCService) identityUser(ctx context.Context, p *auth.OIDCProvider, claims *auth.IDTokenClaims) (*models.User, error) {
+	userID, err := s.store.UseIdentity(ctx, p.Name(), claims.Subject, claims.Email)
+	if err == nil {
+		return s.dbService.GetUser(ctx, userID)
+	}
+	if !errors.Is(err, db.ErrIdentityNotFound) {
+		return nil, err
+	}
+
+	if claims.Email == "" || !bool(claims.EmailVerified) {
+		return nil, ErrProviderEmailUnverified
+	}
+	identity := &models.UserIdentity{Provider: p.Name(), Subject: claims.Subject, Email: claims.Email}
+
+	user, err := s.dbService.GetUserByEmail(ctx, claims.Email)
+	if err == nil {
+		if user.EmailVerifiedAt == nil {
+			return nil, ErrAccountNotLinkable
+		}
+		identity.UserID = user.ID
+		if err := s.store.LinkIdentity(ctx, identity); err != nil {
+			return nil, err
+		}
+		logging.Ctx(ctx, logging.Services).Info().Int64("user_id", user.ID).Str("provider", p.Name()).Msg("Identity linked")
+		return user, nil
+	}
+	if err.Error() != "user not found" {
+		return nil, err
+	}
+
+	if !p.AllowSignup() {
+		return nil, ErrSignupDisabled
+	}
+	// The user has no password until they set one with a reset link
+	password, _, err := auth.NewToken()
+	if err != nil {
+		return nil, err
+	}
+	locale := claims.Locale
+	if len(locale) > 35 {
+		locale = ""
+	}
+	user, err = s.store.CreateUserWithIdentity(ctx, &db.CreateUserRequest{
+		Email:    claims.Email,
+		Name:     displayName(claims),
+		Password: hashPassword(password),
+		Locale:   locale,
+	}, identity)
+	if err != nil {
+		return nil, err
+	}
+
+	logging.Ctx(ctx, logging.Services).Info().Int64("user_id", user.ID).Str("provider", p.Name()).Msg("User created from identity provider")
+	return user, nil
+}
+
+// displayName is the provider's name for the user, or the local part of
+// their address.
+func displayName(claims *auth.IDTokenClaims) string {
+	name := strings.TrimSpace(claims.Name)
+	if name == "" {
+		name, _, _ = strings.Cut(claims.Email, "@")
+	}
+	if len(name) > maxNameLen {
+		name = strings.ToValidUTF8(name[:maxNameLen], "")
+	}
+	return name
+}
+
+// ListIdentities returns the provider accounts linked to a user.
+func (s *OIDCService) ListIdentities(ctx context.Context, userID int64) (identities []models.UserIdentity, err error) {
+	ctx, span := tracing.Start(ctx, "OIDCService.ListIdentities", attribute.Int64("user.id", userID))
+	defer func() { tracing.RecordError(span, err); span.End() }()
+
+	return s.store.ListIdentities(ctx, userID)
+}
+
+// UnlinkIdentity removes a link to a provider account. Logging in with that
+// account again links it again if the address still matches.
+func (s *OIDCService) UnlinkIdentity(ctx context.Context, userID, id int64) (err error) {
+	ctx, span := tracing.Start(ctx, "OIDCService.UnlinkIdentity", attribute.Int64("user.id", userID))
+	defer func() { tracing.RecordError(span, err); span.End() }()
+
+	if err = s.store.DeleteIdentity(ctx, userID, id); err != nil {
+		return err
+	}
+
+	logging.Ctx(ctx, logging.Services).Info().Int64("user_id", userID).Int64("identity_id", id).Msg("Identity unlinked")
+	return nil
+}
@@ -0,0 +1,174 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/manuel/make-it-rain/auth/oidctest"
+	"github.com/manuel/make-it-rain/config"
+	"github.com/manuel/make-it-rain/db"
+	"github.com/manuel/make-it-rain/models"
+)
+
+// memoryIdentities keeps logins in progress and identity links in memory,
+// creating users in accounts.
+type memoryIdentities struct {
+	db.IdentityStore
+	accounts   *memoryAccounts
+	logins     map[string]*models.OIDCLogin
+	identities []*models.UserIdentity
+}
+
+func newMemoryIdentities(accounts *memoryAccounts) *memoryIdentities {
+	return &memoryIdentities{accounts: accounts, logins: make(map[string]*models.OIDCLogin)}
+}
+
+func (s *memoryIdentities) CreateOIDCLogin(ctx context.Context, l *models.OIDCLogin) error {
+	s.logins[l.StateHash] = l
+	return nil
+}
+
+func (s *memoryIdentities) ConsumeOIDCLogin(ctx context.Context, stateHash string) (*models.OIDCLogin, error) {
+	l, ok := s.logins[stateHash]
+	if !ok {
+		return nil, db.ErrTokenInvalid
+	}
+	delete(s.logins, stateHash)
+	return l, nil
+}
+
+func (s *memoryIdentities) UseIdentity(ctx context.Context, provider, subject, email string) (int64, error) {
+	for _, i := range s.identities {
+		if i.Provider == provider && i.Subject == subject {
+			i.Email = email
+			return i.UserID, nil
+		}
+	}
+	return 0, db.ErrIdentityNotFound
+}
+
+func (s *memoryIdentities) LinkIdentity(ctx context.Context, i *models.UserIdentity) error {
+	if _, err := s.UseIdentity(ctx, i.Provider, i.Subject, i.Email); err == nil {
+		return db.ErrIdentityLinked
+	}
+	i.ID = int64(len(s.identities) + 1)
+	s.identities = append(s.identities, i)
+	return nil
+}
+
+func (s *memoryIdentities) CreateUserWithIdentity(ctx context.Context, user *db.CreateUserRequest, i *models.UserIdentity) (*models.User, error) {
+	now := time.Now()
+	u := &models.User{
+		ID:              int64(len(s.accounts.users) + 1),
+		Email:           user.Email,
+		Name:            user.Name,
+		Password:        user.Password,
+		Locale:          user.Locale,
+		IsActive:        true,
+		Role:            models.RoleUser,
+		EmailVerifiedAt: &now,
+	}
+	s.accounts.users[u.ID] = u
+	i.UserID = u.ID
+	if err := s.LinkIdentity(ctx, i); err != nil {
+		return nil, err
+	}
+	return s.accounts.GetUser(ctx, u.ID)
+}
+
+func newTestOIDC(t *testing.T, accounts *memoryAccounts, allowSignup bool) (*OIDCService, *memoryIdentities, *oidctest.Provider) {
+	now := time.Now()
+	authService, _ := newTestAuth(t, accounts, &now)
+	mock := oidctest.NewProvider(t)
+	providerCfg := mock.Config()
+	providerCfg.AllowSignup = allowSignup
+
+	identities := newMemoryIdentities(accounts)
+	s := NewOIDCService(authService, accounts, identities, config.OIDCConfig{
+		LoginTTL:  time.Minute,
+		Timeout:   time.Second,
+		Providers: map[string]config.OIDCProviderConfig{"mock": providerCfg},
+	})
+	return s, identities, mock
+}
+
+// oidcLogin logs in at the mock provider, which issues an ID token with
+// claims overridden.
+func oidcLogin(t *testing.T, s *OIDCService, mock *oidctest.Provider, claims map[string]interface{}) (*TokenPair, error) {
+	authorization, err := s.StartLogin(context.Background(), "mock")
+	if err != nil {
+		t.Fatalf("StartLogin failed: %v", err)
+	}
+	code := mock.Approve(authorization.URL, claims)
+	return s.FinishLogin(context.Background(), "mock", code, authorization.State, Client{})
+}
+
+func verifiedUser() *models.User {
+	u := newTestUser(models.RoleUser)
+	verifiedAt := time.Now()
+	u.EmailVerifiedAt = &verifiedAt
+	return u
+}
+
+func TestOIDCLinksAccountByVerifiedEmail(t *testing.T) {
+	accounts := newMemoryAccounts(verifiedUser())
+	s, identities, mock := newTestOIDC(t, accounts, false)
+
+	tokens, err := oidcLogin(t, s, mock, nil)
+	if err != nil {
+		t.Fatalf("FinishLogin failed: %v", err)
+	}
+	if tokens.AccessToken == "" {
+		t.Error("Expected an access token")
+	}
+	if len(identities.identities) != 1 || identities.identities[0].UserID != 1 || identities.identities[0].Subject != "subject-1" {
+		t.Errorf("Expected the provider account to be linked to user 1, got %+v", identities.identities)
+	}
+	if len(accounts.users) != 1 {
+		t.Errorf("Expected no user to be created, got %d users", len(accounts.users))
+	}
+}
+
+func TestOIDCRepeatLoginUsesLink(t *testing.T) {
+	accounts := newMemoryAccounts(verifiedUser())
+	s, identities, mock := newTestOIDC(t, accounts, false)
+	if _, err := oidcLogin(t, s, mock, nil); err != nil {
+		t.Fatalf("First login failed: %v", err)
+	}
+
+	// The provider now reports another, unverified address; the link still
+	// finds the user
+	claims := map[string]interface{}{"email": "ana@elsewhere.example.com", "email_verified": false}
+	if _, err := oidcLogin(t, s, mock, claims); err != nil {
+		t.Fatalf("Expected the linked account to log in, got %v", err)
+	}
+	if len(identities.identities) != 1 || identities.identities[0].Email != "ana@elsewhere.example.com" {
+		t.Errorf("Expected the link to be reused with the new address, got %+v", identities.identities)
+	}
+}
+
+func TestOIDCRefusesUnverifiedAccount(t *testing.T) {
+	accounts := newMemoryAccounts(newTestUser(models.RoleUser))
+	s, identities, mock := newTestOIDC(t, accounts, true)
+
+	if _, err := oidcLogin(t, s, mock, nil); !errors.Is(err, ErrAccountNotLinkable) {
+		t.Errorf("Expected ErrAccountNotLinkable, got %v", err)
+	}
+	if len(identities.identities) != 0 {
+		t.Errorf("Expected nothing linked, got %+v", identities.identities)
+	}
+}
+
+func TestOIDCSignupDisabled(t *testing.T) {
+	accounts := newMemoryAccounts()
+	s, identities, mock := newTestOIDC(t, accounts, false)
+
+	if _, err := oidcLogin(t, s, mock, nil); !errors.Is(err, ErrSignupDisabled) {
+		t.Errorf("Expected ErrSignupDisabled, got %v", err)
+	}
+	if len(accounts.users) != 0 || len(identities.identities) != 0 {
+		t.Errorf("Expected no user or link to be created, got %d users and %d links", len(accounts.users), len(identities.identities))
+	}
+}
@@ -0,0 +1,108 @@
+package services
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+
+	"github.com/manuel/make-it-rain/auth"
+	"github.com/manuel/make-it-rain/config"
+	"github.com/manuel/make-it-rain/db"
+	"github.com/manuel/make-it-rain/logging"
+	"github.com/manuel/make-it-rain/models"
+	"github.com/manuel/make-it-rain/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// ErrWrongPassword is returned by ChangePassword when the current password
+// doesn't match.
+var ErrWrongPassword = errors.New("current password is incorrect")
+
+// PasswordService resets forgotten passwords through an emailed link and
+// changes passwords for logged-in users. Either way every existing session
+// is revoked.
+type PasswordService struct {
+	users  db.DBService
+	store  db.AuthStore
+	mail   MailSender
+	cfg    config.AuthConfig
+	forgot *auth.Throttle
+}
+
+func NewPasswordService(users db.DBService, store db.AuthStore, mail MailSender, cfg config.AuthConfig) *PasswordService {
+	return &PasswordService{
+		users:  users,
+		store:  store,
+		mail:   mail,
+		cfg:    cfg,
+		forgot: auth.NewThrottle(cfg.ResendLimit, cfg.ResendWindow),
+	}
+}
+
+// ForgotPassword emails a reset link if email belongs to an active account.
+// Like ResendVerification it succeeds either way, so the response doesn't
+// reveal whether the account exists.
+func (s *PasswordService) ForgotPassword(ctx context.Context, email, clientIP string) (err error) {
+	ctx, span := tracing.Start(ctx, "PasswordService.ForgotPassword")
+	defer func() { tracing.RecordError(span, err); span.End() }()
+
+	if !s.forgot.Allow("email:"+email, "ip:"+clientIP) {
+		return ErrRateLimited
+	}
+
+	user, err := s.users.GetUserByEmail(ctx, email)
+	if err != nil {
+		if err.Error() == "user not found" {
+			return nil
+		}
+		return err
+	}
+	if !user.IsActive {
+		return nil
+	}
+
+	err = sendUserToken(ctx, s.store, s.mail, user, models.TokenResetPassword, s.cfg.PasswordResetTTL, s.cfg.ResetPasswordURL)
+	if err != nil {
+		return err
+	}
+
+	logging.Ctx(ctx, logging.Services).Info().Int64("user_id", user.ID).Msg("Password reset email queued")
+	return nil
+}
+
+// ResetPassword sets a new password with a token from a reset email.
+func (s *PasswordService) ResetPassword(ctx context.Context, token, password string) (err error) {
+	ctx, span := tracing.Start(ctx, "PasswordService.ResetPassword")
+	defer func() { tracing.RecordError(span, err); span.End() }()
+
+	user, err := s.users.ResetPassword(ctx, auth.HashToken(token), hashPassword(password))
+	if err != nil {
+		return err
+	}
+
+	logging.Ctx(ctx, logging.Services).Info().Int64("user_id", user.ID).Msg("Password reset")
+	return nil
+}
+
+// ChangePassword replaces the password of a logged-in user who knows the
+// current one.
+func (s *PasswordService) ChangePassword(ctx context.Context, userID int64, current, password string) (err error) {
+	ctx, span := tracing.Start(ctx, "PasswordService.ChangePassword", attribute.Int64("user.id", userID))
+	defer func() { tracing.RecordError(span, err); span.End() }()
+
+	user, err := s.users.GetUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare([]byte(user.Password), []byte(hashPassword(current))) != 1 {
+		logging.Ctx(ctx, logging.Services).Warn().Int64("user_id", userID).Msg("Password change with wrong current password")
+		return ErrWrongPassword
+	}
+
+	if err = s.users.ChangePassword(ctx, userID, hashPassword(password)); err != nil {
+		return err
+	}
+
+	logging.Ctx(ctx, logging.Services).Info().Int64("user_id", userID).Msg("Password changed")
+	return nil
+}
@@ -0,0 +1,70 @@
+package services
+
+import (
+	"context"
+
+	"github.com/manuel/make-it-rain/logging"
+	"github.com/manuel/make-it-rain/models"
+	"github.com/manuel/make-it-rain/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Client describes where a login or refresh came from. It is recorded on
+// the session so users can recognise their devices.
+type Client struct {
+	IP        string
+	UserAgent string
+}
+
+// Session is a login session as listed to its user. Current marks the one
+// the request was made with.
+type Session struct {
+	models.RefreshToken
+	Current bool `json:"current"`
+}
+
+// ListSessions returns a user's live sessions. currentID is the session of
+// the request, or 0.
+func (s *AuthService) ListSessions(ctx context.Context, userID, currentID int64) (sessions []Session, err error) {
+	ctx, span := tracing.Start(ctx, "AuthService.ListSessions", attribute.Int64("user.id", userID))
+	defer func() { tracing.RecordError(span, err); span.End() }()
+
+	tokens, err := s.store.ListSessions(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	sessions = make([]Session, 0, len(tokens))
+	for _, t := range tokens {
+		sessions = append(sessions, Session{RefreshToken: t, Current: t.ID == currentID})
+	}
+	return sessions, nil
+}
+
+// RevokeSession logs one of a user's sessions out. Access tokens issued from
+// it stop working on every instance.
+func (s *AuthService) RevokeSession(ctx context.Context, userID, sessionID int64) (err error) {
+	ctx, span := tracing.Start(ctx, "AuthService.RevokeSession", attribute.Int64("user.id", userID), attribute.Int64("session.id", sessionID))
+	defer func() { tracing.RecordError(span, err); span.End() }()
+
+	if err = s.store.RevokeSession(ctx, userID, sessionID); err != nil {
+		return err
+	}
+
+	logging.Ctx(ctx, logging.Services).Info().Int64("user_id", userID).Int64("session_id", sessionID).Msg("Session revoked")
+	return nil
+}
+
+// RevokeAllSessions logs a user out everywhere and returns how many sessions
+// were ended.
+func (s *AuthService) RevokeAllSessions(ctx context.Context, userID int64) (revoked int64, err error) {
+	ctx, span := tracing.Start(ctx, "AuthService.RevokeAllSessions", attribute.Int64("user.id", userID))
+	defer func() { tracing.RecordError(span, err); span.End() }()
+
+	revoked, err = s.store.RevokeUserSessions(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	logging.Ctx(ctx, logging.Services).Info().Int64("user_id", userID).Int64("sessions", revoked).Msg("All sessions revoked")
+	return revoked, nil
+}
@@ -3,61 +3,208 @@ package services
 import (
 	"context"
 	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/hex"
+	"errors"
 	"fmt"
 
 	"github.com/manuel/make-it-rain/db"
+	"github.com/manuel/make-it-rain/logging"
 	"github.com/manuel/make-it-rain/models"
+	"github.com/manuel/make-it-rain/tracing"
+	"github.com/manuel/make-it-rain/utils"
+	"go.opentelemetry.io/otel/attribute"
 )
 
+var (
+	// ErrInvalidCredentials covers both an unknown email and a wrong
+	// password so callers can't tell them apart.
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	ErrUserInactive       = errors.New("user account is inactive")
+	// ErrInvalidUpdate is returned for an update to a field that can't be
+	// changed this way, or with a value of the wrong type. Role and MFA
+	// changes have their own methods.
+	ErrInvalidUpdate = errors.New("invalid update")
+)
+
+// updatableUserFields are the fields UpdateUser accepts, with a check for
+// each value.
+var updatableUserFields = map[string]func(value interface{}) bool{
+	"name": func(v interface{}) bool {
+		name, ok := v.(string)
+		return ok && name != "" && len(name) <= maxNameLen
+	},
+	"email": func(v interface{}) bool {
+		email, ok := v.(string)
+		return ok && utils.ValidateEmail(email)
+	},
+	"locale": func(v interface{}) bool {
+		locale, ok := v.(string)
+		return ok && len(locale) <= 35
+	},
+	"is_active": func(v interface{}) bool {
+		_, ok := v.(bool)
+		return ok
+	},
+}
+
+// EmailVerifier sends a verification link to a user's current address.
+type EmailVerifier interface {
+	SendVerification(ctx context.Context, user *models.User) error
+}
+
 type UserService struct {
 	dbService db.DBService
+	verifier  EmailVerifier
 }
 
-func NewUserService(dbService db.DBService) *UserService {
+func NewUserService(dbService db.DBService, verifier EmailVerifier) *UserService {
 	return &UserService{
 		dbService: dbService,
+		verifier:  verifier,
 	}
 }
 
-func (s *UserService) CreateUser(ctx context.Context, req *db.CreateUserRequest) (*models.User, error) {
+func (s *UserService) CreateUser(ctx context.Context, req *db.CreateUserRequest) (user *models.User, err error) {
+	ctx, span := tracing.Start(ctx, "UserService.CreateUser")
+	defer func() { tracing.RecordError(span, err); span.End() }()
+
 	req.Password = hashPassword(req.Password)
-	return s.dbService.CreateUser(ctx, req)
+	user, err = s.dbService.CreateUser(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	logging.Ctx(ctx, logging.Services).Info().Int64("user_id", user.ID).Msg("User created")
+	s.sendVerification(ctx, user)
+	return user, nil
 }
 
-func (s *UserService) GetUser(ctx context.Context, userID int64) (*models.User, error) {
+func (s *UserService) GetUser(ctx context.Context, userID int64) (user *models.User, err error) {
+	ctx, span := tracing.Start(ctx, "UserService.GetUser", attribute.Int64("user.id", userID))
+	defer func() { tracing.RecordError(span, err); span.End() }()
+
 	return s.dbService.GetUser(ctx, userID)
 }
 
-func (s *UserService) GetUsers(ctx context.Context, page, pageSize int, sortBy, sortOrder string) (*db.PaginatedUsers, error) {
+func (s *UserService) GetUsers(ctx context.Context, page, pageSize int, sortBy, sortOrder string) (result *db.PaginatedUsers, err error) {
+	ctx, span := tracing.Start(ctx, "UserService.GetUsers",
+		attribute.Int("page", page),
+		attribute.Int("page_size", pageSize),
+	)
+	defer func() { tracing.RecordError(span, err); span.End() }()
+
 	return s.dbService.GetUsers(ctx, page, pageSize, sortBy, sortOrder)
 }
 
-func (s *UserService) UpdateUser(ctx context.Context, userID int64, updates map[string]interface{}) error {
-	return s.dbService.UpdateUser(ctx, userID, updates)
+func (s *UserService) UpdateUser(ctx context.Context, userID int64, updates map[string]interface{}) (err error) {
+	ctx, span := tracing.Start(ctx, "UserService.UpdateUser", attribute.Int64("user.id", userID))
+	defer func() { tracing.RecordError(span, err); span.End() }()
+
+	for field, value := range updates {
+		valid, ok := updatableUserFields[field]
+		if !ok {
+			return fmt.Errorf("%w: %s can't be updated", ErrInvalidUpdate, field)
+		}
+		if !valid(value) {
+			return fmt.Errorf("%w: invalid value for %s", ErrInvalidUpdate, field)
+		}
+	}
+
+	// A new address has to be verified again.
+	emailChanged := false
+	if email, ok := updates["email"].(string); ok {
+		current, err := s.dbService.GetUser(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if email != current.Email {
+			emailChanged = true
+			updates["email_verified_at"] = nil
+		}
+	}
+
+	if err = s.dbService.UpdateUser(ctx, userID, updates); err != nil {
+		return err
+	}
+
+	logging.Ctx(ctx, logging.Services).Info().Int64("user_id", userID).Msg("User updated")
+	if emailChanged {
+		user, err := s.dbService.GetUser(ctx, userID)
+		if err != nil {
+			logging.Ctx(ctx, logging.Services).Error().Err(err).Int64("user_id", userID).Msg("Failed to load user for email verification")
+			return nil
+		}
+		s.sendVerification(ctx, user)
+	}
+	return nil
 }
 
-func (s *UserService) DeleteUser(ctx context.Context, userID int64) error {
-	return s.dbService.DeleteUser(ctx, userID)
+func (s *UserService) DeleteUser(ctx context.Context, userID int64) (err error) {
+	ctx, span := tracing.Start(ctx, "UserService.DeleteUser", attribute.Int64("user.id", userID))
+	defer func() { tracing.RecordError(span, err); span.End() }()
+
+	if err = s.dbService.DeleteUser(ctx, userID); err != nil {
+		return err
+	}
+
+	logging.Ctx(ctx, logging.Services).Info().Int64("user_id", userID).Msg("User deleted")
+	return nil
+}
+
+// SetRole changes a user's role, e.g. to require a second factor.
+func (s *UserService) SetRole(ctx context.Context, userID int64, role string) (user *models.User, err error) {
+	ctx, span := tracing.Start(ctx, "UserService.SetRole", attribute.Int64("user.id", userID))
+	defer func() { tracing.RecordError(span, err); span.End() }()
+
+	if user, err = s.dbService.SetUserRole(ctx, userID, role); err != nil {
+		return nil, err
+	}
+
+	logging.Ctx(ctx, logging.Services).Info().Int64("user_id", userID).Str("role", role).Msg("User role changed")
+	return user, nil
 }
 
-func (s *UserService) AuthenticateUser(ctx context.Context, email, password string) (*models.User, error) {
-	user, err := s.dbService.GetUserByEmail(ctx, email)
+func (s *UserService) AuthenticateUser(ctx context.Context, email, password string) (user *models.User, err error) {
+	ctx, span := tracing.Start(ctx, "UserService.AuthenticateUser")
+	defer func() { tracing.RecordError(span, err); span.End() }()
+
+	// The password is hashed even for an unknown email, so both take as
+	// long as a wrong password.
+	hash := hashPassword(password)
+	user, err = s.dbService.GetUserByEmail(ctx, email)
 	if err != nil {
+		if err.Error() == "user not found" {
+			return nil, ErrInvalidCredentials
+		}
 		return nil, err
 	}
 
-	if user.Password != hashPassword(password) {
-		return nil, fmt.Errorf("invalid credentials")
+	if subtle.ConstantTimeCompare([]byte(user.Password), []byte(hash)) != 1 {
+		logging.Ctx(ctx, logging.Services).Warn().Int64("user_id", user.ID).Msg("Authentication failed")
+		return nil, ErrInvalidCredentials
 	}
 
 	if !user.IsActive {
-		return nil, fmt.Errorf("user account is inactive")
+		logging.Ctx(ctx, logging.Services).Warn().Int64("user_id", user.ID).Msg("Inactive user attempted to authenticate")
+		return nil, ErrUserInactive
 	}
 
 	return user, nil
 }
 
+// sendVerification emails a verification link. The account change has
+// already been saved, so a failure is logged and the user can ask for the
+// link again.
+func (s *UserService) sendVerification(ctx context.Context, user *models.User) {
+	if s.verifier == nil {
+		return
+	}
+	if err := s.verifier.SendVerification(ctx, user); err != nil {
+		logging.Ctx(ctx, logging.Services).Error().Err(err).Int64("user_id", user.ID).Msg("Failed to send verification email")
+	}
+}
+
 func hashPassword(password string) string {
 	hash := sha256.Sum256([]byte(password))
 	return hex.EncodeToString(hash[:])
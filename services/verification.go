@@ -0,0 +1,136 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"time"
+
+	"github.com/manuel/make-it-rain/auth"
+	"github.com/manuel/make-it-rain/config"
+	"github.com/manuel/make-it-rain/db"
+	"github.com/manuel/make-it-rain/logging"
+	"github.com/manuel/make-it-rain/models"
+	"github.com/manuel/make-it-rain/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// ErrRateLimited is returned when a client asks for too many emails.
+var ErrRateLimited = errors.New("too many requests")
+
+// MailSender queues a templated email; *mailer.Sender implements it.
+type MailSender interface {
+	Send(ctx context.Context, to, template, locale string, data interface{}) error
+}
+
+// VerificationService proves that users own their email address. Each link
+// carries a single-use token that expires after cfg.VerificationTTL and is
+// bound to the address it was sent to.
+type VerificationService struct {
+	users  db.DBService
+	store  db.AuthStore
+	mail   MailSender
+	cfg    config.AuthConfig
+	resend *auth.Throttle
+}
+
+func NewVerificationService(users db.DBService, store db.AuthStore, mail MailSender, cfg config.AuthConfig) *VerificationService {
+	return &VerificationService{
+		users:  users,
+		store:  store,
+		mail:   mail,
+		cfg:    cfg,
+		resend: auth.NewThrottle(cfg.ResendLimit, cfg.ResendWindow),
+	}
+}
+
+// SendVerification emails user a link to verify their current address.
+// Earlier links for the user stop working.
+func (s *VerificationService) SendVerification(ctx context.Context, user *models.User) (err error) {
+	ctx, span := tracing.Start(ctx, "VerificationService.SendVerification", attribute.Int64("user.id", user.ID))
+	defer func() { tracing.RecordError(span, err); span.End() }()
+
+	err = sendUserToken(ctx, s.store, s.mail, user, models.TokenVerifyEmail, s.cfg.VerificationTTL, s.cfg.VerifyEmailURL)
+	if err != nil {
+		return err
+	}
+
+	logging.Ctx(ctx, logging.Services).Info().Int64("user_id", user.ID).Msg("Verification email queued")
+	return nil
+}
+
+// VerifyEmail marks the address a token was sent to as verified.
+func (s *VerificationService) VerifyEmail(ctx context.Context, token string) (user *models.User, err error) {
+	ctx, span := tracing.Start(ctx, "VerificationService.VerifyEmail")
+	defer func() { tracing.RecordError(span, err); span.End() }()
+
+	user, err = s.users.VerifyEmail(ctx, auth.HashToken(token))
+	if err != nil {
+		return nil, err
+	}
+
+	logging.Ctx(ctx, logging.Services).Info().Int64("user_id", user.ID).Msg("Email verified")
+	return user, nil
+}
+
+// ResendVerification sends a new link to email if it belongs to an account
+// that still needs one. It succeeds either way so it can't be used to find
+// out which addresses are registered; only the rate limit, which applies to
+// every address alike, is reported.
+func (s *VerificationService) ResendVerification(ctx context.Context, email, clientIP string) (err error) {
+	ctx, span := tracing.Start(ctx, "VerificationService.ResendVerification")
+	defer func() { tracing.RecordError(span, err); span.End() }()
+
+	if !s.resend.Allow("email:"+email, "ip:"+clientIP) {
+		return ErrRateLimited
+	}
+
+	user, err := s.users.GetUserByEmail(ctx, email)
+	if err != nil {
+		if err.Error() == "user not found" {
+			return nil
+		}
+		return err
+	}
+	if user.EmailVerifiedAt != nil || !user.IsActive {
+		return nil
+	}
+	return s.SendVerification(ctx, user)
+}
+
+// sendUserToken stores a new single-use token for user and emails a link to
+// it. The template has the same name as the purpose.
+func sendUserToken(ctx context.Context, store db.AuthStore, mail MailSender, user *models.User, purpose string, ttl time.Duration, baseURL string) error {
+	token, hash, err := auth.NewToken()
+	if err != nil {
+		return err
+	}
+	err = store.CreateUserToken(ctx, &models.UserToken{
+		UserID:    user.ID,
+		Purpose:   purpose,
+		TokenHash: hash,
+		Email:     user.Email,
+		ExpiresAt: time.Now().Add(ttl),
+	})
+	if err != nil {
+		return err
+	}
+
+	link, err := url.Parse(baseURL)
+	if err != nil {
+		return fmt.Errorf("invalid %s URL: %w", purpose, err)
+	}
+	q := link.Query()
+	q.Set("token", token)
+	link.RawQuery = q.Encode()
+
+	data := map[string]interface{}{
+		"Name":  user.Name,
+		"Email": user.Email,
+		"URL":   link.String(),
+		"Hours": int(math.Ceil(ttl.Hours())),
+	}
+	return mail.Send(ctx, user.Email, purpose, user.Locale, data)
+}
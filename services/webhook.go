@@ -0,0 +1,171 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+
+	"github.com/manuel/make-it-rain/db"
+	"github.com/manuel/make-it-rain/logging"
+	"github.com/manuel/make-it-rain/models"
+	"github.com/manuel/make-it-rain/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// ErrInvalidWebhook wraps validation failures so controllers can return 400.
+var ErrInvalidWebhook = errors.New("invalid webhook")
+
+type WebhookService struct {
+	store db.WebhookStore
+}
+
+func NewWebhookService(store db.WebhookStore) *WebhookService {
+	return &WebhookService{store: store}
+}
+
+type CreateWebhookRequest struct {
+	URL        string   `json:"url" binding:"required"`
+	EventTypes []string `json:"event_types"`
+	// Secret is generated when empty
+	Secret string `json:"secret"`
+}
+
+type UpdateWebhookRequest struct {
+	URL          *string   `json:"url"`
+	EventTypes   *[]string `json:"event_types"`
+	IsActive     *bool     `json:"is_active"`
+	RotateSecret bool      `json:"rotate_secret"`
+}
+
+// CreateWebhook validates and stores a subscription. The returned secret is
+// only ever shown here and on rotation.
+func (s *WebhookService) CreateWebhook(ctx context.Context, req *CreateWebhookRequest) (webhook *models.WebhookSubscription, err error) {
+	ctx, span := tracing.Start(ctx, "WebhookService.CreateWebhook")
+	defer func() { tracing.RecordError(span, err); span.End() }()
+
+	if err = validateWebhook(req.URL, req.EventTypes); err != nil {
+		return nil, err
+	}
+
+	secret := req.Secret
+	if secret == "" {
+		if secret, err = newWebhookSecret(); err != nil {
+			return nil, err
+		}
+	}
+	eventTypes := req.EventTypes
+	if eventTypes == nil {
+		eventTypes = []string{}
+	}
+
+	webhook, err = s.store.CreateWebhook(ctx, &models.WebhookSubscription{
+		URL:        req.URL,
+		Secret:     secret,
+		EventTypes: eventTypes,
+		IsActive:   true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	logging.Ctx(ctx, logging.Services).Info().Int64("webhook_id", webhook.ID).Msg("Webhook created")
+	return webhook, nil
+}
+
+func (s *WebhookService) GetWebhook(ctx context.Context, id int64) (*models.WebhookSubscription, error) {
+	return s.store.GetWebhook(ctx, id)
+}
+
+func (s *WebhookService) ListWebhooks(ctx context.Context) ([]models.WebhookSubscription, error) {
+	return s.store.ListWebhooks(ctx)
+}
+
+func (s *WebhookService) UpdateWebhook(ctx context.Context, id int64, req *UpdateWebhookRequest) (webhook *models.WebhookSubscription, err error) {
+	ctx, span := tracing.Start(ctx, "WebhookService.UpdateWebhook", attribute.Int64("webhook.id", id))
+	defer func() { tracing.RecordError(span, err); span.End() }()
+
+	webhook, err = s.store.GetWebhook(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.URL != nil {
+		webhook.URL = *req.URL
+	}
+	if req.EventTypes != nil {
+		webhook.EventTypes = *req.EventTypes
+		if webhook.EventTypes == nil {
+			webhook.EventTypes = []string{}
+		}
+	}
+	if req.IsActive != nil {
+		webhook.IsActive = *req.IsActive
+	}
+	if err = validateWebhook(webhook.URL, webhook.EventTypes); err != nil {
+		return nil, err
+	}
+	if req.RotateSecret {
+		if webhook.Secret, err = newWebhookSecret(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = s.store.UpdateWebhook(ctx, webhook); err != nil {
+		return nil, err
+	}
+
+	logging.Ctx(ctx, logging.Services).Info().Int64("webhook_id", id).Bool("secret_rotated", req.RotateSecret).Msg("Webhook updated")
+	return webhook, nil
+}
+
+func (s *WebhookService) DeleteWebhook(ctx context.Context, id int64) error {
+	if err := s.store.DeleteWebhook(ctx, id); err != nil {
+		return err
+	}
+	logging.Ctx(ctx, logging.Services).Info().Int64("webhook_id", id).Msg("Webhook deleted")
+	return nil
+}
+
+func (s *WebhookService) ListDeliveries(ctx context.Context, webhookID int64, limit int) ([]models.WebhookDelivery, error) {
+	if _, err := s.store.GetWebhook(ctx, webhookID); err != nil {
+		return nil, err
+	}
+	return s.store.ListWebhookDeliveries(ctx, webhookID, limit)
+}
+
+func (s *WebhookService) Redeliver(ctx context.Context, deliveryID int64) (*models.WebhookDelivery, error) {
+	delivery, err := s.store.RedeliverWebhook(ctx, deliveryID)
+	if err != nil {
+		return nil, err
+	}
+	logging.Ctx(ctx, logging.Services).Info().
+		Int64("delivery_id", delivery.ID).
+		Int64("redelivery_of", deliveryID).
+		Msg("Webhook redelivery queued")
+	return delivery, nil
+}
+
+func validateWebhook(rawURL string, eventTypes []string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%w: url must be an absolute http or https URL", ErrInvalidWebhook)
+	}
+	for _, t := range eventTypes {
+		if !slices.Contains(models.EventTypes, t) {
+			return fmt.Errorf("%w: unknown event type %q", ErrInvalidWebhook, t)
+		}
+	}
+	return nil
+}
+
+func newWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate webhook secret: %w", err)
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
@@ -0,0 +1,105 @@
+package utils
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const RedactedValue = "[REDACTED]"
+
+// Redactor masks sensitive values in query strings, headers and JSON bodies.
+// Key matching is case-insensitive.
+type Redactor struct {
+	queryParams map[string]struct{}
+	headers     map[string]struct{}
+	bodyFields  map[string]struct{}
+}
+
+func NewRedactor(queryParams, headers, bodyFields []string) *Redactor {
+	return &Redactor{
+		queryParams: lowerSet(queryParams),
+		headers:     lowerSet(headers),
+		bodyFields:  lowerSet(bodyFields),
+	}
+}
+
+// Query returns rawQuery with the values of sensitive parameters replaced.
+// Parameter order is preserved; unparsable input is dropped entirely rather
+// than logged as-is.
+func (r *Redactor) Query(rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+
+	parts := strings.Split(rawQuery, "&")
+	for i, part := range parts {
+		key, _, hasValue := strings.Cut(part, "=")
+		name, err := url.QueryUnescape(key)
+		if err != nil {
+			return RedactedValue
+		}
+		if _, ok := r.queryParams[strings.ToLower(name)]; ok && hasValue {
+			parts[i] = key + "=" + RedactedValue
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// Headers flattens h into a map, masking sensitive headers.
+func (r *Redactor) Headers(h http.Header) map[string]string {
+	out := make(map[string]string, len(h))
+	for name, values := range h {
+		if _, ok := r.headers[strings.ToLower(name)]; ok {
+			out[name] = RedactedValue
+			continue
+		}
+		out[name] = strings.Join(values, ", ")
+	}
+	return out
+}
+
+// JSON masks sensitive fields at any depth of a JSON document. If body is not
+// valid JSON, nil is returned so callers never log an unredacted payload.
+func (r *Redactor) JSON(body []byte) []byte {
+	var doc interface{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil
+	}
+
+	out, err := json.Marshal(r.redactValue(doc))
+	if err != nil {
+		return nil
+	}
+	return out
+}
+
+func (r *Redactor) redactValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for k, child := range val {
+			if _, ok := r.bodyFields[strings.ToLower(k)]; ok {
+				val[k] = RedactedValue
+				continue
+			}
+			val[k] = r.redactValue(child)
+		}
+		return val
+	case []interface{}:
+		for i, child := range val {
+			val[i] = r.redactValue(child)
+		}
+		return val
+	default:
+		return v
+	}
+}
+
+func lowerSet(items []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(items))
+	for _, item := range items {
+		set[strings.ToLower(strings.TrimSpace(item))] = struct{}{}
+	}
+	return set
+}
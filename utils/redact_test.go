@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRedactorQuery(t *testing.T) {
+	r := NewRedactor([]string{"token", "Email"}, nil, nil)
+
+	got := r.Query("page=2&token=abc&email=a%40b.com&sort=name")
+	want := "page=2&token=[REDACTED]&email=[REDACTED]&sort=name"
+	if got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+
+	if got := r.Query("flag&TOKEN=x"); got != "flag&TOKEN=[REDACTED]" {
+		t.Errorf("Expected case-insensitive match, got %q", got)
+	}
+}
+
+func TestRedactorHeaders(t *testing.T) {
+	r := NewRedactor(nil, []string{"authorization"}, nil)
+
+	h := http.Header{}
+	h.Set("Authorization", "Bearer secret")
+	h.Set("Accept", "application/json")
+
+	got := r.Headers(h)
+	if got["Authorization"] != RedactedValue {
+		t.Errorf("Expected Authorization to be redacted, got %q", got["Authorization"])
+	}
+	if got["Accept"] != "application/json" {
+		t.Errorf("Expected Accept to be kept, got %q", got["Accept"])
+	}
+}
+
+func TestRedactorJSON(t *testing.T) {
+	r := NewRedactor(nil, nil, []string{"password", "token"})
+
+	got := string(r.JSON([]byte(`{"email":"a@b.com","password":"hunter22","nested":{"Token":"t"},"list":[{"password":"p"}]}`)))
+	if strings.Contains(got, "hunter22") || strings.Contains(got, `"t"`) || strings.Contains(got, `"p"`) {
+		t.Errorf("Expected sensitive fields to be redacted, got %s", got)
+	}
+	if !strings.Contains(got, "a@b.com") {
+		t.Errorf("Expected non-sensitive fields to be kept, got %s", got)
+	}
+
+	if got := r.JSON([]byte("not json")); got != nil {
+		t.Errorf("Expected nil for invalid JSON, got %s", got)
+	}
+}
@@ -1,6 +1,10 @@
 package utils
 
 import (
+	"context"
+	"errors"
+	"net/http"
+
 	"github.com/gin-gonic/gin"
 )
 
@@ -34,4 +38,20 @@ func RespondWithSuccess(c *gin.Context, code int, message string, data interface
 		Message: message,
 		Data:    data,
 	})
-}
\ No newline at end of file
+}
+
+// RespondWithContextError maps context errors to consistent responses: a
+// passed deadline (including one hit while waiting for a pooled connection)
+// is a 504 and a cancelled request is a 503. It returns false when err isn't
+// a context error so callers fall through to their own handling.
+func RespondWithContextError(c *gin.Context, err error) bool {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		RespondWithError(c, http.StatusGatewayTimeout, "Request timed out")
+	case errors.Is(err, context.Canceled):
+		RespondWithError(c, http.StatusServiceUnavailable, "Request cancelled")
+	default:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,223 @@
+// Package webhooks sends signed HTTP callbacks for domain events.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/manuel/make-it-rain/config"
+	"github.com/manuel/make-it-rain/data_structures"
+	"github.com/manuel/make-it-rain/db"
+	"github.com/manuel/make-it-rain/events"
+	"github.com/manuel/make-it-rain/logging"
+	"github.com/manuel/make-it-rain/models"
+)
+
+// maxStoredResponse bounds how much of an endpoint's response body is kept
+// in the delivery history.
+const maxStoredResponse = 1024
+
+// Deliverer sends queued webhook deliveries. Each endpoint URL has its own
+// circuit breaker: while it is open, deliveries to that endpoint are
+// postponed without using up their attempts.
+type Deliverer struct {
+	store  db.WebhookStore
+	cfg    config.WebhooksConfig
+	client *http.Client
+
+	mu       sync.Mutex
+	breakers map[string]*data_structures.CircuitBreaker
+
+	wake chan struct{}
+	stop context.CancelFunc
+	done chan struct{}
+}
+
+func NewDeliverer(store db.WebhookStore, cfg config.WebhooksConfig) *Deliverer {
+	return &Deliverer{
+		store:    store,
+		cfg:      cfg,
+		client:   &http.Client{Timeout: cfg.Timeout},
+		breakers: make(map[string]*data_structures.CircuitBreaker),
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// Wake triggers a poll without waiting for the interval.
+func (d *Deliverer) Wake() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Start runs the delivery loop in the background until Stop is called.
+func (d *Deliverer) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	d.stop = cancel
+	d.done = make(chan struct{})
+
+	go func() {
+		defer close(d.done)
+		d.run(ctx)
+	}()
+}
+
+// Stop lets in-flight requests finish and waits for the loop to exit, or for
+// ctx to expire.
+func (d *Deliverer) Stop(ctx context.Context) error {
+	if d.stop == nil {
+		return nil
+	}
+	d.stop()
+	select {
+	case <-d.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (d *Deliverer) run(ctx context.Context) {
+	ticker := time.NewTicker(d.cfg.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		for ctx.Err() == nil {
+			n, err := d.DeliverBatch(context.WithoutCancel(ctx))
+			if err != nil {
+				logging.Ctx(ctx, logging.Services).Error().Err(err).Msg("Failed to deliver webhooks")
+				break
+			}
+			if n < d.cfg.BatchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+	}
+}
+
+// DeliverBatch claims due deliveries and sends them concurrently, returning
+// how many were claimed.
+func (d *Deliverer) DeliverBatch(ctx context.Context) (int, error) {
+	batch, err := d.store.ClaimWebhookDeliveries(ctx, d.cfg.BatchSize, d.cfg.Timeout*2)
+	if err != nil {
+		return 0, err
+	}
+
+	sem := make(chan struct{}, d.cfg.Concurrency)
+	var wg sync.WaitGroup
+	for _, delivery := range batch {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(delivery models.WebhookDelivery) {
+			defer func() { <-sem; wg.Done() }()
+			d.attempt(ctx, delivery)
+		}(delivery)
+	}
+	wg.Wait()
+	return len(batch), nil
+}
+
+func (d *Deliverer) breaker(url string) *data_structures.CircuitBreaker {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	cb, ok := d.breakers[url]
+	if !ok {
+		cb = data_structures.NewCircuitBreaker(d.cfg.BreakerFailures, d.cfg.BreakerReset)
+		d.breakers[url] = cb
+	}
+	return cb
+}
+
+func (d *Deliverer) attempt(ctx context.Context, delivery models.WebhookDelivery) {
+	logger := logging.Ctx(ctx, logging.Services).With().
+		Int64("delivery_id", delivery.ID).
+		Int64("subscription_id", delivery.SubscriptionID).
+		Str("event_type", delivery.EventType).
+		Logger()
+
+	cb := d.breaker(delivery.URL)
+	if !cb.Allow() {
+		if err := d.store.PostponeWebhookDelivery(ctx, delivery.ID, time.Now().Add(d.cfg.BreakerReset)); err != nil {
+			logger.Error().Err(err).Msg("Failed to postpone webhook delivery")
+		}
+		return
+	}
+
+	status, body, err := d.send(ctx, delivery)
+	var statusPtr *int
+	if status != 0 {
+		statusPtr = &status
+	}
+
+	if err == nil {
+		cb.RecordSuccess()
+		if err := d.store.RecordWebhookAttempt(ctx, delivery.ID, true, statusPtr, body, "", nil); err != nil {
+			logger.Error().Err(err).Msg("Failed to record webhook delivery")
+		}
+		return
+	}
+
+	cb.RecordFailure()
+	attempts := delivery.Attempts + 1
+	var retryAt *time.Time
+	if attempts < d.cfg.MaxAttempts {
+		next := time.Now().Add(events.Backoff(attempts, d.cfg.RetryBackoff, d.cfg.MaxRetryBackoff))
+		retryAt = &next
+	}
+	if err := d.store.RecordWebhookAttempt(ctx, delivery.ID, false, statusPtr, body, err.Error(), retryAt); err != nil {
+		logger.Error().Err(err).Msg("Failed to record webhook failure")
+		return
+	}
+
+	if retryAt == nil {
+		logger.Error().Err(err).Int("attempts", attempts).Msg("Webhook delivery failed permanently")
+	} else {
+		logger.Warn().Err(err).Int("attempts", attempts).Time("retry_at", *retryAt).Msg("Webhook delivery failed, will retry")
+	}
+}
+
+// send posts the delivery and treats any non-2xx response as a failure.
+func (d *Deliverer) send(ctx context.Context, delivery models.WebhookDelivery) (int, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid webhook request: %w", err)
+	}
+
+	now := time.Now()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", d.cfg.UserAgent)
+	req.Header.Set(TimestampHeader, strconv.FormatInt(now.Unix(), 10))
+	req.Header.Set(SignatureHeader, Sign(delivery.Secret, now, delivery.Payload))
+	req.Header.Set(EventHeader, delivery.EventType)
+	req.Header.Set(DeliveryHeader, strconv.FormatInt(delivery.ID, 10))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, maxStoredResponse))
+	// Stored as TEXT, which rejects NUL bytes and invalid UTF-8.
+	body := strings.ReplaceAll(strings.ToValidUTF8(string(raw), "\uFFFD"), "\x00", "")
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, body, fmt.Errorf("endpoint responded with %d", resp.StatusCode)
+	}
+	return resp.StatusCode, body, nil
+}
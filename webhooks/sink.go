@@ -0,0 +1,55 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/manuel/make-it-rain/db"
+	"github.com/manuel/make-it-rain/models"
+)
+
+// Envelope is the JSON body sent to webhook endpoints. ID is the event ID,
+// stable across retries and redeliveries, so receivers can deduplicate.
+type Envelope struct {
+	ID        int64           `json:"id"`
+	Type      string          `json:"type"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// Sink is an outbox sink that queues a delivery per matching subscription.
+type Sink struct {
+	store db.WebhookStore
+	wake  func()
+}
+
+// NewSink creates a sink; wake, if set, is called after deliveries are
+// queued so they are sent without waiting for the next poll.
+func NewSink(store db.WebhookStore, wake func()) *Sink {
+	return &Sink{store: store, wake: wake}
+}
+
+func (s *Sink) Name() string { return "webhooks" }
+
+func (s *Sink) Deliver(ctx context.Context, event models.Event) error {
+	payload, err := json.Marshal(Envelope{
+		ID:        event.ID,
+		Type:      event.Type,
+		CreatedAt: event.CreatedAt,
+		Data:      event.Payload,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode webhook payload: %w", err)
+	}
+
+	n, err := s.store.CreateWebhookDeliveries(ctx, event, payload)
+	if err != nil {
+		return err
+	}
+	if n > 0 && s.wake != nil {
+		s.wake()
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package webhooks
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/manuel/make-it-rain/config"
+	"github.com/manuel/make-it-rain/db"
+	"github.com/manuel/make-it-rain/models"
+)
+
+// memoryStore implements the delivery side of db.WebhookStore.
+type memoryStore struct {
+	db.WebhookStore
+	mu         sync.Mutex
+	deliveries map[int64]*models.WebhookDelivery
+	postponed  int
+}
+
+func newMemoryStore(url, secret string, n int) *memoryStore {
+	s := &memoryStore{deliveries: make(map[int64]*models.WebhookDelivery)}
+	for i := int64(1); i <= int64(n); i++ {
+		s.deliveries[i] = &models.WebhookDelivery{
+			ID:        i,
+			EventID:   i,
+			EventType: models.EventUserCreated,
+			Payload:   []byte(`{"id":1,"type":"user.created"}`),
+			Status:    models.WebhookDeliveryPending,
+			URL:       url,
+			Secret:    secret,
+		}
+	}
+	return s
+}
+
+func (s *memoryStore) ClaimWebhookDeliveries(ctx context.Context, limit int, lockFor time.Duration) ([]models.WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var batch []models.WebhookDelivery
+	for i := int64(1); i <= int64(len(s.deliveries)); i++ {
+		if d := s.deliveries[i]; d.Status == models.WebhookDeliveryPending && len(batch) < limit {
+			batch = append(batch, *d)
+		}
+	}
+	return batch, nil
+}
+
+func (s *memoryStore) RecordWebhookAttempt(ctx context.Context, id int64, succeeded bool, responseStatus *int, responseBody, lastError string, retryAt *time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d := s.deliveries[id]
+	d.Attempts++
+	d.ResponseStatus = responseStatus
+	d.LastError = lastError
+	switch {
+	case succeeded:
+		d.Status = models.WebhookDeliverySucceeded
+	case retryAt == nil:
+		d.Status = models.WebhookDeliveryDead
+	}
+	return nil
+}
+
+func (s *memoryStore) PostponeWebhookDelivery(ctx context.Context, id int64, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.postponed++
+	return nil
+}
+
+func testWebhooksConfig() config.WebhooksConfig {
+	return config.WebhooksConfig{
+		PollInterval:    time.Second,
+		BatchSize:       10,
+		Concurrency:     1,
+		Timeout:         time.Second,
+		UserAgent:       "test",
+		MaxAttempts:     2,
+		RetryBackoff:    time.Second,
+		MaxRetryBackoff: time.Minute,
+		BreakerFailures: 2,
+		BreakerReset:    time.Minute,
+	}
+}
+
+func TestDelivererSendsSignedRequests(t *testing.T) {
+	var gotErr atomic.Value
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if err := Verify("s3cr3t", r.Header.Get(SignatureHeader), r.Header.Get(TimestampHeader), body, time.Minute); err != nil {
+			gotErr.Store(err)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Header.Get(EventHeader) != models.EventUserCreated {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	store := newMemoryStore(receiver.URL, "s3cr3t", 1)
+	d := NewDeliverer(store, testWebhooksConfig())
+
+	if n, err := d.DeliverBatch(context.Background()); err != nil || n != 1 {
+		t.Fatalf("Expected 1 delivery, got %d, %v", n, err)
+	}
+	if err, _ := gotErr.Load().(error); err != nil {
+		t.Errorf("Expected a valid signature, got %v", err)
+	}
+	delivery := store.deliveries[1]
+	if delivery.Status != models.WebhookDeliverySucceeded || *delivery.ResponseStatus != http.StatusNoContent {
+		t.Errorf("Expected delivery to succeed with 204, got %s", delivery.Status)
+	}
+}
+
+func TestDelivererRetriesAndOpensCircuit(t *testing.T) {
+	var calls atomic.Int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer receiver.Close()
+
+	store := newMemoryStore(receiver.URL, "s3cr3t", 3)
+	d := NewDeliverer(store, testWebhooksConfig())
+	d.DeliverBatch(context.Background())
+
+	// Two failures open the breaker, so the third delivery isn't attempted.
+	if n := calls.Load(); n != 2 {
+		t.Errorf("Expected 2 requests before the circuit opened, got %d", n)
+	}
+	if store.postponed != 1 {
+		t.Errorf("Expected 1 delivery postponed by the open circuit, got %d", store.postponed)
+	}
+	first := store.deliveries[1]
+	if first.Status != models.WebhookDeliveryPending || first.Attempts != 1 || first.LastError == "" {
+		t.Errorf("Expected first failure to be scheduled for retry, got %s after %d attempts", first.Status, first.Attempts)
+	}
+
+	// Attempts run out on the second failure.
+	d = NewDeliverer(store, testWebhooksConfig())
+	d.DeliverBatch(context.Background())
+	if first.Status != models.WebhookDeliveryDead {
+		t.Errorf("Expected delivery to be dead after max attempts, got %s", first.Status)
+	}
+}
+
+func TestVerifyRejectsTamperingAndStaleTimestamps(t *testing.T) {
+	body := []byte(`{"id":1}`)
+	now := time.Now()
+	sig := Sign("s3cr3t", now, body)
+	ts := now.Unix()
+
+	if err := Verify("s3cr3t", sig, strconv.FormatInt(ts, 10), body, time.Minute); err != nil {
+		t.Errorf("Expected valid signature, got %v", err)
+	}
+	if err := Verify("s3cr3t", sig, strconv.FormatInt(ts, 10), []byte(`{"id":2}`), time.Minute); err != ErrInvalidSignature {
+		t.Errorf("Expected tampered body to fail, got %v", err)
+	}
+	if err := Verify("other", sig, strconv.FormatInt(ts, 10), body, time.Minute); err != ErrInvalidSignature {
+		t.Errorf("Expected wrong secret to fail, got %v", err)
+	}
+	old := now.Add(-time.Hour)
+	if err := Verify("s3cr3t", Sign("s3cr3t", old, body), strconv.FormatInt(old.Unix(), 10), body, time.Minute); err != ErrStaleTimestamp {
+		t.Errorf("Expected stale timestamp to fail, got %v", err)
+	}
+}